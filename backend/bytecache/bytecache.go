@@ -0,0 +1,244 @@
+// Package bytecache provides an in-memory cache that keeps serialized entries
+// in large preallocated byte segments instead of Go values, so millions of
+// entries add almost nothing to the garbage collector's mark work.
+package bytecache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/ethan-k/cachemanager-go/internal/valuecodec"
+)
+
+// ErrEntryTooLarge is returned when an entry does not fit in a single segment.
+var ErrEntryTooLarge = errors.New("entry is larger than a cache segment")
+
+// Cache is an in-memory cache backed by byte ring segments
+type Cache struct {
+	segments        []*segment
+	segmentMask     uint64
+	segmentCount    int
+	capacity        int
+	codec           codec.Codec
+	defaultTTL      time.Duration
+	cleanupInterval time.Duration
+	cleanupTicker   *time.Ticker
+	stopCleanup     chan struct{}
+}
+
+// Option defines the functional option type for configuring the cache
+type Option func(*Cache)
+
+// WithCapacity sets the total number of bytes preallocated for entries. It is
+// split evenly across the segments.
+func WithCapacity(bytes int) Option {
+	return func(c *Cache) {
+		if bytes > 0 {
+			c.capacity = bytes
+		}
+	}
+}
+
+// WithSegments sets the number of independently locked segments. It is
+// rounded up to a power of two, and raised further if a segment would
+// exceed 4 GiB.
+func WithSegments(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.segmentCount = n
+		}
+	}
+}
+
+// WithCodec sets the codec used for values that are not []byte
+func WithCodec(cdc codec.Codec) Option {
+	return func(c *Cache) {
+		c.codec = cdc
+	}
+}
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithCleanupInterval sets the interval for cleanup of expired entries
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(c *Cache) {
+		if interval > 0 {
+			c.cleanupInterval = interval
+		}
+	}
+}
+
+// segmentLayout returns the number of segments, a power of two no smaller
+// than segments, and their size for a total of capacity bytes. The count is
+// raised until every segment fits maxSegmentSize, as the index stores
+// offsets as uint32.
+func segmentLayout(capacity, segments int) (count, size int) {
+	count = 1
+	for count < segments || uint64(capacity/count) > maxSegmentSize {
+		count <<= 1
+	}
+	return count, max(capacity/count, headerSize)
+}
+
+func NewByteCache(opts ...Option) *Cache {
+	cache := &Cache{
+		segmentCount:    64,
+		capacity:        64 << 20,
+		codec:           codec.Gob{},
+		defaultTTL:      cachemanager.NoExpiration,
+		cleanupInterval: time.Minute,
+		stopCleanup:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	count, segmentSize := segmentLayout(cache.capacity, cache.segmentCount)
+
+	cache.segments = make([]*segment, count)
+	for i := range cache.segments {
+		cache.segments[i] = newSegment(segmentSize)
+	}
+	cache.segmentMask = uint64(count - 1)
+
+	cache.startCleanup()
+
+	return cache
+}
+
+func (c *Cache) Get(_ context.Context, key string) (any, bool, error) {
+	hash := hashKey(key)
+	seg := c.segmentFor(hash)
+	now := time.Now().UnixNano()
+
+	seg.mu.RLock()
+	flags, data, found, expired := seg.get(key, hash, now)
+	seg.mu.RUnlock()
+
+	if expired {
+		seg.mu.Lock()
+		if _, _, _, stillExpired := seg.get(key, hash, now); stillExpired {
+			seg.del(key, hash)
+		}
+		seg.mu.Unlock()
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	value, err := valuecodec.Decode(c.codec, key, valuecodec.Flag(flags), data)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *Cache) Set(_ context.Context, key string, value any, ttl time.Duration) error {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+
+	flag, data, err := valuecodec.Encode(c.codec, key, value)
+	if err != nil {
+		return err
+	}
+
+	hash := hashKey(key)
+	seg := c.segmentFor(hash)
+	if len(key) > 0xFFFF || headerSize+len(key)+len(data) > len(seg.buf) {
+		return ErrEntryTooLarge
+	}
+
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	seg.set(key, hash, byte(flag), data, expiresAtFor(time.Now(), ttl))
+	return nil
+}
+
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (c *Cache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+func (c *Cache) Delete(_ context.Context, key string) error {
+	hash := hashKey(key)
+	seg := c.segmentFor(hash)
+
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	seg.del(key, hash)
+	return nil
+}
+
+// Len returns the number of reachable entries, including expired entries not
+// yet cleaned up.
+func (c *Cache) Len() int {
+	total := 0
+	for _, seg := range c.segments {
+		seg.mu.RLock()
+		total += seg.len()
+		seg.mu.RUnlock()
+	}
+	return total
+}
+
+func (c *Cache) Close() error {
+	close(c.stopCleanup)
+	return nil
+}
+
+func (c *Cache) segmentFor(hash uint64) *segment {
+	return c.segments[hash&c.segmentMask]
+}
+
+func (c *Cache) startCleanup() {
+	c.cleanupTicker = time.NewTicker(c.cleanupInterval)
+
+	go func() {
+		for {
+			select {
+			case <-c.cleanupTicker.C:
+				c.cleanup()
+			case <-c.stopCleanup:
+				c.cleanupTicker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// cleanup removes expired entries one segment at a time, so only a single
+// segment is locked at any moment.
+func (c *Cache) cleanup() {
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		seg.removeExpired(time.Now().UnixNano())
+		seg.mu.Unlock()
+	}
+}
+
+// hashKey is an allocation-free FNV-1a hash of key
+func hashKey(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	hash := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= prime64
+	}
+	return hash
+}
@@ -0,0 +1,157 @@
+package bytecache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteCache_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			return NewByteCache(WithCapacity(1<<20), WithDefaultTTL(defaultTTL))
+		},
+		Advance: time.Sleep,
+	})
+}
+
+func TestByteCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("raw bytes are stored as is", func(t *testing.T) {
+		cache := NewByteCache(WithCapacity(1 << 16))
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "raw", []byte("payload"), time.Minute))
+
+		value, exists, err := cache.Get(ctx, "raw")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("payload"), value)
+	})
+
+	t.Run("non-byte values use the codec", func(t *testing.T) {
+		cache := NewByteCache(WithCapacity(1<<16), WithCodec(codec.JSON{}))
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "json", map[string]any{"id": 42}, time.Minute))
+
+		value, exists, err := cache.Get(ctx, "json")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, map[string]any{"id": float64(42)}, value)
+	})
+
+	t.Run("tombstones do not depend on the codec", func(t *testing.T) {
+		cache := NewByteCache(WithCapacity(1<<16), WithCodec(codec.JSON{}))
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "missing", cachemanager.Tombstone{}, time.Minute))
+
+		value, exists, err := cache.Get(ctx, "missing")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.True(t, cachemanager.IsTombstone(value))
+	})
+
+	t.Run("entry larger than a segment", func(t *testing.T) {
+		cache := NewByteCache(WithCapacity(1024), WithSegments(1))
+		defer cache.Close()
+
+		err := cache.Set(ctx, "big", []byte(strings.Repeat("a", 2048)), time.Minute)
+		assert.ErrorIs(t, err, ErrEntryTooLarge)
+	})
+
+	t.Run("full segment evicts oldest entries", func(t *testing.T) {
+		cache := NewByteCache(WithCapacity(1024), WithSegments(1))
+		defer cache.Close()
+
+		value := []byte(strings.Repeat("v", 100))
+		for i := 0; i < 50; i++ {
+			require.NoError(t, cache.Set(ctx, fmt.Sprintf("key%02d", i), value, time.Minute))
+		}
+
+		_, exists, err := cache.Get(ctx, "key00")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		latest, exists, err := cache.Get(ctx, "key49")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, value, latest)
+		assert.Less(t, cache.Len(), 50)
+	})
+
+	t.Run("overwrite survives eviction of the old entry", func(t *testing.T) {
+		cache := NewByteCache(WithCapacity(512), WithSegments(1))
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "key", []byte("old"), time.Minute))
+		require.NoError(t, cache.Set(ctx, "key", []byte("new"), time.Minute))
+		for i := 0; i < 10; i++ {
+			require.NoError(t, cache.Set(ctx, fmt.Sprintf("filler%d", i), []byte("x"), time.Minute))
+		}
+		require.NoError(t, cache.Set(ctx, "key", []byte("newest"), time.Minute))
+
+		value, exists, err := cache.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("newest"), value)
+	})
+
+	t.Run("cleanup drops expired entries", func(t *testing.T) {
+		cache := NewByteCache(WithCapacity(1 << 16))
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "short", "value", time.Millisecond))
+		require.NoError(t, cache.Set(ctx, "long", "value", time.Hour))
+
+		time.Sleep(2 * time.Millisecond)
+		cache.cleanup()
+		assert.Equal(t, 1, cache.Len())
+	})
+}
+
+func TestSegmentLayout(t *testing.T) {
+	count, size := segmentLayout(1024, 3)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, 256, size)
+
+	// Segments never outgrow the uint32 offsets of the index
+	if math.MaxInt > math.MaxUint32 {
+		gib := 1 << 30
+		count, size = segmentLayout(16*gib, 2)
+		assert.Equal(t, 8, count)
+		assert.Equal(t, 2*gib, size)
+	}
+
+	_, size = segmentLayout(16, 1)
+	assert.Equal(t, headerSize, size)
+}
+
+func TestByteCache_WithCacheManager(t *testing.T) {
+	ctx := context.Background()
+	l1 := NewByteCache(WithCapacity(1 << 16))
+	l2 := NewByteCache(WithCapacity(1 << 16))
+
+	cm := cachemanager.NewCacheManager(
+		cachemanager.CacheConfig{Backend: l1, TTL: time.Minute},
+		cachemanager.CacheConfig{Backend: l2, TTL: time.Hour},
+	)
+	defer cm.Close()
+
+	require.NoError(t, cm.Set(ctx, "key", "value"))
+
+	value, err := cm.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
@@ -0,0 +1,185 @@
+package bytecache
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+// Entry layout inside a segment's ring buffer:
+//
+//	expiresAt (8) | hash (8) | keyLen (2) | valueLen (4) | flags (1) | key | value
+//
+// expiresAt is in Unix nanoseconds, zero meaning the entry never expires.
+const (
+	headerSize = 8 + 8 + 2 + 4 + 1
+
+	offExpiresAt = 0
+	offHash      = 8
+	offKeyLen    = 16
+	offValueLen  = 18
+	offFlags     = 22
+)
+
+// maxSegmentSize is the largest segment whose offsets fit the uint32 values
+// of the index
+const maxSegmentSize = math.MaxUint32
+
+// segment is a fixed-size ring buffer of entries and a pointer-free index of
+// key hash to entry offset. New entries are appended at tail; when the ring is
+// full the oldest entries at head are evicted. Overwritten and deleted entries
+// stay in the ring until eviction reaches them, but are unreachable through
+// the index.
+type segment struct {
+	mu    sync.RWMutex
+	buf   []byte
+	index map[uint64]uint32
+
+	// Live data occupies [head, tail) when not wrapped, and
+	// [head, wrapAt) followed by [0, tail) when wrapped.
+	head    int
+	tail    int
+	wrapAt  int
+	wrapped bool
+}
+
+func newSegment(size int) *segment {
+	return &segment{
+		buf:   make([]byte, size),
+		index: make(map[uint64]uint32),
+	}
+}
+
+// get returns the flags and a copy of the value stored for key. expired
+// reports whether the entry was found but has expired.
+func (s *segment) get(key string, hash uint64, now int64) (flags byte, value []byte, found, expired bool) {
+	offset, ok := s.index[hash]
+	if !ok {
+		return 0, nil, false, false
+	}
+	entry := s.buf[offset:]
+	if !s.matches(entry, key) {
+		return 0, nil, false, false
+	}
+	if entryExpired(entry, now) {
+		return 0, nil, false, true
+	}
+
+	keyLen := int(binary.LittleEndian.Uint16(entry[offKeyLen:]))
+	valueLen := int(binary.LittleEndian.Uint32(entry[offValueLen:]))
+	start := headerSize + keyLen
+	value = make([]byte, valueLen)
+	copy(value, entry[start:start+valueLen])
+	return entry[offFlags], value, true, false
+}
+
+// set appends a new entry for key and points the index at it.
+func (s *segment) set(key string, hash uint64, flags byte, value []byte, expiresAt int64) {
+	size := headerSize + len(key) + len(value)
+	offset := s.alloc(size)
+
+	entry := s.buf[offset : offset+size]
+	binary.LittleEndian.PutUint64(entry[offExpiresAt:], uint64(expiresAt))
+	binary.LittleEndian.PutUint64(entry[offHash:], hash)
+	binary.LittleEndian.PutUint16(entry[offKeyLen:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(entry[offValueLen:], uint32(len(value)))
+	entry[offFlags] = flags
+	copy(entry[headerSize:], key)
+	copy(entry[headerSize+len(key):], value)
+
+	s.index[hash] = uint32(offset)
+}
+
+// del removes key from the index. It returns false if key was not present.
+func (s *segment) del(key string, hash uint64) bool {
+	offset, ok := s.index[hash]
+	if !ok || !s.matches(s.buf[offset:], key) {
+		return false
+	}
+	delete(s.index, hash)
+	return true
+}
+
+// removeExpired drops every expired entry from the index and returns how
+// many were removed. Their bytes are reclaimed when eviction reaches them.
+func (s *segment) removeExpired(now int64) int {
+	removed := 0
+	for hash, offset := range s.index {
+		if entryExpired(s.buf[offset:], now) {
+			delete(s.index, hash)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (s *segment) len() int {
+	return len(s.index)
+}
+
+// alloc reserves size contiguous bytes, evicting the oldest entries until
+// there is room. size must not exceed len(s.buf).
+func (s *segment) alloc(size int) int {
+	for {
+		if !s.wrapped {
+			if s.head == s.tail {
+				s.head, s.tail = 0, 0
+			}
+			if len(s.buf)-s.tail >= size {
+				offset := s.tail
+				s.tail += size
+				return offset
+			}
+			if s.head >= size {
+				s.wrapped = true
+				s.wrapAt = s.tail
+				s.tail = 0
+				continue
+			}
+		} else if s.head-s.tail >= size {
+			offset := s.tail
+			s.tail += size
+			return offset
+		}
+		s.evictHead()
+	}
+}
+
+// evictHead removes the oldest entry in the ring.
+func (s *segment) evictHead() {
+	entry := s.buf[s.head:]
+	hash := binary.LittleEndian.Uint64(entry[offHash:])
+	if offset, ok := s.index[hash]; ok && int(offset) == s.head {
+		delete(s.index, hash)
+	}
+
+	s.head += entrySize(entry)
+	if s.wrapped && s.head == s.wrapAt {
+		s.wrapped = false
+		s.head = 0
+	}
+}
+
+func (s *segment) matches(entry []byte, key string) bool {
+	keyLen := int(binary.LittleEndian.Uint16(entry[offKeyLen:]))
+	return keyLen == len(key) && string(entry[headerSize:headerSize+keyLen]) == key
+}
+
+func entrySize(entry []byte) int {
+	keyLen := int(binary.LittleEndian.Uint16(entry[offKeyLen:]))
+	valueLen := int(binary.LittleEndian.Uint32(entry[offValueLen:]))
+	return headerSize + keyLen + valueLen
+}
+
+func entryExpired(entry []byte, now int64) bool {
+	expiresAt := int64(binary.LittleEndian.Uint64(entry[offExpiresAt:]))
+	return expiresAt != 0 && now > expiresAt
+}
+
+func expiresAtFor(now time.Time, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now.Add(ttl).UnixNano()
+}
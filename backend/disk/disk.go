@@ -0,0 +1,684 @@
+// Package disk provides a durable cache backend storing entries in a bbolt
+// database file, for use as a tier below memory that survives restarts and
+// holds more than fits in RAM.
+package disk
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/ethan-k/cachemanager-go/internal/valuecodec"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Record layout, integers big-endian:
+//
+//	expiresAt int64 (Unix nanoseconds, 0 = no expiration)
+//	writtenAt int64 (Unix nanoseconds)
+//	flags byte | value
+const (
+	offExpiresAt  = 0
+	offWrittenAt  = 8
+	offFlags      = 16
+	recordHeader  = 17
+	expiryKeySize = 8
+)
+
+var (
+	// entriesBucket maps keys to records
+	entriesBucket = []byte("entries")
+	// expiryBucket indexes expiring keys as expiresAt | key, so expired
+	// entries are found in expiration order without a full scan
+	expiryBucket = []byte("expiry")
+)
+
+// Cache is a cache stored in a bbolt database file. Keys are also kept in
+// memory, in least recently used order, to enforce the size limit.
+type Cache struct {
+	db               *bolt.DB
+	codec            codec.Codec
+	defaultTTL       time.Duration
+	maxSize          int64
+	cleanupInterval  time.Duration
+	cleanupBatchSize int
+	stop             chan struct{}
+	done             chan struct{}
+
+	mu       sync.Mutex
+	lru      *list.List // of *lruEntry, most recently used first
+	elements map[string]*list.Element
+	size     int64
+}
+
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+// Option defines the functional option type for configuring the cache
+type Option func(*Cache)
+
+// WithCodec sets the codec used for values that are not []byte. It defaults
+// to codec.Gob.
+func WithCodec(cdc codec.Codec) Option {
+	return func(c *Cache) {
+		c.codec = cdc
+	}
+}
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithMaxSize sets the total size of keys and records, in bytes, above which
+// the least recently used entries are evicted. The database file itself
+// does not shrink; bbolt reuses the freed pages. Use 0 for no limit, the
+// default.
+func WithMaxSize(bytes int64) Option {
+	return func(c *Cache) {
+		if bytes >= 0 {
+			c.maxSize = bytes
+		}
+	}
+}
+
+// WithCleanupInterval sets the interval for reclaiming expired entries. It
+// defaults to one minute.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(c *Cache) {
+		if interval > 0 {
+			c.cleanupInterval = interval
+		}
+	}
+}
+
+// WithCleanupBatchSize sets the maximum number of expired entries removed
+// per write transaction. It defaults to 1000.
+func WithCleanupBatchSize(size int) Option {
+	return func(c *Cache) {
+		if size > 0 {
+			c.cleanupBatchSize = size
+		}
+	}
+}
+
+// NewDiskCache opens or creates the database at path. Entries written before
+// a restart are kept; recency of reads is only tracked in memory, so after a
+// restart entries are evicted in the order they were written.
+func NewDiskCache(path string, opts ...Option) (*Cache, error) {
+	cache := &Cache{
+		codec:            codec.Gob{},
+		defaultTTL:       cachemanager.NoExpiration,
+		cleanupInterval:  time.Minute,
+		cleanupBatchSize: 1000,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+		lru:              list.New(),
+		elements:         make(map[string]*list.Element),
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk cache: %w", err)
+	}
+	cache.db = db
+
+	if err := cache.load(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load disk cache: %w", err)
+	}
+	go cache.runCleanup()
+
+	return cache, nil
+}
+
+// load creates the buckets and rebuilds the in-memory LRU index
+func (c *Cache) load() error {
+	type stored struct {
+		key       string
+		size      int64
+		writtenAt int64
+	}
+	var entries []stored
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(expiryBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(entriesBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if len(v) < recordHeader {
+				return fmt.Errorf("corrupted record for key %s", k)
+			}
+			entries = append(entries, stored{
+				key:       string(k),
+				size:      int64(len(k) + len(v)),
+				writtenAt: int64(binary.BigEndian.Uint64(v[offWrittenAt:])),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].writtenAt > entries[j].writtenAt
+	})
+	for _, entry := range entries {
+		c.elements[entry.key] = c.lru.PushBack(&lruEntry{key: entry.key, size: entry.size})
+		c.size += entry.size
+	}
+	return nil
+}
+
+func (c *Cache) Get(_ context.Context, key string) (any, bool, error) {
+	var value any
+	var found bool
+	err := c.db.View(func(tx *bolt.Tx) error {
+		record := tx.Bucket(entriesBucket).Get([]byte(key))
+		if record == nil || expired(record, time.Now()) {
+			return nil
+		}
+		var err error
+		value, err = c.decode(key, record)
+		found = err == nil
+		return err
+	})
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	c.mu.Lock()
+	if elem, ok := c.elements[key]; ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	return value, true, nil
+}
+
+func (c *Cache) Set(_ context.Context, key string, value any, ttl time.Duration) error {
+	record, err := c.newRecord(key, value, ttl, time.Now())
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.update(func(tx *bolt.Tx, changes *lruChanges) error {
+		if err := c.put(tx, changes, key, record); err != nil {
+			return err
+		}
+		return c.evict(tx, changes)
+	})
+}
+
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (c *Cache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+func (c *Cache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.update(func(tx *bolt.Tx, changes *lruChanges) error {
+		return c.remove(tx, changes, key)
+	})
+}
+
+// Touch resets the expiration of key without rewriting its value. It reports
+// whether the key was found.
+func (c *Cache) Touch(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found bool
+	err = c.update(func(tx *bolt.Tx, changes *lruChanges) error {
+		now := time.Now()
+		current := tx.Bucket(entriesBucket).Get([]byte(key))
+		if current == nil || expired(current, now) {
+			return nil
+		}
+		found = true
+
+		record := make([]byte, len(current))
+		copy(record, current)
+		var expiresAt int64
+		if ttl != cachemanager.NoExpiration {
+			expiresAt = now.Add(ttl).UnixNano()
+		}
+		binary.BigEndian.PutUint64(record[offExpiresAt:], uint64(expiresAt))
+		return c.put(tx, changes, key, record)
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// GetMany returns the cached values of keys in one read transaction. Missing
+// keys are left out.
+func (c *Cache) GetMany(_ context.Context, keys []string) (map[string]any, error) {
+	found := make(map[string]any, len(keys))
+	err := c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entriesBucket)
+		now := time.Now()
+		for _, key := range keys {
+			record := bucket.Get([]byte(key))
+			if record == nil || expired(record, now) {
+				continue
+			}
+			value, err := c.decode(key, record)
+			if err != nil {
+				return err
+			}
+			found[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	for key := range found {
+		if elem, ok := c.elements[key]; ok {
+			c.lru.MoveToFront(elem)
+		}
+	}
+	c.mu.Unlock()
+	return found, nil
+}
+
+// SetMany stores values in one write transaction
+func (c *Cache) SetMany(_ context.Context, values map[string]any, ttl time.Duration) error {
+	now := time.Now()
+	records := make(map[string][]byte, len(values))
+	for key, value := range values {
+		record, err := c.newRecord(key, value, ttl, now)
+		if err != nil {
+			return err
+		}
+		records[key] = record
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.update(func(tx *bolt.Tx, changes *lruChanges) error {
+		for key, record := range records {
+			if err := c.put(tx, changes, key, record); err != nil {
+				return err
+			}
+		}
+		return c.evict(tx, changes)
+	})
+}
+
+// DeleteMany removes keys in one write transaction
+func (c *Cache) DeleteMany(_ context.Context, keys []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.update(func(tx *bolt.Tx, changes *lruChanges) error {
+		for _, key := range keys {
+			if err := c.remove(tx, changes, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Clear removes every entry from the cache.
+func (c *Cache) Clear(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, expiryBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.lru.Init()
+	c.elements = make(map[string]*list.Element)
+	c.size = 0
+	return nil
+}
+
+// DeletePrefix removes every entry whose key starts with prefix. Keys are
+// stored sorted, so only the matching range is visited.
+func (c *Cache) DeletePrefix(_ context.Context, prefix string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.update(func(tx *bolt.Tx, changes *lruChanges) error {
+		var keys []string
+		cursor := tx.Bucket(entriesBucket).Cursor()
+		for k, _ := cursor.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = cursor.Next() {
+			keys = append(keys, string(k))
+		}
+		for _, key := range keys {
+			if err := c.remove(tx, changes, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Size returns the total size of the stored keys and records in bytes
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *Cache) GetInvalidationChannel() <-chan string {
+	return nil
+}
+
+// Close stops the background cleanup and closes the database
+func (c *Cache) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.db.Close()
+}
+
+// lruChanges records how a write transaction changes the LRU index. They
+// are applied once the transaction commits, so that a transaction rolled
+// back leaves the index matching the database.
+type lruChanges struct {
+	puts    []string         // keys written, least recent first
+	sizes   map[string]int64 // sizes of the keys written
+	removed map[string]bool  // keys removed
+	delta   int64            // change of the total size
+}
+
+func newLRUChanges() *lruChanges {
+	return &lruChanges{sizes: make(map[string]int64), removed: make(map[string]bool)}
+}
+
+// update runs fn in a write transaction and applies the LRU changes it
+// records after the transaction commits. The caller must hold c.mu.
+func (c *Cache) update(fn func(tx *bolt.Tx, changes *lruChanges) error) error {
+	changes := newLRUChanges()
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		return fn(tx, changes)
+	})
+	if err != nil {
+		return err
+	}
+
+	for key := range changes.removed {
+		if elem, ok := c.elements[key]; ok {
+			c.lru.Remove(elem)
+			delete(c.elements, key)
+		}
+	}
+	for _, key := range changes.puts {
+		size := changes.sizes[key]
+		if elem, ok := c.elements[key]; ok {
+			elem.Value.(*lruEntry).size = size
+			c.lru.MoveToFront(elem)
+		} else {
+			c.elements[key] = c.lru.PushFront(&lruEntry{key: key, size: size})
+		}
+	}
+	c.size += changes.delta
+	return nil
+}
+
+// sizeOf returns the size of key as of the changes recorded so far
+func (c *Cache) sizeOf(changes *lruChanges, key string) (int64, bool) {
+	if size, ok := changes.sizes[key]; ok {
+		return size, true
+	}
+	if changes.removed[key] {
+		return 0, false
+	}
+	if elem, ok := c.elements[key]; ok {
+		return elem.Value.(*lruEntry).size, true
+	}
+	return 0, false
+}
+
+// forget drops key from the keys written by the transaction
+func (changes *lruChanges) forget(key string) {
+	if _, ok := changes.sizes[key]; !ok {
+		return
+	}
+	delete(changes.sizes, key)
+	for i, written := range changes.puts {
+		if written == key {
+			changes.puts = append(changes.puts[:i], changes.puts[i+1:]...)
+			break
+		}
+	}
+}
+
+// put stores record under key, replacing its expiry index entry, and
+// records the move of key to the front of the LRU list. The caller must
+// hold c.mu.
+func (c *Cache) put(tx *bolt.Tx, changes *lruChanges, key string, record []byte) error {
+	bucket := tx.Bucket(entriesBucket)
+	expiry := tx.Bucket(expiryBucket)
+	if current := bucket.Get([]byte(key)); current != nil {
+		if err := expiry.Delete(expiryKey(key, current)); err != nil {
+			return err
+		}
+	}
+	if err := bucket.Put([]byte(key), record); err != nil {
+		return err
+	}
+	if expiresAt(record) != 0 {
+		if err := expiry.Put(expiryKey(key, record), nil); err != nil {
+			return err
+		}
+	}
+
+	if size, ok := c.sizeOf(changes, key); ok {
+		changes.delta -= size
+	}
+	changes.forget(key)
+	size := int64(len(key) + len(record))
+	changes.puts = append(changes.puts, key)
+	changes.sizes[key] = size
+	changes.delta += size
+	return nil
+}
+
+// remove deletes key and its expiry index entry, and records its removal
+// from the LRU list. The caller must hold c.mu.
+func (c *Cache) remove(tx *bolt.Tx, changes *lruChanges, key string) error {
+	bucket := tx.Bucket(entriesBucket)
+	current := bucket.Get([]byte(key))
+	if current == nil {
+		return nil
+	}
+	if err := tx.Bucket(expiryBucket).Delete(expiryKey(key, current)); err != nil {
+		return err
+	}
+	if err := bucket.Delete([]byte(key)); err != nil {
+		return err
+	}
+
+	if size, ok := c.sizeOf(changes, key); ok {
+		changes.delta -= size
+	}
+	changes.forget(key)
+	changes.removed[key] = true
+	return nil
+}
+
+// evict removes least recently used entries until the cache fits its size
+// limit, always keeping the most recent entry. Entries untouched by the
+// transaction go first, then those it wrote, oldest first. The caller must
+// hold c.mu.
+func (c *Cache) evict(tx *bolt.Tx, changes *lruChanges) error {
+	if c.maxSize == 0 {
+		return nil
+	}
+	elem := c.lru.Back()
+	for c.size+changes.delta > c.maxSize {
+		var victim string
+		switch {
+		case elem != nil:
+			victim = elem.Value.(*lruEntry).key
+			elem = elem.Prev()
+			if _, written := changes.sizes[victim]; written || changes.removed[victim] {
+				continue
+			}
+		case len(changes.puts) > 1:
+			victim = changes.puts[0]
+		default:
+			return nil
+		}
+		if err := c.remove(tx, changes, victim); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) runCleanup() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = c.cleanup()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// cleanup removes expired entries in batches of at most cleanupBatchSize,
+// one write transaction per batch.
+func (c *Cache) cleanup() error {
+	for {
+		removed, err := c.removeExpired(time.Now(), c.cleanupBatchSize)
+		if err != nil || removed < c.cleanupBatchSize {
+			return err
+		}
+	}
+}
+
+// removeExpired removes up to limit entries that expired before now, walking
+// the expiry index from the oldest expiration, and returns how many were
+// removed.
+func (c *Cache) removeExpired(now time.Time, limit int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var removed int
+	err := c.update(func(tx *bolt.Tx, changes *lruChanges) error {
+		var keys []string
+		cursor := tx.Bucket(expiryBucket).Cursor()
+		for k, _ := cursor.First(); k != nil && len(keys) < limit; k, _ = cursor.Next() {
+			if int64(binary.BigEndian.Uint64(k)) >= now.UnixNano() {
+				break
+			}
+			keys = append(keys, string(k[expiryKeySize:]))
+		}
+		for _, key := range keys {
+			if err := c.remove(tx, changes, key); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	return removed, err
+}
+
+func (c *Cache) newRecord(key string, value any, ttl time.Duration, now time.Time) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("disk cache keys must not be empty")
+	}
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	flag, data, err := valuecodec.Encode(c.codec, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	var expiresAt int64
+	if ttl != cachemanager.NoExpiration {
+		expiresAt = now.Add(ttl).UnixNano()
+	}
+	record := make([]byte, recordHeader+len(data))
+	binary.BigEndian.PutUint64(record[offExpiresAt:], uint64(expiresAt))
+	binary.BigEndian.PutUint64(record[offWrittenAt:], uint64(now.UnixNano()))
+	record[offFlags] = byte(flag)
+	copy(record[recordHeader:], data)
+	return record, nil
+}
+
+// decode returns the value of record. It must be called inside the
+// transaction the record was read in.
+func (c *Cache) decode(key string, record []byte) (any, error) {
+	data := record[recordHeader:]
+	flag := valuecodec.Flag(record[offFlags])
+	if flag == valuecodec.Raw {
+		// bbolt memory is only valid during the transaction
+		data = bytes.Clone(data)
+	}
+	return valuecodec.Decode(c.codec, key, flag, data)
+}
+
+func expiresAt(record []byte) int64 {
+	return int64(binary.BigEndian.Uint64(record[offExpiresAt:]))
+}
+
+func expired(record []byte, now time.Time) bool {
+	at := expiresAt(record)
+	return at != 0 && now.UnixNano() >= at
+}
+
+// expiryKey returns the expiry index key of key stored as record
+func expiryKey(key string, record []byte) []byte {
+	k := make([]byte, expiryKeySize+len(key))
+	binary.BigEndian.PutUint64(k, uint64(expiresAt(record)))
+	copy(k[expiryKeySize:], key)
+	return k
+}
@@ -0,0 +1,182 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/inmemory"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newCache(t *testing.T, opts ...Option) (*Cache, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	cache, err := NewDiskCache(path, opts...)
+	require.NoError(t, err)
+	return cache, path
+}
+
+func TestDiskCache_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			cache, _ := newCache(t, WithDefaultTTL(defaultTTL))
+			return cache
+		},
+		Advance: time.Sleep,
+	})
+	cachetest.RunPersistent(t, cachetest.PersistentHarness{
+		Open: func(t *testing.T, path string) cachemanager.CacheBackend {
+			cache, err := NewDiskCache(path, WithCodec(codec.JSON{}))
+			require.NoError(t, err)
+			return cache
+		},
+	})
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("the size is rebuilt after a restart and reset by clear", func(t *testing.T) {
+		cache, path := newCache(t)
+		require.NoError(t, cache.Set(ctx, "key:1", "a", time.Hour))
+		require.NoError(t, cache.Set(ctx, "key:2", []byte("b"), cachemanager.NoExpiration))
+		size := cache.Size()
+		require.NoError(t, cache.Close())
+
+		cache, err := NewDiskCache(path)
+		require.NoError(t, err)
+		defer cache.Close()
+		assert.Equal(t, size, cache.Size())
+
+		require.NoError(t, cache.Clear(ctx))
+		assert.Zero(t, cache.Size())
+	})
+
+	t.Run("the size limit evicts the least recently used entries", func(t *testing.T) {
+		probe, _ := newCache(t)
+		require.NoError(t, probe.Set(ctx, "key:0", []byte("0123456789"), time.Hour))
+		entrySize := probe.Size()
+		require.NoError(t, probe.Close())
+
+		cache, _ := newCache(t, WithMaxSize(3*entrySize))
+		defer cache.Close()
+		for i := 0; i < 3; i++ {
+			require.NoError(t, cache.Set(ctx, fmt.Sprintf("key:%d", i), []byte("0123456789"), time.Hour))
+		}
+		// Reading key:0 makes key:1 the least recently used
+		_, found, err := cache.Get(ctx, "key:0")
+		require.NoError(t, err)
+		require.True(t, found)
+
+		require.NoError(t, cache.Set(ctx, "key:3", []byte("0123456789"), time.Hour))
+		for key, expected := range map[string]bool{"key:0": true, "key:1": false, "key:2": true, "key:3": true} {
+			_, found, err := cache.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, expected, found, key)
+		}
+		assert.LessOrEqual(t, cache.Size(), 3*entrySize)
+	})
+
+	t.Run("rolled back transactions leave the index unchanged", func(t *testing.T) {
+		cache, _ := newCache(t)
+		defer cache.Close()
+		require.NoError(t, cache.Set(ctx, "kept", "v", time.Hour))
+		size := cache.Size()
+
+		record, err := cache.newRecord("new", "v", time.Hour, time.Now())
+		require.NoError(t, err)
+		failed := errors.New("failed")
+		err = cache.update(func(tx *bolt.Tx, changes *lruChanges) error {
+			if err := cache.put(tx, changes, "new", record); err != nil {
+				return err
+			}
+			if err := cache.remove(tx, changes, "kept"); err != nil {
+				return err
+			}
+			return failed
+		})
+		assert.ErrorIs(t, err, failed)
+
+		assert.Equal(t, size, cache.Size())
+		assert.Len(t, cache.elements, 1)
+		assert.Contains(t, cache.elements, "kept")
+		_, found, err := cache.Get(ctx, "kept")
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+
+	t.Run("expired entries are reclaimed in the background", func(t *testing.T) {
+		cache, _ := newCache(t, WithCleanupInterval(10*time.Millisecond), WithCleanupBatchSize(2))
+		defer cache.Close()
+		for i := 0; i < 5; i++ {
+			require.NoError(t, cache.Set(ctx, fmt.Sprintf("short:%d", i), "v", 5*time.Millisecond))
+		}
+		require.NoError(t, cache.Set(ctx, "long", "v", time.Hour))
+
+		assert.Eventually(t, func() bool {
+			var entries, expiring int
+			_ = cache.db.View(func(tx *bolt.Tx) error {
+				entries = tx.Bucket(entriesBucket).Stats().KeyN
+				expiring = tx.Bucket(expiryBucket).Stats().KeyN
+				return nil
+			})
+			return entries == 1 && expiring == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("touch moves the expiry index entry", func(t *testing.T) {
+		cache, _ := newCache(t)
+		defer cache.Close()
+		require.NoError(t, cache.Set(ctx, "key", "v", 20*time.Millisecond))
+
+		found, err := cache.Touch(ctx, "key", time.Hour)
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		time.Sleep(30 * time.Millisecond)
+		removed, err := cache.removeExpired(time.Now(), 10)
+		require.NoError(t, err)
+		assert.Zero(t, removed)
+		_, found, err = cache.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+}
+
+func TestCacheManager_MemoryOverDisk(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	disk, err := NewDiskCache(path)
+	require.NoError(t, err)
+	cm := cachemanager.NewCacheManager(
+		cachemanager.CacheConfig{Backend: inmemory.NewInMemoryCache(), TTL: time.Minute},
+		cachemanager.CacheConfig{Backend: disk, TTL: time.Hour},
+	)
+	require.NoError(t, cm.Set(ctx, "report", "data"))
+	require.NoError(t, cm.Close())
+
+	// After a restart the memory tier is cold but the disk tier is not
+	disk, err = NewDiskCache(path)
+	require.NoError(t, err)
+	cm = cachemanager.NewCacheManager(
+		cachemanager.CacheConfig{Backend: inmemory.NewInMemoryCache(), TTL: time.Minute},
+		cachemanager.CacheConfig{Backend: disk, TTL: time.Hour},
+	)
+	defer cm.Close()
+
+	value, err := cm.GetOrLoad(ctx, "report", func(context.Context) (any, error) {
+		return nil, fmt.Errorf("must not reach the origin")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "data", value)
+}
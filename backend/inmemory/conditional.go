@@ -0,0 +1,78 @@
+package inmemory
+
+import (
+	"context"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// SetNX stores value only if key is missing or expired and reports whether
+// it did.
+func (c *Cache) SetNX(_ context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, exists := c.data[key]; exists && !entry.expired(now) {
+		return false, nil
+	}
+	c.storeConditional(key, value, ttl, now)
+	return true, nil
+}
+
+// CompareAndSwap stores value only if key still has expectedVersion, as
+// returned by GetWithVersion, and reports whether it did.
+func (c *Cache) CompareAndSwap(_ context.Context, key string, expectedVersion uint64, value any, ttl time.Duration) (bool, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry, exists := c.data[key]
+	if !exists || entry.expired(now) || entry.version != expectedVersion {
+		return false, nil
+	}
+	c.storeConditional(key, value, ttl, now)
+	return true, nil
+}
+
+// GetWithVersion returns the value of key and its version. Every write gives
+// the entry a new version, drawn from a counter shared by all keys so a key
+// that is deleted and written again never reuses one.
+func (c *Cache) GetWithVersion(_ context.Context, key string) (any, uint64, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.data[key]
+	if !exists || entry.expired(time.Now()) {
+		return nil, 0, false, nil
+	}
+	return entry.value, entry.version, true, nil
+}
+
+// storeConditional stores a value written by SetNX or CompareAndSwap. The
+// caller must hold the write lock.
+func (c *Cache) storeConditional(key string, value any, ttl time.Duration, now time.Time) {
+	var expiresAt time.Time
+	if ttl != cachemanager.NoExpiration {
+		expiresAt = now.Add(ttl)
+	}
+	c.storeEntry(key, value, ttl, expiresAt, now, nil)
+}
+
+// nextVersion returns a version no entry has had yet. The caller must hold
+// the write lock.
+func (c *Cache) nextVersion() uint64 {
+	c.lastVersion++
+	return c.lastVersion
+}
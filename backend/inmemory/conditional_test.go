@@ -0,0 +1,76 @@
+package inmemory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryCache_ConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("set NX only stores missing or expired keys", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		stored, err := cache.SetNX(ctx, "key", "first", 20*time.Millisecond)
+		require.NoError(t, err)
+		assert.True(t, stored)
+		stored, err = cache.SetNX(ctx, "key", "second", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		time.Sleep(30 * time.Millisecond)
+		stored, err = cache.SetNX(ctx, "key", "third", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, stored)
+	})
+
+	t.Run("every write changes the version", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "key", "a", time.Minute))
+		_, version, found, err := cache.GetWithVersion(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, found)
+
+		stored, err := cache.CompareAndSwap(ctx, "key", version, "b", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, stored)
+		stored, err = cache.CompareAndSwap(ctx, "key", version, "c", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		// Deleting and writing again does not bring the old version back
+		_, current, _, _ := cache.GetWithVersion(ctx, "key")
+		require.NoError(t, cache.Delete(ctx, "key"))
+		require.NoError(t, cache.Set(ctx, "key", "d", time.Minute))
+		stored, err = cache.CompareAndSwap(ctx, "key", current, "e", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		_, err = cache.Incr(ctx, "count", 1, time.Minute)
+		require.NoError(t, err)
+		_, before, _, _ := cache.GetWithVersion(ctx, "count")
+		_, err = cache.Incr(ctx, "count", 1, time.Minute)
+		require.NoError(t, err)
+		_, after, _, _ := cache.GetWithVersion(ctx, "count")
+		assert.NotEqual(t, before, after)
+	})
+
+	t.Run("missing keys cannot be swapped", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		_, _, found, err := cache.GetWithVersion(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, found)
+		stored, err := cache.CompareAndSwap(ctx, "key", 0, "value", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, stored)
+	})
+}
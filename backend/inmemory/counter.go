@@ -0,0 +1,40 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// Incr adds delta to the int64 counter at key and returns the new value. A
+// missing counter starts from 0 and expires after ttl; incrementing an
+// existing counter keeps its expiration.
+func (c *Cache) Incr(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, exists := c.data[key]; exists && !entry.expired(now) {
+		count, ok := entry.value.(int64)
+		if !ok {
+			return 0, fmt.Errorf("key %s: %w", key, cachemanager.ErrNotCounter)
+		}
+		entry.value = count + delta
+		entry.version = c.nextVersion()
+		return count + delta, nil
+	}
+
+	var expiresAt time.Time
+	if ttl != cachemanager.NoExpiration {
+		expiresAt = now.Add(ttl)
+	}
+	c.storeEntry(key, delta, ttl, expiresAt, now, nil)
+	return delta, nil
+}
@@ -0,0 +1,66 @@
+package inmemory
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryCache_Incr(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("concurrent increments are not lost", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					_, err := cache.Incr(ctx, "views", 1, time.Minute)
+					assert.NoError(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		value, found, err := cache.Get(ctx, "views")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, int64(5000), value)
+	})
+
+	t.Run("the TTL is set when the counter is created", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		_, err := cache.Incr(ctx, "window", 1, 50*time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(30 * time.Millisecond)
+		value, err := cache.Incr(ctx, "window", 1, 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), value)
+
+		// The second increment did not extend the window
+		time.Sleep(30 * time.Millisecond)
+		value, err = cache.Incr(ctx, "window", 1, 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), value)
+	})
+
+	t.Run("values that are not counters are rejected", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "name", "text", time.Minute))
+		_, err := cache.Incr(ctx, "name", 1, time.Minute)
+		assert.ErrorIs(t, err, cachemanager.ErrNotCounter)
+	})
+}
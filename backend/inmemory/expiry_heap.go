@@ -0,0 +1,67 @@
+package inmemory
+
+// expiryHeap is a min-heap of entries ordered by expiresAt. It implements
+// heap.Interface and keeps each entry's heapIndex up to date so entries can be
+// removed or re-positioned in O(log n) when they are overwritten or deleted.
+type expiryHeap []*cacheEntry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].expiresAt.Before(h[j].expiresAt)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	entry := x.(*cacheEntry)
+	entry.heapIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.heapIndex = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// fillLeaseHeap is a min-heap of fill leases ordered by expiresAt, kept like
+// expiryHeap so leases can be removed in O(log n) when they are used,
+// released or revoked.
+type fillLeaseHeap []*fillLease
+
+func (h fillLeaseHeap) Len() int { return len(h) }
+
+func (h fillLeaseHeap) Less(i, j int) bool {
+	return h[i].expiresAt.Before(h[j].expiresAt)
+}
+
+func (h fillLeaseHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *fillLeaseHeap) Push(x any) {
+	lease := x.(*fillLease)
+	lease.heapIndex = len(*h)
+	*h = append(*h, lease)
+}
+
+func (h *fillLeaseHeap) Pop() any {
+	old := *h
+	n := len(old)
+	lease := old[n-1]
+	old[n-1] = nil
+	lease.heapIndex = -1
+	*h = old[:n-1]
+	return lease
+}
@@ -1,22 +1,41 @@
 package inmemory
 
 import (
+	"container/heap"
 	"container/list"
 	"context"
 	"sync"
 	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
 )
 
 // Cache represents an in-memory cache
 type Cache struct {
-	mu              sync.RWMutex
-	data            map[string]cacheEntry
-	ageList         *list.List
-	ageElements     map[string]*list.Element
-	cleanupTicker   *time.Ticker
-	stopCleanup     chan struct{}
-	cleanupInterval time.Duration
-	maxEntries      int
+	mu                   sync.RWMutex
+	data                 map[string]*cacheEntry
+	ageList              *list.List
+	ageElements          map[string]*list.Element
+	expiry               expiryHeap
+	cleanupTicker        *time.Ticker
+	stop                 chan struct{}
+	cleanupInterval      time.Duration
+	cleanupBatchSize     int
+	maxEntries           int
+	defaultTTL           time.Duration
+	codec                codec.Codec
+	snapshotPath         string
+	snapshotInterval     time.Duration
+	snapshotErrorHandler func(err error)
+	sliding              bool
+	tags                 map[string]map[string]struct{}
+	keys                 prefixIndex
+	fillLeases           map[string]*fillLease
+	fillLeaseExpiry      fillLeaseHeap
+	fillLeaseTTL         time.Duration
+	lastFillToken        uint64
+	lastVersion          uint64
 }
 
 type ageEntry struct {
@@ -24,10 +43,17 @@ type ageEntry struct {
 	createdAt time.Time
 }
 
+// cacheEntry is a cached value. A zero expiresAt means the entry never
+// expires; such entries are not tracked by the expiry heap.
 type cacheEntry struct {
+	key       string
 	value     any
 	expiresAt time.Time
 	createdAt time.Time
+	ttl       time.Duration
+	tags      []string
+	version   uint64
+	heapIndex int
 }
 
 // Option defines the functional option type for configuring the cache
@@ -42,6 +68,42 @@ func WithCleanupInterval(interval time.Duration) Option {
 	}
 }
 
+// WithCleanupBatchSize sets the maximum number of expired entries removed
+// while holding the write lock. The lock is released between batches so
+// readers and writers are not stalled by a large expiration wave.
+func WithCleanupBatchSize(size int) Option {
+	return func(c *Cache) {
+		if size > 0 {
+			c.cleanupBatchSize = size
+		}
+	}
+}
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithCodec sets the codec used to encode values in snapshots. It defaults
+// to codec.Gob.
+func WithCodec(cdc codec.Codec) Option {
+	return func(c *Cache) {
+		c.codec = cdc
+	}
+}
+
+// WithSlidingExpiration makes every successful Get reset the entry's
+// expiration to the TTL it was written with, so entries that keep being read
+// never expire.
+func WithSlidingExpiration() Option {
+	return func(c *Cache) {
+		c.sliding = true
+	}
+}
+
 // WithMaxEntries sets the maximum number of entries in the cache
 // Use -1 for unlimited entries
 func WithMaxEntries(max int) Option {
@@ -52,83 +114,227 @@ func WithMaxEntries(max int) Option {
 
 func NewInMemoryCache(opts ...Option) *Cache {
 	cache := &Cache{
-		data:            make(map[string]cacheEntry),
-		ageList:         list.New(),
-		ageElements:     make(map[string]*list.Element),
-		cleanupInterval: 5 * time.Minute,
-		maxEntries:      -1,
-		stopCleanup:     make(chan struct{}),
+		data:             make(map[string]*cacheEntry),
+		ageList:          list.New(),
+		ageElements:      make(map[string]*list.Element),
+		tags:             make(map[string]map[string]struct{}),
+		fillLeases:       make(map[string]*fillLease),
+		fillLeaseTTL:     defaultFillLeaseTTL,
+		cleanupInterval:  5 * time.Minute,
+		cleanupBatchSize: 1000,
+		maxEntries:       -1,
+		defaultTTL:       cachemanager.NoExpiration,
+		codec:            codec.Gob{},
+		stop:             make(chan struct{}),
 	}
 
 	for _, opt := range opts {
 		opt(cache)
 	}
 
+	if cache.snapshotPath != "" {
+		// A missing or corrupted snapshot only means a cold start
+		_ = cache.loadSnapshotFile()
+		cache.startSnapshots()
+	}
 	cache.startCleanup()
 
 	return cache
 }
 
 func (c *Cache) Get(_ context.Context, key string) (any, bool, error) {
+	if c.sliding {
+		return c.getAndTouch(key)
+	}
+
+	c.mu.RLock()
 	entry, exists := c.data[key]
 	if !exists {
+		c.mu.RUnlock()
 		return nil, false, nil
 	}
-
-	if time.Now().After(entry.expiresAt) {
-		delete(c.data, key)
+	value, expired := entry.value, entry.expired(time.Now())
+	c.mu.RUnlock()
+
+	if expired {
+		c.mu.Lock()
+		// Re-check under the write lock: the entry may have been replaced.
+		if current, ok := c.data[key]; ok && current == entry {
+			c.removeEntry(key)
+		}
+		c.mu.Unlock()
 		return nil, false, nil
 	}
 
-	return entry.value, true, nil
+	return value, true, nil
 }
 
 func (c *Cache) Set(_ context.Context, key string, value any, ttl time.Duration) error {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	now := time.Now()
+	var expiresAt time.Time
+	if ttl != cachemanager.NoExpiration {
+		expiresAt = now.Add(ttl)
+	}
+	c.storeEntry(key, value, ttl, expiresAt, now, nil)
 
-	if elem, exists := c.ageElements[key]; exists {
-		c.ageList.Remove(elem)
-		delete(c.ageElements, key)
+	return nil
+}
+
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (c *Cache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+// getAndTouch is Get for sliding expiration: a hit pushes the entry's
+// expiration out by its TTL.
+func (c *Cache) getAndTouch(key string) (any, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.data[key]
+	if !exists {
+		return nil, false, nil
+	}
+	now := time.Now()
+	if entry.expired(now) {
+		c.removeEntry(key)
+		return nil, false, nil
+	}
+	c.resetExpiry(entry, entry.ttl, now)
+	return entry.value, true, nil
+}
+
+// Touch resets the expiration of key to ttl from now without rewriting its
+// value. It reports whether the key was found.
+func (c *Cache) Touch(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.data[key]
+	if !exists {
+		return false, nil
+	}
+	now := time.Now()
+	if entry.expired(now) {
+		c.removeEntry(key)
+		return false, nil
+	}
+	c.resetExpiry(entry, ttl, now)
+	return true, nil
+}
+
+// resetExpiry sets entry to expire ttl after now and moves it within the
+// expiry heap. The caller must hold the write lock.
+func (c *Cache) resetExpiry(entry *cacheEntry, ttl time.Duration, now time.Time) {
+	entry.ttl = ttl
+	if ttl == cachemanager.NoExpiration {
+		entry.expiresAt = time.Time{}
+		if entry.heapIndex >= 0 {
+			heap.Remove(&c.expiry, entry.heapIndex)
+		}
+		return
+	}
+
+	entry.expiresAt = now.Add(ttl)
+	if entry.heapIndex >= 0 {
+		heap.Fix(&c.expiry, entry.heapIndex)
+	} else {
+		heap.Push(&c.expiry, entry)
+	}
+}
+
+// storeEntry inserts or replaces key as the newest entry, evicting the oldest
+// entry if the cache is full, and gives it a new version. The caller must
+// hold the write lock.
+func (c *Cache) storeEntry(key string, value any, ttl time.Duration, expiresAt, createdAt time.Time, tags []string) {
+	if _, exists := c.data[key]; exists {
+		c.removeEntry(key)
 	} else if c.maxEntries > 0 && len(c.data) >= c.maxEntries {
 		if oldest := c.ageList.Front(); oldest != nil {
-			oldestKey := oldest.Value.(ageEntry).key
-			c.ageList.Remove(oldest)
-			delete(c.ageElements, oldestKey)
-			delete(c.data, oldestKey)
+			c.removeEntry(oldest.Value.(ageEntry).key)
 		}
 	}
 
-	c.data[key] = cacheEntry{
+	entry := &cacheEntry{
+		key:       key,
 		value:     value,
-		expiresAt: now.Add(ttl),
-		createdAt: now,
+		expiresAt: expiresAt,
+		createdAt: createdAt,
+		ttl:       ttl,
+		tags:      tags,
+		version:   c.nextVersion(),
+		heapIndex: -1,
+	}
+	if !expiresAt.IsZero() {
+		heap.Push(&c.expiry, entry)
 	}
+	c.data[key] = entry
+	c.keys.insert(key)
+	c.indexTags(entry)
 
 	elem := c.ageList.PushBack(ageEntry{
 		key:       key,
-		createdAt: now,
+		createdAt: createdAt,
 	})
 	c.ageElements[key] = elem
-
-	return nil
 }
 
+// Delete removes key and revokes its outstanding fill lease, so a value
+// loaded before the delete cannot be written back with SetWithLease.
 func (c *Cache) Delete(ctx context.Context, key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.data, key)
+	c.removeEntry(key)
+	c.removeFillLease(key)
 	return nil
 }
 
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// removeEntry drops key from the data map, the age list and the expiry heap.
+// The caller must hold the write lock.
+func (c *Cache) removeEntry(key string) {
+	entry, exists := c.data[key]
+	if !exists {
+		return
+	}
+	delete(c.data, key)
+	c.keys.remove(key)
+	c.unindexTags(entry)
+	if entry.heapIndex >= 0 {
+		heap.Remove(&c.expiry, entry.heapIndex)
+	}
+	if elem, ok := c.ageElements[key]; ok {
+		c.ageList.Remove(elem)
+		delete(c.ageElements, key)
+	}
+}
+
 func (c *Cache) GetInvalidationChannel() <-chan string {
 	return nil
 }
 
 func (c *Cache) Close() error {
-	close(c.stopCleanup)
+	close(c.stop)
+	if c.snapshotPath != "" {
+		return c.saveSnapshotFile()
+	}
 	return nil
 }
 
@@ -140,7 +346,7 @@ func (c *Cache) startCleanup() {
 			select {
 			case <-c.cleanupTicker.C:
 				c.cleanup()
-			case <-c.stopCleanup:
+			case <-c.stop:
 				c.cleanupTicker.Stop()
 				return
 			}
@@ -148,45 +354,35 @@ func (c *Cache) startCleanup() {
 	}()
 }
 
+// cleanup removes expired fill leases and entries in batches of at most
+// cleanupBatchSize, releasing the write lock between batches.
 func (c *Cache) cleanup() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	now := time.Now()
-	var expiredKeys []string
-
-	for key, entry := range c.data {
-		if now.After(entry.expiresAt) {
-			expiredKeys = append(expiredKeys, key)
+	for {
+		if c.removeExpiredFillLeases(time.Now(), c.cleanupBatchSize) < c.cleanupBatchSize {
+			break
 		}
 	}
-
-	for _, key := range expiredKeys {
-		delete(c.data, key)
-	}
-
-	if c.maxEntries > 0 && len(c.data) > c.maxEntries {
-		type keyAge struct {
-			key       string
-			createdAt time.Time
-		}
-		entries := make([]keyAge, 0, len(c.data))
-
-		for key, entry := range c.data {
-			entries = append(entries, keyAge{key, entry.createdAt})
+	for {
+		if c.removeExpired(time.Now(), c.cleanupBatchSize) < c.cleanupBatchSize {
+			return
 		}
+	}
+}
 
-		for i := 0; i < len(entries)-1; i++ {
-			for j := 0; j < len(entries)-i-1; j++ {
-				if entries[j+1].createdAt.Before(entries[j].createdAt) {
-					entries[j], entries[j+1] = entries[j+1], entries[j]
-				}
-			}
-		}
+// removeExpired pops up to limit entries that expired before now from the
+// expiry heap and returns how many were removed.
+func (c *Cache) removeExpired(now time.Time, limit int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		numToRemove := len(c.data) - c.maxEntries
-		for i := 0; i < numToRemove && i < len(entries); i++ {
-			delete(c.data, entries[i].key)
+	removed := 0
+	for removed < limit && c.expiry.Len() > 0 {
+		entry := c.expiry[0]
+		if !now.After(entry.expiresAt) {
+			break
 		}
+		c.removeEntry(entry.key)
+		removed++
 	}
+	return removed
 }
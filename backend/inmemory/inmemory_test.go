@@ -2,9 +2,12 @@ package inmemory
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/cachetest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -55,3 +58,105 @@ func TestInMemoryCache(t *testing.T) {
 		assert.Nil(t, value)
 	})
 }
+
+func TestInMemoryCache_Cleanup(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("removes only expired entries", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "short", "value", time.Millisecond))
+		require.NoError(t, cache.Set(ctx, "long", "value", time.Hour))
+
+		time.Sleep(time.Millisecond * 2)
+		cache.cleanup()
+
+		cache.mu.RLock()
+		defer cache.mu.RUnlock()
+		assert.NotContains(t, cache.data, "short")
+		assert.Contains(t, cache.data, "long")
+		assert.Equal(t, 1, cache.expiry.Len())
+		assert.Equal(t, 1, cache.ageList.Len())
+	})
+
+	t.Run("removes expired entries in bounded batches", func(t *testing.T) {
+		cache := NewInMemoryCache(WithCleanupBatchSize(2))
+		defer cache.Close()
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, cache.Set(ctx, fmt.Sprintf("key%d", i), i, time.Millisecond))
+		}
+		time.Sleep(time.Millisecond * 2)
+
+		removed := cache.removeExpired(time.Now(), cache.cleanupBatchSize)
+		assert.Equal(t, 2, removed)
+
+		cache.cleanup()
+		cache.mu.RLock()
+		defer cache.mu.RUnlock()
+		assert.Empty(t, cache.data)
+		assert.Equal(t, 0, cache.expiry.Len())
+	})
+
+	t.Run("overwrite reschedules expiry", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "key", "old", time.Millisecond))
+		require.NoError(t, cache.Set(ctx, "key", "new", time.Hour))
+
+		time.Sleep(time.Millisecond * 2)
+		cache.cleanup()
+
+		value, exists, err := cache.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "new", value)
+	})
+
+	t.Run("max entries evicts oldest", func(t *testing.T) {
+		cache := NewInMemoryCache(WithMaxEntries(2))
+		defer cache.Close()
+
+		require.NoError(t, cache.Set(ctx, "a", 1, time.Hour))
+		require.NoError(t, cache.Set(ctx, "b", 2, time.Hour))
+		require.NoError(t, cache.Set(ctx, "c", 3, time.Hour))
+
+		_, exists, err := cache.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		assert.Equal(t, 2, cache.expiry.Len())
+	})
+}
+
+func TestInMemoryCache_SlidingExpiration(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(WithSlidingExpiration())
+	defer cache.Close()
+
+	require.NoError(t, cache.Set(ctx, "session", "data", 60*time.Millisecond))
+
+	// Keep reading before the TTL runs out; the entry must stay alive
+	for i := 0; i < 4; i++ {
+		time.Sleep(30 * time.Millisecond)
+		value, exists, err := cache.Get(ctx, "session")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, "data", value)
+	}
+
+	time.Sleep(90 * time.Millisecond)
+	_, exists, err := cache.Get(ctx, "session")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestInMemoryCache_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			return NewInMemoryCache(WithDefaultTTL(defaultTTL))
+		},
+		Advance: time.Sleep,
+	})
+}
@@ -0,0 +1,134 @@
+package inmemory
+
+import (
+	"container/heap"
+	"context"
+	"strings"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// defaultFillLeaseTTL is how long a fill lease handed out on a miss stays
+// valid
+const defaultFillLeaseTTL = 10 * time.Second
+
+// fillLease is the outstanding memcache-style lease on a missing key
+type fillLease struct {
+	key       string
+	token     uint64
+	expiresAt time.Time
+	// heapIndex is the position of the lease in the fill lease heap
+	heapIndex int
+}
+
+// WithFillLeaseTTL sets how long the token handed out by GetWithLease on a
+// miss stays valid. It defaults to 10 seconds.
+func WithFillLeaseTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		if ttl > 0 {
+			c.fillLeaseTTL = ttl
+		}
+	}
+}
+
+// GetWithLease returns the value of key. On a miss it hands out a fill token
+// for SetWithLease, or 0 if another caller holds an unexpired one.
+func (c *Cache) GetWithLease(ctx context.Context, key string) (any, bool, uint64, error) {
+	value, found, err := c.Get(ctx, key)
+	if err != nil || found {
+		return value, found, 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if lease, ok := c.fillLeases[key]; ok && now.Before(lease.expiresAt) {
+		return nil, false, 0, nil
+	}
+	c.lastFillToken++
+	c.removeFillLease(key)
+	lease := &fillLease{key: key, token: c.lastFillToken, expiresAt: now.Add(c.fillLeaseTTL)}
+	c.fillLeases[key] = lease
+	heap.Push(&c.fillLeaseExpiry, lease)
+	return nil, false, c.lastFillToken, nil
+}
+
+// SetWithLease stores value if token is still the outstanding fill lease on
+// key, which Delete revokes. It reports whether the value was stored.
+func (c *Cache) SetWithLease(_ context.Context, key string, value any, ttl time.Duration, token uint64) (bool, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	lease, ok := c.fillLeases[key]
+	if !ok || lease.token != token || !now.Before(lease.expiresAt) {
+		return false, nil
+	}
+	c.removeFillLease(key)
+
+	var expiresAt time.Time
+	if ttl != cachemanager.NoExpiration {
+		expiresAt = now.Add(ttl)
+	}
+	c.storeEntry(key, value, ttl, expiresAt, now, nil)
+	return true, nil
+}
+
+// ReleaseFillLease drops the fill lease on key if token is still the
+// outstanding one
+func (c *Cache) ReleaseFillLease(_ context.Context, key string, token uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if lease, ok := c.fillLeases[key]; ok && lease.token == token {
+		c.removeFillLease(key)
+	}
+	return nil
+}
+
+// removeFillLease drops the fill lease on key, if any, from the lease map and
+// the fill lease heap. The caller must hold the write lock.
+func (c *Cache) removeFillLease(key string) {
+	lease, ok := c.fillLeases[key]
+	if !ok {
+		return
+	}
+	delete(c.fillLeases, key)
+	heap.Remove(&c.fillLeaseExpiry, lease.heapIndex)
+}
+
+// revokeFillLeases drops the fill leases on keys starting with prefix. The
+// caller must hold the write lock.
+func (c *Cache) revokeFillLeases(prefix string) {
+	for key := range c.fillLeases {
+		if strings.HasPrefix(key, prefix) {
+			c.removeFillLease(key)
+		}
+	}
+}
+
+// removeExpiredFillLeases pops up to limit fill leases that were never used
+// and expired before now from the fill lease heap and returns how many were
+// removed.
+func (c *Cache) removeExpiredFillLeases(now time.Time, limit int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for removed < limit && c.fillLeaseExpiry.Len() > 0 {
+		lease := c.fillLeaseExpiry[0]
+		if now.Before(lease.expiresAt) {
+			break
+		}
+		c.removeFillLease(lease.key)
+		removed++
+	}
+	return removed
+}
@@ -0,0 +1,133 @@
+package inmemory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryCache_FillLease(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("a miss hands out one token at a time", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		_, found, token, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.NotZero(t, token)
+
+		_, _, second, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		assert.Zero(t, second)
+
+		stored, err := cache.SetWithLease(ctx, "key", "value", time.Minute, token)
+		require.NoError(t, err)
+		assert.True(t, stored)
+
+		value, found, token, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "value", value)
+		assert.Zero(t, token)
+	})
+
+	t.Run("delete revokes the token", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		_, _, token, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		require.NoError(t, cache.Delete(ctx, "key"))
+
+		stored, err := cache.SetWithLease(ctx, "key", "stale", time.Minute, token)
+		require.NoError(t, err)
+		assert.False(t, stored)
+		_, found, _ := cache.Get(ctx, "key")
+		assert.False(t, found)
+
+		// The next miss gets a fresh token
+		_, _, fresh, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		assert.NotZero(t, fresh)
+		assert.NotEqual(t, token, fresh)
+	})
+
+	t.Run("released tokens free the key for the next miss", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		_, _, token, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		require.NoError(t, cache.ReleaseFillLease(ctx, "key", token+1))
+		_, _, blocked, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		assert.Zero(t, blocked, "only the outstanding token is released")
+
+		require.NoError(t, cache.ReleaseFillLease(ctx, "key", token))
+		_, _, fresh, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		assert.NotZero(t, fresh)
+	})
+
+	t.Run("prefix deletion and tag invalidation revoke tokens", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		_, _, prefixed, err := cache.GetWithLease(ctx, "user:1")
+		require.NoError(t, err)
+		_, _, other, err := cache.GetWithLease(ctx, "order:1")
+		require.NoError(t, err)
+		require.NoError(t, cache.DeletePrefix(ctx, "user:"))
+
+		stored, err := cache.SetWithLease(ctx, "user:1", "stale", time.Minute, prefixed)
+		require.NoError(t, err)
+		assert.False(t, stored)
+		stored, err = cache.SetWithLease(ctx, "order:1", "value", time.Minute, other)
+		require.NoError(t, err)
+		assert.True(t, stored)
+
+		require.NoError(t, cache.SetWithTags(ctx, "tagged", "v", time.Minute, []string{"t"}))
+		require.NoError(t, cache.Delete(ctx, "tagged"))
+		_, _, tagged, err := cache.GetWithLease(ctx, "tagged")
+		require.NoError(t, err)
+		require.NoError(t, cache.SetWithTags(ctx, "tagged", "v", time.Minute, []string{"t"}))
+		_, err = cache.InvalidateTags(ctx, "t")
+		require.NoError(t, err)
+		stored, err = cache.SetWithLease(ctx, "tagged", "stale", time.Minute, tagged)
+		require.NoError(t, err)
+		assert.False(t, stored)
+	})
+
+	t.Run("expired tokens are rejected and reclaimed", func(t *testing.T) {
+		cache := NewInMemoryCache(WithFillLeaseTTL(10 * time.Millisecond))
+		defer cache.Close()
+
+		_, _, token, err := cache.GetWithLease(ctx, "key")
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+
+		stored, err := cache.SetWithLease(ctx, "key", "late", time.Minute, token)
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		_, _, fresh, err := cache.GetWithLease(ctx, "fresh")
+		require.NoError(t, err)
+		assert.Equal(t, 1, cache.removeExpiredFillLeases(time.Now(), 10))
+		cache.mu.RLock()
+		assert.Len(t, cache.fillLeases, 1)
+		assert.Len(t, cache.fillLeaseExpiry, 1)
+		cache.mu.RUnlock()
+		stored, err = cache.SetWithLease(ctx, "fresh", "value", time.Minute, fresh)
+		require.NoError(t, err)
+		assert.True(t, stored)
+		cache.mu.RLock()
+		defer cache.mu.RUnlock()
+		assert.Empty(t, cache.fillLeases)
+		assert.Empty(t, cache.fillLeaseExpiry)
+	})
+}
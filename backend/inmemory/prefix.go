@@ -0,0 +1,155 @@
+package inmemory
+
+import (
+	"container/list"
+	"context"
+)
+
+// Clear removes every entry from the cache.
+func (c *Cache) Clear(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.data = make(map[string]*cacheEntry)
+	c.ageList = list.New()
+	c.ageElements = make(map[string]*list.Element)
+	c.expiry = nil
+	c.tags = make(map[string]map[string]struct{})
+	c.keys = prefixIndex{}
+	c.fillLeases = make(map[string]*fillLease)
+	c.fillLeaseExpiry = nil
+	return nil
+}
+
+// DeletePrefix removes every entry whose key starts with prefix.
+func (c *Cache) DeletePrefix(_ context.Context, prefix string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range c.keys.withPrefix(prefix) {
+		c.removeEntry(key)
+	}
+	c.revokeFillLeases(prefix)
+	return nil
+}
+
+// prefixIndex is a radix tree of the cached keys, so that the keys sharing a
+// prefix can be found without scanning the whole cache.
+type prefixIndex struct {
+	root prefixNode
+}
+
+// prefixNode is an edge of the radix tree. A node's key is the concatenation
+// of the labels from the root down to it; leaf marks nodes whose key is
+// cached. Sibling labels never share a first byte.
+type prefixNode struct {
+	label    string
+	leaf     bool
+	children []*prefixNode
+}
+
+// child returns the child whose label starts with b, and its position.
+func (n *prefixNode) child(b byte) (*prefixNode, int) {
+	for i, child := range n.children {
+		if child.label[0] == b {
+			return child, i
+		}
+	}
+	return nil, -1
+}
+
+func (idx *prefixIndex) insert(key string) {
+	n := &idx.root
+	for key != "" {
+		child, i := n.child(key[0])
+		if child == nil {
+			n.children = append(n.children, &prefixNode{label: key, leaf: true})
+			return
+		}
+
+		common := commonPrefixLen(child.label, key)
+		if common < len(child.label) {
+			// Split the edge where key diverges from it
+			split := &prefixNode{label: child.label[:common], children: []*prefixNode{child}}
+			child.label = child.label[common:]
+			n.children[i] = split
+			child = split
+		}
+		n, key = child, key[common:]
+	}
+	n.leaf = true
+}
+
+func (idx *prefixIndex) remove(key string) {
+	// path holds the nodes from the root down to key's node
+	path := []*prefixNode{&idx.root}
+	n := &idx.root
+	for key != "" {
+		child, _ := n.child(key[0])
+		if child == nil || commonPrefixLen(child.label, key) < len(child.label) {
+			return
+		}
+		path = append(path, child)
+		n, key = child, key[len(child.label):]
+	}
+	if !n.leaf {
+		return
+	}
+	n.leaf = false
+
+	// Drop the node if it is now empty, then merge whatever is left with a
+	// single child so the tree stays compact
+	if len(path) > 1 && len(n.children) == 0 {
+		parent := path[len(path)-2]
+		_, i := parent.child(n.label[0])
+		parent.children = append(parent.children[:i], parent.children[i+1:]...)
+		n = parent
+		path = path[:len(path)-1]
+	}
+	if len(path) > 1 && !n.leaf && len(n.children) == 1 {
+		only := n.children[0]
+		n.label += only.label
+		n.leaf = only.leaf
+		n.children = only.children
+	}
+}
+
+// withPrefix returns every indexed key starting with prefix.
+func (idx *prefixIndex) withPrefix(prefix string) []string {
+	n, base := &idx.root, ""
+	for prefix != "" {
+		child, _ := n.child(prefix[0])
+		if child == nil {
+			return nil
+		}
+		common := commonPrefixLen(child.label, prefix)
+		switch {
+		case common == len(prefix):
+			// prefix ends inside this edge; everything below matches
+			return child.collect(base+child.label, nil)
+		case common < len(child.label):
+			return nil
+		}
+		n, base, prefix = child, base+child.label, prefix[common:]
+	}
+	return n.collect(base, nil)
+}
+
+// collect appends the keys of n and its descendants, where key is n's key.
+func (n *prefixNode) collect(key string, keys []string) []string {
+	if n.leaf {
+		keys = append(keys, key)
+	}
+	for _, child := range n.children {
+		keys = child.collect(key+child.label, keys)
+	}
+	return keys
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
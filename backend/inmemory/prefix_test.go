@@ -0,0 +1,123 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixIndex(t *testing.T) {
+	var idx prefixIndex
+	for _, key := range []string{"user:1", "user:10", "user:2", "users", "order:1", "u"} {
+		idx.insert(key)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"", []string{"order:1", "u", "user:1", "user:10", "user:2", "users"}},
+		{"user:", []string{"user:1", "user:10", "user:2"}},
+		{"user:1", []string{"user:1", "user:10"}},
+		{"us", []string{"user:1", "user:10", "user:2", "users"}},
+		{"users", []string{"users"}},
+		{"usersx", nil},
+		{"x", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			keys := idx.withPrefix(tt.prefix)
+			sort.Strings(keys)
+			assert.Equal(t, tt.expected, keys)
+		})
+	}
+
+	idx.remove("user:1")
+	idx.remove("user:1") // removing twice is a no-op
+	idx.remove("use")    // not a key, only a prefix
+	keys := idx.withPrefix("user")
+	sort.Strings(keys)
+	assert.Equal(t, []string{"user:10", "user:2", "users"}, keys)
+}
+
+func TestPrefixIndex_MatchesScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var idx prefixIndex
+	keys := make(map[string]bool)
+
+	randomKey := func() string {
+		var b strings.Builder
+		for n := rng.Intn(6); n >= 0; n-- {
+			b.WriteByte("ab:"[rng.Intn(3)])
+		}
+		return b.String()
+	}
+
+	for i := 0; i < 2000; i++ {
+		key := randomKey()
+		if keys[key] {
+			idx.remove(key)
+			delete(keys, key)
+		} else {
+			idx.insert(key)
+			keys[key] = true
+		}
+
+		prefix := randomKey()
+		prefix = prefix[:rng.Intn(len(prefix)+1)]
+		var expected []string
+		for key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				expected = append(expected, key)
+			}
+		}
+		got := idx.withPrefix(prefix)
+		sort.Strings(expected)
+		sort.Strings(got)
+		require.Equal(t, expected, got, "prefix %q after %d operations", prefix, i)
+	}
+
+	for key := range keys {
+		idx.remove(key)
+	}
+	assert.Empty(t, idx.root.children, "removing every key should leave an empty tree")
+}
+
+func TestInMemoryCache_DeletePrefixAndClear(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(WithMaxEntries(100))
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, cache.Set(ctx, fmt.Sprintf("user:%d", i), i, time.Minute))
+		require.NoError(t, cache.Set(ctx, fmt.Sprintf("order:%d", i), i, time.Minute))
+	}
+
+	require.NoError(t, cache.DeletePrefix(ctx, "user:"))
+	for i := 0; i < 10; i++ {
+		_, exists, _ := cache.Get(ctx, fmt.Sprintf("user:%d", i))
+		assert.False(t, exists)
+		_, exists, _ = cache.Get(ctx, fmt.Sprintf("order:%d", i))
+		assert.True(t, exists)
+	}
+
+	require.NoError(t, cache.Clear(ctx))
+	_, exists, _ := cache.Get(ctx, "order:1")
+	assert.False(t, exists)
+	assert.Empty(t, cache.keys.withPrefix(""))
+
+	// The cache stays usable after Clear
+	require.NoError(t, cache.SetWithTags(ctx, "user:1", "alice", time.Millisecond, []string{"user"}))
+	value, exists, _ := cache.Get(ctx, "user:1")
+	assert.True(t, exists)
+	assert.Equal(t, "alice", value)
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 1, cache.removeExpired(time.Now(), 10))
+}
@@ -0,0 +1,383 @@
+package inmemory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// Snapshot format, all integers little-endian:
+//
+//	magic "CMSS" | version uint16 | entry count uint64
+//	entries, oldest first:
+//	  key length uvarint | key | createdAt varint (Unix nanoseconds)
+//	  remaining TTL varint (nanoseconds, 0 = no expiration)
+//	  TTL varint (nanoseconds)
+//	  tag count uvarint, then per tag: length uvarint | tag
+//	  value length uvarint | value encoded with the cache codec
+//	CRC-32C of everything above uint32
+const (
+	snapshotMagic   = "CMSS"
+	snapshotVersion = 1
+)
+
+var (
+	// ErrInvalidSnapshot is returned when a snapshot is truncated, corrupted or
+	// was written in an unknown format.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// SkippedEntriesError is returned by SaveSnapshot when the values of some
+// entries could not be encoded. The snapshot is still complete without them.
+type SkippedEntriesError struct {
+	// Count is the number of entries left out
+	Count int
+	// Err is the error of the first entry left out
+	Err error
+}
+
+func (e *SkippedEntriesError) Error() string {
+	return fmt.Sprintf("snapshot skipped %d entries: %v", e.Count, e.Err)
+}
+
+func (e *SkippedEntriesError) Unwrap() error {
+	return e.Err
+}
+
+// WithSnapshotFile enables warm restarts from path. The snapshot is loaded
+// when the cache is created, written every interval (if positive) and
+// written again when the cache is closed. A missing or unreadable snapshot
+// file at startup leaves the cache empty.
+func WithSnapshotFile(path string, interval time.Duration) Option {
+	return func(c *Cache) {
+		c.snapshotPath = path
+		c.snapshotInterval = interval
+	}
+}
+
+// WithSnapshotErrorHandler sets a function called with the errors of the
+// periodic snapshots, including a *SkippedEntriesError when some values
+// could not be encoded. Without it these errors are dropped.
+func WithSnapshotErrorHandler(handler func(err error)) Option {
+	return func(c *Cache) {
+		c.snapshotErrorHandler = handler
+	}
+}
+
+type snapshotEntry struct {
+	key       string
+	value     any
+	createdAt time.Time
+	expiresAt time.Time
+	ttl       time.Duration
+	tags      []string
+}
+
+// SaveSnapshot writes every live entry to w in eviction order, together with
+// its remaining TTL. Entries whose value the codec cannot encode are left
+// out and reported with a *SkippedEntriesError once the snapshot is written.
+func (c *Cache) SaveSnapshot(w io.Writer) error {
+	now := time.Now()
+
+	c.mu.RLock()
+	entries := make([]snapshotEntry, 0, len(c.data))
+	for elem := c.ageList.Front(); elem != nil; elem = elem.Next() {
+		entry := c.data[elem.Value.(ageEntry).key]
+		// Cached misses are short-lived and not worth a codec round trip
+		if entry == nil || entry.expired(now) || cachemanager.IsTombstone(entry.value) {
+			continue
+		}
+		entries = append(entries, snapshotEntry{
+			key:       entry.key,
+			value:     entry.value,
+			createdAt: entry.createdAt,
+			expiresAt: entry.expiresAt,
+			ttl:       entry.ttl,
+			tags:      entry.tags,
+		})
+	}
+	c.mu.RUnlock()
+
+	values := make([][]byte, 0, len(entries))
+	var skipped *SkippedEntriesError
+	kept := entries[:0]
+	for _, entry := range entries {
+		value, err := c.codec.Marshal(entry.value)
+		if err != nil {
+			if skipped == nil {
+				skipped = &SkippedEntriesError{Err: fmt.Errorf("failed to encode value for key %s: %w", entry.key, err)}
+			}
+			skipped.Count++
+			continue
+		}
+		kept = append(kept, entry)
+		values = append(values, value)
+	}
+	entries = kept
+
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(w)
+	out := io.MultiWriter(bw, crc)
+
+	header := make([]byte, 0, len(snapshotMagic)+2+8)
+	header = append(header, snapshotMagic...)
+	header = binary.LittleEndian.AppendUint16(header, snapshotVersion)
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(entries)))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	var buf []byte
+	for i, entry := range entries {
+		value := values[i]
+
+		var remaining time.Duration
+		if !entry.expiresAt.IsZero() {
+			// Never write 0 for an expiring entry; it would mean no expiration
+			remaining = max(entry.expiresAt.Sub(now), 1)
+		}
+
+		buf = binary.AppendUvarint(buf[:0], uint64(len(entry.key)))
+		buf = append(buf, entry.key...)
+		buf = binary.AppendVarint(buf, entry.createdAt.UnixNano())
+		buf = binary.AppendVarint(buf, int64(remaining))
+		buf = binary.AppendVarint(buf, int64(entry.ttl))
+		buf = binary.AppendUvarint(buf, uint64(len(entry.tags)))
+		for _, tag := range entry.tags {
+			buf = binary.AppendUvarint(buf, uint64(len(tag)))
+			buf = append(buf, tag...)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+		if _, err := out.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	if err := binary.Write(bw, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if skipped != nil {
+		return skipped
+	}
+	return nil
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot and adds its entries
+// to the cache, replacing entries with the same key. Nothing is loaded unless
+// the whole snapshot is valid.
+func (c *Cache) LoadSnapshot(r io.Reader) error {
+	crc := crc32.New(crcTable)
+	br := bufio.NewReader(r)
+	in := &snapshotReader{r: io.TeeReader(br, crc)}
+
+	magic := in.bytes(len(snapshotMagic))
+	version := in.uint16()
+	count := in.uint64()
+	if in.err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, in.err)
+	}
+	if string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+
+	type loadedEntry struct {
+		key       string
+		value     []byte
+		createdAt int64
+		remaining int64
+		ttl       int64
+		tags      []string
+	}
+	var loaded []loadedEntry
+	for i := uint64(0); i < count && in.err == nil; i++ {
+		var entry loadedEntry
+		entry.key = string(in.bytes(int(in.uvarint())))
+		entry.createdAt = in.varint()
+		entry.remaining = in.varint()
+		entry.ttl = in.varint()
+		tagCount := in.uvarint()
+		for j := uint64(0); j < tagCount && in.err == nil; j++ {
+			entry.tags = append(entry.tags, string(in.bytes(int(in.uvarint()))))
+		}
+		entry.value = in.bytes(int(in.uvarint()))
+		loaded = append(loaded, entry)
+	}
+	if in.err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, in.err)
+	}
+
+	expected := crc.Sum32()
+	var checksum uint32
+	if err := binary.Read(br, binary.LittleEndian, &checksum); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if checksum != expected {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	values := make([]any, len(loaded))
+	for i, entry := range loaded {
+		value, err := c.codec.Unmarshal(entry.value)
+		if err != nil {
+			return fmt.Errorf("failed to decode value for key %s: %w", entry.key, err)
+		}
+		values[i] = value
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, entry := range loaded {
+		var expiresAt time.Time
+		if entry.remaining > 0 {
+			expiresAt = now.Add(time.Duration(entry.remaining))
+		}
+		c.storeEntry(entry.key, values[i], time.Duration(entry.ttl), expiresAt, time.Unix(0, entry.createdAt), entry.tags)
+	}
+	return nil
+}
+
+// saveSnapshotFile atomically replaces the snapshot file. A snapshot with
+// skipped entries still replaces it, and the *SkippedEntriesError is
+// returned afterwards.
+func (c *Cache) saveSnapshotFile() error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.snapshotPath), filepath.Base(c.snapshotPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var skipped *SkippedEntriesError
+	if err := c.SaveSnapshot(tmp); err != nil && !errors.As(err, &skipped) {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.snapshotPath); err != nil {
+		return err
+	}
+	if skipped != nil {
+		return skipped
+	}
+	return nil
+}
+
+func (c *Cache) loadSnapshotFile() error {
+	f, err := os.Open(c.snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.LoadSnapshot(f)
+}
+
+func (c *Cache) startSnapshots() {
+	if c.snapshotInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.snapshotInterval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.saveSnapshotFile(); err != nil && c.snapshotErrorHandler != nil {
+					c.snapshotErrorHandler(err)
+				}
+			case <-c.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// snapshotReader decodes snapshot fields, remembering the first error so the
+// caller only has to check once.
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *snapshotReader) bytes(n int) []byte {
+	if s.err != nil {
+		return nil
+	}
+	if n < 0 {
+		s.err = errors.New("negative length")
+		return nil
+	}
+	// Read through a LimitReader rather than allocating n bytes up front, so
+	// a corrupted length cannot trigger a huge allocation
+	buf, err := io.ReadAll(io.LimitReader(s.r, int64(n)))
+	if err == nil && len(buf) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	s.err = err
+	return buf
+}
+
+func (s *snapshotReader) uint16() uint16 {
+	buf := s.bytes(2)
+	if s.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(buf)
+}
+
+func (s *snapshotReader) uint64() uint64 {
+	buf := s.bytes(8)
+	if s.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(buf)
+}
+
+func (s *snapshotReader) uvarint() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	var v uint64
+	v, s.err = binary.ReadUvarint(s)
+	return v
+}
+
+func (s *snapshotReader) varint() int64 {
+	if s.err != nil {
+		return 0
+	}
+	var v int64
+	v, s.err = binary.ReadVarint(s)
+	return v
+}
+
+// ReadByte lets binary.ReadUvarint and binary.ReadVarint consume s.
+func (s *snapshotReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(s.r, b[:])
+	return b[0], err
+}
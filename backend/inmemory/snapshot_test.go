@@ -0,0 +1,209 @@
+package inmemory
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("round trip keeps values, TTLs and eviction order", func(t *testing.T) {
+		source := NewInMemoryCache()
+		defer source.Close()
+
+		require.NoError(t, source.Set(ctx, "first", "a", time.Hour))
+		require.NoError(t, source.Set(ctx, "second", 2, 0))
+		require.NoError(t, source.Set(ctx, "third", []byte("c"), time.Hour))
+		require.NoError(t, source.Set(ctx, "expired", "gone", time.Millisecond))
+		time.Sleep(2 * time.Millisecond)
+
+		var buf bytes.Buffer
+		require.NoError(t, source.SaveSnapshot(&buf))
+
+		target := NewInMemoryCache(WithMaxEntries(3))
+		defer target.Close()
+		require.NoError(t, target.LoadSnapshot(&buf))
+
+		for key, expected := range map[string]any{"first": "a", "second": 2, "third": []byte("c")} {
+			value, exists, err := target.Get(ctx, key)
+			require.NoError(t, err)
+			assert.True(t, exists, key)
+			assert.Equal(t, expected, value)
+		}
+		_, exists, err := target.Get(ctx, "expired")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		target.mu.RLock()
+		assert.True(t, target.data["second"].expiresAt.IsZero())
+		remaining := time.Until(target.data["first"].expiresAt)
+		target.mu.RUnlock()
+		assert.InDelta(t, time.Hour, remaining, float64(time.Minute))
+
+		// "first" is still the oldest entry and is evicted first
+		require.NoError(t, target.Set(ctx, "fourth", "d", time.Hour))
+		_, exists, err = target.Get(ctx, "first")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("tombstones are not saved", func(t *testing.T) {
+		source := NewInMemoryCache(WithCodec(codec.JSON{}))
+		defer source.Close()
+		require.NoError(t, source.Set(ctx, "missing", cachemanager.Tombstone{}, time.Hour))
+		require.NoError(t, source.Set(ctx, "present", "value", time.Hour))
+
+		var buf bytes.Buffer
+		require.NoError(t, source.SaveSnapshot(&buf))
+
+		target := NewInMemoryCache(WithCodec(codec.JSON{}))
+		defer target.Close()
+		require.NoError(t, target.LoadSnapshot(&buf))
+
+		_, exists, err := target.Get(ctx, "missing")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		_, exists, err = target.Get(ctx, "present")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("corrupted snapshot is rejected", func(t *testing.T) {
+		source := NewInMemoryCache()
+		defer source.Close()
+		require.NoError(t, source.Set(ctx, "key", "value", time.Hour))
+
+		var buf bytes.Buffer
+		require.NoError(t, source.SaveSnapshot(&buf))
+		data := buf.Bytes()
+		data[len(data)-6] ^= 0xFF
+
+		target := NewInMemoryCache()
+		defer target.Close()
+		err := target.LoadSnapshot(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+		_, exists, err := target.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("truncated snapshot is rejected", func(t *testing.T) {
+		source := NewInMemoryCache()
+		defer source.Close()
+		require.NoError(t, source.Set(ctx, "key", "value", time.Hour))
+
+		var buf bytes.Buffer
+		require.NoError(t, source.SaveSnapshot(&buf))
+
+		target := NewInMemoryCache()
+		defer target.Close()
+		err := target.LoadSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+		assert.ErrorIs(t, err, ErrInvalidSnapshot)
+	})
+
+	t.Run("unknown versions are rejected", func(t *testing.T) {
+		source := NewInMemoryCache()
+		defer source.Close()
+
+		var buf bytes.Buffer
+		require.NoError(t, source.SaveSnapshot(&buf))
+		data := buf.Bytes()
+		data[len(snapshotMagic)] = snapshotVersion + 1
+
+		target := NewInMemoryCache()
+		defer target.Close()
+		err := target.LoadSnapshot(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrInvalidSnapshot)
+		assert.ErrorContains(t, err, "unsupported version 2")
+	})
+
+	t.Run("values the codec cannot encode are skipped", func(t *testing.T) {
+		source := NewInMemoryCache()
+		defer source.Close()
+		require.NoError(t, source.Set(ctx, "kept", "value", time.Hour))
+		require.NoError(t, source.Set(ctx, "channel", make(chan int), time.Hour))
+		require.NoError(t, source.Set(ctx, "func", func() {}, time.Hour))
+
+		var buf bytes.Buffer
+		err := source.SaveSnapshot(&buf)
+		var skipped *SkippedEntriesError
+		require.ErrorAs(t, err, &skipped)
+		assert.Equal(t, 2, skipped.Count)
+
+		target := NewInMemoryCache()
+		defer target.Close()
+		require.NoError(t, target.LoadSnapshot(&buf))
+		value, exists, err := target.Get(ctx, "kept")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "value", value)
+	})
+
+	t.Run("snapshot file is written on close and loaded on start", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+		first := NewInMemoryCache(WithSnapshotFile(path, 0))
+		require.NoError(t, first.Set(ctx, "key", "value", time.Hour))
+		require.NoError(t, first.Close())
+
+		second := NewInMemoryCache(WithSnapshotFile(path, 0))
+		defer second.Close()
+		value, exists, err := second.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "value", value)
+	})
+
+	t.Run("snapshot file is written periodically", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+		cache := NewInMemoryCache(WithSnapshotFile(path, 10*time.Millisecond))
+		defer cache.Close()
+		require.NoError(t, cache.Set(ctx, "key", "value", time.Hour))
+
+		assert.Eventually(t, func() bool {
+			restored := NewInMemoryCache()
+			defer restored.Close()
+			f, err := os.Open(path)
+			if err != nil {
+				return false
+			}
+			defer f.Close()
+			if err := restored.LoadSnapshot(f); err != nil {
+				return false
+			}
+			_, exists, _ := restored.Get(ctx, "key")
+			return exists
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("periodic snapshot errors reach the handler", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing", "cache.snapshot")
+		errs := make(chan error, 1)
+		cache := NewInMemoryCache(WithSnapshotFile(path, 10*time.Millisecond), WithSnapshotErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+		defer cache.Close()
+
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		case <-time.After(time.Second):
+			t.Fatal("the handler was not called")
+		}
+	})
+}
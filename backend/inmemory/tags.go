@@ -0,0 +1,71 @@
+package inmemory
+
+import (
+	"context"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// SetWithTags stores value like Set and associates the entry with tags, so
+// it can later be removed by InvalidateTags.
+func (c *Cache) SetWithTags(_ context.Context, key string, value any, ttl time.Duration, tags []string) error {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var expiresAt time.Time
+	if ttl != cachemanager.NoExpiration {
+		expiresAt = now.Add(ttl)
+	}
+	c.storeEntry(key, value, ttl, expiresAt, now, append([]string(nil), tags...))
+
+	return nil
+}
+
+// InvalidateTags removes every entry tagged with any of tags and returns the
+// removed keys.
+func (c *Cache) InvalidateTags(_ context.Context, tags ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var keys []string
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			c.removeEntry(key)
+			c.removeFillLease(key)
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// indexTags adds entry to the reverse index of each of its tags. The caller
+// must hold the write lock.
+func (c *Cache) indexTags(entry *cacheEntry) {
+	for _, tag := range entry.tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[entry.key] = struct{}{}
+	}
+}
+
+// unindexTags removes entry from the reverse index of each of its tags. The
+// caller must hold the write lock.
+func (c *Cache) unindexTags(entry *cacheEntry) {
+	for _, tag := range entry.tags {
+		keys := c.tags[tag]
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package inmemory
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryCache_Tags(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("invalidate removes every tagged entry", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		require.NoError(t, cache.SetWithTags(ctx, "profile:42", "p", time.Minute, []string{"user:42"}))
+		require.NoError(t, cache.SetWithTags(ctx, "orders:42", "o", time.Minute, []string{"user:42", "orders"}))
+		require.NoError(t, cache.SetWithTags(ctx, "profile:7", "p", time.Minute, []string{"user:7"}))
+
+		keys, err := cache.InvalidateTags(ctx, "user:42")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"profile:42", "orders:42"}, keys)
+
+		for _, key := range []string{"profile:42", "orders:42"} {
+			_, exists, err := cache.Get(ctx, key)
+			require.NoError(t, err)
+			assert.False(t, exists, key)
+		}
+		_, exists, err := cache.Get(ctx, "profile:7")
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		cache.mu.RLock()
+		defer cache.mu.RUnlock()
+		assert.NotContains(t, cache.tags, "user:42")
+		assert.NotContains(t, cache.tags, "orders")
+	})
+
+	t.Run("overwrite replaces tags", func(t *testing.T) {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+
+		require.NoError(t, cache.SetWithTags(ctx, "key", "v1", time.Minute, []string{"old"}))
+		require.NoError(t, cache.Set(ctx, "key", "v2", time.Minute))
+
+		keys, err := cache.InvalidateTags(ctx, "old")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+
+		value, exists, err := cache.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "v2", value)
+	})
+
+	t.Run("tags survive snapshots", func(t *testing.T) {
+		source := NewInMemoryCache()
+		defer source.Close()
+		require.NoError(t, source.SetWithTags(ctx, "key", "value", time.Minute, []string{"tag"}))
+
+		var buf bytes.Buffer
+		require.NoError(t, source.SaveSnapshot(&buf))
+
+		target := NewInMemoryCache()
+		defer target.Close()
+		require.NoError(t, target.LoadSnapshot(&buf))
+
+		keys, err := target.InvalidateTags(ctx, "tag")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"key"}, keys)
+	})
+}
@@ -0,0 +1,178 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	crlf = []byte("\r\n")
+
+	// errCacheMiss is returned by the storage commands when the key is missing
+	errCacheMiss = errors.New("memcached: cache miss")
+	// errNotStored is returned when add, cas or touch did not apply
+	errNotStored = errors.New("memcached: not stored")
+)
+
+// ErrServer is wrapped by the errors reported by a memcached server.
+var ErrServer = errors.New("memcached server error")
+
+// conn is a connection to one memcached server speaking the text protocol
+type conn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+// pool keeps idle connections to one server for reuse
+type pool struct {
+	addr        string
+	dialTimeout time.Duration
+	maxIdle     int
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("memcached: cache is closed")
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: p.dialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{nc: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}, nil
+}
+
+// put returns c to the pool, closing it if the pool is full or closed
+func (p *pool) put(c *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.maxIdle {
+		c.nc.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, c := range p.idle {
+		c.nc.Close()
+	}
+	p.idle = nil
+}
+
+// value is an item read by get or gets
+type value struct {
+	flags uint32
+	cas   uint64
+	data  []byte
+}
+
+// writeStorage writes a set, add or cas command. cas is only sent for cas.
+func (c *conn) writeStorage(cmd, key string, flags uint32, exptime int64, data []byte, cas uint64) error {
+	line := cmd + " " + key + " " + strconv.FormatUint(uint64(flags), 10) + " " +
+		strconv.FormatInt(exptime, 10) + " " + strconv.Itoa(len(data))
+	if cmd == "cas" {
+		line += " " + strconv.FormatUint(cas, 10)
+	}
+	if _, err := c.rw.WriteString(line + "\r\n"); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(data); err != nil {
+		return err
+	}
+	_, err := c.rw.Write(crlf)
+	return err
+}
+
+// readStorageReply reads the reply to a storage, delete or touch command
+func (c *conn) readStorageReply() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	switch line {
+	case "STORED", "DELETED", "TOUCHED":
+		return nil
+	case "NOT_STORED", "EXISTS":
+		return errNotStored
+	case "NOT_FOUND":
+		return errCacheMiss
+	default:
+		return replyError(line)
+	}
+}
+
+// readValues reads the items returned by get or gets up to END
+func (c *conn) readValues(fn func(key string, v value)) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+		fields := bytes.Fields([]byte(line))
+		if len(fields) < 4 || string(fields[0]) != "VALUE" {
+			return replyError(line)
+		}
+		flags, err1 := strconv.ParseUint(string(fields[2]), 10, 32)
+		size, err2 := strconv.Atoi(string(fields[3]))
+		if err := errors.Join(err1, err2); err != nil {
+			return fmt.Errorf("memcached: malformed reply %q: %w", line, err)
+		}
+		var cas uint64
+		if len(fields) > 4 {
+			if cas, err = strconv.ParseUint(string(fields[4]), 10, 64); err != nil {
+				return fmt.Errorf("memcached: malformed reply %q: %w", line, err)
+			}
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.rw, data); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(data, crlf) {
+			return fmt.Errorf("memcached: malformed value for key %s", fields[1])
+		}
+		fn(string(fields[1]), value{flags: uint32(flags), cas: cas, data: data[:size]})
+	}
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.rw.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(line, crlf)), nil
+}
+
+// replyError converts an unexpected reply to an error
+func replyError(line string) error {
+	return fmt.Errorf("%w: %s", ErrServer, line)
+}
@@ -0,0 +1,479 @@
+// Package memcached provides a cache backend for a pool of memcached servers
+// speaking the text protocol. Keys are spread over the servers with
+// consistent hashing.
+package memcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/ethan-k/cachemanager-go/internal/hashring"
+	"github.com/ethan-k/cachemanager-go/internal/valuecodec"
+)
+
+const (
+	defaultTimeout         = time.Second
+	defaultMaxIdleConns    = 2
+	defaultPointsPerServer = 160
+
+	// maxKeyLength is the longest key memcached accepts
+	maxKeyLength = 250
+	// maxRelativeExptime is the longest expiration memcached reads as
+	// seconds from now; larger values are Unix timestamps
+	maxRelativeExptime = 30 * 24 * time.Hour
+)
+
+// ErrInvalidKey is returned for keys memcached cannot store: keys longer than
+// 250 bytes or containing spaces or control characters.
+var ErrInvalidKey = errors.New("invalid memcached key")
+
+// Cache is a cache backed by one or more memcached servers
+type Cache struct {
+	ring            *hashring.Ring
+	pools           map[string]*pool
+	codec           codec.Codec
+	defaultTTL      time.Duration
+	timeout         time.Duration
+	maxIdleConns    int
+	pointsPerServer int
+}
+
+// Option defines the functional option type for configuring the cache
+type Option func(*Cache)
+
+// WithCodec sets the codec used for values that are not []byte. It defaults
+// to codec.Gob.
+func WithCodec(cdc codec.Codec) Option {
+	return func(c *Cache) {
+		c.codec = cdc
+	}
+}
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithTimeout sets the timeout for dialing a server and for each command,
+// unless the context has an earlier deadline. It defaults to one second.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Cache) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithMaxIdleConns sets how many idle connections are kept per server. It
+// defaults to 2.
+func WithMaxIdleConns(n int) Option {
+	return func(c *Cache) {
+		if n >= 0 {
+			c.maxIdleConns = n
+		}
+	}
+}
+
+// WithPointsPerServer sets how many points each server gets on the hash
+// ring. More points spread keys more evenly. It defaults to 160.
+func WithPointsPerServer(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.pointsPerServer = n
+		}
+	}
+}
+
+// NewMemcachedCache creates a cache spreading keys over servers, given as
+// host:port addresses. Connections are opened on first use.
+func NewMemcachedCache(servers []string, opts ...Option) (*Cache, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("memcached: no servers")
+	}
+
+	cache := &Cache{
+		pools:           make(map[string]*pool, len(servers)),
+		codec:           codec.Gob{},
+		defaultTTL:      cachemanager.NoExpiration,
+		timeout:         defaultTimeout,
+		maxIdleConns:    defaultMaxIdleConns,
+		pointsPerServer: defaultPointsPerServer,
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	for _, server := range servers {
+		cache.pools[server] = &pool{addr: server, dialTimeout: cache.timeout, maxIdle: cache.maxIdleConns}
+	}
+	cache.ring = hashring.New(servers, cache.pointsPerServer)
+
+	return cache, nil
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (any, bool, error) {
+	item, found, err := c.getItem(ctx, "get", key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	value, err := valuecodec.Decode(c.codec, key, valuecodec.Flag(item.flags), item.data)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return c.store(ctx, "set", key, value, ttl, 0)
+}
+
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (c *Cache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	err := c.do(ctx, c.ring.Node(key), func(cn *conn) error {
+		if _, err := cn.rw.WriteString("delete " + key + "\r\n"); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		return cn.readStorageReply()
+	})
+	if errors.Is(err, errCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// Touch resets the expiration of key without rewriting its value. It reports
+// whether the key was found.
+func (c *Cache) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.do(ctx, c.ring.Node(key), func(cn *conn) error {
+		if _, err := cn.rw.WriteString("touch " + key + " " + strconv.FormatInt(exptime(ttl), 10) + "\r\n"); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		return cn.readStorageReply()
+	})
+	if errors.Is(err, errCacheMiss) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetMany returns the cached values of keys. Missing keys are left out. Keys
+// are fetched with one multi-get per server, and the servers are queried
+// concurrently.
+func (c *Cache) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	byServer := make(map[string][]string)
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+		server := c.ring.Node(key)
+		byServer[server] = append(byServer[server], key)
+	}
+
+	var mu sync.Mutex
+	found := make(map[string]any, len(keys))
+	err := c.forEachServer(byServer, func(server string, keys []string) error {
+		return c.do(ctx, server, func(cn *conn) error {
+			if err := writeGet(cn, "get", keys); err != nil {
+				return err
+			}
+			return cn.readValues(func(key string, item value) {
+				value, err := valuecodec.Decode(c.codec, key, valuecodec.Flag(item.flags), item.data)
+				if err != nil {
+					// An undecodable entry is treated as missing
+					return
+				}
+				mu.Lock()
+				found[key] = value
+				mu.Unlock()
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// SetMany stores values with one pipelined round trip per server
+func (c *Cache) SetMany(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+
+	byServer := make(map[string][]string)
+	for key := range values {
+		if err := validateKey(key); err != nil {
+			return err
+		}
+		server := c.ring.Node(key)
+		byServer[server] = append(byServer[server], key)
+	}
+
+	return c.forEachServer(byServer, func(server string, keys []string) error {
+		return c.do(ctx, server, func(cn *conn) error {
+			for _, key := range keys {
+				flag, data, err := valuecodec.Encode(c.codec, key, values[key])
+				if err != nil {
+					return err
+				}
+				if err := cn.writeStorage("set", key, uint32(flag), exptime(ttl), data, 0); err != nil {
+					return err
+				}
+			}
+			if err := cn.rw.Flush(); err != nil {
+				return err
+			}
+			for range keys {
+				if err := cn.readStorageReply(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// DeleteMany removes keys with one pipelined round trip per server
+func (c *Cache) DeleteMany(ctx context.Context, keys []string) error {
+	byServer := make(map[string][]string)
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return err
+		}
+		server := c.ring.Node(key)
+		byServer[server] = append(byServer[server], key)
+	}
+
+	return c.forEachServer(byServer, func(server string, keys []string) error {
+		return c.do(ctx, server, func(cn *conn) error {
+			for _, key := range keys {
+				if _, err := cn.rw.WriteString("delete " + key + "\r\n"); err != nil {
+					return err
+				}
+			}
+			if err := cn.rw.Flush(); err != nil {
+				return err
+			}
+			for range keys {
+				if err := cn.readStorageReply(); err != nil && !errors.Is(err, errCacheMiss) {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// SetNX stores value with add, only if key is missing, and reports whether
+// it did.
+func (c *Cache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	err := c.store(ctx, "add", key, value, ttl, 0)
+	if errors.Is(err, errNotStored) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompareAndSwap stores value with cas, only if key still has the CAS value
+// expectedVersion returned by GetWithVersion, and reports whether it did.
+func (c *Cache) CompareAndSwap(ctx context.Context, key string, expectedVersion uint64, value any, ttl time.Duration) (bool, error) {
+	err := c.store(ctx, "cas", key, value, ttl, expectedVersion)
+	if errors.Is(err, errNotStored) || errors.Is(err, errCacheMiss) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetWithVersion returns the value of key with its CAS value, read with gets.
+func (c *Cache) GetWithVersion(ctx context.Context, key string) (any, uint64, bool, error) {
+	item, found, err := c.getItem(ctx, "gets", key)
+	if err != nil || !found {
+		return nil, 0, false, err
+	}
+	value, err := valuecodec.Decode(c.codec, key, valuecodec.Flag(item.flags), item.data)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return value, item.cas, true, nil
+}
+
+// GetInvalidationChannel returns nil; memcached does not report
+// invalidations.
+func (c *Cache) GetInvalidationChannel() <-chan string {
+	return nil
+}
+
+// Close closes the idle connections. Connections in use are closed when they
+// are returned.
+func (c *Cache) Close() error {
+	for _, p := range c.pools {
+		p.close()
+	}
+	return nil
+}
+
+// getItem reads one key with get or gets
+func (c *Cache) getItem(ctx context.Context, cmd, key string) (value, bool, error) {
+	if err := validateKey(key); err != nil {
+		return value{}, false, err
+	}
+
+	var item value
+	var found bool
+	err := c.do(ctx, c.ring.Node(key), func(cn *conn) error {
+		if err := writeGet(cn, cmd, []string{key}); err != nil {
+			return err
+		}
+		return cn.readValues(func(_ string, v value) {
+			item, found = v, true
+		})
+	})
+	return item, found, err
+}
+
+// store runs the storage command cmd for key
+func (c *Cache) store(ctx context.Context, cmd, key string, value any, ttl time.Duration, cas uint64) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+	flag, data, err := valuecodec.Encode(c.codec, key, value)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, c.ring.Node(key), func(cn *conn) error {
+		if err := cn.writeStorage(cmd, key, uint32(flag), exptime(ttl), data, cas); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		return cn.readStorageReply()
+	})
+}
+
+// do runs fn on a pooled connection to server. The connection goes back to
+// the pool unless fn failed with an I/O or protocol error, after which its
+// state is unknown.
+func (c *Cache) do(ctx context.Context, server string, fn func(cn *conn) error) error {
+	p := c.pools[server]
+	cn, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := cn.nc.SetDeadline(deadline); err != nil {
+		cn.nc.Close()
+		return err
+	}
+
+	err = fn(cn)
+	if err != nil && !errors.Is(err, errCacheMiss) && !errors.Is(err, errNotStored) {
+		cn.nc.Close()
+		return err
+	}
+	p.put(cn)
+	return err
+}
+
+// forEachServer calls fn for every server's keys concurrently and returns
+// the last error
+func (c *Cache) forEachServer(byServer map[string][]string, fn func(server string, keys []string) error) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var lastErr error
+	for server, keys := range byServer {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(server, keys); err != nil {
+				mu.Lock()
+				lastErr = fmt.Errorf("error from memcached server %s: %w", server, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return lastErr
+}
+
+func writeGet(cn *conn, cmd string, keys []string) error {
+	if _, err := cn.rw.WriteString(cmd); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := cn.rw.WriteString(" " + key); err != nil {
+			return err
+		}
+	}
+	if _, err := cn.rw.Write(crlf); err != nil {
+		return err
+	}
+	return cn.rw.Flush()
+}
+
+// exptime converts a resolved TTL to a memcached expiration time. Memcached
+// counts in whole seconds, so TTLs are rounded up, and reads values beyond
+// 30 days as Unix timestamps.
+func exptime(ttl time.Duration) int64 {
+	if ttl == cachemanager.NoExpiration {
+		return 0
+	}
+	if ttl > maxRelativeExptime {
+		return time.Now().Add(ttl).Unix()
+	}
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+func validateKey(key string) error {
+	if key == "" || len(key) > maxKeyLength {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package memcached
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/memcached/memcachedtest"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newServers starts n fake memcached servers that are closed with the test
+func newServers(t *testing.T, n int) ([]*memcachedtest.Server, []string) {
+	t.Helper()
+	servers := make([]*memcachedtest.Server, n)
+	addrs := make([]string, n)
+	for i := range servers {
+		server, err := memcachedtest.NewServer()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = server.Close() })
+		servers[i], addrs[i] = server, server.Addr()
+	}
+	return servers, addrs
+}
+
+func newCache(t *testing.T, addrs []string, opts ...Option) *Cache {
+	t.Helper()
+	cache, err := NewMemcachedCache(addrs, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cache.Close() })
+	return cache
+}
+
+func TestMemcachedCache_Conformance(t *testing.T) {
+	var server *memcachedtest.Server
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			servers, addrs := newServers(t, 1)
+			server = servers[0]
+			cache, err := NewMemcachedCache(addrs, WithDefaultTTL(defaultTTL))
+			require.NoError(t, err)
+			return cache
+		},
+		// Memcached rounds TTLs up to whole seconds
+		Advance: func(d time.Duration) {
+			server.Advance(d + time.Second)
+		},
+	})
+}
+
+func TestMemcachedCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("values round trip through the codec", func(t *testing.T) {
+		_, addrs := newServers(t, 1)
+		cache := newCache(t, addrs, WithCodec(codec.JSON{}))
+
+		require.NoError(t, cache.Set(ctx, "user", map[string]any{"name": "ada"}, time.Minute))
+		require.NoError(t, cache.Set(ctx, "raw", []byte("payload"), time.Minute))
+		require.NoError(t, cache.Set(ctx, "missing", cachemanager.Tombstone{}, time.Minute))
+
+		value, found, err := cache.Get(ctx, "user")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, map[string]any{"name": "ada"}, value)
+
+		value, _, err = cache.Get(ctx, "raw")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("payload"), value)
+
+		value, _, err = cache.Get(ctx, "missing")
+		require.NoError(t, err)
+		assert.Equal(t, cachemanager.Tombstone{}, value)
+	})
+
+	t.Run("keys are spread over the servers", func(t *testing.T) {
+		servers, addrs := newServers(t, 3)
+		cache := newCache(t, addrs)
+
+		values := make(map[string]any)
+		keys := make([]string, 0, 300)
+		for i := 0; i < 300; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			values[key] = i
+			keys = append(keys, key)
+		}
+		require.NoError(t, cache.SetMany(ctx, values, time.Minute))
+
+		for _, server := range servers {
+			assert.Greater(t, server.Len(), 50)
+		}
+		for _, key := range keys[:20] {
+			owners := 0
+			for _, server := range servers {
+				if server.Has(key) {
+					owners++
+				}
+			}
+			assert.Equal(t, 1, owners, key)
+		}
+
+		found, err := cache.GetMany(ctx, append(keys[:len(keys):len(keys)], "absent"))
+		require.NoError(t, err)
+		assert.Equal(t, values, found)
+
+		require.NoError(t, cache.DeleteMany(ctx, append(keys[:150:150], "absent")))
+		found, err = cache.GetMany(ctx, keys)
+		require.NoError(t, err)
+		assert.Len(t, found, 150)
+	})
+
+	t.Run("compare and swap uses CAS values", func(t *testing.T) {
+		_, addrs := newServers(t, 1)
+		cache := newCache(t, addrs)
+
+		stored, err := cache.SetNX(ctx, "cart", "a", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, stored)
+		stored, err = cache.SetNX(ctx, "cart", "b", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		value, version, found, err := cache.GetWithVersion(ctx, "cart")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "a", value)
+
+		stored, err = cache.CompareAndSwap(ctx, "cart", version, "ab", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, stored)
+		stored, err = cache.CompareAndSwap(ctx, "cart", version, "ac", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, stored)
+		stored, err = cache.CompareAndSwap(ctx, "absent", version, "x", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		value, _, err = cache.Get(ctx, "cart")
+		require.NoError(t, err)
+		assert.Equal(t, "ab", value)
+	})
+
+	t.Run("connections are reused", func(t *testing.T) {
+		servers, addrs := newServers(t, 1)
+		cache := newCache(t, addrs, WithMaxIdleConns(4))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					assert.NoError(t, cache.Set(ctx, fmt.Sprintf("key:%d", j), j, time.Minute))
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.LessOrEqual(t, servers[0].Connections(), 4)
+	})
+
+	t.Run("broken connections are replaced", func(t *testing.T) {
+		_, addrs := newServers(t, 1)
+		cache := newCache(t, addrs)
+		require.NoError(t, cache.Set(ctx, "key", "value", time.Minute))
+
+		// Close the pooled connection behind the cache's back
+		p := cache.pools[addrs[0]]
+		p.mu.Lock()
+		for _, cn := range p.idle {
+			cn.nc.Close()
+		}
+		p.mu.Unlock()
+
+		_, _, err := cache.Get(ctx, "key")
+		assert.Error(t, err)
+		value, found, err := cache.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "value", value)
+	})
+
+	t.Run("invalid keys are rejected", func(t *testing.T) {
+		_, addrs := newServers(t, 1)
+		cache := newCache(t, addrs)
+
+		for _, key := range []string{"", "with space", "new\nline", strings.Repeat("k", 251)} {
+			assert.ErrorIs(t, cache.Set(ctx, key, "value", time.Minute), ErrInvalidKey)
+			_, _, err := cache.Get(ctx, key)
+			assert.ErrorIs(t, err, ErrInvalidKey)
+		}
+	})
+
+	t.Run("server errors are reported", func(t *testing.T) {
+		_, addrs := newServers(t, 1)
+		cache := newCache(t, addrs)
+
+		err := cache.do(ctx, addrs[0], func(cn *conn) error {
+			if _, err := cn.rw.WriteString("bogus\r\n"); err != nil {
+				return err
+			}
+			if err := cn.rw.Flush(); err != nil {
+				return err
+			}
+			return cn.readStorageReply()
+		})
+		assert.ErrorIs(t, err, ErrServer)
+	})
+}
+
+func TestExptime(t *testing.T) {
+	assert.Equal(t, int64(0), exptime(cachemanager.NoExpiration))
+	assert.Equal(t, int64(1), exptime(50*time.Millisecond))
+	assert.Equal(t, int64(2), exptime(1500*time.Millisecond))
+	assert.Equal(t, int64(60), exptime(time.Minute))
+
+	absolute := exptime(60 * 24 * time.Hour)
+	assert.InDelta(t, time.Now().Add(60*24*time.Hour).Unix(), absolute, 1)
+}
@@ -0,0 +1,312 @@
+// Package memcachedtest provides a fake memcached server for tests. It speaks
+// enough of the text protocol for the memcached backend: get, gets, set, add,
+// cas, delete and touch.
+package memcachedtest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRelativeExptime is the longest expiration read as seconds from now
+const maxRelativeExptime = 30 * 24 * 60 * 60
+
+// Server is a fake memcached server listening on a local TCP port
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	items   map[string]item
+	lastCAS uint64
+	offset  time.Duration
+	conns   map[net.Conn]struct{}
+	accepts int
+}
+
+type item struct {
+	flags     uint32
+	data      []byte
+	cas       uint64
+	expiresAt time.Time
+}
+
+// NewServer starts a server on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener: listener,
+		items:    make(map[string]item),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Advance moves the server's clock forward by d, expiring items early
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Len returns the number of live items
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	n := 0
+	for _, it := range s.items {
+		if !it.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Has reports whether key is stored and not expired
+func (s *Server) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[key]
+	return ok && !it.expired(s.now())
+}
+
+// Connections returns how many connections the server has accepted
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepts
+}
+
+// Close stops the server and closes every open connection
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (it item) expired(now time.Time) bool {
+	return !it.expiresAt.IsZero() && !now.Before(it.expiresAt)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.accepts++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c)
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else {
+			switch fields[0] {
+			case "get", "gets":
+				s.get(w, fields[1:], fields[0] == "gets")
+			case "set", "add", "cas":
+				if !s.store(r, w, fields) {
+					return
+				}
+			case "delete":
+				s.delete(w, fields[1:])
+			case "touch":
+				s.touch(w, fields[1:])
+			case "quit":
+				return
+			default:
+				w.WriteString("ERROR\r\n")
+			}
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) get(w *bufio.Writer, keys []string, withCAS bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, key := range keys {
+		it, ok := s.items[key]
+		if !ok || it.expired(now) {
+			continue
+		}
+		w.WriteString("VALUE " + key + " " + strconv.FormatUint(uint64(it.flags), 10) + " " + strconv.Itoa(len(it.data)))
+		if withCAS {
+			w.WriteString(" " + strconv.FormatUint(it.cas, 10))
+		}
+		w.WriteString("\r\n")
+		w.Write(it.data)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+// store handles set, add and cas. It returns false if the connection must
+// be dropped.
+func (s *Server) store(r *bufio.Reader, w *bufio.Writer, fields []string) bool {
+	cmd := fields[0]
+	if (cmd == "cas" && len(fields) < 6) || len(fields) < 5 {
+		w.WriteString("ERROR\r\n")
+		return true
+	}
+	flags, err1 := strconv.ParseUint(fields[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(fields[3], 10, 64)
+	size, err3 := strconv.Atoi(fields[4])
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	var cas uint64
+	if cmd == "cas" {
+		var err error
+		if cas, err = strconv.ParseUint(fields[5], 10, 64); err != nil {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return false
+	}
+	if string(data[size:]) != "\r\n" {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	current, exists := s.items[fields[1]]
+	exists = exists && !current.expired(now)
+	switch {
+	case cmd == "add" && exists:
+		w.WriteString("NOT_STORED\r\n")
+		return true
+	case cmd == "cas" && !exists:
+		w.WriteString("NOT_FOUND\r\n")
+		return true
+	case cmd == "cas" && current.cas != cas:
+		w.WriteString("EXISTS\r\n")
+		return true
+	}
+
+	s.lastCAS++
+	s.items[fields[1]] = item{
+		flags:     uint32(flags),
+		data:      data[:size],
+		cas:       s.lastCAS,
+		expiresAt: s.expiresAt(exptime, now),
+	}
+	w.WriteString("STORED\r\n")
+	return true
+}
+
+func (s *Server) delete(w *bufio.Writer, args []string) {
+	if len(args) < 1 {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[args[0]]
+	delete(s.items, args[0])
+	if !ok || it.expired(s.now()) {
+		w.WriteString("NOT_FOUND\r\n")
+		return
+	}
+	w.WriteString("DELETED\r\n")
+}
+
+func (s *Server) touch(w *bufio.Writer, args []string) {
+	if len(args) < 2 {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	it, ok := s.items[args[0]]
+	if !ok || it.expired(now) {
+		w.WriteString("NOT_FOUND\r\n")
+		return
+	}
+	it.expiresAt = s.expiresAt(exptime, now)
+	s.items[args[0]] = it
+	w.WriteString("TOUCHED\r\n")
+}
+
+// expiresAt interprets exptime the way memcached does: 0 never expires,
+// negative values expire immediately, up to 30 days counts seconds from now
+// and anything larger is a Unix timestamp
+func (s *Server) expiresAt(exptime int64, now time.Time) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return now
+	case exptime <= maxRelativeExptime:
+		return now.Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
@@ -0,0 +1,551 @@
+// Package namespace wraps a cache backend so that several services can
+// share it without their keys colliding.
+//
+// Every logical key is stored as "<namespace>:<key>:<version>", with an
+// empty version unless a schema version is set. Namespaces and versions
+// cannot contain the separator, so no two of them share a stored key.
+// Bumping the version makes every entry written under the old version
+// unreachable without flushing the backend; the old entries simply expire.
+// Keys longer than a limit can be replaced by a hash, for backends such as
+// memcached that cap key length.
+package namespace
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+const (
+	// separator joins the namespace, the key and the version
+	separator = ":"
+	// hashMarker starts the key part of hashed keys
+	hashMarker = "#"
+)
+
+// errNoFillLeases is returned by the fill lease methods when the wrapped
+// backend has no fill leases
+var errNoFillLeases = errors.New("backend does not support fill leases")
+
+// errNoLeases is returned by the lease methods when the wrapped backend is
+// not a cachemanager.Locker
+var errNoLeases = errors.New("backend does not support leases")
+
+// HashFunc maps an over-long key to a short, fixed-length string
+type HashFunc func(key string) string
+
+// SHA256 hashes keys with SHA-256, hex encoded
+func SHA256(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// XXHash hashes keys with 64-bit xxHash, hex encoded. It is much faster than
+// SHA256 but not collision resistant against crafted keys.
+func XXHash(key string) string {
+	return strconv.FormatUint(xxhash.Sum64String(key), 16)
+}
+
+// Cache stores the entries of a wrapped backend under a namespace
+type Cache struct {
+	backend      cachemanager.CacheBackend
+	namespace    string
+	version      string
+	maxKeyLength int
+	hash         HashFunc
+	hashed       *hashedKeys
+
+	invalidationChan   chan string
+	invalidationEvents chan cachemanager.InvalidationEvent
+}
+
+// Option configures a Cache created by NewNamespacedCache
+type Option func(*Cache)
+
+// WithVersion appends version to every key. Changing it invalidates every
+// entry written with another version. It must not contain ":".
+func WithVersion(version string) Option {
+	return func(c *Cache) {
+		c.version = version
+	}
+}
+
+// WithKeyHashing replaces the key part of stored keys with hash(key) when the
+// full stored key would be longer than maxLength bytes.
+func WithKeyHashing(maxLength int, hash HashFunc) Option {
+	return func(c *Cache) {
+		c.maxKeyLength = maxLength
+		c.hash = hash
+	}
+}
+
+// WithHashedKeyMemory sets how many hashed keys are remembered so that
+// invalidations of hashed keys can be mapped back to logical keys. It
+// defaults to 10000; invalidations of forgotten keys are dropped.
+func WithHashedKeyMemory(size int) Option {
+	return func(c *Cache) {
+		if size > 0 {
+			c.hashed.limit = size
+		}
+	}
+}
+
+// NewNamespacedCache wraps backend so that every key is stored under
+// namespace, which must not contain ":". The Cache only serves the optional
+// interfaces that backend does.
+func NewNamespacedCache(backend cachemanager.CacheBackend, namespace string, opts ...Option) (*Cache, error) {
+	cache := &Cache{
+		backend:   backend,
+		namespace: namespace,
+		hashed:    newHashedKeys(10000),
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+	if strings.Contains(namespace, separator) {
+		return nil, fmt.Errorf("namespace %q must not contain %q", namespace, separator)
+	}
+	if strings.Contains(cache.version, separator) {
+		return nil, fmt.Errorf("version %q must not contain %q", cache.version, separator)
+	}
+
+	if source, ok := cachemanager.As[cachemanager.CacheBackendWithInvalidationChannel](backend); ok {
+		if keys := source.GetInvalidationChannel(); keys != nil {
+			cache.invalidationChan = make(chan string, 100)
+			go cache.translateKeys(keys)
+		}
+	}
+	if source, ok := cachemanager.As[cachemanager.CacheBackendWithInvalidationEvents](backend); ok {
+		if events := source.GetInvalidationEvents(); events != nil {
+			cache.invalidationEvents = make(chan cachemanager.InvalidationEvent, 100)
+			go cache.translateEvents(events)
+		}
+	}
+
+	return cache, nil
+}
+
+// Supports reports whether the wrapped backend serves capability
+func (c *Cache) Supports(capability reflect.Type) bool {
+	return cachemanager.Supports(c.backend, capability)
+}
+
+// Key returns the key under which the logical key is stored. With hashing
+// enabled, keys starting with the hash marker are always hashed, so they
+// cannot be mistaken for the hashed form of another key.
+func (c *Cache) Key(key string) string {
+	stored := c.namespace + separator + key + separator + c.version
+	if c.hash == nil || (len(stored) <= c.maxKeyLength && !strings.HasPrefix(key, hashMarker)) {
+		return stored
+	}
+
+	stored = c.namespace + separator + hashMarker + c.hash(key) + separator + c.version
+	c.hashed.remember(stored, key)
+	return stored
+}
+
+// logicalKey maps a stored key back to the logical key. It reports false for
+// keys outside the namespace, of another version, or hashed keys that are no
+// longer remembered.
+func (c *Cache) logicalKey(stored string) (string, bool) {
+	if key, ok := c.hashed.lookup(stored); ok {
+		return key, true
+	}
+	key, ok := strings.CutPrefix(stored, c.namespace+separator)
+	if !ok || (c.hash != nil && strings.HasPrefix(key, hashMarker)) {
+		return "", false
+	}
+	return strings.CutSuffix(key, separator+c.version)
+}
+
+func (c *Cache) tag(tag string) string {
+	return c.namespace + separator + tag
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (any, bool, error) {
+	return c.backend.Get(ctx, c.Key(key))
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return c.backend.Set(ctx, c.Key(key), value, ttl)
+}
+
+// DefaultTTL returns the default TTL of the wrapped backend, or
+// cachemanager.NoExpiration if it does not report one
+func (c *Cache) DefaultTTL() time.Duration {
+	if backend, ok := cachemanager.As[cachemanager.DefaultTTLBackend](c.backend); ok {
+		return backend.DefaultTTL()
+	}
+	return cachemanager.NoExpiration
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	return c.backend.Delete(ctx, c.Key(key))
+}
+
+// Touch resets the TTL of key. Backends that cannot touch entries have the
+// value rewritten instead.
+func (c *Cache) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	stored := c.Key(key)
+	if touchable, ok := cachemanager.As[cachemanager.TouchableBackend](c.backend); ok {
+		return touchable.Touch(ctx, stored, ttl)
+	}
+
+	value, found, err := c.backend.Get(ctx, stored)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, c.backend.Set(ctx, stored, value, ttl)
+}
+
+// GetMany returns the cached values of keys, keyed by logical key
+func (c *Cache) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	batch, ok := cachemanager.As[cachemanager.BatchBackend](c.backend)
+	if !ok {
+		found := make(map[string]any, len(keys))
+		for _, key := range keys {
+			value, exists, err := c.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				found[key] = value
+			}
+		}
+		return found, nil
+	}
+
+	stored := make([]string, len(keys))
+	logical := make(map[string]string, len(keys))
+	for i, key := range keys {
+		stored[i] = c.Key(key)
+		logical[stored[i]] = key
+	}
+	values, err := batch.GetMany(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]any, len(values))
+	for key, value := range values {
+		found[logical[key]] = value
+	}
+	return found, nil
+}
+
+func (c *Cache) SetMany(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	batch, ok := cachemanager.As[cachemanager.BatchBackend](c.backend)
+	if !ok {
+		for key, value := range values {
+			if err := c.Set(ctx, key, value, ttl); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stored := make(map[string]any, len(values))
+	for key, value := range values {
+		stored[c.Key(key)] = value
+	}
+	return batch.SetMany(ctx, stored, ttl)
+}
+
+func (c *Cache) DeleteMany(ctx context.Context, keys []string) error {
+	batch, ok := cachemanager.As[cachemanager.BatchBackend](c.backend)
+	if !ok {
+		for _, key := range keys {
+			if err := c.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stored := make([]string, len(keys))
+	for i, key := range keys {
+		stored[i] = c.Key(key)
+	}
+	return batch.DeleteMany(ctx, stored)
+}
+
+// SetWithTags stores value with tags scoped to the namespace. Backends
+// without tag support store the value untagged.
+func (c *Cache) SetWithTags(ctx context.Context, key string, value any, ttl time.Duration, tags []string) error {
+	tagBackend, ok := cachemanager.As[cachemanager.TagBackend](c.backend)
+	if !ok {
+		return c.Set(ctx, key, value, ttl)
+	}
+
+	stored := make([]string, len(tags))
+	for i, tag := range tags {
+		stored[i] = c.tag(tag)
+	}
+	return tagBackend.SetWithTags(ctx, c.Key(key), value, ttl, stored)
+}
+
+// InvalidateTags removes the entries tagged with tags in this namespace and
+// returns their logical keys
+func (c *Cache) InvalidateTags(ctx context.Context, tags ...string) ([]string, error) {
+	tagBackend, ok := cachemanager.As[cachemanager.TagBackend](c.backend)
+	if !ok {
+		return nil, nil
+	}
+
+	stored := make([]string, len(tags))
+	for i, tag := range tags {
+		stored[i] = c.tag(tag)
+	}
+	removed, err := tagBackend.InvalidateTags(ctx, stored...)
+	keys := make([]string, 0, len(removed))
+	for _, key := range removed {
+		if logical, ok := c.logicalKey(key); ok {
+			keys = append(keys, logical)
+		}
+	}
+	return keys, err
+}
+
+// Clear removes every entry in the namespace, of every version, leaving the
+// rest of the backend alone
+func (c *Cache) Clear(ctx context.Context) error {
+	clearable, ok := cachemanager.As[cachemanager.ClearableBackend](c.backend)
+	if !ok {
+		return cachemanager.ErrClearNotSupported
+	}
+	return clearable.DeletePrefix(ctx, c.namespace+separator)
+}
+
+// DeletePrefix removes every entry whose logical key starts with prefix.
+// Hashed keys, which include every key starting with "#" when hashing is
+// enabled, no longer carry their prefix and are only removed by Clear.
+func (c *Cache) DeletePrefix(ctx context.Context, prefix string) error {
+	clearable, ok := cachemanager.As[cachemanager.ClearableBackend](c.backend)
+	if !ok {
+		return cachemanager.ErrClearNotSupported
+	}
+	return clearable.DeletePrefix(ctx, c.namespace+separator+prefix)
+}
+
+// AcquireLease takes the lease on key from the wrapped backend, which must
+// implement cachemanager.Locker
+func (c *Cache) AcquireLease(ctx context.Context, key string, ttl time.Duration) (*cachemanager.Lease, error) {
+	locker, ok := cachemanager.As[cachemanager.Locker](c.backend)
+	if !ok {
+		return nil, errNoLeases
+	}
+	return locker.AcquireLease(ctx, c.Key(key), ttl)
+}
+
+// ReleaseLease gives up a lease taken with AcquireLease
+func (c *Cache) ReleaseLease(ctx context.Context, lease *cachemanager.Lease) error {
+	locker, ok := cachemanager.As[cachemanager.Locker](c.backend)
+	if !ok {
+		return errNoLeases
+	}
+	return locker.ReleaseLease(ctx, lease)
+}
+
+// SetFenced stores value under lease. Backends without fencing store it
+// unconditionally.
+func (c *Cache) SetFenced(ctx context.Context, key string, value any, ttl time.Duration, lease *cachemanager.Lease) (bool, error) {
+	fenced, ok := cachemanager.As[cachemanager.FencedBackend](c.backend)
+	if !ok {
+		return true, c.Set(ctx, key, value, ttl)
+	}
+	return fenced.SetFenced(ctx, c.Key(key), value, ttl, lease)
+}
+
+// GetWithLease reads key from the wrapped backend, which must implement
+// cachemanager.LeaseBackend, handing out its fill token on a miss
+func (c *Cache) GetWithLease(ctx context.Context, key string) (any, bool, uint64, error) {
+	leaseBackend, ok := cachemanager.As[cachemanager.LeaseBackend](c.backend)
+	if !ok {
+		return nil, false, 0, errNoFillLeases
+	}
+	return leaseBackend.GetWithLease(ctx, c.Key(key))
+}
+
+// SetWithLease stores value if token is still the key's fill lease in the
+// wrapped backend
+func (c *Cache) SetWithLease(ctx context.Context, key string, value any, ttl time.Duration, token uint64) (bool, error) {
+	leaseBackend, ok := cachemanager.As[cachemanager.LeaseBackend](c.backend)
+	if !ok {
+		return false, errNoFillLeases
+	}
+	return leaseBackend.SetWithLease(ctx, c.Key(key), value, ttl, token)
+}
+
+// ReleaseFillLease gives up a fill token of the wrapped backend
+func (c *Cache) ReleaseFillLease(ctx context.Context, key string, token uint64) error {
+	leaseBackend, ok := cachemanager.As[cachemanager.LeaseBackend](c.backend)
+	if !ok {
+		return errNoFillLeases
+	}
+	return leaseBackend.ReleaseFillLease(ctx, c.Key(key), token)
+}
+
+// Incr adds delta to the counter at key in the wrapped backend, which must
+// implement cachemanager.CounterBackend
+func (c *Cache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	counter, ok := cachemanager.As[cachemanager.CounterBackend](c.backend)
+	if !ok {
+		return 0, cachemanager.ErrCounterNotSupported
+	}
+	return counter.Incr(ctx, c.Key(key), delta, ttl)
+}
+
+// SetNX stores value only if key is missing from the wrapped backend, which
+// must implement cachemanager.ConditionalBackend
+func (c *Cache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	conditional, ok := cachemanager.As[cachemanager.ConditionalBackend](c.backend)
+	if !ok {
+		return false, cachemanager.ErrConditionalNotSupported
+	}
+	return conditional.SetNX(ctx, c.Key(key), value, ttl)
+}
+
+// CompareAndSwap stores value only if key still has expectedVersion in the
+// wrapped backend, which must implement cachemanager.ConditionalBackend
+func (c *Cache) CompareAndSwap(ctx context.Context, key string, expectedVersion uint64, value any, ttl time.Duration) (bool, error) {
+	conditional, ok := cachemanager.As[cachemanager.ConditionalBackend](c.backend)
+	if !ok {
+		return false, cachemanager.ErrConditionalNotSupported
+	}
+	return conditional.CompareAndSwap(ctx, c.Key(key), expectedVersion, value, ttl)
+}
+
+// GetWithVersion returns the value of key and its version from the wrapped
+// backend, which must implement cachemanager.ConditionalBackend
+func (c *Cache) GetWithVersion(ctx context.Context, key string) (any, uint64, bool, error) {
+	conditional, ok := cachemanager.As[cachemanager.ConditionalBackend](c.backend)
+	if !ok {
+		return nil, 0, false, cachemanager.ErrConditionalNotSupported
+	}
+	return conditional.GetWithVersion(ctx, c.Key(key))
+}
+
+// GetInvalidationChannel returns the wrapped backend's invalidated keys that
+// belong to this namespace and version, as logical keys
+func (c *Cache) GetInvalidationChannel() <-chan string {
+	return c.invalidationChan
+}
+
+// GetInvalidationEvents returns the wrapped backend's invalidation events
+// that concern this namespace, in terms of logical keys, tags and prefixes
+func (c *Cache) GetInvalidationEvents() <-chan cachemanager.InvalidationEvent {
+	return c.invalidationEvents
+}
+
+func (c *Cache) Close() error {
+	return c.backend.Close()
+}
+
+func (c *Cache) translateKeys(keys <-chan string) {
+	defer close(c.invalidationChan)
+	for stored := range keys {
+		if key, ok := c.logicalKey(stored); ok {
+			c.invalidationChan <- key
+		}
+	}
+}
+
+func (c *Cache) translateEvents(events <-chan cachemanager.InvalidationEvent) {
+	defer close(c.invalidationEvents)
+	for event := range events {
+		if translated, ok := c.translateEvent(event); ok {
+			c.invalidationEvents <- translated
+		}
+	}
+}
+
+// translateEvent maps an event on stored keys to logical keys, dropping the
+// parts that concern other namespaces. It reports false if nothing is left.
+func (c *Cache) translateEvent(event cachemanager.InvalidationEvent) (cachemanager.InvalidationEvent, bool) {
+	var translated cachemanager.InvalidationEvent
+	prefix := c.namespace + separator
+
+	for _, stored := range event.Keys {
+		if key, ok := c.logicalKey(stored); ok {
+			translated.Keys = append(translated.Keys, key)
+		}
+	}
+	for _, tag := range event.Tags {
+		if logical, ok := strings.CutPrefix(tag, prefix); ok {
+			translated.Tags = append(translated.Tags, logical)
+		}
+	}
+	for _, stored := range event.Prefixes {
+		switch {
+		case strings.HasPrefix(prefix, stored):
+			// The deleted prefix covers the whole namespace
+			translated.All = true
+		case strings.HasPrefix(stored, prefix):
+			translated.Prefixes = append(translated.Prefixes, stored[len(prefix):])
+		}
+	}
+	translated.All = translated.All || event.All
+
+	ok := translated.All || len(translated.Keys) > 0 || len(translated.Tags) > 0 || len(translated.Prefixes) > 0
+	return translated, ok
+}
+
+// hashedKeys remembers the logical keys of recently used hashed keys, least
+// recently used first out
+type hashedKeys struct {
+	mu      sync.Mutex
+	limit   int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type hashedKey struct {
+	stored  string
+	logical string
+}
+
+func newHashedKeys(limit int) *hashedKeys {
+	return &hashedKeys{
+		limit:   limit,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (h *hashedKeys) remember(stored, logical string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if elem, ok := h.entries[stored]; ok {
+		h.order.MoveToBack(elem)
+		return
+	}
+	h.entries[stored] = h.order.PushBack(hashedKey{stored: stored, logical: logical})
+	if h.order.Len() > h.limit {
+		oldest := h.order.Front()
+		h.order.Remove(oldest)
+		delete(h.entries, oldest.Value.(hashedKey).stored)
+	}
+}
+
+func (h *hashedKeys) lookup(stored string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	elem, ok := h.entries[stored]
+	if !ok {
+		return "", false
+	}
+	return elem.Value.(hashedKey).logical, true
+}
@@ -0,0 +1,293 @@
+package namespace
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/bytecache"
+	"github.com/ethan-k/cachemanager-go/backend/inmemory"
+	"github.com/ethan-k/cachemanager-go/backend/redis"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newNamespaced(t *testing.T, backend cachemanager.CacheBackend, namespace string, opts ...Option) *Cache {
+	t.Helper()
+	cache, err := NewNamespacedCache(backend, namespace, opts...)
+	require.NoError(t, err)
+	return cache
+}
+
+func TestNamespacedCache_Key(t *testing.T) {
+	backend := inmemory.NewInMemoryCache()
+	defer backend.Close()
+
+	plain := newNamespaced(t, backend, "billing")
+	assert.Equal(t, "billing:config:", plain.Key("config"))
+
+	versioned := newNamespaced(t, backend, "billing", WithVersion("v2"))
+	assert.Equal(t, "billing:config:v2", versioned.Key("config"))
+
+	hashed := newNamespaced(t, backend, "billing", WithVersion("v2"), WithKeyHashing(32, SHA256))
+	assert.Equal(t, "billing:short:v2", hashed.Key("short"))
+	long := strings.Repeat("x", 100)
+	assert.Equal(t, "billing:#"+SHA256(long)+":v2", hashed.Key(long))
+
+	key, ok := hashed.logicalKey(hashed.Key(long))
+	assert.True(t, ok)
+	assert.Equal(t, long, key)
+
+	// A short key shaped like a hashed key is hashed too, so it cannot
+	// collide with the long key
+	lookalike := "#" + SHA256(long)[:8]
+	assert.Equal(t, "billing:#"+SHA256(lookalike)+":v2", hashed.Key(lookalike))
+	key, ok = hashed.logicalKey(hashed.Key(lookalike))
+	assert.True(t, ok)
+	assert.Equal(t, lookalike, key)
+	assert.NotEqual(t, hashed.Key(long), hashed.Key("#"+SHA256(long)))
+
+	assert.Len(t, XXHash(long), 16)
+	assert.NotEqual(t, XXHash(long), XXHash(long+"y"))
+
+	// Keys containing the separator stay apart from other versions and
+	// namespaces
+	assert.NotEqual(t, plain.Key("config:v2"), versioned.Key("config"))
+	_, ok = plain.logicalKey(versioned.Key("config"))
+	assert.False(t, ok)
+
+	_, err := NewNamespacedCache(backend, "billing:eu")
+	assert.ErrorContains(t, err, "must not contain")
+	_, err = NewNamespacedCache(backend, "billing", WithVersion("v2:1"))
+	assert.ErrorContains(t, err, "must not contain")
+}
+
+func TestNamespacedCache_Isolation(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.NewInMemoryCache()
+	defer backend.Close()
+
+	billing := newNamespaced(t, backend, "billing")
+	search := newNamespaced(t, backend, "search")
+
+	require.NoError(t, billing.Set(ctx, "config", "billing config", time.Minute))
+	require.NoError(t, search.Set(ctx, "config", "search config", time.Minute))
+
+	value, exists, err := billing.Get(ctx, "config")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "billing config", value)
+
+	require.NoError(t, billing.Clear(ctx))
+	_, exists, _ = billing.Get(ctx, "config")
+	assert.False(t, exists)
+	value, exists, _ = search.Get(ctx, "config")
+	assert.True(t, exists)
+	assert.Equal(t, "search config", value)
+}
+
+func TestNamespacedCache_Counters(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.NewInMemoryCache()
+	defer backend.Close()
+
+	billing := newNamespaced(t, backend, "billing")
+	search := newNamespaced(t, backend, "search")
+
+	value, err := billing.Incr(ctx, "views", 2, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), value)
+	value, err = search.Incr(ctx, "views", 1, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), value)
+
+	stored, _, err := backend.Get(ctx, "billing:views:")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stored)
+}
+
+func TestNamespacedCache_Capabilities(t *testing.T) {
+	ctx := context.Background()
+	local := inmemory.NewInMemoryCache()
+	shared := newNamespaced(t, bytecache.NewByteCache(), "billing")
+
+	assert.False(t, cachemanager.Supports(shared, reflect.TypeFor[cachemanager.CounterBackend]()))
+	assert.True(t, cachemanager.Supports(newNamespaced(t, local, "billing"), reflect.TypeFor[cachemanager.CounterBackend]()))
+	_, _, _, err := shared.GetWithLease(ctx, "key")
+	assert.Error(t, err, "no fill token is made up")
+
+	// The wrapped backend cannot count, so the counter lives in the tier
+	// above it
+	cm := cachemanager.NewCacheManager(
+		cachemanager.CacheConfig{Backend: local, TTL: time.Minute},
+		cachemanager.CacheConfig{Backend: shared, TTL: time.Hour},
+	)
+	defer cm.Close()
+	value, err := cm.Increment(ctx, "views", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), value)
+
+	require.NoError(t, cm.Set(ctx, "key", "value"))
+	got, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+		return "loaded", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "value", got)
+}
+
+func TestNamespacedCache_VersionBump(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.NewInMemoryCache()
+	defer backend.Close()
+
+	v1 := newNamespaced(t, backend, "billing", WithVersion("v1"))
+	require.NoError(t, v1.Set(ctx, "config", "old schema", time.Minute))
+
+	v2 := newNamespaced(t, backend, "billing", WithVersion("v2"))
+	_, exists, err := v2.Get(ctx, "config")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	_, ok := v2.logicalKey(v1.Key("config"))
+	assert.False(t, ok, "keys of another version must not map back")
+}
+
+func TestNamespacedCache_TagsAndPrefixes(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.NewInMemoryCache()
+	defer backend.Close()
+
+	billing := newNamespaced(t, backend, "billing", WithVersion("v1"))
+	search := newNamespaced(t, backend, "search", WithVersion("v1"))
+
+	require.NoError(t, billing.SetWithTags(ctx, "invoice:1", "i", time.Minute, []string{"user:42"}))
+	require.NoError(t, search.SetWithTags(ctx, "results:1", "r", time.Minute, []string{"user:42"}))
+
+	keys, err := billing.InvalidateTags(ctx, "user:42")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"invoice:1"}, keys)
+	_, exists, _ := search.Get(ctx, "results:1")
+	assert.True(t, exists)
+
+	require.NoError(t, billing.SetMany(ctx, map[string]any{"user:1": "a", "user:2": "b", "order:1": "c"}, time.Minute))
+	require.NoError(t, billing.DeletePrefix(ctx, "user:"))
+	found, err := billing.GetMany(ctx, []string{"user:1", "user:2", "order:1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"order:1": "c"}, found)
+}
+
+func TestNamespacedCache_TranslateEvent(t *testing.T) {
+	backend := inmemory.NewInMemoryCache()
+	defer backend.Close()
+	cache := newNamespaced(t, backend, "billing", WithVersion("v1"), WithKeyHashing(20, SHA256))
+	long := strings.Repeat("x", 30)
+
+	tests := []struct {
+		name     string
+		event    cachemanager.InvalidationEvent
+		expected cachemanager.InvalidationEvent
+		ok       bool
+	}{
+		{
+			name:     "keys",
+			event:    cachemanager.InvalidationEvent{Keys: []string{"billing:a:v1", "search:a:v1", "billing:a:v0", cache.Key(long)}},
+			expected: cachemanager.InvalidationEvent{Keys: []string{"a", long}},
+			ok:       true,
+		},
+		{
+			name:     "tags",
+			event:    cachemanager.InvalidationEvent{Tags: []string{"billing:user:42", "search:user:42"}},
+			expected: cachemanager.InvalidationEvent{Tags: []string{"user:42"}},
+			ok:       true,
+		},
+		{
+			name:     "prefix inside the namespace",
+			event:    cachemanager.InvalidationEvent{Prefixes: []string{"billing:user:"}},
+			expected: cachemanager.InvalidationEvent{Prefixes: []string{"user:"}},
+			ok:       true,
+		},
+		{
+			name:     "prefix covering the namespace",
+			event:    cachemanager.InvalidationEvent{Prefixes: []string{"bill"}},
+			expected: cachemanager.InvalidationEvent{All: true},
+			ok:       true,
+		},
+		{
+			name:     "clear",
+			event:    cachemanager.InvalidationEvent{All: true},
+			expected: cachemanager.InvalidationEvent{All: true},
+			ok:       true,
+		},
+		{
+			name:  "other namespace",
+			event: cachemanager.InvalidationEvent{Keys: []string{"search:a"}, Prefixes: []string{"search:"}},
+			ok:    false,
+		},
+		{
+			name:  "forgotten hashed key",
+			event: cachemanager.InvalidationEvent{Keys: []string{"billing:#0123:v1"}},
+			ok:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translated, ok := cache.translateEvent(tt.event)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.expected, translated)
+			}
+		})
+	}
+}
+
+func TestNamespacedCache_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			return newNamespaced(t, inmemory.NewInMemoryCache(inmemory.WithDefaultTTL(defaultTTL)), "test",
+				WithVersion("v1"), WithKeyHashing(16, SHA256))
+		},
+		Advance: time.Sleep,
+	})
+}
+
+func TestCacheManager_NamespacedInvalidation(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	newInstance := func() (*cachemanager.CacheManager, *inmemory.Cache) {
+		local := inmemory.NewInMemoryCache()
+		shared, err := redis.NewRedisCache(redis.NewGoRedisAdapter(mr.Addr()))
+		require.NoError(t, err)
+		cm := cachemanager.NewCacheManager(
+			cachemanager.CacheConfig{Backend: local, TTL: time.Minute},
+			cachemanager.CacheConfig{Backend: newNamespaced(t, shared, "billing", WithVersion("v1")), TTL: time.Hour},
+		)
+		t.Cleanup(func() { _ = cm.Close() })
+		return cm, local
+	}
+	instanceA, _ := newInstance()
+	instanceB, localB := newInstance()
+
+	require.NoError(t, instanceA.SetWithTags(ctx, "config", "value", "settings"))
+	assert.True(t, mr.Exists("billing:config:v1"))
+
+	_, err := instanceB.Get(ctx, "config")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, exists, _ := localB.Get(ctx, "config")
+		return exists
+	}, time.Second, 10*time.Millisecond)
+
+	// Instance B's local tier holds the logical key, so the broadcast must be
+	// translated back from the stored key to reach it
+	require.NoError(t, instanceA.InvalidateTags(ctx, "settings"))
+	assert.Eventually(t, func() bool {
+		_, exists, _ := localB.Get(ctx, "config")
+		return !exists
+	}, time.Second, 10*time.Millisecond)
+}
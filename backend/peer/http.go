@@ -0,0 +1,256 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethan-k/cachemanager-go/internal/valuecodec"
+)
+
+// Headers and query parameters of the peer protocol. A value travels in
+// the body, with its flags and its TTL in milliseconds (0 for none) in
+// headers. Writes forwarded to an owner name the peer they come from, which
+// already dropped its own copy.
+const (
+	flagsHeader  = "X-Cache-Flags"
+	ttlHeader    = "X-Cache-Ttl"
+	originHeader = "X-Cache-Origin"
+	hotParam     = "hot"
+)
+
+// ServeHTTP answers the requests of the other peers: GET reads a key, PUT
+// stores it and DELETE removes it. A DELETE with the hot parameter only
+// evicts a hot copy. Requests are served locally even if this peer does not
+// own the key in its own view of the ring, so that peers with different
+// views never forward requests in a loop. Requests refused by the
+// authorizer set with WithAuthorizer get 401 Unauthorized.
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.authorize != nil {
+		if err := c.authorize(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, c.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(path, c.basePath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		c.serveGet(ctx, w, r, key)
+	case http.MethodPut:
+		c.servePut(ctx, w, r, key)
+	case http.MethodDelete:
+		c.serveDelete(ctx, w, r, key)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *Cache) serveGet(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	result, err := c.getOwned(ctx, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !result.found {
+		http.NotFound(w, r)
+		return
+	}
+	flag, data, err := valuecodec.Encode(c.codec, key, result.value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(flagsHeader, strconv.Itoa(int(flag)))
+	w.Header().Set(ttlHeader, formatTTL(result.ttl))
+	_, _ = w.Write(data)
+}
+
+func (c *Cache) servePut(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	flags, err1 := strconv.Atoi(r.Header.Get(flagsHeader))
+	ttl, err2 := parseTTL(r.Header.Get(ttlHeader))
+	data, err3 := io.ReadAll(http.MaxBytesReader(w, r.Body, c.maxValueSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err3, &tooLarge) {
+		http.Error(w, fmt.Sprintf("value larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		http.Error(w, "malformed value", http.StatusBadRequest)
+		return
+	}
+	value, err := valuecodec.Decode(c.codec, key, valuecodec.Flag(flags), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := c.setOwned(ctx, key, value, ttl); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.notify(key)
+	c.evictHotCopies(ctx, key, r.Header.Get(originHeader))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Cache) serveDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	if r.URL.Query().Has(hotParam) {
+		_ = c.hot.Delete(ctx, key)
+		c.notify(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := c.owned.Delete(ctx, key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.notify(key)
+	c.evictHotCopies(ctx, key, r.Header.Get(originHeader))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fetch reads key from its owner
+func (c *Cache) fetch(ctx context.Context, owner, key string) (lookup, error) {
+	resp, err := c.do(ctx, http.MethodGet, owner, key, nil, nil, nil)
+	if err != nil {
+		return lookup{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return lookup{}, nil
+	}
+	if err := checkStatus(owner, resp); err != nil {
+		return lookup{}, err
+	}
+	flags, err1 := strconv.Atoi(resp.Header.Get(flagsHeader))
+	ttl, err2 := parseTTL(resp.Header.Get(ttlHeader))
+	data, err3 := io.ReadAll(io.LimitReader(resp.Body, c.maxValueSize+1))
+	if err1 != nil || err2 != nil || err3 != nil {
+		return lookup{}, fmt.Errorf("%w: malformed response from %s for key %s", ErrPeer, owner, key)
+	}
+	if int64(len(data)) > c.maxValueSize {
+		return lookup{}, fmt.Errorf("%w: value from %s for key %s is larger than %d bytes", ErrPeer, owner, key, c.maxValueSize)
+	}
+	value, err := valuecodec.Decode(c.codec, key, valuecodec.Flag(flags), data)
+	if err != nil {
+		return lookup{}, err
+	}
+	return lookup{value: value, ttl: ttl, found: true}, nil
+}
+
+// store writes key to its owner with a resolved TTL
+func (c *Cache) store(ctx context.Context, owner, key string, value any, ttl time.Duration) error {
+	flag, data, err := valuecodec.Encode(c.codec, key, value)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set(flagsHeader, strconv.Itoa(int(flag)))
+	header.Set(ttlHeader, formatTTL(ttl))
+	return c.send(ctx, http.MethodPut, owner, key, header, data)
+}
+
+// remove deletes key from its owner
+func (c *Cache) remove(ctx context.Context, owner, key string) error {
+	return c.send(ctx, http.MethodDelete, owner, key, http.Header{}, nil)
+}
+
+// evictHotCopies drops the hot copies of key held by every peer but self
+// and origin. Peers that cannot be reached are skipped; their copies expire
+// with the hot cache TTL.
+func (c *Cache) evictHotCopies(ctx context.Context, key, origin string) {
+	var wg sync.WaitGroup
+	for _, peer := range c.Peers() {
+		if peer == c.self || peer == origin {
+			continue
+		}
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			resp, err := c.do(ctx, http.MethodDelete, peer, key, url.Values{hotParam: {"1"}}, nil, nil)
+			if err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// send runs a request without a response body, naming self as its origin
+func (c *Cache) send(ctx context.Context, method, peer, key string, header http.Header, body []byte) error {
+	header.Set(originHeader, c.self)
+	resp, err := c.do(ctx, method, peer, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(peer, resp)
+}
+
+func (c *Cache) do(ctx context.Context, method, peer, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	target := peer + c.basePath + url.PathEscape(key)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPeer, err)
+	}
+	return resp, nil
+}
+
+// checkStatus returns an error for responses that are not successful
+func checkStatus(peer string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%w: %s returned %s: %s", ErrPeer, peer, resp.Status, strings.TrimSpace(string(message)))
+}
+
+// formatTTL encodes a resolved TTL in milliseconds, rounding up so that a
+// short TTL does not turn into no expiration
+func formatTTL(ttl time.Duration) string {
+	return strconv.FormatInt(int64((ttl+time.Millisecond-1)/time.Millisecond), 10)
+}
+
+func parseTTL(header string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("invalid TTL %q", header)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
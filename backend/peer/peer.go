@@ -0,0 +1,411 @@
+// Package peer provides a cache backend distributed over the instances of a
+// service, in the style of groupcache. The instances form a consistent-hash
+// ring over HTTP: every key is owned by one peer, which stores it and
+// single-flights its loads, while the other peers fetch it from the owner and
+// keep a small hot cache of what they fetched.
+package peer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/inmemory"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/ethan-k/cachemanager-go/internal/hashring"
+	"github.com/ethan-k/cachemanager-go/internal/singleflight"
+)
+
+// DefaultBasePath is the path under which peers serve each other
+const DefaultBasePath = "/_cachemanager/"
+
+const (
+	defaultTimeout       = time.Second
+	defaultHotCacheSize  = 1000
+	defaultHotCacheTTL   = time.Minute
+	defaultPointsPerPeer = 160
+	defaultMaxValueSize  = 32 << 20
+)
+
+// ErrPeer is wrapped by the errors reported for failed requests to a peer
+var ErrPeer = errors.New("peer request failed")
+
+// Getter loads the value of a key missing from its owner. It returns
+// cachemanager.ErrNotFound if the key has no value.
+type Getter func(ctx context.Context, key string) (any, error)
+
+// Cache is one peer of a distributed cache. It must be served over HTTP
+// under its base path, for example with
+// mux.Handle(peer.DefaultBasePath, cache). The handler lets anyone who
+// reaches it read, overwrite and delete entries, so serve it on a private
+// listener only the other peers can reach, or guard it with WithAuthorizer.
+type Cache struct {
+	self          string
+	basePath      string
+	client        *http.Client
+	timeout       time.Duration
+	codec         codec.Codec
+	defaultTTL    time.Duration
+	maxEntries    int
+	hotCacheSize  int
+	hotCacheTTL   time.Duration
+	pointsPerPeer int
+	getter        Getter
+	getterTTL     time.Duration
+	authorize     func(*http.Request) error
+	maxValueSize  int64
+
+	mu            sync.RWMutex
+	peers         []string
+	ring          *hashring.Ring
+	invalidations chan string
+	closed        bool
+
+	owned   *inmemory.Cache
+	hot     *inmemory.Cache
+	loads   singleflight.Group[lookup]
+	fetches singleflight.Group[lookup]
+}
+
+// ownedEntry is a value stored by its owner, with the expiration sent to
+// the peers that fetch it
+type ownedEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+// lookup is the result of reading a key from its owner. ttl is the time
+// left before the value expires, cachemanager.NoExpiration if it never does.
+type lookup struct {
+	value any
+	ttl   time.Duration
+	found bool
+}
+
+// Option defines the functional option type for configuring the cache
+type Option func(*Cache)
+
+// WithBasePath sets the path under which the peers serve each other. Every
+// peer must use the same path. It defaults to DefaultBasePath.
+func WithBasePath(path string) Option {
+	return func(c *Cache) {
+		c.basePath = path
+	}
+}
+
+// WithHTTPClient sets the client used to reach the other peers
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Cache) {
+		c.client = client
+	}
+}
+
+// WithTimeout sets the timeout of each request to another peer, unless the
+// context has an earlier deadline. It defaults to one second and is ignored
+// when WithHTTPClient is used.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Cache) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithCodec sets the codec used to send values that are not []byte to other
+// peers. It defaults to codec.Gob.
+func WithCodec(cdc codec.Codec) Option {
+	return func(c *Cache) {
+		c.codec = cdc
+	}
+}
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithMaxEntries sets the maximum number of entries this peer stores for the
+// keys it owns. It defaults to unlimited.
+func WithMaxEntries(max int) Option {
+	return func(c *Cache) {
+		c.maxEntries = max
+	}
+}
+
+// WithHotCache sets how many values fetched from other peers are kept, and
+// for how long at most. It defaults to 1000 values kept for one minute.
+func WithHotCache(size int, ttl time.Duration) Option {
+	return func(c *Cache) {
+		if size > 0 {
+			c.hotCacheSize = size
+		}
+		if ttl > 0 {
+			c.hotCacheTTL = ttl
+		}
+	}
+}
+
+// WithPointsPerPeer sets how many points each peer gets on the hash ring.
+// More points spread keys more evenly. It defaults to 160.
+func WithPointsPerPeer(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.pointsPerPeer = n
+		}
+	}
+}
+
+// WithGetter makes owners load missing keys with getter and store them with
+// ttl. Concurrent requests for the same key, from any peer, share one load.
+func WithGetter(getter Getter, ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.getter = getter
+		c.getterTTL = ttl
+	}
+}
+
+// WithAuthorizer makes the peer reject the requests of other peers for
+// which authorize returns an error. The credentials it checks are sent by
+// the client set with WithHTTPClient, for example from its transport.
+func WithAuthorizer(authorize func(r *http.Request) error) Option {
+	return func(c *Cache) {
+		c.authorize = authorize
+	}
+}
+
+// WithMaxValueSize sets the largest encoded value, in bytes, a peer accepts
+// in a request from another peer or in the response of an owner. Larger
+// writes get 413 Request Entity Too Large. It defaults to 32 MiB.
+func WithMaxValueSize(bytes int64) Option {
+	return func(c *Cache) {
+		if bytes > 0 {
+			c.maxValueSize = bytes
+		}
+	}
+}
+
+// NewPeerCache creates the peer reachable at self, a base URL such as
+// "http://10.0.0.1:8080". The ring only contains self until SetPeers is
+// called.
+func NewPeerCache(self string, opts ...Option) *Cache {
+	cache := &Cache{
+		self:          self,
+		basePath:      DefaultBasePath,
+		timeout:       defaultTimeout,
+		codec:         codec.Gob{},
+		defaultTTL:    cachemanager.NoExpiration,
+		maxEntries:    -1,
+		hotCacheSize:  defaultHotCacheSize,
+		hotCacheTTL:   defaultHotCacheTTL,
+		pointsPerPeer: defaultPointsPerPeer,
+		maxValueSize:  defaultMaxValueSize,
+		getterTTL:     cachemanager.DefaultTTL,
+		invalidations: make(chan string, 100),
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	if cache.client == nil {
+		cache.client = &http.Client{Timeout: cache.timeout}
+	}
+	cache.owned = inmemory.NewInMemoryCache(inmemory.WithMaxEntries(cache.maxEntries))
+	cache.hot = inmemory.NewInMemoryCache(inmemory.WithMaxEntries(cache.hotCacheSize))
+	cache.SetPeers()
+
+	return cache
+}
+
+// SetPeers replaces the members of the ring, given as base URLs. Self is
+// always a member. Keys whose owner changes are fetched from, or loaded by,
+// their new owner from then on.
+func (c *Cache) SetPeers(peers ...string) {
+	members := map[string]struct{}{c.self: {}}
+	for _, peer := range peers {
+		members[peer] = struct{}{}
+	}
+	sorted := make([]string, 0, len(members))
+	for peer := range members {
+		sorted = append(sorted, peer)
+	}
+	sort.Strings(sorted)
+
+	r := hashring.New(sorted, c.pointsPerPeer)
+	c.mu.Lock()
+	c.peers = sorted
+	c.ring = r
+	c.mu.Unlock()
+}
+
+// Peers returns the members of the ring, self included
+func (c *Cache) Peers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.peers...)
+}
+
+// Owner returns the base URL of the peer owning key
+func (c *Cache) Owner(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Node(key)
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (any, bool, error) {
+	owner := c.Owner(key)
+	if owner == c.self {
+		result, err := c.getOwned(ctx, key)
+		return result.value, result.found, err
+	}
+
+	if value, found, err := c.hot.Get(ctx, key); err == nil && found {
+		return value, true, nil
+	}
+	result, err := c.fetches.Do(key, func() (lookup, error) {
+		result, err := c.fetch(ctx, owner, key)
+		if err != nil || !result.found {
+			return result, err
+		}
+		ttl := c.hotCacheTTL
+		if result.ttl != cachemanager.NoExpiration && result.ttl < ttl {
+			ttl = result.ttl
+		}
+		return result, c.hot.Set(ctx, key, result.value, ttl)
+	})
+	return result.value, result.found, err
+}
+
+// Set stores value with its owner. The hot copies other peers hold are
+// evicted before Set returns.
+func (c *Cache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+
+	owner := c.Owner(key)
+	if owner != c.self {
+		_ = c.hot.Delete(ctx, key)
+		return c.store(ctx, owner, key, value, ttl)
+	}
+	if err := c.setOwned(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	c.evictHotCopies(ctx, key, c.self)
+	return nil
+}
+
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (c *Cache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+// Delete removes key from its owner and evicts the hot copies other peers
+// hold
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	owner := c.Owner(key)
+	if owner != c.self {
+		_ = c.hot.Delete(ctx, key)
+		return c.remove(ctx, owner, key)
+	}
+	if err := c.owned.Delete(ctx, key); err != nil {
+		return err
+	}
+	c.evictHotCopies(ctx, key, c.self)
+	return nil
+}
+
+// GetInvalidationChannel reports keys written or deleted through other
+// peers, so that the tiers above this one can drop their copies
+func (c *Cache) GetInvalidationChannel() <-chan string {
+	return c.invalidations
+}
+
+// Close releases the local stores. The HTTP handler must be unregistered
+// separately. Closing a closed cache does nothing.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.invalidations)
+	return errors.Join(c.owned.Close(), c.hot.Close())
+}
+
+// getOwned reads a key this peer owns, loading it with the getter on a miss
+func (c *Cache) getOwned(ctx context.Context, key string) (lookup, error) {
+	if result, err := c.lookupOwned(ctx, key); err != nil || result.found || c.getter == nil {
+		return result, err
+	}
+
+	return c.loads.Do(key, func() (lookup, error) {
+		// Another load may have stored the key while this one waited
+		if result, err := c.lookupOwned(ctx, key); err != nil || result.found {
+			return result, err
+		}
+		ttl, err := cachemanager.ResolveTTL(c.getterTTL, c.defaultTTL)
+		if err != nil {
+			return lookup{}, err
+		}
+		value, err := c.getter(ctx, key)
+		if errors.Is(err, cachemanager.ErrNotFound) {
+			return lookup{}, nil
+		}
+		if err != nil {
+			return lookup{}, err
+		}
+		if err := c.setOwned(ctx, key, value, ttl); err != nil {
+			return lookup{}, err
+		}
+		return lookup{value: value, ttl: ttl, found: true}, nil
+	})
+}
+
+func (c *Cache) lookupOwned(ctx context.Context, key string) (lookup, error) {
+	value, found, err := c.owned.Get(ctx, key)
+	if err != nil || !found {
+		return lookup{}, err
+	}
+	entry := value.(ownedEntry)
+	ttl := cachemanager.NoExpiration
+	if !entry.expiresAt.IsZero() {
+		ttl = time.Until(entry.expiresAt)
+		if ttl <= 0 {
+			return lookup{}, nil
+		}
+	}
+	return lookup{value: entry.value, ttl: ttl, found: true}, nil
+}
+
+// setOwned stores a key this peer owns with a resolved TTL
+func (c *Cache) setOwned(ctx context.Context, key string, value any, ttl time.Duration) error {
+	entry := ownedEntry{value: value}
+	if ttl != cachemanager.NoExpiration {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	return c.owned.Set(ctx, key, entry, ttl)
+}
+
+// notify reports key on the invalidation channel without blocking
+func (c *Cache) notify(key string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.invalidations <- key:
+	default:
+	}
+}
@@ -0,0 +1,318 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/inmemory"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCluster starts n peers on localhost that know each other
+func newCluster(t *testing.T, n int, opts ...Option) []*Cache {
+	t.Helper()
+	peers := make([]*Cache, n)
+	urls := make([]string, n)
+	for i := range peers {
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		peers[i] = NewPeerCache(server.URL, opts...)
+		mux.Handle(DefaultBasePath, peers[i])
+		urls[i] = server.URL
+
+		cache := peers[i]
+		t.Cleanup(func() {
+			server.Close()
+			cache.Close()
+		})
+	}
+	for _, peer := range peers {
+		peer.SetPeers(urls...)
+	}
+	return peers
+}
+
+// keyOwnedBy returns a key owned by owner
+func keyOwnedBy(t *testing.T, owner *Cache, prefix string) string {
+	t.Helper()
+	return keyOwnedByURL(t, owner, owner.self, prefix)
+}
+
+// keyOwnedByURL returns a key that peer sees as owned by url
+func keyOwnedByURL(t *testing.T, peer *Cache, url, prefix string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%s:%d", prefix, i)
+		if peer.Owner(key) == url {
+			return key
+		}
+	}
+	t.Fatalf("no key owned by %s", url)
+	return ""
+}
+
+func TestPeerCache_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			return newCluster(t, 3, WithDefaultTTL(defaultTTL))[0]
+		},
+		Advance: time.Sleep,
+	})
+}
+
+func TestPeerCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("keys are stored by their owner and fetched by the others", func(t *testing.T) {
+		peers := newCluster(t, 3, WithCodec(codec.JSON{}))
+		owner, writer, reader := peers[0], peers[1], peers[2]
+		key := keyOwnedBy(t, owner, "user")
+
+		require.NoError(t, writer.Set(ctx, key, map[string]any{"name": "ada"}, time.Minute))
+		_, found, err := writer.hot.Get(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, found, "the writer must not keep a copy")
+		_, found, err = owner.owned.Get(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		value, found, err := reader.Get(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, map[string]any{"name": "ada"}, value)
+		value, found, err = reader.hot.Get(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, found, "the reader keeps a hot copy")
+		assert.Equal(t, map[string]any{"name": "ada"}, value)
+	})
+
+	t.Run("writes evict hot copies on every peer", func(t *testing.T) {
+		peers := newCluster(t, 3)
+		owner := peers[0]
+		key := keyOwnedBy(t, owner, "user")
+		require.NoError(t, owner.Set(ctx, key, []byte("v1"), time.Minute))
+		for _, peer := range peers[1:] {
+			value, _, err := peer.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("v1"), value)
+		}
+
+		require.NoError(t, peers[1].Set(ctx, key, []byte("v2"), time.Minute))
+		for _, peer := range peers {
+			value, _, err := peer.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("v2"), value)
+		}
+
+		require.NoError(t, peers[2].Delete(ctx, key))
+		for _, peer := range peers {
+			_, found, err := peer.Get(ctx, key)
+			require.NoError(t, err)
+			assert.False(t, found)
+		}
+	})
+
+	t.Run("hot copies expire with the owner entry", func(t *testing.T) {
+		peers := newCluster(t, 2)
+		key := keyOwnedBy(t, peers[0], "session")
+		require.NoError(t, peers[0].Set(ctx, key, cachemanager.Tombstone{}, 50*time.Millisecond))
+
+		value, found, err := peers[1].Get(ctx, key)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, cachemanager.Tombstone{}, value)
+
+		time.Sleep(100 * time.Millisecond)
+		_, found, err = peers[1].Get(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("owners single-flight loads from every peer", func(t *testing.T) {
+		var loads atomic.Int32
+		release := make(chan struct{})
+		getter := func(ctx context.Context, key string) (any, error) {
+			loads.Add(1)
+			<-release
+			if key == "missing" {
+				return nil, cachemanager.ErrNotFound
+			}
+			return "loaded:" + key, nil
+		}
+		peers := newCluster(t, 3, WithGetter(getter, time.Minute))
+		key := keyOwnedBy(t, peers[0], "report")
+
+		var wg sync.WaitGroup
+		for _, peer := range peers {
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func(peer *Cache) {
+					defer wg.Done()
+					value, found, err := peer.Get(ctx, key)
+					assert.NoError(t, err)
+					assert.True(t, found)
+					assert.Equal(t, "loaded:"+key, value)
+				}(peer)
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		assert.Equal(t, int32(1), loads.Load())
+
+		_, found, err := peers[1].Get(ctx, "missing")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("membership changes move keys to their new owner", func(t *testing.T) {
+		peers := newCluster(t, 3)
+		leaving := peers[2]
+		key := keyOwnedBy(t, leaving, "user")
+		require.NoError(t, peers[0].Set(ctx, key, "value", time.Minute))
+
+		remaining := []string{peers[0].self, peers[1].self}
+		for _, peer := range peers[:2] {
+			peer.SetPeers(remaining...)
+			assert.ElementsMatch(t, remaining, peer.Peers())
+			assert.NotEqual(t, leaving.self, peer.Owner(key))
+		}
+
+		// The new owner starts cold and then serves the key for everyone
+		_, found, err := peers[0].Get(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, found)
+		require.NoError(t, peers[1].Set(ctx, key, "moved", time.Minute))
+		for _, peer := range peers[:2] {
+			value, _, err := peer.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, "moved", value)
+		}
+	})
+
+	t.Run("unreachable owners are reported", func(t *testing.T) {
+		peers := newCluster(t, 1)
+		peers[0].SetPeers("http://127.0.0.1:1")
+		key := keyOwnedByURL(t, peers[0], "http://127.0.0.1:1", "key")
+
+		_, _, err := peers[0].Get(ctx, key)
+		assert.ErrorIs(t, err, ErrPeer)
+		assert.ErrorIs(t, peers[0].Set(ctx, key, "value", time.Minute), ErrPeer)
+	})
+}
+
+// tokenTransport sends token with every request
+type tokenTransport struct {
+	token string
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestPeerCache_Authorizer(t *testing.T) {
+	ctx := context.Background()
+	authorize := func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("unknown peer")
+		}
+		return nil
+	}
+	peers := newCluster(t, 2,
+		WithAuthorizer(authorize),
+		WithHTTPClient(&http.Client{Transport: tokenTransport{token: "secret"}}))
+
+	key := keyOwnedBy(t, peers[1], "key")
+	require.NoError(t, peers[0].Set(ctx, key, "value", time.Minute))
+	value, _, err := peers[0].Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// Requests without the token cannot change entries
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, peers[1].self+DefaultBasePath+key, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	value, _, err = peers[1].Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	outsider := NewPeerCache("http://outsider")
+	defer outsider.Close()
+	outsider.SetPeers(peers[1].self)
+	assert.ErrorIs(t, outsider.Set(ctx, keyOwnedByURL(t, outsider, peers[1].self, "key"), "forged", time.Minute), ErrPeer)
+}
+
+func TestCacheManager_MemoryOverPeers(t *testing.T) {
+	ctx := context.Background()
+	peers := newCluster(t, 2)
+	key := keyOwnedBy(t, peers[0], "user")
+
+	managers := make([]*cachemanager.CacheManager, len(peers))
+	for i, peer := range peers {
+		managers[i] = cachemanager.NewCacheManager(
+			cachemanager.CacheConfig{Backend: inmemory.NewInMemoryCache(), TTL: time.Minute},
+			cachemanager.CacheConfig{Backend: peer, TTL: time.Hour},
+		)
+		t.Cleanup(func() { managers[i].Close() })
+	}
+	require.NoError(t, managers[1].Set(ctx, key, "v1"))
+	value, err := managers[0].Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", value)
+
+	// A write through one instance reaches the memory tier of the other
+	// through the invalidation channel
+	require.NoError(t, managers[1].Set(ctx, key, "v2"))
+	assert.Eventually(t, func() bool {
+		value, err := managers[0].Get(ctx, key)
+		return err == nil && value == "v2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPeerCache_MaxValueSize(t *testing.T) {
+	ctx := context.Background()
+	peers := newCluster(t, 2, WithMaxValueSize(64))
+	key := keyOwnedBy(t, peers[1], "key")
+	large := strings.Repeat("x", 100)
+
+	// Values over the limit are not accepted from other peers
+	assert.ErrorIs(t, peers[0].Set(ctx, key, large, time.Minute), ErrPeer)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, peers[1].self+DefaultBasePath+key, strings.NewReader(large))
+	require.NoError(t, err)
+	req.Header.Set(flagsHeader, "0")
+	req.Header.Set(ttlHeader, formatTTL(time.Minute))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	_, found, err := peers[1].Get(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// nor read from their owner
+	require.NoError(t, peers[1].Set(ctx, key, large, time.Minute))
+	_, _, err = peers[0].Get(ctx, key)
+	assert.ErrorIs(t, err, ErrPeer)
+
+	require.NoError(t, peers[0].Set(ctx, key, "small", time.Minute))
+	value, _, err := peers[0].Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "small", value)
+}
@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// defaultScanBatchSize is the COUNT hint passed to SCAN by Clear and
+// DeletePrefix
+const defaultScanBatchSize = 1000
+
+// WithScanBatchSize sets how many keys Clear and DeletePrefix ask SCAN for
+// per call, and so how many keys each UNLINK removes. It defaults to 1000.
+func WithScanBatchSize(size int) CacheOption {
+	return func(c *Cache) {
+		if size > 0 {
+			c.scanBatchSize = size
+		}
+	}
+}
+
+// WithClearPrefix scopes Clear to the keys starting with prefix, the ones
+// this cache owns in a database shared with other applications
+func WithClearPrefix(prefix string) CacheOption {
+	return func(c *Cache) {
+		c.clearPrefix = &prefix
+	}
+}
+
+// WithClearDatabase lets Clear remove every key in the logical database,
+// including keys, locks and leases written by other applications. Only use
+// it when the database belongs to this cache alone.
+func WithClearDatabase() CacheOption {
+	return func(c *Cache) {
+		c.clearDatabase = true
+	}
+}
+
+// Clear removes the keys under the prefix set by WithClearPrefix, or every
+// key in the database with WithClearDatabase, and tells the other instances
+// to do the same. Without either option it returns
+// cachemanager.ErrClearNotSupported rather than guess what may be deleted.
+func (c *Cache) Clear(ctx context.Context) error {
+	switch {
+	case c.clearPrefix != nil:
+		return c.deletePrefix(ctx, *c.clearPrefix)
+	case c.clearDatabase:
+		if err := c.unlinkMatching(ctx, "*"); err != nil {
+			return err
+		}
+		return c.broadcast(ctx, cachemanager.InvalidationEvent{All: true})
+	}
+	return fmt.Errorf("%w: configure WithClearPrefix or WithClearDatabase", cachemanager.ErrClearNotSupported)
+}
+
+// DeletePrefix removes every key starting with prefix, revoking their fill
+// leases, and tells the other instances to do the same. An empty prefix
+// would match keys this cache does not own, so it returns
+// cachemanager.ErrEmptyPrefix; Clear is the scoped way to remove everything.
+func (c *Cache) DeletePrefix(ctx context.Context, prefix string) error {
+	if prefix == "" {
+		return cachemanager.ErrEmptyPrefix
+	}
+	return c.deletePrefix(ctx, prefix)
+}
+
+// deletePrefix is DeletePrefix without the empty prefix check, for Clear
+func (c *Cache) deletePrefix(ctx context.Context, prefix string) error {
+	if err := c.unlinkMatching(ctx, escapeGlob(prefix)+"*"); err != nil {
+		return err
+	}
+	// Fill leases and tags of keys without a hash tag are named
+	// "{key}" + suffix
+	for _, suffix := range []string{fillLeaseSuffix, tagsSuffix} {
+		if err := c.unlinkMatching(ctx, "{"+escapeGlob(prefix)+"*}"+suffix); err != nil {
+			return err
+		}
+	}
+	return c.broadcast(ctx, cachemanager.InvalidationEvent{Prefixes: []string{prefix}})
+}
+
+// unlinkMatching walks the keyspace with SCAN rather than KEYS so the server
+// is never blocked, unlinking each batch as it is found
+func (c *Cache) unlinkMatching(ctx context.Context, match string) error {
+	return c.client.Scan(ctx, match, int64(c.scanBatchSize), func(keys []string) error {
+		return c.client.Unlink(ctx, keys)
+	})
+}
+
+// escapeGlob escapes the characters that are special in Redis glob patterns
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
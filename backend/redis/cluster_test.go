@@ -0,0 +1,383 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCluster starts one miniredis per node and a go-redis cluster client
+// whose slot map splits the hash slots evenly across them. miniredis has no
+// cluster mode of its own, so the slot map stands in for CLUSTER SLOTS.
+func newTestCluster(t *testing.T, nodes int) ([]*miniredis.Miniredis, Client) {
+	servers := make([]*miniredis.Miniredis, nodes)
+	slots := make([]redis.ClusterSlot, nodes)
+	for i := range servers {
+		servers[i] = miniredis.RunT(t)
+		slots[i] = redis.ClusterSlot{
+			Start: i * clusterSlots / nodes,
+			End:   (i+1)*clusterSlots/nodes - 1,
+			Nodes: []redis.ClusterNode{{Addr: servers[i].Addr()}},
+		}
+	}
+
+	client := &goRedisClient{
+		client: redis.NewClusterClient(&redis.ClusterOptions{
+			ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+				return slots, nil
+			},
+		}),
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return servers, client
+}
+
+// nodeFor returns the index of the node owning key in a cluster built by
+// newTestCluster
+func nodeFor(key string, nodes int) int {
+	return int(keySlot(key)) * nodes / clusterSlots
+}
+
+func TestRedisCache_Cluster(t *testing.T) {
+	ctx := context.Background()
+	servers, client := newTestCluster(t, 3)
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+
+	keys := make([]string, 30)
+	values := make(map[string]any, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		values[keys[i]] = fmt.Sprintf("value%d", i)
+	}
+
+	t.Run("single-key commands follow hash slots", func(t *testing.T) {
+		require.NoError(t, cache.Set(ctx, "user:1", "alice", time.Minute))
+		owner := servers[nodeFor("user:1", len(servers))]
+		got, err := owner.Get("user:1")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", got)
+
+		value, exists, err := cache.Get(ctx, "user:1")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "alice", value)
+	})
+
+	t.Run("batch writes land on the owning nodes", func(t *testing.T) {
+		require.NoError(t, cache.SetMany(ctx, values, time.Minute))
+
+		for _, key := range keys {
+			owner := servers[nodeFor(key, len(servers))]
+			assert.True(t, owner.Exists(key), "%s should be on node %d", key, nodeFor(key, len(servers)))
+		}
+	})
+
+	t.Run("batch reads span slots", func(t *testing.T) {
+		found, err := cache.GetMany(ctx, append(keys, "missing"))
+		require.NoError(t, err)
+		assert.Equal(t, values, found)
+	})
+
+	t.Run("batch reads include versioned values on every node", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("versioned%d", i)
+			stored, err := cache.SetNX(ctx, key, "v", time.Minute)
+			require.NoError(t, err)
+			require.True(t, stored)
+		}
+
+		found, err := cache.GetMany(ctx, append(keys, "versioned0", "versioned5", "versioned9", "missing"))
+		require.NoError(t, err)
+		for _, key := range []string{"versioned0", "versioned5", "versioned9"} {
+			assert.Equal(t, "v", found[key], key)
+		}
+		assert.Len(t, found, len(keys)+3)
+	})
+
+	t.Run("batch deletes span slots", func(t *testing.T) {
+		require.NoError(t, cache.DeleteMany(ctx, keys))
+
+		found, err := cache.GetMany(ctx, keys)
+		require.NoError(t, err)
+		assert.Empty(t, found)
+	})
+}
+
+// runFakeSentinel serves the subset of the Sentinel protocol go-redis needs
+// to discover masterName at masterAddr.
+func runFakeSentinel(t *testing.T, masterName, masterAddr string) string {
+	sentinel := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(masterAddr)
+	require.NoError(t, err)
+
+	err = sentinel.Server().Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 1 {
+			c.WriteError("ERR wrong number of arguments for 'sentinel' command")
+			return
+		}
+		switch strings.ToLower(args[0]) {
+		case "get-master-addr-by-name":
+			if len(args) != 2 || args[1] != masterName {
+				c.WriteNull()
+				return
+			}
+			c.WriteStrings([]string{host, port})
+		case "sentinels", "replicas", "slaves":
+			c.WriteLen(0)
+		default:
+			c.WriteError("ERR unknown sentinel subcommand")
+		}
+	})
+	require.NoError(t, err)
+	return sentinel.Addr()
+}
+
+func TestRedisCache_Sentinel(t *testing.T) {
+	ctx := context.Background()
+	master := miniredis.RunT(t)
+	sentinelAddr := runFakeSentinel(t, "mymaster", master.Addr())
+
+	client := NewGoRedisSentinelAdapter("mymaster", []string{sentinelAddr})
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+	defer cache.Close()
+
+	require.NoError(t, cache.Set(ctx, "key", "value", time.Minute))
+	got, err := master.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got)
+
+	value, exists, err := cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "value", value)
+}
+
+// serveRole makes master answer ROLE, which miniredis does not implement and
+// the rueidis Sentinel client uses to check that it reached the master
+func serveRole(t *testing.T, master *miniredis.Miniredis) {
+	err := master.Server().Register("ROLE", func(c *server.Peer, cmd string, args []string) {
+		c.WriteLen(3)
+		c.WriteBulk("master")
+		c.WriteInt(0)
+		c.WriteLen(0)
+	})
+	require.NoError(t, err)
+}
+
+func TestRueidisCache_Cluster(t *testing.T) {
+	ctx := context.Background()
+	// miniredis reports itself as the owner of every slot, which is enough
+	// for rueidis to reject multi-key commands that cross slots
+	node := miniredis.RunT(t)
+	client, err := NewRueidisClusterAdapter([]string{node.Addr()}, WithoutClientTracking())
+	require.NoError(t, err)
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+	defer cache.Close()
+
+	values := make(map[string]any)
+	keys := make([]string, 0, 30)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		values[key] = fmt.Sprintf("value%d", i)
+		keys = append(keys, key)
+	}
+
+	require.NoError(t, cache.Set(ctx, "key", "value", time.Minute))
+	value, exists, err := cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "value", value)
+
+	require.NoError(t, cache.SetMany(ctx, values, time.Minute))
+	found, err := cache.GetMany(ctx, append(keys, "missing"))
+	require.NoError(t, err)
+	assert.Equal(t, values, found)
+	require.NoError(t, cache.DeleteMany(ctx, keys[:10]))
+	found, err = cache.GetMany(ctx, keys)
+	require.NoError(t, err)
+	assert.Len(t, found, 20)
+
+	require.NoError(t, cache.DeletePrefix(ctx, "user:"))
+	assert.Equal(t, []string{"key"}, node.Keys())
+}
+
+func TestRueidisCache_Sentinel(t *testing.T) {
+	ctx := context.Background()
+	master := miniredis.RunT(t)
+	serveRole(t, master)
+	sentinelAddr := runFakeSentinel(t, "mymaster", master.Addr())
+
+	client, err := NewRueidisSentinelAdapter("mymaster", []string{sentinelAddr}, WithoutClientTracking())
+	require.NoError(t, err)
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+	defer cache.Close()
+
+	require.NoError(t, cache.Set(ctx, "key", "value", time.Minute))
+	got, err := master.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got)
+
+	value, exists, err := cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "value", value)
+}
+
+func TestRedisCache_ClusterDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	servers, client := newTestCluster(t, 3)
+	cache, err := NewRedisCache(client, WithClearDatabase())
+	require.NoError(t, err)
+
+	values := make(map[string]any)
+	for i := 0; i < 30; i++ {
+		values[fmt.Sprintf("user:%d", i)] = "value"
+		values[fmt.Sprintf("order:%d", i)] = "value"
+	}
+	require.NoError(t, cache.SetMany(ctx, values, time.Minute))
+
+	require.NoError(t, cache.DeletePrefix(ctx, "user:"))
+	remaining := 0
+	for _, server := range servers {
+		for _, key := range server.Keys() {
+			assert.True(t, strings.HasPrefix(key, "order:"), key)
+			remaining++
+		}
+	}
+	assert.Equal(t, 30, remaining)
+
+	require.NoError(t, cache.Clear(ctx))
+	for _, server := range servers {
+		assert.Empty(t, server.Keys())
+	}
+}
+
+func TestRedisCache_ClusterInvalidateTags(t *testing.T) {
+	ctx := context.Background()
+	servers, client := newTestCluster(t, 3)
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+
+	// Find two hash tags owned by different nodes
+	first, second := "{t0}", ""
+	for i := 1; second == ""; i++ {
+		tag := fmt.Sprintf("{t%d}", i)
+		if nodeFor(tag, len(servers)) != nodeFor(first, len(servers)) {
+			second = tag
+		}
+	}
+
+	for _, tag := range []string{first, second} {
+		require.NoError(t, cache.SetWithTags(ctx, tag+":a", "value", time.Minute, []string{tag}))
+		require.NoError(t, cache.SetWithTags(ctx, tag+":b", "value", time.Minute, []string{tag}))
+	}
+
+	keys, err := cache.InvalidateTags(ctx, first, second)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{first + ":a", first + ":b", second + ":a", second + ":b"}, keys)
+	for _, server := range servers {
+		assert.Empty(t, server.Keys())
+	}
+}
+
+func TestRedisCache_ClusterSetWithTags(t *testing.T) {
+	ctx := context.Background()
+	servers, client := newTestCluster(t, 3)
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+
+	// Pick tags whose sets live on a different node than the entry
+	key := "profile:42"
+	var tags []string
+	for i := 0; len(tags) < 2; i++ {
+		tag := fmt.Sprintf("user:%d", i)
+		if nodeFor(tagKeyPrefix+tag, len(servers)) != nodeFor(key, len(servers)) {
+			tags = append(tags, tag)
+		}
+	}
+
+	require.NoError(t, cache.SetWithTags(ctx, key, "value", time.Minute, tags))
+	for _, tagKey := range cache.tagKeys(tags) {
+		owner := servers[nodeFor(tagKey, len(servers))]
+		members, err := owner.Members(tagKey)
+		require.NoError(t, err)
+		assert.Equal(t, []string{key}, members)
+		assert.Equal(t, time.Minute, owner.TTL(tagKey))
+	}
+
+	deleted, err := cache.InvalidateTags(ctx, tags[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, deleted)
+	assert.False(t, servers[nodeFor(key, len(servers))].Exists(key))
+}
+
+func TestRedisCache_ClusterLeases(t *testing.T) {
+	ctx := context.Background()
+	servers, client := newTestCluster(t, 3)
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+
+	lease, err := cache.AcquireLease(ctx, "report", time.Minute)
+	require.NoError(t, err)
+	stored, err := cache.SetFenced(ctx, "report", "value", time.Minute, lease)
+	require.NoError(t, err)
+	assert.True(t, stored)
+	require.NoError(t, cache.ReleaseLease(ctx, lease))
+	assert.True(t, servers[nodeFor("report", len(servers))].Exists("report"))
+
+	_, _, token, err := cache.GetWithLease(ctx, "{a}b}")
+	require.NoError(t, err)
+	stored, err = cache.SetWithLease(ctx, "{a}b}", "value", time.Minute, token)
+	require.NoError(t, err)
+	assert.True(t, stored)
+
+	// Without a hash tag, the lease keys of a key with a "}" land elsewhere
+	_, err = cache.AcquireLease(ctx, "a}b", time.Minute)
+	assert.ErrorIs(t, err, ErrClusterKey)
+	_, _, _, err = cache.GetWithLease(ctx, "a}b")
+	assert.ErrorIs(t, err, ErrClusterKey)
+	_, err = cache.SetWithLease(ctx, "a}b", "value", time.Minute, 1)
+	assert.ErrorIs(t, err, ErrClusterKey)
+}
+
+func TestRedisCache_ClusterTagCleanup(t *testing.T) {
+	ctx := context.Background()
+	servers, client := newTestCluster(t, 3)
+	cache, err := NewRedisCache(client)
+	require.NoError(t, err)
+
+	// Pick tags whose sets live on a different node than the entry
+	key := "profile:42"
+	var tags []string
+	for i := 0; len(tags) < 2; i++ {
+		tag := fmt.Sprintf("user:%d", i)
+		if nodeFor(tagKeyPrefix+tag, len(servers)) != nodeFor(key, len(servers)) {
+			tags = append(tags, tag)
+		}
+	}
+	tagKeys := cache.tagKeys(tags)
+
+	require.NoError(t, cache.SetWithTags(ctx, key, "value", cachemanager.NoExpiration, tags))
+	require.NoError(t, cache.SetWithTags(ctx, key, "value", cachemanager.NoExpiration, tags[1:]))
+	assert.False(t, servers[nodeFor(tagKeys[0], len(servers))].Exists(tagKeys[0]))
+	assert.True(t, servers[nodeFor(tagKeys[1], len(servers))].Exists(tagKeys[1]))
+
+	require.NoError(t, cache.Delete(ctx, key))
+	for _, server := range servers {
+		assert.Empty(t, server.Keys())
+	}
+}
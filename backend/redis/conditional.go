@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// setNXScript stores a versioned value only if the key is missing.
+// KEYS[1] is the key.
+// ARGV[1] is the value, ARGV[2] its version and ARGV[3] the TTL in
+// milliseconds (0 = no expiration). Returns 1 if stored.
+const setNXScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'v', ARGV[1], 'ver', ARGV[2])
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`
+
+// compareAndSwapScript stores a versioned value only if the stored one still
+// has the expected version.
+// KEYS[1] is the key.
+// ARGV[1] is the expected version, ARGV[2] the new value, ARGV[3] its
+// version and ARGV[4] the TTL in milliseconds (0 = no expiration).
+// Returns 1 if stored.
+const compareAndSwapScript = readLua + `
+local current, version = read(KEYS[1])
+if not current or version ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'v', ARGV[2], 'ver', ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[4])
+end
+return 1
+`
+
+// SetNX stores value only if key is missing and reports whether it did.
+func (c *Cache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	strValue, err := encodeValue(value)
+	if err != nil {
+		return false, err
+	}
+	ttl, err = cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.client.Eval(ctx, setNXScript, []string{key},
+		strValue, strconv.FormatUint(newVersion(), 10), ttlMillis(ttl))
+	if err != nil {
+		return false, err
+	}
+	return result == int64(1), nil
+}
+
+// CompareAndSwap stores value only if key still has expectedVersion, as
+// returned by GetWithVersion, and reports whether it did. Values written by
+// Set have version 0, so a read-modify-write can start from them, but two
+// writes with Set in between cannot be told apart.
+func (c *Cache) CompareAndSwap(ctx context.Context, key string, expectedVersion uint64, value any, ttl time.Duration) (bool, error) {
+	strValue, err := encodeValue(value)
+	if err != nil {
+		return false, err
+	}
+	ttl, err = cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.client.Eval(ctx, compareAndSwapScript, []string{key},
+		strconv.FormatUint(expectedVersion, 10), strValue,
+		strconv.FormatUint(newVersion(), 10), ttlMillis(ttl))
+	if err != nil {
+		return false, err
+	}
+	return result == int64(1), nil
+}
+
+// GetWithVersion returns the value of key and its version.
+func (c *Cache) GetWithVersion(ctx context.Context, key string) (any, uint64, bool, error) {
+	found, err := c.getVersioned(ctx, []string{key}, keepTTL)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	entry, ok := found[key]
+	if !ok {
+		return nil, 0, false, nil
+	}
+	return entry.value, entry.version, true, nil
+}
+
+// newVersion returns a random non-zero version. Versions are random rather
+// than counted so a key that is deleted and written again never reuses one.
+func newVersion() uint64 {
+	return rand.Uint64() | 1
+}
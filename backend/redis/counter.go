@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// incrScript adds to a counter, setting the expiration only when it creates
+// the counter.
+// KEYS[1] is the counter.
+// ARGV[1] is the delta and ARGV[2] the TTL in milliseconds (0 = no
+// expiration). Returns the new value.
+const incrScript = `
+local created = redis.call('EXISTS', KEYS[1]) == 0
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if created and tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return value
+`
+
+// Incr adds delta to the counter at key with INCRBY and returns the new
+// value. A missing counter starts from 0 and expires after ttl; incrementing
+// an existing counter keeps its expiration. Get returns counters as decimal
+// strings.
+func (c *Cache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := c.client.Eval(ctx, incrScript, []string{key},
+		strconv.FormatInt(delta, 10), ttlMillis(ttl))
+	if err != nil {
+		if strings.Contains(err.Error(), "not an integer") || isWrongType(err) {
+			return 0, fmt.Errorf("key %s: %w", key, cachemanager.ErrNotCounter)
+		}
+		return 0, err
+	}
+	value, ok := result.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected counter script result %T", result)
+	}
+	return value, nil
+}
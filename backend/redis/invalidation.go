@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+// defaultInvalidationChannel is the Pub/Sub channel used to broadcast
+// invalidations that client-side caching cannot express, such as tags
+const defaultInvalidationChannel = "cachemanager:invalidations"
+
+// WithInvalidationChannel sets the Pub/Sub channel used to broadcast
+// invalidations to other cache instances. Instances that should see each
+// other's invalidations must use the same channel.
+func WithInvalidationChannel(channel string) CacheOption {
+	return func(c *Cache) {
+		c.invalidationChannel = channel
+	}
+}
+
+// broadcastMessage is the payload published on the invalidation channel.
+// Origin lets an instance ignore its own broadcasts.
+type broadcastMessage struct {
+	Origin string `json:"origin"`
+	cachemanager.InvalidationEvent
+}
+
+// GetInvalidationEvents returns a channel that receives invalidations
+// broadcast by other instances
+func (c *Cache) GetInvalidationEvents() <-chan cachemanager.InvalidationEvent {
+	return c.invalidationEvents
+}
+
+// broadcast publishes event to the other instances sharing this Redis
+func (c *Cache) broadcast(ctx context.Context, event cachemanager.InvalidationEvent) error {
+	payload, err := json.Marshal(broadcastMessage{Origin: c.instanceID, InvalidationEvent: event})
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, c.invalidationChannel, string(payload))
+}
+
+func (c *Cache) startBroadcastListener() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, err := c.client.Subscribe(ctx, c.invalidationChannel)
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.stopListening = cancel
+	c.invalidationEvents = make(chan cachemanager.InvalidationEvent, 100)
+
+	go func() {
+		defer close(c.invalidationEvents)
+		for payload := range messages {
+			var msg broadcastMessage
+			if err := json.Unmarshal([]byte(payload), &msg); err != nil || msg.Origin == c.instanceID {
+				continue
+			}
+			select {
+			case c.invalidationEvents <- msg.InvalidationEvent:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func newRandomID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
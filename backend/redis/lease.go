@@ -0,0 +1,276 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+const (
+	// leaseSuffix and fenceSuffix name the companion keys holding a key's
+	// lease token and fencing counter
+	leaseSuffix = ":cachemanager:lease"
+	fenceSuffix = ":cachemanager:fence"
+
+	// fillLeaseSuffix names the companion key holding the fill token handed
+	// out by GetWithLease
+	fillLeaseSuffix = ":cachemanager:fill"
+
+	// defaultFillLeaseTTL is how long a fill token stays valid
+	defaultFillLeaseTTL = 10 * time.Second
+
+	// fenceTTL is how long a fencing counter outlives the last lease granted
+	// on its key. Writes under a lease older than that are rejected, as the
+	// counter starts over from zero.
+	fenceTTL = 24 * time.Hour
+)
+
+// ErrClusterKey is returned on a Redis Cluster for keys containing a "}"
+// but no hash tag, whose lease keys cannot be placed in the key's hash slot.
+// Wrap such keys in a hash tag, as in "{a}b}", to use leases on them;
+// otherwise CacheManager, seeing cachemanager.ErrLeaseNotSupported, reads and
+// writes them without one.
+var ErrClusterKey = fmt.Errorf("%w: key has a \"}\" but no hash tag, so its lease keys cannot share its cluster slot", cachemanager.ErrLeaseNotSupported)
+
+// acquireLeaseScript takes the lease with SET NX PX and, if granted, bumps
+// the fencing counter.
+// KEYS[1] is the lease key, KEYS[2] the fencing counter.
+// ARGV[1] is the token, ARGV[2] the lease TTL and ARGV[3] the counter TTL,
+// both in milliseconds. Returns the fence, or 0 if the lease is held.
+const acquireLeaseScript = `
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 0
+end
+local fence = redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+return fence
+`
+
+// releaseLeaseScript deletes the lease only if it still holds the token.
+// KEYS[1] is the lease key, ARGV[1] the token.
+const releaseLeaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+// setFencedScript stores a value only if no newer lease has been granted.
+// KEYS[1] is the key, KEYS[2] its fencing counter.
+// ARGV[1] is the value, ARGV[2] the TTL in milliseconds (0 = no expiration)
+// and ARGV[3] the fence of the writer's lease. Returns 1 if stored.
+const setFencedScript = `
+if tonumber(redis.call('GET', KEYS[2]) or '0') ~= tonumber(ARGV[3]) then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 1
+`
+
+// AcquireLease takes the lease on key for ttl, shared by every instance using
+// this Redis. It returns nil if another instance holds it.
+func (c *Cache) AcquireLease(ctx context.Context, key string, ttl time.Duration) (*cachemanager.Lease, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lease TTL must be positive")
+	}
+	if err := c.checkCompanionSlot(key); err != nil {
+		return nil, err
+	}
+	token, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.client.Eval(ctx, acquireLeaseScript,
+		[]string{companionKey(key, leaseSuffix), companionKey(key, fenceSuffix)},
+		token, ttlMillis(ttl), ttlMillis(fenceTTL))
+	if err != nil {
+		return nil, err
+	}
+	fence, ok := result.(int64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected lease script result %T", result)
+	}
+	if fence == 0 {
+		return nil, nil
+	}
+	return &cachemanager.Lease{Key: key, Token: token, Fence: fence}, nil
+}
+
+// ReleaseLease gives up lease if it is still held
+func (c *Cache) ReleaseLease(ctx context.Context, lease *cachemanager.Lease) error {
+	if err := c.checkCompanionSlot(lease.Key); err != nil {
+		return err
+	}
+	_, err := c.client.Eval(ctx, releaseLeaseScript, []string{companionKey(lease.Key, leaseSuffix)}, lease.Token)
+	return err
+}
+
+// SetFenced stores value unless a lease on key newer than lease has been
+// granted since. It reports whether the value was stored.
+func (c *Cache) SetFenced(ctx context.Context, key string, value any, ttl time.Duration, lease *cachemanager.Lease) (bool, error) {
+	if err := c.checkCompanionSlot(key); err != nil {
+		return false, err
+	}
+	strValue, err := encodeValue(value)
+	if err != nil {
+		return false, err
+	}
+	ttl, err = cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.client.Eval(ctx, setFencedScript,
+		[]string{key, companionKey(key, fenceSuffix)},
+		strValue, ttlMillis(ttl), strconv.FormatInt(lease.Fence, 10))
+	if err != nil {
+		return false, err
+	}
+	return result == int64(1), nil
+}
+
+// checkCompanionSlot returns ErrClusterKey if the client is a Redis Cluster
+// and the companion keys of key hash to another slot
+func (c *Cache) checkCompanionSlot(key string) error {
+	if c.cluster && !hasCompanionSlot(key) {
+		return fmt.Errorf("%w: %q", ErrClusterKey, key)
+	}
+	return nil
+}
+
+// getWithLeaseScript reads a key and, on a miss, hands out a fill token
+// unless one is outstanding.
+// KEYS[1] is the key, KEYS[2] its fill lease.
+// ARGV[1] is the new token and ARGV[2] the lease TTL in milliseconds.
+// Returns {1, value} on a hit, {0, token} on a miss and {0, ""} if another
+// caller holds the fill lease.
+const getWithLeaseScript = readLua + `
+local value = read(KEYS[1])
+if value then
+	return {1, value}
+end
+if redis.call('SET', KEYS[2], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return {0, ARGV[1]}
+end
+return {0, ''}
+`
+
+// setWithLeaseScript stores a value only if the fill token is still
+// outstanding, consuming it.
+// KEYS[1] is the key, KEYS[2] its fill lease.
+// ARGV[1] is the value, ARGV[2] the TTL in milliseconds (0 = no expiration)
+// and ARGV[3] the token. Returns 1 if stored.
+const setWithLeaseScript = `
+if redis.call('GET', KEYS[2]) ~= ARGV[3] then
+	return 0
+end
+redis.call('DEL', KEYS[2])
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 1
+`
+
+// releaseFillLeaseScript drops a fill lease if the token is still the
+// outstanding one.
+// KEYS[1] is the fill lease, ARGV[1] the token.
+const releaseFillLeaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`
+
+// WithFillLeaseTTL sets how long the token handed out by GetWithLease on a
+// miss stays valid. It defaults to 10 seconds.
+func WithFillLeaseTTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		if ttl > 0 {
+			c.fillLeaseTTL = ttl
+		}
+	}
+}
+
+// GetWithLease returns the value of key. On a miss it hands out a fill token
+// for SetWithLease, shared by every instance using this Redis, or 0 if
+// another caller holds an unexpired one. Sliding expiration does not apply.
+func (c *Cache) GetWithLease(ctx context.Context, key string) (any, bool, uint64, error) {
+	if err := c.checkCompanionSlot(key); err != nil {
+		return nil, false, 0, err
+	}
+	// 0 means no token, so never hand it out
+	token := rand.Uint64() | 1
+
+	result, err := c.client.Eval(ctx, getWithLeaseScript,
+		[]string{key, companionKey(key, fillLeaseSuffix)},
+		strconv.FormatUint(token, 10), ttlMillis(c.fillLeaseTTL))
+	if err != nil {
+		return nil, false, 0, err
+	}
+	reply, ok := result.([]any)
+	if !ok || len(reply) != 2 {
+		return nil, false, 0, fmt.Errorf("unexpected fill lease script result %T", result)
+	}
+	if reply[0] == int64(1) {
+		return decodeValue(reply[1]), true, 0, nil
+	}
+	if reply[1] == "" {
+		return nil, false, 0, nil
+	}
+	return nil, false, token, nil
+}
+
+// SetWithLease stores value if token is still the outstanding fill lease on
+// key, which Delete revokes. It reports whether the value was stored.
+func (c *Cache) SetWithLease(ctx context.Context, key string, value any, ttl time.Duration, token uint64) (bool, error) {
+	if err := c.checkCompanionSlot(key); err != nil {
+		return false, err
+	}
+	strValue, err := encodeValue(value)
+	if err != nil {
+		return false, err
+	}
+	ttl, err = cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.client.Eval(ctx, setWithLeaseScript,
+		[]string{key, companionKey(key, fillLeaseSuffix)},
+		strValue, ttlMillis(ttl), strconv.FormatUint(token, 10))
+	if err != nil {
+		return false, err
+	}
+	return result == int64(1), nil
+}
+
+// ReleaseFillLease drops the fill lease on key if token is still the
+// outstanding one
+func (c *Cache) ReleaseFillLease(ctx context.Context, key string, token uint64) error {
+	if err := c.checkCompanionSlot(key); err != nil {
+		return err
+	}
+	_, err := c.client.Eval(ctx, releaseFillLeaseScript,
+		[]string{companionKey(key, fillLeaseSuffix)}, strconv.FormatUint(token, 10))
+	return err
+}
+
+// fillLeaseKeys returns the fill lease keys of keys
+func fillLeaseKeys(keys []string) []string {
+	leases := make([]string, len(keys))
+	for i, key := range keys {
+		leases[i] = companionKey(key, fillLeaseSuffix)
+	}
+	return leases
+}
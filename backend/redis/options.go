@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// Option configures the connection made by the Redis adapters
+type Option func(*redisOptions)
+
+type redisOptions struct {
+	Username         string
+	Password         string
+	DB               int
+	SentinelPassword string
+	TLSConfig        *tls.Config
+
+	PoolSize     int
+	MinIdleConns int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+
+	DisableClientTracking bool
+}
+
+func newRedisOptions(opts []Option) *redisOptions {
+	options := &redisOptions{
+		Password: "",
+		DB:       0,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+func WithPassword(password string) Option {
+	return func(ro *redisOptions) {
+		ro.Password = password
+	}
+}
+
+// WithUsername sets the ACL username used together with WithPassword
+func WithUsername(username string) Option {
+	return func(ro *redisOptions) {
+		ro.Username = username
+	}
+}
+
+func WithDB(db int) Option {
+	return func(ro *redisOptions) {
+		ro.DB = db
+	}
+}
+
+// WithSentinelPassword sets the password used to authenticate with Sentinel
+// nodes. It only applies to the Sentinel adapters.
+func WithSentinelPassword(password string) Option {
+	return func(ro *redisOptions) {
+		ro.SentinelPassword = password
+	}
+}
+
+// WithTLSConfig enables TLS. Set RootCAs for a custom CA and Certificates for
+// client certificates.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(ro *redisOptions) {
+		ro.TLSConfig = config
+	}
+}
+
+// WithPoolSize sets the maximum number of connections per node. rueidis
+// pipelines regular commands over a single connection per node, so there it
+// only sizes the pool used for blocking commands.
+func WithPoolSize(size int) Option {
+	return func(ro *redisOptions) {
+		ro.PoolSize = size
+	}
+}
+
+// WithMinIdleConns sets the number of idle connections kept open per node.
+// It only applies to the go-redis adapters.
+func WithMinIdleConns(n int) Option {
+	return func(ro *redisOptions) {
+		ro.MinIdleConns = n
+	}
+}
+
+// WithTimeouts sets the dial, read and write timeouts. rueidis has a single
+// timeout for reads and writes and uses the larger of the two.
+func WithTimeouts(dial, read, write time.Duration) Option {
+	return func(ro *redisOptions) {
+		ro.DialTimeout = dial
+		ro.ReadTimeout = read
+		ro.WriteTimeout = write
+	}
+}
+
+// WithMaxRetries sets how many times a failed command is retried. Use -1 to
+// disable retries.
+func WithMaxRetries(n int) Option {
+	return func(ro *redisOptions) {
+		ro.MaxRetries = n
+	}
+}
+
+// WithRetryBackoff sets the bounds of the exponential backoff between retries
+func WithRetryBackoff(minBackoff, maxBackoff time.Duration) Option {
+	return func(ro *redisOptions) {
+		ro.MinRetryBackoff = minBackoff
+		ro.MaxRetryBackoff = maxBackoff
+	}
+}
+
+// WithoutClientTracking makes the rueidis adapters speak RESP2 without
+// client tracking, for servers and proxies that support neither RESP3 nor
+// CLIENT TRACKING. Keys changed by other clients are then no longer reported
+// on the invalidation channel; messages published by WithInvalidationChannel
+// still are.
+func WithoutClientTracking() Option {
+	return func(ro *redisOptions) {
+		ro.DisableClientTracking = true
+	}
+}
+
+// applyRueidis copies the connection options onto a rueidis client option
+func (ro *redisOptions) applyRueidis(option *rueidis.ClientOption) {
+	option.Username = ro.Username
+	option.Password = ro.Password
+	option.TLSConfig = ro.TLSConfig
+	option.BlockingPoolSize = ro.PoolSize
+	option.Dialer.Timeout = ro.DialTimeout
+	option.ConnWriteTimeout = max(ro.ReadTimeout, ro.WriteTimeout)
+
+	if option.Sentinel.MasterSet != "" {
+		option.Sentinel.Password = ro.SentinelPassword
+		option.Sentinel.TLSConfig = ro.TLSConfig
+		option.Sentinel.Dialer.Timeout = ro.DialTimeout
+	}
+
+	switch {
+	case ro.MaxRetries < 0:
+		option.DisableRetry = true
+	case ro.MaxRetries > 0 || ro.MinRetryBackoff > 0 || ro.MaxRetryBackoff > 0:
+		option.RetryDelay = ro.retryDelay
+	}
+}
+
+// retryDelay implements rueidis.RetryDelayFn with the configured retry limit
+// and exponential backoff
+func (ro *redisOptions) retryDelay(attempts int, _ rueidis.Completed, _ error) time.Duration {
+	if ro.MaxRetries > 0 && attempts > ro.MaxRetries {
+		return -1
+	}
+	minBackoff, maxBackoff := ro.MinRetryBackoff, ro.MaxRetryBackoff
+	if minBackoff <= 0 {
+		minBackoff = 8 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 512 * time.Millisecond
+	}
+
+	backoff := minBackoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
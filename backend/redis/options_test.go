@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate issues a certificate for 127.0.0.1 signed by ca, or a
+// self-signed CA certificate when ca is nil.
+func testCertificate(t *testing.T, ca *tls.Certificate, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "cachemanager test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, any(key)
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent = ca.Leaf
+		signer = ca.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestGoRedisAdapter_TLSAndUsername(t *testing.T) {
+	ca := testCertificate(t, nil, x509.ExtKeyUsageAny)
+	serverCert := testCertificate(t, &ca, x509.ExtKeyUsageServerAuth)
+	clientCert := testCertificate(t, &ca, x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	mr, err := miniredis.RunTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err)
+	defer mr.Close()
+	mr.RequireUserAuth("cache", "secret")
+
+	cache, err := NewRedisCache(NewGoRedisAdapter(mr.Addr(),
+		WithUsername("cache"),
+		WithPassword("secret"),
+		WithTLSConfig(&tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{clientCert},
+		}),
+	))
+	require.NoError(t, err)
+	defer cache.Close()
+
+	ctx := context.Background()
+	require.NoError(t, cache.Set(ctx, "key", "value", time.Minute))
+	value, exists, err := cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "value", value)
+}
+
+func TestGoRedisAdapter_Options(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	client := NewGoRedisAdapter("localhost:6379",
+		WithUsername("user"),
+		WithTLSConfig(tlsConfig),
+		WithPoolSize(32),
+		WithMinIdleConns(4),
+		WithTimeouts(time.Second, 2*time.Second, 3*time.Second),
+		WithMaxRetries(5),
+		WithRetryBackoff(10*time.Millisecond, time.Second),
+	)
+	defer client.Close()
+
+	options := client.(*goRedisClient).client.(*redis.Client).Options()
+	assert.Equal(t, "user", options.Username)
+	assert.Same(t, tlsConfig, options.TLSConfig)
+	assert.Equal(t, 32, options.PoolSize)
+	assert.Equal(t, 4, options.MinIdleConns)
+	assert.Equal(t, time.Second, options.DialTimeout)
+	assert.Equal(t, 2*time.Second, options.ReadTimeout)
+	assert.Equal(t, 3*time.Second, options.WriteTimeout)
+	assert.Equal(t, 5, options.MaxRetries)
+	assert.Equal(t, 10*time.Millisecond, options.MinRetryBackoff)
+	assert.Equal(t, time.Second, options.MaxRetryBackoff)
+}
+
+func TestRedisOptions_ApplyRueidis(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	options := newRedisOptions([]Option{
+		WithUsername("user"),
+		WithPassword("secret"),
+		WithSentinelPassword("sentinel-secret"),
+		WithTLSConfig(tlsConfig),
+		WithPoolSize(8),
+		WithTimeouts(time.Second, 2*time.Second, 3*time.Second),
+		WithMaxRetries(3),
+		WithRetryBackoff(10*time.Millisecond, 30*time.Millisecond),
+	})
+
+	option := rueidis.ClientOption{Sentinel: rueidis.SentinelOption{MasterSet: "mymaster"}}
+	options.applyRueidis(&option)
+
+	assert.Equal(t, "user", option.Username)
+	assert.Equal(t, "secret", option.Password)
+	assert.Same(t, tlsConfig, option.TLSConfig)
+	assert.Equal(t, 8, option.BlockingPoolSize)
+	assert.Equal(t, time.Second, option.Dialer.Timeout)
+	assert.Equal(t, 3*time.Second, option.ConnWriteTimeout)
+	assert.Equal(t, "sentinel-secret", option.Sentinel.Password)
+	assert.Same(t, tlsConfig, option.Sentinel.TLSConfig)
+	require.NotNil(t, option.RetryDelay)
+
+	assert.Equal(t, 10*time.Millisecond, option.RetryDelay(1, rueidis.Completed{}, nil))
+	assert.Equal(t, 20*time.Millisecond, option.RetryDelay(2, rueidis.Completed{}, nil))
+	assert.Equal(t, 30*time.Millisecond, option.RetryDelay(3, rueidis.Completed{}, nil))
+	assert.Negative(t, option.RetryDelay(4, rueidis.Completed{}, nil))
+
+	disabled := rueidis.ClientOption{}
+	newRedisOptions([]Option{WithMaxRetries(-1)}).applyRueidis(&disabled)
+	assert.True(t, disabled.DisableRetry)
+}
@@ -2,26 +2,120 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
 )
 
 type Cache struct {
-	client           Client
-	invalidationChan <-chan string
+	client              Client
+	invalidationChan    <-chan string
+	invalidationEvents  chan cachemanager.InvalidationEvent
+	invalidationChannel string
+	instanceID          string
+	stopListening       context.CancelFunc
+	defaultTTL          time.Duration
+	sliding             bool
+	slidingTTL          time.Duration
+	scanBatchSize       int
+	clearPrefix         *string
+	clearDatabase       bool
+	fillLeaseTTL        time.Duration
+	cluster             bool
+	tagKeyPrefix        string
+}
+
+// CacheOption configures a Cache created by NewRedisCache
+type CacheOption func(*Cache)
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
 }
 
 type Client interface {
 	Get(ctx context.Context, key string) (any, error)
+	// GetEx gets the value of key and sets its TTL in the same round trip.
+	// A zero ttl removes the expiration.
+	GetEx(ctx context.Context, key string, ttl time.Duration) (any, error)
+	// Expire sets the TTL of key, reporting whether the key exists. A zero
+	// ttl removes the expiration.
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
 	Set(ctx context.Context, key string, value any, ttl time.Duration) error
 	Del(ctx context.Context, key string) error
+	// MGet returns the values of keys in order, nil for missing keys.
+	// Implementations group keys by cluster hash slot.
+	MGet(ctx context.Context, keys []string) ([]any, error)
+	MSet(ctx context.Context, values map[string]any, ttl time.Duration) error
+	DelMulti(ctx context.Context, keys []string) error
+	// Scan iterates with SCAN over the keys matching the glob pattern match
+	// on every primary node, passing each batch of about count keys to fn.
+	// fn may be called concurrently for different nodes.
+	Scan(ctx context.Context, match string, count int64, fn func(keys []string) error) error
+	// Unlink removes keys without blocking the server on freeing their
+	// memory. Implementations group keys by cluster hash slot.
+	Unlink(ctx context.Context, keys []string) error
+	// Eval runs a Lua script, returning its result as int64, string, nil or
+	// a []any of those.
+	Eval(ctx context.Context, script string, keys []string, args ...string) (any, error)
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe delivers messages published to channel until ctx is done,
+	// then closes the returned channel.
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
 	Close() error
 	StartInvalidationListener(ctx context.Context) (<-chan string, error)
 }
 
-func NewRedisCache(client Client) (*Cache, error) {
+// clusterDetector is implemented by the adapters of this package to report
+// whether they talk to a Redis Cluster
+type clusterDetector interface {
+	isCluster() bool
+}
+
+// WithSlidingExpiration makes every successful Get reset the key's TTL to
+// ttl using GETEX. Redis does not remember the TTL a key was written with, so
+// the sliding window is given here; cachemanager.DefaultTTL uses the cache's
+// default TTL.
+func WithSlidingExpiration(ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.sliding = true
+		c.slidingTTL = ttl
+	}
+}
+
+func NewRedisCache(client Client, opts ...CacheOption) (*Cache, error) {
+	instanceID, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
 	cache := &Cache{
-		client: client,
+		client:              client,
+		invalidationChannel: defaultInvalidationChannel,
+		instanceID:          instanceID,
+		defaultTTL:          cachemanager.NoExpiration,
+		scanBatchSize:       defaultScanBatchSize,
+		fillLeaseTTL:        defaultFillLeaseTTL,
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+	if detector, ok := client.(clusterDetector); ok {
+		cache.cluster = detector.isCluster()
+	}
+	// Keep tag sets under the clear prefix, so Clear removes them too
+	cache.tagKeyPrefix = tagKeyPrefix
+	if cache.clearPrefix != nil {
+		cache.tagKeyPrefix = *cache.clearPrefix + tagKeyPrefix
 	}
 
 	invalidationChan, err := client.StartInvalidationListener(context.Background())
@@ -30,33 +124,219 @@ func NewRedisCache(client Client) (*Cache, error) {
 	}
 	cache.invalidationChan = invalidationChan
 
+	if err := cache.startBroadcastListener(); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to invalidation broadcasts: %w", err)
+	}
+
 	return cache, nil
 }
 
 func (c *Cache) Get(ctx context.Context, key string) (any, bool, error) {
-	value, err := c.client.Get(ctx, key)
+	var value any
+	var err error
+	if c.sliding {
+		var ttl time.Duration
+		if ttl, err = cachemanager.ResolveTTL(c.slidingTTL, c.defaultTTL); err != nil {
+			return nil, false, err
+		}
+		value, err = c.client.GetEx(ctx, key, ttl)
+		if isWrongType(err) {
+			return c.getHash(ctx, key, ttl)
+		}
+	} else {
+		value, err = c.client.Get(ctx, key)
+		if isWrongType(err) {
+			return c.getHash(ctx, key, keepTTL)
+		}
+	}
 	if err != nil {
 		return nil, false, err
 	}
 	if value == nil {
 		return nil, false, nil
 	}
-	return value, true, nil
+	return decodeValue(value), true, nil
 }
 
+// Set stores value under key. Like the other backends, it drops the tags the
+// key was stored with, so a later InvalidateTags of one of them keeps the new
+// value.
 func (c *Cache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
-	strValue, ok := value.(string)
-	if !ok {
-		return fmt.Errorf("redis cache only supports string values")
+	if c.tracksTags(key) {
+		return c.SetWithTags(ctx, key, value, ttl, nil)
+	}
+	strValue, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+	ttl, err = cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
 	}
 	return c.client.Set(ctx, key, strValue, ttl)
 }
 
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (c *Cache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+// Touch resets the TTL of key without rewriting its value. It reports whether
+// the key was found.
+func (c *Cache) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+	return c.client.Expire(ctx, key, ttl)
+}
+
+// Delete removes key and revokes its outstanding fill lease, so a value
+// loaded before the delete cannot be written back with SetWithLease. The key
+// is also removed from the sets of its tags.
 func (c *Cache) Delete(ctx context.Context, key string) error {
-	return c.client.Del(ctx, key)
+	_, err := c.deleteEntries(ctx, []string{key}, nil)
+	return err
+}
+
+// GetMany returns the cached values of keys. Missing keys are left out.
+func (c *Cache) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	values, err := c.client.MGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]any, len(keys))
+	var missing []string
+	for i, value := range values {
+		if value != nil {
+			found[keys[i]] = decodeValue(value)
+		} else {
+			missing = append(missing, keys[i])
+		}
+	}
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	// MGET reports the hashes of versioned values as missing, so read the
+	// missing keys again, one script per slot
+	groups := groupBySlot(missing)
+	results := make([]map[string]versioned, len(groups))
+	errs := make([]error, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func(i int, group []string) {
+			defer wg.Done()
+			results[i], errs[i] = c.getVersioned(ctx, group, keepTTL)
+		}(i, group)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		for key, entry := range result {
+			found[key] = entry.value
+		}
+	}
+	return found, nil
+}
+
+// versioned is a value read by getScript
+type versioned struct {
+	value   any
+	version uint64
+}
+
+// getVersioned reads keys, all in one slot, whatever kind of value they
+// hold, and sets the TTL of those found unless ttl is keepTTL. Missing keys
+// are left out.
+func (c *Cache) getVersioned(ctx context.Context, keys []string, ttl time.Duration) (map[string]versioned, error) {
+	ttlArg := int64(-1)
+	if ttl != keepTTL {
+		ttlArg = roundUpMillis(ttl).Milliseconds()
+	}
+	result, err := c.client.Eval(ctx, getScript, keys, strconv.FormatInt(ttlArg, 10))
+	if err != nil {
+		return nil, err
+	}
+	reply, ok := result.([]any)
+	if !ok || len(reply) != len(keys) {
+		return nil, fmt.Errorf("unexpected get script result %T", result)
+	}
+
+	found := make(map[string]versioned, len(keys))
+	for i, item := range reply {
+		if item == nil {
+			continue
+		}
+		fields, ok := item.([]any)
+		if !ok || len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected get script result %T", item)
+		}
+		version, err := strconv.ParseUint(fmt.Sprint(fields[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version of key %s: %w", keys[i], err)
+		}
+		found[keys[i]] = versioned{value: decodeValue(fields[0]), version: version}
+	}
+	return found, nil
+}
+
+// getHash reads key after GET rejected it as holding a versioned value
+func (c *Cache) getHash(ctx context.Context, key string, ttl time.Duration) (any, bool, error) {
+	found, err := c.getVersioned(ctx, []string{key}, ttl)
+	if err != nil {
+		return nil, false, err
+	}
+	entry, ok := found[key]
+	return entry.value, ok, nil
+}
+
+// isWrongType reports whether err is the error Redis returns for a command
+// run against a key of another kind, directly or from a script
+func isWrongType(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "WRONGTYPE")
+}
+
+// ttlMillis formats a resolved TTL as the milliseconds a script expects, "0"
+// meaning no expiration. It rounds up, so a TTL under a millisecond still
+// expires instead of being stored forever.
+func ttlMillis(ttl time.Duration) string {
+	return strconv.FormatInt(roundUpMillis(ttl).Milliseconds(), 10)
+}
+
+// roundUpMillis rounds ttl up to a whole millisecond, the precision of PX and
+// PEXPIRE, so a positive TTL never becomes 0
+func roundUpMillis(ttl time.Duration) time.Duration {
+	return (ttl + time.Millisecond - 1).Truncate(time.Millisecond)
+}
+
+func (c *Cache) SetMany(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	encoded := make(map[string]any, len(values))
+	for key, value := range values {
+		strValue, err := encodeValue(value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = strValue
+	}
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+	return c.client.MSet(ctx, encoded, ttl)
+}
+
+func (c *Cache) DeleteMany(ctx context.Context, keys []string) error {
+	_, err := c.deleteEntries(ctx, keys, nil)
+	return err
 }
 
 func (c *Cache) Close() error {
+	c.stopListening()
 	return c.client.Close()
 }
 
@@ -3,48 +3,88 @@ package redis
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-type Option func(*redisOptions)
-
-type redisOptions struct {
-	Password string
-	DB       int
+type goRedisClient struct {
+	client  redis.UniversalClient
+	scripts sync.Map // script source -> *redis.Script
 }
 
-func WithPassword(password string) Option {
-	return func(ro *redisOptions) {
-		ro.Password = password
-	}
-}
+func NewGoRedisAdapter(addr string, opts ...Option) Client {
+	options := newRedisOptions(opts)
 
-func WithDB(db int) Option {
-	return func(ro *redisOptions) {
-		ro.DB = db
+	rdb := redis.NewClient(&redis.Options{
+		Addr:            addr,
+		Username:        options.Username,
+		Password:        options.Password,
+		DB:              options.DB,
+		TLSConfig:       options.TLSConfig,
+		PoolSize:        options.PoolSize,
+		MinIdleConns:    options.MinIdleConns,
+		DialTimeout:     options.DialTimeout,
+		ReadTimeout:     options.ReadTimeout,
+		WriteTimeout:    options.WriteTimeout,
+		MaxRetries:      options.MaxRetries,
+		MinRetryBackoff: options.MinRetryBackoff,
+		MaxRetryBackoff: options.MaxRetryBackoff,
+	})
+
+	return &goRedisClient{
+		client: rdb,
 	}
 }
 
-type goRedisClient struct {
-	client *redis.Client
-}
+// NewGoRedisClusterAdapter connects to a Redis Cluster through the given seed
+// addresses. Commands are routed to the node owning each key's hash slot.
+// WithDB is ignored because Redis Cluster only has database 0.
+func NewGoRedisClusterAdapter(addrs []string, opts ...Option) Client {
+	options := newRedisOptions(opts)
 
-func NewGoRedisAdapter(addr string, opts ...Option) Client {
-	options := &redisOptions{
-		Password: "",
-		DB:       0,
-	}
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:           addrs,
+		Username:        options.Username,
+		Password:        options.Password,
+		TLSConfig:       options.TLSConfig,
+		PoolSize:        options.PoolSize,
+		MinIdleConns:    options.MinIdleConns,
+		DialTimeout:     options.DialTimeout,
+		ReadTimeout:     options.ReadTimeout,
+		WriteTimeout:    options.WriteTimeout,
+		MaxRetries:      options.MaxRetries,
+		MinRetryBackoff: options.MinRetryBackoff,
+		MaxRetryBackoff: options.MaxRetryBackoff,
+	})
 
-	for _, opt := range opts {
-		opt(options)
+	return &goRedisClient{
+		client: rdb,
 	}
+}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: options.Password,
-		DB:       options.DB,
+// NewGoRedisSentinelAdapter connects to the master named masterName, as
+// reported by the given Sentinel addresses, and follows failovers.
+func NewGoRedisSentinelAdapter(masterName string, sentinelAddrs []string, opts ...Option) Client {
+	options := newRedisOptions(opts)
+
+	rdb := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:       masterName,
+		SentinelAddrs:    sentinelAddrs,
+		SentinelPassword: options.SentinelPassword,
+		Username:         options.Username,
+		Password:         options.Password,
+		DB:               options.DB,
+		TLSConfig:        options.TLSConfig,
+		PoolSize:         options.PoolSize,
+		MinIdleConns:     options.MinIdleConns,
+		DialTimeout:      options.DialTimeout,
+		ReadTimeout:      options.ReadTimeout,
+		WriteTimeout:     options.WriteTimeout,
+		MaxRetries:       options.MaxRetries,
+		MinRetryBackoff:  options.MinRetryBackoff,
+		MaxRetryBackoff:  options.MaxRetryBackoff,
 	})
 
 	return &goRedisClient{
@@ -63,17 +103,197 @@ func (g *goRedisClient) Get(ctx context.Context, key string) (any, error) {
 	return val, nil
 }
 
-func (g *goRedisClient) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+func (g *goRedisClient) GetEx(ctx context.Context, key string, ttl time.Duration) (any, error) {
+	val, err := g.client.GetEx(ctx, key, ttl).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func (g *goRedisClient) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
 	if ttl > 0 {
-		return g.client.Set(ctx, key, value, ttl).Err()
+		return g.client.PExpire(ctx, key, ttl).Result()
+	}
+	// PERSIST reports false for keys without a TTL, so check existence instead
+	pipe := g.client.TxPipeline()
+	exists := pipe.Exists(ctx, key)
+	pipe.Persist(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return exists.Val() == 1, nil
+}
+
+func (g *goRedisClient) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return g.client.Set(ctx, key, value, ttl).Err()
+}
+
+// MGet issues one MGET per hash slot, pipelined in a single round trip
+func (g *goRedisClient) MGet(ctx context.Context, keys []string) ([]any, error) {
+	groups := groupBySlot(keys)
+	cmds := make([]*redis.SliceCmd, len(groups))
+	_, err := g.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, group := range groups {
+			cmds[i] = pipe.MGet(ctx, group...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]any, len(keys))
+	for i, group := range groups {
+		for j, value := range cmds[i].Val() {
+			if value != nil {
+				found[group[j]] = value
+			}
+		}
+	}
+
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i] = found[key]
+	}
+	return values, nil
+}
+
+func (g *goRedisClient) MSet(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	_, err := g.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, group := range groupBySlot(keys) {
+			for _, key := range group {
+				pipe.Set(ctx, key, values[key], ttl)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// DelMulti issues one DEL per hash slot, pipelined in a single round trip
+func (g *goRedisClient) DelMulti(ctx context.Context, keys []string) error {
+	_, err := g.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, group := range groupBySlot(keys) {
+			pipe.Del(ctx, group...)
+		}
+		return nil
+	})
+	return err
+}
+
+func (g *goRedisClient) isCluster() bool {
+	_, ok := g.client.(*redis.ClusterClient)
+	return ok
+}
+
+func (g *goRedisClient) Scan(ctx context.Context, match string, count int64, fn func(keys []string) error) error {
+	if cluster, ok := g.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node, match, count, fn)
+		})
+	}
+	return scanNode(ctx, g.client, match, count, fn)
+}
+
+// scanNode runs a full SCAN iteration against a single node
+func scanNode(ctx context.Context, node redis.Cmdable, match string, count int64, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, match, count).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
 	}
-	return g.client.Set(ctx, key, value, 0).Err()
+}
+
+// Unlink issues one UNLINK per hash slot, pipelined in a single round trip
+func (g *goRedisClient) Unlink(ctx context.Context, keys []string) error {
+	_, err := g.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, group := range groupBySlot(keys) {
+			pipe.Unlink(ctx, group...)
+		}
+		return nil
+	})
+	return err
 }
 
 func (g *goRedisClient) Del(ctx context.Context, key string) error {
 	return g.client.Del(ctx, key).Err()
 }
 
+// Eval runs script with EVALSHA, falling back to EVAL the first time a node
+// sees it
+func (g *goRedisClient) Eval(ctx context.Context, script string, keys []string, args ...string) (any, error) {
+	cached, ok := g.scripts.Load(script)
+	if !ok {
+		cached, _ = g.scripts.LoadOrStore(script, redis.NewScript(script))
+	}
+
+	scriptArgs := make([]any, len(args))
+	for i, arg := range args {
+		scriptArgs[i] = arg
+	}
+	result, err := cached.(*redis.Script).Run(ctx, g.client, keys, scriptArgs...).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (g *goRedisClient) Publish(ctx context.Context, channel, message string) error {
+	return g.client.Publish(ctx, channel, message).Err()
+}
+
+func (g *goRedisClient) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	pubsub := g.client.Subscribe(ctx, channel)
+	// Wait for the subscription to be confirmed so connection errors surface here
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	messages := make(chan string, 100)
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages, nil
+}
+
 func (g *goRedisClient) Close() error {
 	return g.client.Close()
 }
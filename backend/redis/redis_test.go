@@ -3,12 +3,18 @@ package redis
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/inmemory"
+	"github.com/ethan-k/cachemanager-go/cachetest"
 	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -29,7 +35,8 @@ func (s *RedisCacheTestSuite) SetupTest() {
 	s.client = redis.NewClient(&redis.Options{
 		Addr: s.mr.Addr(),
 	})
-	s.cache = NewRedisCache(NewGoRedisAdapter(s.mr.Addr()))
+	s.cache, err = NewRedisCache(NewGoRedisAdapter(s.mr.Addr()))
+	require.NoError(s.T(), err)
 	s.ctx = context.Background()
 }
 
@@ -42,6 +49,67 @@ func TestRedisCacheSuite(t *testing.T) {
 	suite.Run(t, new(RedisCacheTestSuite))
 }
 
+func TestRedisCache_Conformance(t *testing.T) {
+	var mr *miniredis.Miniredis
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			mr = miniredis.RunT(t)
+			cache, err := NewRedisCache(NewGoRedisAdapter(mr.Addr()), WithDefaultTTL(defaultTTL))
+			require.NoError(t, err)
+			return cache
+		},
+		Advance: func(d time.Duration) {
+			mr.FastForward(d)
+		},
+	})
+}
+
+func TestRueidisCache_Conformance(t *testing.T) {
+	var mr *miniredis.Miniredis
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			mr = miniredis.RunT(t)
+			client, err := NewRueidisAdapter(mr.Addr(), WithoutClientTracking())
+			require.NoError(t, err)
+			cache, err := NewRedisCache(client, WithDefaultTTL(defaultTTL))
+			require.NoError(t, err)
+			return cache
+		},
+		Advance: func(d time.Duration) {
+			mr.FastForward(d)
+		},
+	})
+}
+
+// TestRueidisAdapter_SubMillisecondTTL checks that positive TTLs under a
+// millisecond round up to PX 1 instead of being rejected as PX 0, or deleting
+// the key as PEXPIRE 0
+func TestRueidisAdapter_SubMillisecondTTL(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client, err := NewRueidisAdapter(mr.Addr(), WithoutClientTracking())
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.Set(ctx, "set", "value", 500*time.Microsecond))
+	assert.Equal(t, time.Millisecond, mr.TTL("set"))
+
+	require.NoError(t, client.MSet(ctx, map[string]any{"mset": "value"}, 500*time.Microsecond))
+	assert.Equal(t, time.Millisecond, mr.TTL("mset"))
+
+	require.NoError(t, mr.Set("expire", "value"))
+	found, err := client.Expire(ctx, "expire", 500*time.Microsecond)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, time.Millisecond, mr.TTL("expire"))
+
+	require.NoError(t, mr.Set("getex", "value"))
+	value, err := client.GetEx(ctx, "getex", 500*time.Microsecond)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	assert.Equal(t, time.Millisecond, mr.TTL("getex"))
+}
+
 func (s *RedisCacheTestSuite) TestSetAndGet() {
 	tests := []struct {
 		name        string
@@ -202,3 +270,682 @@ func (s *RedisCacheTestSuite) TestContextCancellation() {
 	err = s.cache.Delete(ctx, "test")
 	s.Error(err)
 }
+
+func (s *RedisCacheTestSuite) TestSlidingExpiration() {
+	cache, err := NewRedisCache(NewGoRedisAdapter(s.mr.Addr()), WithSlidingExpiration(time.Minute))
+	s.Require().NoError(err)
+
+	s.NoError(cache.Set(s.ctx, "session", "data", time.Second))
+	s.Equal(time.Second, s.mr.TTL("session"))
+
+	value, exists, err := cache.Get(s.ctx, "session")
+	s.NoError(err)
+	s.True(exists)
+	s.Equal("data", value)
+	s.Equal(time.Minute, s.mr.TTL("session"))
+
+	_, exists, err = cache.Get(s.ctx, "missing")
+	s.NoError(err)
+	s.False(exists)
+}
+
+func (s *RedisCacheTestSuite) TestTouch() {
+	s.NoError(s.cache.Set(s.ctx, "key", "value", time.Second))
+
+	found, err := s.cache.Touch(s.ctx, "key", time.Hour)
+	s.NoError(err)
+	s.True(found)
+	s.Equal(time.Hour, s.mr.TTL("key"))
+
+	found, err = s.cache.Touch(s.ctx, "key", cachemanager.NoExpiration)
+	s.NoError(err)
+	s.True(found)
+	s.Equal(time.Duration(0), s.mr.TTL("key"))
+
+	found, err = s.cache.Touch(s.ctx, "missing", time.Hour)
+	s.NoError(err)
+	s.False(found)
+}
+
+func (s *RedisCacheTestSuite) TestTags() {
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:42", "p", time.Minute, []string{"user:42"}))
+	s.NoError(s.cache.SetWithTags(s.ctx, "orders:42", "o", time.Hour, []string{"user:42"}))
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:7", "p", time.Minute, []string{"user:7"}))
+
+	members, err := s.mr.Members(tagKeyPrefix + "user:42")
+	s.NoError(err)
+	s.ElementsMatch([]string{"profile:42", "orders:42"}, members)
+	s.Equal(time.Hour, s.mr.TTL(tagKeyPrefix+"user:42"))
+
+	keys, err := s.cache.InvalidateTags(s.ctx, "user:42")
+	s.NoError(err)
+	s.ElementsMatch([]string{"profile:42", "orders:42"}, keys)
+
+	s.False(s.mr.Exists("profile:42"))
+	s.False(s.mr.Exists("orders:42"))
+	s.False(s.mr.Exists(tagKeyPrefix + "user:42"))
+	s.True(s.mr.Exists("profile:7"))
+}
+
+func (s *RedisCacheTestSuite) TestTagsAreReplaced() {
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:42", "p1", cachemanager.NoExpiration, []string{"user:42", "team:1"}))
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:42", "p2", cachemanager.NoExpiration, []string{"user:42", "team:2"}))
+
+	// The key left the set of the tag it lost, which was its only member
+	s.False(s.mr.Exists(tagKeyPrefix + "team:1"))
+	members, err := s.mr.Members(tagKeyPrefix + "team:2")
+	s.NoError(err)
+	s.Equal([]string{"profile:42"}, members)
+
+	keys, err := s.cache.InvalidateTags(s.ctx, "team:1")
+	s.NoError(err)
+	s.Empty(keys)
+	s.True(s.mr.Exists("profile:42"))
+
+	// Invalidating one tag removes the key from the sets of the others
+	keys, err = s.cache.InvalidateTags(s.ctx, "team:2")
+	s.NoError(err)
+	s.Equal([]string{"profile:42"}, keys)
+	s.Empty(s.mr.Keys())
+}
+
+func (s *RedisCacheTestSuite) TestTagsAreRemovedOnSet() {
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:42", "p1", cachemanager.NoExpiration, []string{"user:42"}))
+	s.NoError(s.cache.Set(s.ctx, "profile:42", "p2", time.Minute))
+
+	s.False(s.mr.Exists(tagKeyPrefix + "user:42"))
+	s.False(s.mr.Exists(companionKey("profile:42", tagsSuffix)))
+	s.Equal(time.Minute, s.mr.TTL("profile:42"))
+
+	keys, err := s.cache.InvalidateTags(s.ctx, "user:42")
+	s.NoError(err)
+	s.Empty(keys)
+	value, found, err := s.cache.Get(s.ctx, "profile:42")
+	s.NoError(err)
+	s.True(found)
+	s.Equal("p2", value)
+}
+
+func (s *RedisCacheTestSuite) TestTagsAreRemovedOnDelete() {
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:42", "p", cachemanager.NoExpiration, []string{"user:42"}))
+	s.NoError(s.cache.SetWithTags(s.ctx, "orders:42", "o", cachemanager.NoExpiration, []string{"user:42"}))
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:7", "p", cachemanager.NoExpiration, []string{"user:7"}))
+
+	s.NoError(s.cache.Delete(s.ctx, "profile:42"))
+	members, err := s.mr.Members(tagKeyPrefix + "user:42")
+	s.NoError(err)
+	s.Equal([]string{"orders:42"}, members)
+
+	s.NoError(s.cache.DeleteMany(s.ctx, []string{"orders:42", "profile:7"}))
+	s.Empty(s.mr.Keys())
+}
+
+func (s *RedisCacheTestSuite) TestTagsAreClearedWithThePrefix() {
+	cache, err := NewRedisCache(NewGoRedisAdapter(s.mr.Addr()), WithClearPrefix("app:"))
+	s.Require().NoError(err)
+	defer cache.Close()
+
+	s.NoError(cache.SetWithTags(s.ctx, "app:profile:42", "p", cachemanager.NoExpiration, []string{"user:42"}))
+	s.True(s.mr.Exists("app:" + tagKeyPrefix + "user:42"))
+	s.NoError(s.mr.Set("other-app:lock", "token"))
+
+	s.NoError(cache.Clear(s.ctx))
+	s.Equal([]string{"other-app:lock"}, s.mr.Keys())
+}
+
+func (s *RedisCacheTestSuite) TestTagsWithSubMillisecondTTL() {
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:42", "p", 500*time.Microsecond, []string{"user:42"}))
+
+	s.Equal(time.Millisecond, s.mr.TTL("profile:42"))
+	s.Equal(time.Millisecond, s.mr.TTL(tagKeyPrefix+"user:42"))
+}
+
+func (s *RedisCacheTestSuite) TestTagInvalidationIsBroadcast() {
+	other, err := NewRedisCache(NewGoRedisAdapter(s.mr.Addr()))
+	s.Require().NoError(err)
+	defer other.Close()
+
+	s.NoError(s.cache.SetWithTags(s.ctx, "profile:42", "p", time.Minute, []string{"user:42"}))
+	_, err = s.cache.InvalidateTags(s.ctx, "user:42")
+	s.NoError(err)
+
+	select {
+	case event := <-other.GetInvalidationEvents():
+		s.Equal([]string{"user:42"}, event.Tags)
+		s.Equal([]string{"profile:42"}, event.Keys)
+	case <-time.After(time.Second):
+		s.Fail("invalidation event was not received")
+	}
+
+	select {
+	case event := <-s.cache.GetInvalidationEvents():
+		s.Failf("instance received its own broadcast", "%+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCacheManager_TagInvalidationAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	newInstance := func() (*cachemanager.CacheManager, *inmemory.Cache) {
+		local := inmemory.NewInMemoryCache()
+		shared, err := NewRedisCache(NewGoRedisAdapter(mr.Addr()))
+		require.NoError(t, err)
+		cm := cachemanager.NewCacheManager(
+			cachemanager.CacheConfig{Backend: local, TTL: time.Minute},
+			cachemanager.CacheConfig{Backend: shared, TTL: time.Hour},
+		)
+		t.Cleanup(func() { _ = cm.Close() })
+		return cm, local
+	}
+	instanceA, _ := newInstance()
+	instanceB, localB := newInstance()
+
+	require.NoError(t, instanceA.SetWithTags(ctx, "profile:42", "alice", "user:42"))
+
+	// Instance B backfills its local tier from Redis, without tags
+	value, err := instanceB.Get(ctx, "profile:42")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", value)
+	require.Eventually(t, func() bool {
+		_, exists, _ := localB.Get(ctx, "profile:42")
+		return exists
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, instanceA.InvalidateTags(ctx, "user:42"))
+
+	assert.Eventually(t, func() bool {
+		_, exists, _ := localB.Get(ctx, "profile:42")
+		return !exists
+	}, time.Second, 10*time.Millisecond)
+	_, err = instanceB.Get(ctx, "profile:42")
+	assert.Error(t, err)
+}
+
+// miniredis's SCAN cursor is an offset into the sorted keyspace, so keys
+// deleted mid-iteration shift later keys past the cursor. Redis guarantees
+// every key present for the whole iteration is returned, but to stay within
+// miniredis's semantics these tests use one SCAN batch per node.
+func (s *RedisCacheTestSuite) TestDeletePrefix() {
+	for i := 0; i < 10; i++ {
+		s.NoError(s.mr.Set(fmt.Sprintf("user:%d", i), "value"))
+	}
+	s.NoError(s.mr.Set("user*x", "value"))
+	s.NoError(s.mr.Set("userx", "value"))
+	s.NoError(s.mr.Set("order:1", "value"))
+
+	s.NoError(s.cache.DeletePrefix(s.ctx, "user:"))
+	s.ElementsMatch([]string{"user*x", "userx", "order:1"}, s.mr.Keys())
+
+	// Glob characters in the prefix are matched literally
+	s.NoError(s.cache.DeletePrefix(s.ctx, "user*"))
+	s.ElementsMatch([]string{"userx", "order:1"}, s.mr.Keys())
+
+	// An empty prefix would match keys this cache does not own
+	s.ErrorIs(s.cache.DeletePrefix(s.ctx, ""), cachemanager.ErrEmptyPrefix)
+	s.ElementsMatch([]string{"userx", "order:1"}, s.mr.Keys())
+}
+
+func (s *RedisCacheTestSuite) TestClear() {
+	s.NoError(s.mr.Set("key1", "value"))
+	s.NoError(s.mr.Set("key2", "value"))
+
+	other, err := NewRedisCache(NewGoRedisAdapter(s.mr.Addr()))
+	s.Require().NoError(err)
+	defer other.Close()
+
+	// Clearing a shared database must be asked for
+	s.ErrorIs(s.cache.Clear(s.ctx), cachemanager.ErrClearNotSupported)
+	s.Len(s.mr.Keys(), 2)
+
+	cache, err := NewRedisCache(NewGoRedisAdapter(s.mr.Addr()), WithClearDatabase())
+	s.Require().NoError(err)
+	defer cache.Close()
+	s.NoError(cache.Clear(s.ctx))
+	s.Empty(s.mr.Keys())
+
+	select {
+	case event := <-other.GetInvalidationEvents():
+		s.True(event.All)
+	case <-time.After(time.Second):
+		s.Fail("clear was not broadcast")
+	}
+
+	s.NoError(s.cache.DeletePrefix(s.ctx, "user:"))
+	select {
+	case event := <-other.GetInvalidationEvents():
+		s.Equal([]string{"user:"}, event.Prefixes)
+	case <-time.After(time.Second):
+		s.Fail("prefix deletion was not broadcast")
+	}
+}
+
+func (s *RedisCacheTestSuite) TestClearPrefix() {
+	cache, err := NewRedisCache(NewGoRedisAdapter(s.mr.Addr()), WithClearPrefix("app:"))
+	s.Require().NoError(err)
+	defer cache.Close()
+
+	s.NoError(cache.Set(s.ctx, "app:1", "value", time.Minute))
+	s.NoError(s.mr.Set("other-app:lock", "token"))
+	s.NoError(cache.Clear(s.ctx))
+	s.Equal([]string{"other-app:lock"}, s.mr.Keys())
+}
+
+func TestEscapeGlob(t *testing.T) {
+	assert.Equal(t, "user:", escapeGlob("user:"))
+	assert.Equal(t, `a\*b\?c\[d\]e\\f`, escapeGlob(`a*b?c[d]e\f`))
+}
+
+func (s *RedisCacheTestSuite) TestTombstone() {
+	s.NoError(s.cache.Set(s.ctx, "missing", cachemanager.Tombstone{}, time.Minute))
+	raw, err := s.mr.Get("missing")
+	s.NoError(err)
+	s.Equal(tombstoneValue, raw)
+
+	value, exists, err := s.cache.Get(s.ctx, "missing")
+	s.NoError(err)
+	s.True(exists)
+	s.True(cachemanager.IsTombstone(value))
+
+	s.NoError(s.cache.SetMany(s.ctx, map[string]any{"a": cachemanager.Tombstone{}, "b": "value"}, time.Minute))
+	found, err := s.cache.GetMany(s.ctx, []string{"a", "b"})
+	s.NoError(err)
+	s.Equal(map[string]any{"a": cachemanager.Tombstone{}, "b": "value"}, found)
+}
+
+func TestCacheManager_NegativeCachingThroughRedis(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	shared, err := NewRedisCache(NewGoRedisAdapter(mr.Addr()))
+	require.NoError(t, err)
+	cm := cachemanager.NewCacheManager(
+		cachemanager.CacheConfig{Backend: inmemory.NewInMemoryCache(), TTL: time.Minute, NegativeTTL: time.Second},
+		cachemanager.CacheConfig{Backend: shared, TTL: time.Hour, NegativeTTL: 10 * time.Second},
+	)
+	defer cm.Close()
+
+	loads := 0
+	load := func(context.Context) (any, error) {
+		loads++
+		return nil, cachemanager.ErrNotFound
+	}
+
+	_, err = cm.GetOrLoad(ctx, "user:404", load)
+	assert.ErrorIs(t, err, cachemanager.ErrNotFound)
+	assert.Equal(t, 10*time.Second, mr.TTL("user:404"))
+
+	// A second instance sharing Redis sees the cached miss too
+	other, err := NewRedisCache(NewGoRedisAdapter(mr.Addr()))
+	require.NoError(t, err)
+	otherCM := cachemanager.NewCacheManager(cachemanager.CacheConfig{Backend: other, TTL: time.Hour, NegativeTTL: 10 * time.Second})
+	defer otherCM.Close()
+
+	_, err = otherCM.GetOrLoad(ctx, "user:404", load)
+	assert.ErrorIs(t, err, cachemanager.ErrNotFound)
+	_, err = otherCM.Get(ctx, "user:404")
+	assert.ErrorIs(t, err, cachemanager.ErrNotFound)
+	assert.Equal(t, 1, loads)
+}
+
+func (s *RedisCacheTestSuite) TestEarlyRefreshEntry() {
+	expiry := time.Now().Add(time.Minute).Round(0)
+	entry := cachemanager.EarlyRefreshEntry{Value: "a:b:c", Delta: 250 * time.Millisecond, Expiry: expiry}
+	s.NoError(s.cache.Set(s.ctx, "key", entry, time.Minute))
+
+	value, exists, err := s.cache.Get(s.ctx, "key")
+	s.NoError(err)
+	s.True(exists)
+	decoded, ok := value.(cachemanager.EarlyRefreshEntry)
+	s.Require().True(ok)
+	s.Equal("a:b:c", decoded.Value)
+	s.Equal(250*time.Millisecond, decoded.Delta)
+	s.True(expiry.Equal(decoded.Expiry))
+
+	s.NoError(s.cache.Set(s.ctx, "forever", cachemanager.EarlyRefreshEntry{Value: "v"}, 0))
+	value, _, err = s.cache.Get(s.ctx, "forever")
+	s.NoError(err)
+	s.Equal(cachemanager.EarlyRefreshEntry{Value: "v"}, value)
+
+	s.Error(s.cache.Set(s.ctx, "key", cachemanager.EarlyRefreshEntry{Value: 42}, time.Minute))
+}
+
+func TestCacheManager_EarlyRefreshThroughRedis(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	shared, err := NewRedisCache(NewGoRedisAdapter(mr.Addr()))
+	require.NoError(t, err)
+	cm := cachemanager.NewCacheManager(cachemanager.CacheConfig{Backend: shared, TTL: time.Hour})
+	defer cm.Close()
+
+	value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+		return "value", nil
+	}, cachemanager.WithEarlyRefresh(1))
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// Another instance reading the key gets the value and its metadata back
+	raw, exists, err := shared.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	entry, ok := raw.(cachemanager.EarlyRefreshEntry)
+	require.True(t, ok)
+	assert.Equal(t, "value", entry.Value)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.Expiry, time.Second)
+
+	value, err = cm.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func (s *RedisCacheTestSuite) TestLease() {
+	lease, err := s.cache.AcquireLease(s.ctx, "report", time.Second)
+	s.Require().NoError(err)
+	s.Require().NotNil(lease)
+	s.Equal(int64(1), lease.Fence)
+	s.Equal(time.Second, s.mr.TTL(companionKey("report", leaseSuffix)))
+
+	held, err := s.cache.AcquireLease(s.ctx, "report", time.Second)
+	s.NoError(err)
+	s.Nil(held, "the lease is exclusive")
+
+	// Only the holder's token releases the lease
+	s.NoError(s.cache.ReleaseLease(s.ctx, &cachemanager.Lease{Key: "report", Token: "someone else"}))
+	s.True(s.mr.Exists(companionKey("report", leaseSuffix)))
+	s.NoError(s.cache.ReleaseLease(s.ctx, lease))
+	s.False(s.mr.Exists(companionKey("report", leaseSuffix)))
+
+	next, err := s.cache.AcquireLease(s.ctx, "report", time.Second)
+	s.NoError(err)
+	s.Require().NotNil(next)
+	s.Equal(int64(2), next.Fence)
+
+	// A stuck holder's lease expires and is granted again
+	s.mr.FastForward(2 * time.Second)
+	latest, err := s.cache.AcquireLease(s.ctx, "report", time.Second)
+	s.NoError(err)
+	s.Require().NotNil(latest)
+	s.Equal(int64(3), latest.Fence)
+
+	_, err = s.cache.AcquireLease(s.ctx, "report", 0)
+	s.Error(err)
+}
+
+func (s *RedisCacheTestSuite) TestSetFenced() {
+	stale, err := s.cache.AcquireLease(s.ctx, "report", time.Second)
+	s.Require().NoError(err)
+	s.mr.FastForward(2 * time.Second)
+	current, err := s.cache.AcquireLease(s.ctx, "report", time.Second)
+	s.Require().NoError(err)
+
+	stored, err := s.cache.SetFenced(s.ctx, "report", "new", time.Minute, current)
+	s.NoError(err)
+	s.True(stored)
+
+	// The holder whose lease ran out finishes late and must not win
+	stored, err = s.cache.SetFenced(s.ctx, "report", "old", time.Minute, stale)
+	s.NoError(err)
+	s.False(stored)
+
+	value, _, err := s.cache.Get(s.ctx, "report")
+	s.NoError(err)
+	s.Equal("new", value)
+	s.Equal(time.Minute, s.mr.TTL("report"))
+}
+
+func (s *RedisCacheTestSuite) TestFillLease() {
+	_, found, token, err := s.cache.GetWithLease(s.ctx, "user:1")
+	s.Require().NoError(err)
+	s.False(found)
+	s.NotZero(token)
+	s.Equal(10*time.Second, s.mr.TTL(companionKey("user:1", fillLeaseSuffix)))
+
+	// Only one caller at a time gets to fill the key
+	_, _, second, err := s.cache.GetWithLease(s.ctx, "user:1")
+	s.Require().NoError(err)
+	s.Zero(second)
+
+	stored, err := s.cache.SetWithLease(s.ctx, "user:1", "value", time.Minute, token)
+	s.NoError(err)
+	s.True(stored)
+	s.Equal(time.Minute, s.mr.TTL("user:1"))
+	s.False(s.mr.Exists(companionKey("user:1", fillLeaseSuffix)))
+
+	value, found, token, err := s.cache.GetWithLease(s.ctx, "user:1")
+	s.NoError(err)
+	s.True(found)
+	s.Equal("value", value)
+	s.Zero(token)
+}
+
+func (s *RedisCacheTestSuite) TestFillLeaseRevokedByDelete() {
+	_, _, token, err := s.cache.GetWithLease(s.ctx, "user:1")
+	s.Require().NoError(err)
+	s.Require().NoError(s.cache.Delete(s.ctx, "user:1"))
+
+	stored, err := s.cache.SetWithLease(s.ctx, "user:1", "stale", time.Minute, token)
+	s.NoError(err)
+	s.False(stored)
+	s.False(s.mr.Exists("user:1"))
+
+	_, _, token, err = s.cache.GetWithLease(s.ctx, "user:2")
+	s.Require().NoError(err)
+	s.Require().NoError(s.cache.DeletePrefix(s.ctx, "user:"))
+	stored, err = s.cache.SetWithLease(s.ctx, "user:2", "stale", time.Minute, token)
+	s.NoError(err)
+	s.False(stored)
+}
+
+func (s *RedisCacheTestSuite) TestReleaseFillLease() {
+	_, _, token, err := s.cache.GetWithLease(s.ctx, "user:1")
+	s.Require().NoError(err)
+
+	// Releasing someone else's token has no effect
+	s.NoError(s.cache.ReleaseFillLease(s.ctx, "user:1", token+2))
+	s.True(s.mr.Exists(companionKey("user:1", fillLeaseSuffix)))
+
+	s.NoError(s.cache.ReleaseFillLease(s.ctx, "user:1", token))
+	s.False(s.mr.Exists(companionKey("user:1", fillLeaseSuffix)))
+	_, _, fresh, err := s.cache.GetWithLease(s.ctx, "user:1")
+	s.NoError(err)
+	s.NotZero(fresh)
+}
+
+func (s *RedisCacheTestSuite) TestIncr() {
+	value, err := s.cache.Incr(s.ctx, "views", 3, time.Minute)
+	s.Require().NoError(err)
+	s.Equal(int64(3), value)
+	s.Equal(time.Minute, s.mr.TTL("views"))
+
+	s.mr.FastForward(30 * time.Second)
+	value, err = s.cache.Incr(s.ctx, "views", -5, time.Minute)
+	s.Require().NoError(err)
+	s.Equal(int64(-2), value)
+	s.Equal(30*time.Second, s.mr.TTL("views"))
+
+	stored, found, err := s.cache.Get(s.ctx, "views")
+	s.NoError(err)
+	s.True(found)
+	s.Equal("-2", stored)
+
+	s.Require().NoError(s.cache.Set(s.ctx, "name", "text", time.Minute))
+	_, err = s.cache.Incr(s.ctx, "name", 1, time.Minute)
+	s.ErrorIs(err, cachemanager.ErrNotCounter)
+}
+
+func (s *RedisCacheTestSuite) TestIncrWithSubMillisecondTTL() {
+	_, err := s.cache.Incr(s.ctx, "views", 1, 500*time.Microsecond)
+	s.Require().NoError(err)
+	s.Equal(time.Millisecond, s.mr.TTL("views"))
+}
+
+func (s *RedisCacheTestSuite) TestConditionalWrites() {
+	stored, err := s.cache.SetNX(s.ctx, "cart", "a", time.Minute)
+	s.Require().NoError(err)
+	s.True(stored)
+	s.Equal(time.Minute, s.mr.TTL("cart"))
+	stored, err = s.cache.SetNX(s.ctx, "cart", "b", time.Minute)
+	s.Require().NoError(err)
+	s.False(stored)
+
+	value, version, found, err := s.cache.GetWithVersion(s.ctx, "cart")
+	s.Require().NoError(err)
+	s.True(found)
+	s.Equal("a", value)
+	s.NotZero(version)
+
+	stored, err = s.cache.CompareAndSwap(s.ctx, "cart", version, "ab", time.Hour)
+	s.Require().NoError(err)
+	s.True(stored)
+	s.Equal(time.Hour, s.mr.TTL("cart"))
+	stored, err = s.cache.CompareAndSwap(s.ctx, "cart", version, "ac", time.Hour)
+	s.Require().NoError(err)
+	s.False(stored)
+
+	// The value and version are stored side by side in a hash
+	_, swapped, _, err := s.cache.GetWithVersion(s.ctx, "cart")
+	s.Require().NoError(err)
+	s.NotEqual(version, swapped)
+	s.Equal("ab", s.mr.HGet("cart", "v"))
+	s.Equal(strconv.FormatUint(swapped, 10), s.mr.HGet("cart", "ver"))
+
+	// Every read understands versioned values
+	value, found, err = s.cache.Get(s.ctx, "cart")
+	s.NoError(err)
+	s.True(found)
+	s.Equal("ab", value)
+	s.Require().NoError(s.cache.Set(s.ctx, "plain", "p", time.Minute))
+	values, err := s.cache.GetMany(s.ctx, []string{"cart", "plain", "missing"})
+	s.NoError(err)
+	s.Equal(map[string]any{"cart": "ab", "plain": "p"}, values)
+	value, found, token, err := s.cache.GetWithLease(s.ctx, "cart")
+	s.NoError(err)
+	s.True(found)
+	s.Zero(token)
+	s.Equal("ab", value)
+	_, err = s.cache.Incr(s.ctx, "cart", 1, time.Minute)
+	s.ErrorIs(err, cachemanager.ErrNotCounter)
+
+	// A plain write replaces the versioned value
+	s.Require().NoError(s.cache.Set(s.ctx, "cart", "reset", time.Minute))
+	value, version, found, err = s.cache.GetWithVersion(s.ctx, "cart")
+	s.Require().NoError(err)
+	s.True(found)
+	s.Equal("reset", value)
+	s.Zero(version)
+}
+
+func (s *RedisCacheTestSuite) TestSlidingExpirationVersioned() {
+	cache, err := NewRedisCache(NewGoRedisAdapter(s.mr.Addr()), WithSlidingExpiration(time.Minute))
+	s.Require().NoError(err)
+
+	stored, err := cache.SetNX(s.ctx, "session", "data", time.Second)
+	s.Require().NoError(err)
+	s.True(stored)
+
+	value, found, err := cache.Get(s.ctx, "session")
+	s.NoError(err)
+	s.True(found)
+	s.Equal("data", value)
+	s.Equal(time.Minute, s.mr.TTL("session"))
+}
+
+func (s *RedisCacheTestSuite) TestCompareAndSwapUnversioned() {
+	s.Require().NoError(s.cache.Set(s.ctx, "config", "v1", time.Minute))
+
+	value, version, found, err := s.cache.GetWithVersion(s.ctx, "config")
+	s.Require().NoError(err)
+	s.True(found)
+	s.Equal("v1", value)
+	s.Zero(version)
+
+	stored, err := s.cache.CompareAndSwap(s.ctx, "config", 0, "v2", time.Minute)
+	s.Require().NoError(err)
+	s.True(stored)
+
+	// The value is versioned now, so version 0 no longer matches
+	stored, err = s.cache.CompareAndSwap(s.ctx, "config", 0, "v3", time.Minute)
+	s.Require().NoError(err)
+	s.False(stored)
+	stored, err = s.cache.CompareAndSwap(s.ctx, "missing", 0, "v1", time.Minute)
+	s.Require().NoError(err)
+	s.False(stored)
+}
+
+func (s *RedisCacheTestSuite) TestConditionalWritesWithSubMillisecondTTL() {
+	stored, err := s.cache.SetNX(s.ctx, "config", "v1", 500*time.Microsecond)
+	s.Require().NoError(err)
+	s.True(stored)
+	s.Equal(time.Millisecond, s.mr.TTL("config"))
+
+	_, version, _, err := s.cache.GetWithVersion(s.ctx, "config")
+	s.Require().NoError(err)
+	stored, err = s.cache.CompareAndSwap(s.ctx, "config", version, "v2", 500*time.Microsecond)
+	s.Require().NoError(err)
+	s.True(stored)
+	s.Equal(time.Millisecond, s.mr.TTL("config"))
+}
+
+func (s *RedisCacheTestSuite) TestSetNXTombstone() {
+	stored, err := s.cache.SetNX(s.ctx, "missing", cachemanager.Tombstone{}, time.Minute)
+	s.Require().NoError(err)
+	s.True(stored)
+
+	value, found, err := s.cache.Get(s.ctx, "missing")
+	s.NoError(err)
+	s.True(found)
+	s.Equal(cachemanager.Tombstone{}, value)
+}
+
+func TestCacheManager_LeaseAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	newInstance := func() (*cachemanager.CacheManager, *Cache) {
+		shared, err := NewRedisCache(NewGoRedisAdapter(mr.Addr()))
+		require.NoError(t, err)
+		cm := cachemanager.NewCacheManager(
+			cachemanager.CacheConfig{Backend: inmemory.NewInMemoryCache(), TTL: time.Minute},
+			cachemanager.CacheConfig{Backend: shared, TTL: time.Hour},
+		)
+		t.Cleanup(func() { _ = cm.Close() })
+		return cm, shared
+	}
+	instanceA, sharedA := newInstance()
+	instanceB, sharedB := newInstance()
+
+	var loads atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slowLoad := func(context.Context) (any, error) {
+		loads.Add(1)
+		close(started)
+		<-release
+		return "report", nil
+	}
+
+	done := make(chan any)
+	go func() {
+		value, err := instanceA.GetOrLoad(ctx, "report", slowLoad, cachemanager.WithLease(sharedA, time.Minute))
+		assert.NoError(t, err)
+		done <- value
+	}()
+	<-started
+
+	// Instance B finds the lease taken and waits for A's value
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	value, err := instanceB.GetOrLoad(ctx, "report", func(context.Context) (any, error) {
+		loads.Add(1)
+		return "duplicate", nil
+	}, cachemanager.WithLease(sharedB, time.Minute), cachemanager.WithLeaseWait(time.Second, 10*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, "report", value)
+	assert.Equal(t, "report", <-done)
+	assert.Equal(t, int32(1), loads.Load())
+
+	// The lease was released once the value was stored
+	assert.False(t, mr.Exists(companionKey("report", leaseSuffix)))
+}
@@ -3,56 +3,121 @@ package redis
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/redis/rueidis"
 )
 
 type rueidisClient struct {
-	client rueidis.Client
+	client          rueidis.Client
+	invalidatedKeys chan string
+	cluster         bool
+	scripts         sync.Map // script source -> *rueidis.Lua
 }
 
 func NewRueidisAdapter(addr string, opts ...Option) (Client, error) {
-	options := &redisOptions{
-		Password: "",
-		DB:       0,
-	}
-
-	for _, opt := range opts {
-		opt(options)
-	}
+	options := newRedisOptions(opts)
 
-	client, err := rueidis.NewClient(rueidis.ClientOption{
+	return newRueidisClient(options, rueidis.ClientOption{
 		InitAddress: []string{addr},
-		Password:    options.Password,
 		SelectDB:    options.DB,
-		ClientTrackingOptions: []string{
-			"OPTIN",  // Only track keys that are explicitly requested
+	}, false)
+}
+
+// NewRueidisClusterAdapter connects to a Redis Cluster through the given seed
+// addresses. Commands are routed to the node owning each key's hash slot.
+// WithDB is ignored because Redis Cluster only has database 0.
+func NewRueidisClusterAdapter(addrs []string, opts ...Option) (Client, error) {
+	options := newRedisOptions(opts)
+
+	return newRueidisClient(options, rueidis.ClientOption{
+		InitAddress: addrs,
+		ShuffleInit: true,
+	}, true)
+}
+
+// NewRueidisSentinelAdapter connects to the master named masterName, as
+// reported by the given Sentinel addresses, and follows failovers.
+func NewRueidisSentinelAdapter(masterName string, sentinelAddrs []string, opts ...Option) (Client, error) {
+	options := newRedisOptions(opts)
+
+	return newRueidisClient(options, rueidis.ClientOption{
+		InitAddress: sentinelAddrs,
+		SelectDB:    options.DB,
+		Sentinel: rueidis.SentinelOption{
+			MasterSet: masterName,
+		},
+	}, false)
+}
+
+// newRueidisClient creates the client with the connection options applied and
+// client-side caching invalidations delivered to the adapter's invalidation
+// channel. cluster tells whether option connects to a Redis Cluster.
+func newRueidisClient(options *redisOptions, option rueidis.ClientOption, cluster bool) (Client, error) {
+	options.applyRueidis(&option)
+	invalidatedKeys := make(chan string, 100)
+
+	if options.DisableClientTracking {
+		option.DisableCache = true
+		option.AlwaysRESP2 = true
+	} else {
+		option.ClientTrackingOptions = []string{
 			"BCAST",  // Broadcast mode - all clients will receive invalidation messages
 			"NOLOOP", // Don't receive invalidation messages for our own modifications
-		},
-	})
+		}
+		option.OnInvalidations = func(messages []rueidis.RedisMessage) {
+			for _, msg := range messages {
+				key, err := msg.ToString()
+				if err != nil {
+					continue
+				}
+				// The callback runs on the connection's reader, so never block it
+				select {
+				case invalidatedKeys <- key:
+				default:
+				}
+			}
+		}
+	}
+
+	client, err := rueidis.NewClient(option)
 	if err != nil {
 		return nil, err
 	}
 
 	return &rueidisClient{
-		client: client,
+		client:          client,
+		invalidatedKeys: invalidatedKeys,
+		cluster:         cluster,
 	}, nil
 }
 
+func (c *rueidisClient) isCluster() bool {
+	return c.cluster
+}
+
 func (c *rueidisClient) Get(ctx context.Context, key string) (any, error) {
-	// Enable client tracking for this key
-	cmd := c.client.B().ClientTracking().On().Optin().Build()
-	err := c.client.Do(ctx, cmd).Error()
-	if err != nil {
-		return nil, err
+	cmd := c.client.B().Get().Key(key).Build()
+	resp := c.client.Do(ctx, cmd)
+	if resp.Error() == rueidis.Nil {
+		return nil, nil
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
 	}
+	return resp.ToString()
+}
 
-	// Get the value with client caching
-	cmd = c.client.B().Get().Key(key).Build()
+func (c *rueidisClient) GetEx(ctx context.Context, key string, ttl time.Duration) (any, error) {
+	var cmd rueidis.Completed
+	if ttl > 0 {
+		cmd = c.client.B().Getex().Key(key).Px(roundUpMillis(ttl)).Build()
+	} else {
+		cmd = c.client.B().Getex().Key(key).Persist().Build()
+	}
 	resp := c.client.Do(ctx, cmd)
-	if resp.Error() == rueidis.Nil {
+	if rueidis.IsRedisNil(resp.Error()) {
 		return nil, nil
 	}
 	if resp.Error() != nil {
@@ -61,6 +126,26 @@ func (c *rueidisClient) Get(ctx context.Context, key string) (any, error) {
 	return resp.ToString()
 }
 
+func (c *rueidisClient) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if ttl > 0 {
+		cmd := c.client.B().Pexpire().Key(key).Milliseconds(roundUpMillis(ttl).Milliseconds()).Build()
+		return c.client.Do(ctx, cmd).AsBool()
+	}
+	// PERSIST reports false for keys without a TTL, so check existence instead
+	resps := c.client.DoMulti(ctx,
+		c.client.B().Multi().Build(),
+		c.client.B().Exists().Key(key).Build(),
+		c.client.B().Persist().Key(key).Build(),
+		c.client.B().Exec().Build(),
+	)
+	results, err := resps[3].ToArray()
+	if err != nil {
+		return false, err
+	}
+	exists, err := results[0].AsInt64()
+	return exists == 1, err
+}
+
 func (c *rueidisClient) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
 	strValue, ok := value.(string)
 	if !ok {
@@ -69,52 +154,187 @@ func (c *rueidisClient) Set(ctx context.Context, key string, value any, ttl time
 
 	var cmd rueidis.Completed
 	if ttl > 0 {
-		cmd = c.client.B().Set().Key(key).Value(strValue).Px(ttl).Build()
+		cmd = c.client.B().Set().Key(key).Value(strValue).Px(roundUpMillis(ttl)).Build()
 	} else {
 		cmd = c.client.B().Set().Key(key).Value(strValue).Build()
 	}
 	return c.client.Do(ctx, cmd).Error()
 }
 
+// MGet issues one MGET per hash slot, pipelined in a single round trip
+func (c *rueidisClient) MGet(ctx context.Context, keys []string) ([]any, error) {
+	groups := groupBySlot(keys)
+	cmds := make(rueidis.Commands, len(groups))
+	for i, group := range groups {
+		cmds[i] = c.client.B().Mget().Key(group...).Build()
+	}
+
+	found := make(map[string]any, len(keys))
+	for i, resp := range c.client.DoMulti(ctx, cmds...) {
+		messages, err := resp.ToArray()
+		if err != nil {
+			return nil, err
+		}
+		for j, msg := range messages {
+			if value, err := msg.ToString(); err == nil {
+				found[groups[i][j]] = value
+			}
+		}
+	}
+
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i] = found[key]
+	}
+	return values, nil
+}
+
+func (c *rueidisClient) MSet(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	cmds := make(rueidis.Commands, 0, len(keys))
+	for _, group := range groupBySlot(keys) {
+		for _, key := range group {
+			strValue, ok := values[key].(string)
+			if !ok {
+				return errors.New("redis cache only supports string values")
+			}
+			if ttl > 0 {
+				cmds = append(cmds, c.client.B().Set().Key(key).Value(strValue).Px(roundUpMillis(ttl)).Build())
+			} else {
+				cmds = append(cmds, c.client.B().Set().Key(key).Value(strValue).Build())
+			}
+		}
+	}
+	for _, resp := range c.client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelMulti issues one DEL per hash slot, pipelined in a single round trip
+func (c *rueidisClient) DelMulti(ctx context.Context, keys []string) error {
+	groups := groupBySlot(keys)
+	cmds := make(rueidis.Commands, len(groups))
+	for i, group := range groups {
+		cmds[i] = c.client.B().Del().Key(group...).Build()
+	}
+	for _, resp := range c.client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan iterates over every node the client knows of. Keys found on a replica
+// are also found on its primary, so deleting them twice is harmless.
+func (c *rueidisClient) Scan(ctx context.Context, match string, count int64, fn func(keys []string) error) error {
+	for _, node := range c.client.Nodes() {
+		var cursor uint64
+		for {
+			cmd := node.B().Scan().Cursor(cursor).Match(match).Count(count).Build()
+			entry, err := node.Do(ctx, cmd).AsScanEntry()
+			if err != nil {
+				return err
+			}
+			if len(entry.Elements) > 0 {
+				if err := fn(entry.Elements); err != nil {
+					return err
+				}
+			}
+			if entry.Cursor == 0 {
+				break
+			}
+			cursor = entry.Cursor
+		}
+	}
+	return nil
+}
+
+// Unlink issues one UNLINK per hash slot, pipelined in a single round trip
+func (c *rueidisClient) Unlink(ctx context.Context, keys []string) error {
+	groups := groupBySlot(keys)
+	cmds := make(rueidis.Commands, len(groups))
+	for i, group := range groups {
+		cmds[i] = c.client.B().Unlink().Key(group...).Build()
+	}
+	for _, resp := range c.client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *rueidisClient) Del(ctx context.Context, key string) error {
 	cmd := c.client.B().Del().Key(key).Build()
 	return c.client.Do(ctx, cmd).Error()
 }
 
-// Close closes the client connection
-func (c *rueidisClient) Close() error {
-	c.client.Close()
-	return nil
+// Eval runs script with EVALSHA, falling back to EVAL the first time a node
+// sees it
+func (c *rueidisClient) Eval(ctx context.Context, script string, keys []string, args ...string) (any, error) {
+	cached, ok := c.scripts.Load(script)
+	if !ok {
+		cached, _ = c.scripts.LoadOrStore(script, rueidis.NewLuaScript(script))
+	}
+
+	resp := cached.(*rueidis.Lua).Exec(ctx, c.client, keys, args)
+	if rueidis.IsRedisNil(resp.Error()) {
+		return nil, nil
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return resp.ToAny()
 }
 
-// StartInvalidationListener starts listening for cache invalidation messages
-// It returns a channel that will receive invalidated keys
-func (c *rueidisClient) StartInvalidationListener(ctx context.Context) (<-chan string, error) {
-	invalidatedKeys := make(chan string, 100)
+func (c *rueidisClient) Publish(ctx context.Context, channel, message string) error {
+	cmd := c.client.B().Publish().Channel(channel).Message(message).Build()
+	return c.client.Do(ctx, cmd).Error()
+}
 
-	// Start the invalidation message handler
-	go func() {
-		defer close(invalidatedKeys)
+func (c *rueidisClient) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	messages := make(chan string, 100)
 
-		c.client.Receive(ctx)
-		for msg := range c.client.Receive(ctx) {
-			// Handle invalidation message
-			if msg.Error != nil {
-				continue
-			}
-			if len(msg.Message) >= 2 {
-				switch msg.Message[0] {
-				case "invalidate":
-					// Message format: ["invalidate", key]
-					invalidatedKeys <- msg.Message[1]
-				case "switched":
-					// Reconnection happened, need to re-enable tracking
-					cmd := c.client.B().ClientTracking().On().Optin().Build()
-					_ = c.client.Do(ctx, cmd).Error()
+	go func() {
+		defer close(messages)
+		cmd := c.client.B().Subscribe().Channel(channel).Build()
+		// Receive returns when the connection breaks; subscribe again until ctx is done
+		for ctx.Err() == nil {
+			err := c.client.Receive(ctx, cmd, func(msg rueidis.PubSubMessage) {
+				select {
+				case messages <- msg.Message:
+				case <-ctx.Done():
 				}
+			})
+			if errors.Is(err, rueidis.ErrClosing) {
+				return
+			}
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
 			}
 		}
 	}()
+	return messages, nil
+}
+
+// Close closes the client connection
+func (c *rueidisClient) Close() error {
+	c.client.Close()
+	return nil
+}
 
-	return invalidatedKeys, nil
+// StartInvalidationListener returns a channel that receives keys invalidated
+// by other clients. Keys are delivered through the client-side caching
+// invalidation callback registered when the client was created.
+func (c *rueidisClient) StartInvalidationListener(_ context.Context) (<-chan string, error) {
+	return c.invalidatedKeys, nil
 }
@@ -0,0 +1,78 @@
+package redis
+
+import "strings"
+
+// clusterSlots is the number of hash slots in a Redis Cluster
+const clusterSlots = 16384
+
+// keySlot returns the Redis Cluster hash slot of key. Only the part between
+// the first "{" and the following "}" is hashed when it is non-empty, so keys
+// sharing a hash tag land in the same slot.
+func keySlot(key string) uint16 {
+	if tag, ok := hashTag(key); ok {
+		key = tag
+	}
+	return crc16(key) % clusterSlots
+}
+
+// hashTag returns the hash tag of key, if it has one
+func hashTag(key string) (string, bool) {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end], true
+		}
+	}
+	return "", false
+}
+
+// companionKey returns a key named after key and suffix that hashes to the
+// same slot as key, so scripts can touch both on a Redis Cluster. Keys with a
+// "}" but no hash tag cannot be wrapped in one, so their companion keys land
+// in another slot; see hasCompanionSlot.
+func companionKey(key, suffix string) string {
+	if _, ok := hashTag(key); ok || strings.IndexByte(key, '}') >= 0 {
+		return key + suffix
+	}
+	return "{" + key + "}" + suffix
+}
+
+// hasCompanionSlot reports whether the companion keys of key hash to the
+// same slot as key
+func hasCompanionSlot(key string) bool {
+	_, ok := hashTag(key)
+	return ok || strings.IndexByte(key, '}') < 0
+}
+
+// groupBySlot splits keys by hash slot so multi-key commands never cross
+// slots. Keys keep their relative order within each group.
+func groupBySlot(keys []string) [][]string {
+	indexes := make(map[uint16]int)
+	var groups [][]string
+	for _, key := range keys {
+		slot := keySlot(key)
+		i, ok := indexes[slot]
+		if !ok {
+			i = len(groups)
+			indexes[slot] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+// crc16 implements CRC-16/XMODEM as used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySlot(t *testing.T) {
+	// Reference values from the Redis Cluster specification and CLUSTER KEYSLOT
+	assert.Equal(t, uint16(12739), keySlot("123456789"))
+	assert.Equal(t, uint16(12182), keySlot("foo"))
+	assert.Equal(t, uint16(11058), keySlot("somekey"))
+	assert.Equal(t, keySlot("user"), keySlot("{user}:profile"))
+	assert.Equal(t, keySlot("{user}:profile"), keySlot("{user}:settings"))
+	// An empty hash tag does not count, so the whole key is hashed
+	assert.Equal(t, crc16("{}:a")%clusterSlots, keySlot("{}:a"))
+	assert.Equal(t, crc16("a{}{b}")%clusterSlots, keySlot("a{}{b}"))
+	assert.NotEqual(t, keySlot(""), keySlot("{}:a"))
+}
+
+func TestGroupBySlot(t *testing.T) {
+	groups := groupBySlot([]string{"{a}1", "{b}1", "{a}2", "{b}2", "{a}3"})
+	assert.Equal(t, [][]string{{"{a}1", "{a}2", "{a}3"}, {"{b}1", "{b}2"}}, groups)
+}
+
+func TestCompanionKey(t *testing.T) {
+	for _, key := range []string{"user:1", "{user}:1", "a{b}c", "{user:1"} {
+		companion := companionKey(key, ":meta")
+		assert.Equal(t, keySlot(key), keySlot(companion), "%s -> %s", key, companion)
+	}
+	assert.Equal(t, "{user:1}:meta", companionKey("user:1", ":meta"))
+	assert.Equal(t, "{user}:1:meta", companionKey("{user}:1", ":meta"))
+}
@@ -0,0 +1,379 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+const (
+	// tagKeyPrefix prefixes the Redis sets that hold the keys carrying a
+	// tag. With WithClearPrefix, the clear prefix comes first.
+	tagKeyPrefix = "cachemanager:tag:"
+
+	// tagsSuffix names the companion set listing the tags of a key, so they
+	// can be removed from their tag sets when the key is deleted or tagged
+	// again
+	tagsSuffix = ":cachemanager:tags"
+)
+
+// tagLua defines tag(set, member, ttl), which adds member to a tag set. A
+// tag set lives as long as the longest-lived entry added to it.
+const tagLua = `
+local function tag(set, member, ttl)
+	local existed = redis.call('EXISTS', set)
+	redis.call('SADD', set, member)
+	if ttl == 0 then
+		redis.call('PERSIST', set)
+	elseif existed == 0 then
+		redis.call('PEXPIRE', set, ttl)
+	else
+		local current = redis.call('PTTL', set)
+		if current >= 0 and current < ttl then
+			redis.call('PEXPIRE', set, ttl)
+		end
+	end
+end
+`
+
+// setWithTagsScript stores a value, replaces the tags listed in the key's
+// companion set and adds the key to the tag sets in its hash slot.
+//
+// KEYS[1] is the entry key. If ARGV[3] is "1", KEYS[2] is its companion set
+// of tags; the remaining KEYS are tag sets.
+// ARGV[1] is the value, ARGV[2] the TTL in milliseconds (0 = no expiration)
+// and ARGV[4..] every tag of the key.
+// Returns the tags the companion set listed that the key no longer has.
+const setWithTagsScript = tagLua + `
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+local first = 2
+local removed = {}
+if ARGV[3] == '1' then
+	first = 3
+	local current = {}
+	for i = 4, #ARGV do
+		current[ARGV[i]] = true
+	end
+	for _, old in ipairs(redis.call('SMEMBERS', KEYS[2])) do
+		if not current[old] then
+			table.insert(removed, old)
+		end
+	end
+	redis.call('DEL', KEYS[2])
+	if #ARGV >= 4 then
+		redis.call('SADD', KEYS[2], unpack(ARGV, 4))
+		if ttl > 0 then
+			redis.call('PEXPIRE', KEYS[2], ttl)
+		end
+	end
+end
+for i = first, #KEYS do
+	tag(KEYS[i], KEYS[1], ttl)
+end
+return removed
+`
+
+// addTagScript adds a key to tag sets in another hash slot than the key.
+//
+// KEYS are the tag sets, all in one hash slot.
+// ARGV[1] is the entry key, ARGV[2] the TTL in milliseconds (0 = no expiration).
+const addTagScript = tagLua + `
+local ttl = tonumber(ARGV[2])
+for i = 1, #KEYS do
+	tag(KEYS[i], ARGV[1], ttl)
+end
+return 1
+`
+
+// popTagScript removes a tag set and returns its members, so keys tagged
+// after it ran start a new set instead of being dropped from the index.
+//
+// KEYS[1] is the tag set.
+const popTagScript = `
+local members = redis.call('SMEMBERS', KEYS[1])
+redis.call('DEL', KEYS[1])
+return members
+`
+
+// deleteTaggedScript deletes entries with their fill leases and companion
+// sets of tags, returning the tags so the keys can be removed from their tag
+// sets.
+//
+// KEYS come in threes, all in one hash slot: an entry key, its fill lease
+// and its companion set of tags.
+// ARGV, if not empty, are invalidated tags: an entry is then only deleted if
+// its companion set lists one of them, or if it has none, so a key stored
+// again without the tag since is kept.
+// Returns {key, 1 if it existed, tags...} for every entry deleted.
+const deleteTaggedScript = `
+local invalidated = {}
+for i = 1, #ARGV do
+	invalidated[ARGV[i]] = true
+end
+local deleted = {}
+for i = 1, #KEYS, 3 do
+	local tags = redis.call('SMEMBERS', KEYS[i + 2])
+	local matched = #ARGV == 0 or #tags == 0
+	for _, tag in ipairs(tags) do
+		if invalidated[tag] then
+			matched = true
+		end
+	end
+	if matched then
+		local existed = redis.call('DEL', KEYS[i])
+		redis.call('DEL', KEYS[i + 1], KEYS[i + 2])
+		table.insert(deleted, {KEYS[i], existed, unpack(tags)})
+	end
+end
+return deleted
+`
+
+// untagScript removes keys from tag sets.
+//
+// KEYS are tag sets, all in one hash slot, and ARGV[i] the key to remove
+// from KEYS[i].
+const untagScript = `
+for i = 1, #KEYS do
+	redis.call('SREM', KEYS[i], ARGV[i])
+end
+return 1
+`
+
+// deleteKeysScript deletes keys and returns the ones that existed.
+//
+// KEYS are the keys to delete, all in one hash slot.
+const deleteKeysScript = `
+local deleted = {}
+for i = 1, #KEYS do
+	if redis.call('DEL', KEYS[i]) == 1 then
+		table.insert(deleted, KEYS[i])
+	end
+end
+return deleted
+`
+
+// SetWithTags stores value and adds key to the set of each tag. Tag sets in
+// the hash slot of key are updated in the same script as the value. Those in
+// other slots, which live on other nodes of a Redis Cluster, are updated
+// first by one script per slot, so an entry is never stored without being
+// indexed under its tags. The key is then removed from the sets of the tags
+// it had before but not anymore.
+func (c *Cache) SetWithTags(ctx context.Context, key string, value any, ttl time.Duration, tags []string) error {
+	strValue, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+	ttl, err = cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+	ttlArg := ttlMillis(ttl)
+
+	keys := []string{key}
+	tracked := "0"
+	if c.tracksTags(key) {
+		keys = append(keys, companionKey(key, tagsSuffix))
+		tracked = "1"
+	}
+	var others []string
+	slot := keySlot(key)
+	for _, tagKey := range c.tagKeys(tags) {
+		if keySlot(tagKey) == slot {
+			keys = append(keys, tagKey)
+		} else {
+			others = append(others, tagKey)
+		}
+	}
+	for _, group := range groupBySlot(others) {
+		if _, err := c.client.Eval(ctx, addTagScript, group, key, ttlArg); err != nil {
+			return err
+		}
+	}
+	args := append([]string{strValue, ttlArg, tracked}, tags...)
+	result, err := c.client.Eval(ctx, setWithTagsScript, keys, args...)
+	if err != nil {
+		return err
+	}
+	removed, err := toStrings(result)
+	if err != nil {
+		return err
+	}
+	return c.untag(ctx, map[string][]string{key: removed})
+}
+
+// InvalidateTags deletes every key carrying any of tags, then broadcasts the
+// invalidation to other instances. It returns the deleted keys. Each tag set
+// is emptied atomically, and its keys are then deleted in one script per
+// hash slot, so tags and keys may live on different cluster nodes. Keys
+// stored again without any of tags since they were indexed are kept.
+func (c *Cache) InvalidateTags(ctx context.Context, tags ...string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	var members []string
+	seen := make(map[string]struct{})
+	for _, tagKey := range c.tagKeys(tags) {
+		result, err := c.client.Eval(ctx, popTagScript, []string{tagKey})
+		if err != nil {
+			return nil, err
+		}
+		keys, err := toStrings(result)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				members = append(members, key)
+			}
+		}
+	}
+
+	deleted, err := c.deleteEntries(ctx, members, tags)
+	if err != nil {
+		return deleted, err
+	}
+	err = c.broadcast(ctx, cachemanager.InvalidationEvent{Keys: deleted, Tags: tags})
+	return deleted, err
+}
+
+// deleteEntries deletes keys with their fill leases and removes them from
+// their tag sets, one script per hash slot. With tags given, only keys still
+// tagged with one of them are deleted. It returns the deleted keys that
+// existed.
+func (c *Cache) deleteEntries(ctx context.Context, keys []string, tags []string) ([]string, error) {
+	var tracked, untracked []string
+	for _, key := range keys {
+		if c.tracksTags(key) {
+			tracked = append(tracked, key)
+		} else {
+			untracked = append(untracked, key)
+		}
+	}
+
+	var deleted []string
+	stale := make(map[string][]string)
+	for _, group := range groupBySlot(tracked) {
+		scriptKeys := make([]string, 0, 3*len(group))
+		for _, key := range group {
+			scriptKeys = append(scriptKeys, key, companionKey(key, fillLeaseSuffix), companionKey(key, tagsSuffix))
+		}
+		result, err := c.client.Eval(ctx, deleteTaggedScript, scriptKeys, tags...)
+		if err != nil {
+			return deleted, err
+		}
+		entries, ok := result.([]any)
+		if !ok {
+			return deleted, fmt.Errorf("unexpected script result %T", result)
+		}
+		for _, entry := range entries {
+			fields, ok := entry.([]any)
+			if !ok || len(fields) < 2 {
+				return deleted, fmt.Errorf("unexpected script result item %T", entry)
+			}
+			key, _ := fields[0].(string)
+			if fields[1] == int64(1) {
+				deleted = append(deleted, key)
+			}
+			if stale[key], err = itemsToStrings(fields[2:]); err != nil {
+				return deleted, err
+			}
+		}
+	}
+
+	// Keys whose companion keys live in another cluster slot have no tags
+	// recorded; delete them and their fill leases separately
+	for _, group := range groupBySlot(untracked) {
+		result, err := c.client.Eval(ctx, deleteKeysScript, group)
+		if err != nil {
+			return deleted, err
+		}
+		existed, err := toStrings(result)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, existed...)
+	}
+	if len(untracked) > 0 {
+		if err := c.client.DelMulti(ctx, fillLeaseKeys(untracked)); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, c.untag(ctx, stale)
+}
+
+// untag removes each key of tags from the sets of its tags, one script per
+// hash slot
+func (c *Cache) untag(ctx context.Context, tags map[string][]string) error {
+	type removal struct {
+		sets, keys []string
+	}
+	bySlot := make(map[uint16]*removal)
+	var slots []uint16
+	for key, keyTags := range tags {
+		for _, tagKey := range c.tagKeys(keyTags) {
+			slot := keySlot(tagKey)
+			r, ok := bySlot[slot]
+			if !ok {
+				r = &removal{}
+				bySlot[slot] = r
+				slots = append(slots, slot)
+			}
+			r.sets = append(r.sets, tagKey)
+			r.keys = append(r.keys, key)
+		}
+	}
+	for _, slot := range slots {
+		r := bySlot[slot]
+		if _, err := c.client.Eval(ctx, untagScript, r.sets, r.keys...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tracksTags reports whether the tags of key are recorded in its companion
+// set, which a script has to reach with the key. On a Redis Cluster, keys
+// with a "}" but no hash tag have it in another slot, so their tag sets are
+// only cleaned up by InvalidateTags.
+func (c *Cache) tracksTags(key string) bool {
+	return !c.cluster || hasCompanionSlot(key)
+}
+
+// tagKeys returns the names of the sets of tags
+func (c *Cache) tagKeys(tags []string) []string {
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = c.tagKeyPrefix + tag
+	}
+	return keys
+}
+
+func toStrings(result any) ([]string, error) {
+	items, ok := result.([]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected script result %T", result)
+	}
+	return itemsToStrings(items)
+}
+
+// itemsToStrings converts the items of a script result to strings
+func itemsToStrings(items []any) ([]string, error) {
+	values := make([]string, len(items))
+	for i, item := range items {
+		var ok bool
+		if values[i], ok = item.(string); !ok {
+			return nil, fmt.Errorf("unexpected script result item %T", item)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+)
+
+// tombstoneValue is how a cachemanager.Tombstone is stored. The leading NUL
+// byte keeps it apart from any printable string a caller would cache.
+const tombstoneValue = "\x00cachemanager:tombstone"
+
+// earlyRefreshPrefix starts a stored cachemanager.EarlyRefreshEntry, which
+// is encoded as prefix | delta ns | ":" | expiry Unix ns | ":" | value
+const earlyRefreshPrefix = "\x00cachemanager:xfetch:"
+
+// Values written by SetNX or CompareAndSwap are stored as a hash holding
+// the encoded value in field v and its version in field ver, so the scripts
+// replace both at once. Other values are strings and have version 0.
+//
+// readLua defines read(key) for scripts that read values of either kind. It
+// returns the encoded value and version of key, or false if it is missing.
+const readLua = `
+local function read(key)
+	local kind = redis.call('TYPE', key).ok
+	if kind == 'hash' then
+		local fields = redis.call('HMGET', key, 'v', 'ver')
+		return fields[1], fields[2]
+	elseif kind == 'string' then
+		return redis.call('GET', key), '0'
+	end
+	return false, false
+end
+`
+
+// getScript reads keys of either kind, for the hashes of versioned values
+// that GET rejects and MGET reports as missing.
+// KEYS are the keys, all in one slot.
+// ARGV[1] is the TTL set on the keys found in milliseconds, 0 to remove it
+// or -1 to leave it. Returns {value, version} for each key found, false for
+// the others.
+const getScript = readLua + `
+local ttl = tonumber(ARGV[1])
+local found = {}
+for i, key in ipairs(KEYS) do
+	local value, version = read(key)
+	if value then
+		found[i] = {value, version}
+		if ttl > 0 then
+			redis.call('PEXPIRE', key, ttl)
+		elseif ttl == 0 then
+			redis.call('PERSIST', key)
+		end
+	else
+		found[i] = false
+	end
+end
+return found
+`
+
+// keepTTL makes getScript leave the TTL of the keys it reads
+const keepTTL time.Duration = -1
+
+// encodeValue converts a cached value to the string stored in Redis
+func encodeValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case cachemanager.Tombstone:
+		return tombstoneValue, nil
+	case cachemanager.EarlyRefreshEntry:
+		inner, ok := v.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("%w: redis cache only supports string values", codec.ErrEncode)
+		}
+		var expiry int64
+		if !v.Expiry.IsZero() {
+			expiry = v.Expiry.UnixNano()
+		}
+		return earlyRefreshPrefix + strconv.FormatInt(int64(v.Delta), 10) + ":" +
+			strconv.FormatInt(expiry, 10) + ":" + inner, nil
+	default:
+		return "", fmt.Errorf("%w: redis cache only supports string values", codec.ErrEncode)
+	}
+}
+
+// decodeValue converts a value read from Redis back to the cached value
+func decodeValue(value any) any {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	if s == tombstoneValue {
+		return cachemanager.Tombstone{}
+	}
+	if entry, ok := decodeEarlyRefresh(s); ok {
+		return entry
+	}
+	return value
+}
+
+func decodeEarlyRefresh(s string) (cachemanager.EarlyRefreshEntry, bool) {
+	rest, ok := strings.CutPrefix(s, earlyRefreshPrefix)
+	if !ok {
+		return cachemanager.EarlyRefreshEntry{}, false
+	}
+	fields := strings.SplitN(rest, ":", 3)
+	if len(fields) != 3 {
+		return cachemanager.EarlyRefreshEntry{}, false
+	}
+	delta, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return cachemanager.EarlyRefreshEntry{}, false
+	}
+	expiry, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return cachemanager.EarlyRefreshEntry{}, false
+	}
+
+	entry := cachemanager.EarlyRefreshEntry{Value: fields[2], Delta: time.Duration(delta)}
+	if expiry != 0 {
+		entry.Expiry = time.Unix(0, expiry)
+	}
+	return entry, true
+}
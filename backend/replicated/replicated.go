@@ -0,0 +1,608 @@
+// Package replicated writes every entry to several backends and reads it
+// back with quorums, so that losing a replica neither loses data nor serves
+// stale data.
+package replicated
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+)
+
+// ErrQuorumNotReached is wrapped by the QuorumError of an operation that
+// fewer replicas acknowledged than its quorum requires
+var ErrQuorumNotReached = errors.New("quorum not reached")
+
+const (
+	defaultDeleteTombstoneTTL = 10 * time.Minute
+	defaultBackgroundTimeout  = 30 * time.Second
+)
+
+// entryPrefix starts every entry stored in a replica. The leading NUL byte
+// keeps it apart from any printable string written to the replica directly.
+const entryPrefix = "\x00cachemanager:replicated:"
+
+// Entry flags
+const (
+	flagDeleted = 1 << iota
+	flagExpires
+)
+
+// Entry is what a Cache stores in each replica: the value with what is
+// needed to tell which replica holds the newest one. Replicas receive it
+// encoded as a string, see encodeEntry.
+type Entry struct {
+	Value any
+	// Version is the value's own version, for values implementing Versioned
+	Version uint64
+	// WrittenAt is when the value was written, by the writer's clock
+	WrittenAt time.Time
+	// ExpiresAt is when the entry expires, zero if it does not
+	ExpiresAt time.Time
+	// Deleted marks the entry left by Delete, so that replicas that missed
+	// the delete cannot bring the value back
+	Deleted bool
+}
+
+// Versioned is implemented by values that carry their own version, such as
+// a row version from the origin. When replicas hold different versions of a
+// value, the highest version wins even if it was written earlier.
+type Versioned interface {
+	CacheVersion() uint64
+}
+
+// ReplicaError is the failure of one replica of a Cache
+type ReplicaError struct {
+	// Replica is the index of the replica in NewReplicatedCache
+	Replica int
+	Err     error
+}
+
+func (e *ReplicaError) Error() string {
+	return fmt.Sprintf("replica %d: %v", e.Replica, e.Err)
+}
+
+func (e *ReplicaError) Unwrap() error {
+	return e.Err
+}
+
+// QuorumError reports an operation of a Cache that fewer
+// replicas acknowledged than its quorum requires, with the error of every
+// replica that failed before the operation gave up
+type QuorumError struct {
+	Op     string
+	Quorum int
+	Acks   int
+	Errors []*ReplicaError
+
+	// ctxErr is set when the context ended before the quorum was reached
+	ctxErr error
+}
+
+func (e *QuorumError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %v: %d acknowledgements, %d required", e.Op, ErrQuorumNotReached, e.Acks, e.Quorum)
+	if e.ctxErr != nil {
+		fmt.Fprintf(&b, ": %v", e.ctxErr)
+	}
+	for i, err := range e.Errors {
+		if i == 0 {
+			b.WriteString(" (")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(err.Error())
+		if i == len(e.Errors)-1 {
+			b.WriteString(")")
+		}
+	}
+	return b.String()
+}
+
+func (e *QuorumError) Unwrap() []error {
+	errs := []error{ErrQuorumNotReached}
+	if e.ctxErr != nil {
+		errs = append(errs, e.ctxErr)
+	}
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Cache writes every entry to several replicas and reads it
+// back with quorums: a write succeeds once W replicas acknowledged it and a
+// read answers once R replicas did, so with R + W greater than the number of
+// replicas every read sees the latest successful write. When the replicas
+// that answered disagree, the newest entry wins and the replicas holding an
+// older one, or none, are repaired in the background.
+//
+// Replicas keep receiving a write after it returned, and reads keep
+// waiting for every replica to repair them, for at most the background
+// timeout. Their failures, and those of replicas that failed while the
+// quorum was still reached, are passed to the handler set with
+// WithReplicaErrorHandler.
+type Cache struct {
+	replicas          []cachemanager.CacheBackend
+	writeQuorum       int
+	readQuorum        int
+	defaultTTL        time.Duration
+	tombstoneTTL      time.Duration
+	backgroundTimeout time.Duration
+	codec             codec.Codec
+	onError           func(op string, err *ReplicaError)
+	background        sync.WaitGroup
+
+	// closing is canceled by Close to stop the background work
+	closing context.Context
+	close   context.CancelFunc
+}
+
+// Option configures a Cache created by NewReplicatedCache
+type Option func(*Cache)
+
+// WithWriteQuorum sets how many replicas must acknowledge a write. It
+// defaults to a majority of the replicas.
+func WithWriteQuorum(w int) Option {
+	return func(r *Cache) {
+		r.writeQuorum = w
+	}
+}
+
+// WithReadQuorum sets how many replicas must answer a read. It defaults to
+// a majority of the replicas.
+func WithReadQuorum(r int) Option {
+	return func(b *Cache) {
+		b.readQuorum = r
+	}
+}
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(r *Cache) {
+		r.defaultTTL = ttl
+	}
+}
+
+// WithDeleteTombstoneTTL sets how long the marker left by Delete is kept.
+// It must outlast the time a replica can stay behind. It defaults to ten
+// minutes.
+func WithDeleteTombstoneTTL(ttl time.Duration) Option {
+	return func(r *Cache) {
+		if ttl > 0 {
+			r.tombstoneTTL = ttl
+		}
+	}
+}
+
+// WithBackgroundTimeout sets how long replicas keep receiving a write after
+// it returned, and how long the replicas of a read are waited for and
+// repaired. It defaults to 30 seconds.
+func WithBackgroundTimeout(timeout time.Duration) Option {
+	return func(r *Cache) {
+		if timeout > 0 {
+			r.backgroundTimeout = timeout
+		}
+	}
+}
+
+// WithCodec sets the codec used to encode values in the replicas. It
+// defaults to codec.Gob.
+func WithCodec(cdc codec.Codec) Option {
+	return func(r *Cache) {
+		r.codec = cdc
+	}
+}
+
+// WithReplicaErrorHandler sets a function called with the failures of
+// individual replicas that are not reported by a QuorumError
+func WithReplicaErrorHandler(handler func(op string, err *ReplicaError)) Option {
+	return func(r *Cache) {
+		r.onError = handler
+	}
+}
+
+// NewReplicatedCache creates a backend replicating entries over replicas
+func NewReplicatedCache(replicas []cachemanager.CacheBackend, opts ...Option) (*Cache, error) {
+	majority := len(replicas)/2 + 1
+	r := &Cache{
+		replicas:          replicas,
+		writeQuorum:       majority,
+		readQuorum:        majority,
+		defaultTTL:        cachemanager.NoExpiration,
+		tombstoneTTL:      defaultDeleteTombstoneTTL,
+		backgroundTimeout: defaultBackgroundTimeout,
+		codec:             codec.Gob{},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if len(replicas) == 0 {
+		return nil, errors.New("replicated backend needs at least one replica")
+	}
+	if r.writeQuorum < 1 || r.writeQuorum > len(replicas) {
+		return nil, fmt.Errorf("write quorum %d out of range for %d replicas", r.writeQuorum, len(replicas))
+	}
+	if r.readQuorum < 1 || r.readQuorum > len(replicas) {
+		return nil, fmt.Errorf("read quorum %d out of range for %d replicas", r.readQuorum, len(replicas))
+	}
+	r.closing, r.close = context.WithCancel(context.Background())
+	return r, nil
+}
+
+// replicaResult is the outcome of a call to one replica
+type replicaResult[T any] struct {
+	replica int
+	value   T
+	err     error
+}
+
+// replicaRead is what one replica holds for a key
+type replicaRead struct {
+	entry Entry
+	found bool
+}
+
+func (r *Cache) Get(ctx context.Context, key string) (any, bool, error) {
+	// Late replicas are still read to repair them
+	replicaCtx, cancel := r.detach(ctx)
+	results := fanOut(r, replicaCtx, func(ctx context.Context, replica cachemanager.CacheBackend) (replicaRead, error) {
+		value, found, err := replica.Get(ctx, key)
+		if err != nil || !found {
+			return replicaRead{}, err
+		}
+		entry, err := r.decodeEntry(value)
+		if err != nil {
+			return replicaRead{}, err
+		}
+		return replicaRead{entry: entry, found: true}, nil
+	})
+
+	received, err := awaitQuorum(ctx, r, "get", results, r.readQuorum)
+	finishInBackground(r, replicaCtx, cancel, "get", results, received, func(all []replicaResult[replicaRead]) {
+		r.repair(replicaCtx, key, all)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	newest, found := newestEntry(received)
+	if !found || newest.Deleted {
+		return nil, false, nil
+	}
+	return newest.Value, true, nil
+}
+
+func (r *Cache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	ttl, err := cachemanager.ResolveTTL(ttl, r.defaultTTL)
+	if err != nil {
+		return err
+	}
+	entry := Entry{Value: value, WrittenAt: time.Now()}
+	if versioned, ok := value.(Versioned); ok {
+		entry.Version = versioned.CacheVersion()
+	}
+	if ttl != cachemanager.NoExpiration {
+		entry.ExpiresAt = entry.WrittenAt.Add(ttl)
+	}
+	return r.write(ctx, "set", key, entry, ttl)
+}
+
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (r *Cache) DefaultTTL() time.Duration {
+	return r.defaultTTL
+}
+
+// Delete replaces the entry with a deletion marker kept for the tombstone
+// TTL, so that a replica that missed the delete loses to the marker on read
+func (r *Cache) Delete(ctx context.Context, key string) error {
+	now := time.Now()
+	entry := Entry{WrittenAt: now, ExpiresAt: now.Add(r.tombstoneTTL), Deleted: true}
+	return r.write(ctx, "delete", key, entry, r.tombstoneTTL)
+}
+
+// Close cancels the writes and repairs still in flight, waits for them to
+// stop and closes every replica
+func (r *Cache) Close() error {
+	r.close()
+	r.background.Wait()
+	var errs []error
+	for i, replica := range r.replicas {
+		if err := replica.Close(); err != nil {
+			errs = append(errs, &ReplicaError{Replica: i, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Cache) write(ctx context.Context, op, key string, entry Entry, ttl time.Duration) error {
+	encoded, err := r.encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	// Replicas keep receiving the write once the quorum returned
+	replicaCtx, cancel := r.detach(ctx)
+	results := fanOut(r, replicaCtx, func(ctx context.Context, replica cachemanager.CacheBackend) (struct{}, error) {
+		return struct{}{}, replica.Set(ctx, key, encoded, ttl)
+	})
+	received, err := awaitQuorum(ctx, r, op, results, r.writeQuorum)
+	finishInBackground(r, replicaCtx, cancel, op, results, received, nil)
+	return err
+}
+
+// detach returns the context of replica calls that outlive the operation:
+// it keeps the values of ctx but not its cancellation, and ends after the
+// background timeout or when the Cache is closed
+func (r *Cache) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.backgroundTimeout)
+	stop := context.AfterFunc(r.closing, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// repair writes the newest entry to the replicas that answered with an
+// older one or none
+func (r *Cache) repair(ctx context.Context, key string, reads []replicaResult[replicaRead]) {
+	newest, found := newestEntry(reads)
+	if !found {
+		return
+	}
+	ttl := cachemanager.NoExpiration
+	if !newest.ExpiresAt.IsZero() {
+		if ttl = time.Until(newest.ExpiresAt); ttl <= 0 {
+			return
+		}
+	}
+
+	encoded, err := r.encodeEntry(newest)
+	if err != nil {
+		return
+	}
+	for _, read := range reads {
+		if read.err != nil || (read.value.found && !newer(newest, read.value.entry)) {
+			continue
+		}
+		if err := r.replicas[read.replica].Set(ctx, key, encoded, ttl); err != nil {
+			r.reportError("repair", &ReplicaError{Replica: read.replica, Err: err})
+		}
+	}
+}
+
+// finishInBackground collects the results that arrive after an operation
+// returned, reports their failures and passes every result to then. It
+// gives up on the replicas that have not answered once ctx ends, and calls
+// cancel when done.
+func finishInBackground[T any](r *Cache, ctx context.Context, cancel context.CancelFunc, op string, results <-chan replicaResult[T], received []replicaResult[T], then func([]replicaResult[T])) {
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+		defer cancel()
+		all := received
+		collect := func(result replicaResult[T]) {
+			if result.err != nil {
+				r.reportError(op, &ReplicaError{Replica: result.replica, Err: result.err})
+			}
+			all = append(all, result)
+		}
+		for len(all) < len(r.replicas) {
+			select {
+			case result := <-results:
+				collect(result)
+			case <-ctx.Done():
+				// Keep the results that arrived along with the cancellation
+				for drained := false; !drained && len(all) < len(r.replicas); {
+					select {
+					case result := <-results:
+						collect(result)
+					default:
+						drained = true
+					}
+				}
+				answered := make(map[int]bool, len(all))
+				for _, result := range all {
+					answered[result.replica] = true
+				}
+				for i := range r.replicas {
+					if !answered[i] {
+						r.reportError(op, &ReplicaError{Replica: i, Err: ctx.Err()})
+					}
+				}
+				return
+			}
+		}
+		if then != nil {
+			then(all)
+		}
+	}()
+}
+
+func (r *Cache) reportError(op string, err *ReplicaError) {
+	if r.onError != nil {
+		r.onError(op, err)
+	}
+}
+
+// fanOut calls fn on every replica concurrently. The channel receives one
+// result per replica.
+func fanOut[T any](r *Cache, ctx context.Context, fn func(ctx context.Context, replica cachemanager.CacheBackend) (T, error)) <-chan replicaResult[T] {
+	results := make(chan replicaResult[T], len(r.replicas))
+	for i, replica := range r.replicas {
+		go func() {
+			value, err := fn(ctx, replica)
+			results <- replicaResult[T]{replica: i, value: value, err: err}
+		}()
+	}
+	return results
+}
+
+// awaitQuorum receives results until quorum replicas succeeded, too many
+// failed for that to happen, or ctx ends. The failures seen are reported in
+// the QuorumError, or passed to the error handler if the quorum is reached.
+func awaitQuorum[T any](ctx context.Context, r *Cache, op string, results <-chan replicaResult[T], quorum int) ([]replicaResult[T], error) {
+	n := len(r.replicas)
+	received := make([]replicaResult[T], 0, n)
+	acks := 0
+	var failed []*ReplicaError
+	for acks < quorum && len(failed) <= n-quorum {
+		select {
+		case result := <-results:
+			received = append(received, result)
+			if result.err != nil {
+				failed = append(failed, &ReplicaError{Replica: result.replica, Err: result.err})
+			} else {
+				acks++
+			}
+		case <-ctx.Done():
+			return received, &QuorumError{Op: op, Quorum: quorum, Acks: acks, Errors: failed, ctxErr: ctx.Err()}
+		}
+	}
+
+	if acks < quorum {
+		return received, &QuorumError{Op: op, Quorum: quorum, Acks: acks, Errors: failed}
+	}
+	for _, err := range failed {
+		r.reportError(op, err)
+	}
+	return received, nil
+}
+
+// newestEntry returns the newest live entry among the successful reads
+func newestEntry(reads []replicaResult[replicaRead]) (Entry, bool) {
+	var newest Entry
+	found := false
+	now := time.Now()
+	for _, read := range reads {
+		if read.err != nil || !read.value.found {
+			continue
+		}
+		entry := read.value.entry
+		if !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		if !found || newer(entry, newest) {
+			newest, found = entry, true
+		}
+	}
+	return newest, found
+}
+
+// newer reports whether a supersedes b. Two values are ordered by their
+// own version when it differs; otherwise, and whenever a delete is
+// involved, the latest write wins.
+func newer(a, b Entry) bool {
+	if !a.Deleted && !b.Deleted && a.Version != b.Version {
+		return a.Version > b.Version
+	}
+	return a.WrittenAt.After(b.WrittenAt)
+}
+
+// errInvalidEntry is returned for replica values that start like an Entry
+// but cannot be decoded
+var errInvalidEntry = errors.New("invalid replicated entry")
+
+// encodeEntry converts entry to the string stored in the replicas, which is
+// entryPrefix | flags | version | written at | expires at | value: numbers
+// are varints, times Unix nanoseconds, expires at is only there with
+// flagExpires, and the value is encoded with the codec unless the entry is
+// a deletion marker
+func (r *Cache) encodeEntry(entry Entry) (string, error) {
+	var flags byte
+	if entry.Deleted {
+		flags |= flagDeleted
+	}
+	if !entry.ExpiresAt.IsZero() {
+		flags |= flagExpires
+	}
+	buf := append([]byte(entryPrefix), flags)
+	buf = binary.AppendUvarint(buf, entry.Version)
+	buf = binary.AppendVarint(buf, unixNano(entry.WrittenAt))
+	if !entry.ExpiresAt.IsZero() {
+		buf = binary.AppendVarint(buf, entry.ExpiresAt.UnixNano())
+	}
+	if !entry.Deleted {
+		value, err := r.codec.Marshal(entry.Value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", codec.ErrEncode, err)
+		}
+		buf = append(buf, value...)
+	}
+	return string(buf), nil
+}
+
+// decodeEntry converts a value read from a replica back to an Entry. Values
+// written to the replica directly are older than any replicated write.
+func (r *Cache) decodeEntry(value any) (Entry, error) {
+	var stored string
+	switch v := value.(type) {
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	}
+	rest, ok := strings.CutPrefix(stored, entryPrefix)
+	if !ok {
+		return Entry{Value: value}, nil
+	}
+
+	buf := []byte(rest)
+	if len(buf) == 0 {
+		return Entry{}, errInvalidEntry
+	}
+	flags := buf[0]
+	buf = buf[1:]
+	var entry Entry
+	var n int
+	if entry.Version, n = binary.Uvarint(buf); n <= 0 {
+		return Entry{}, errInvalidEntry
+	}
+	buf = buf[n:]
+	writtenAt, n := binary.Varint(buf)
+	if n <= 0 {
+		return Entry{}, errInvalidEntry
+	}
+	buf = buf[n:]
+	if writtenAt != 0 {
+		entry.WrittenAt = time.Unix(0, writtenAt)
+	}
+	if flags&flagExpires != 0 {
+		expiresAt, n := binary.Varint(buf)
+		if n <= 0 {
+			return Entry{}, errInvalidEntry
+		}
+		buf = buf[n:]
+		entry.ExpiresAt = time.Unix(0, expiresAt)
+	}
+	if flags&flagDeleted != 0 {
+		entry.Deleted = true
+		return entry, nil
+	}
+	decoded, err := r.codec.Unmarshal(buf)
+	if err != nil {
+		return Entry{}, fmt.Errorf("error decoding value: %w", err)
+	}
+	entry.Value = decoded
+	return entry, nil
+}
+
+// unixNano returns t in Unix nanoseconds, or 0 for the zero time of values
+// written to a replica directly
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,371 @@
+package replicated
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/inmemory"
+	"github.com/ethan-k/cachemanager-go/backend/redis"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replicaMock stores entries in a map and fails every call while down. A
+// hung replica blocks until release is closed, whatever its context says.
+type replicaMock struct {
+	mu      sync.Mutex
+	data    map[string]any
+	ttls    map[string]time.Duration
+	down    bool
+	release chan struct{}
+}
+
+var errReplicaDown = errors.New("replica down")
+
+func newReplicaMock() *replicaMock {
+	return &replicaMock{data: make(map[string]any), ttls: make(map[string]time.Duration)}
+}
+
+func (m *replicaMock) setDown(down bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.down = down
+}
+
+func (m *replicaMock) hang() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.release = make(chan struct{})
+}
+
+func (m *replicaMock) err() error {
+	m.mu.Lock()
+	release, down := m.release, m.down
+	m.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+	if down {
+		return errReplicaDown
+	}
+	return nil
+}
+
+func (m *replicaMock) Get(ctx context.Context, key string) (any, bool, error) {
+	if err := m.err(); err != nil {
+		return nil, false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, exists := m.data[key]
+	return value, exists, nil
+}
+
+func (m *replicaMock) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := m.err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	m.ttls[key] = ttl
+	return nil
+}
+
+func (m *replicaMock) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *replicaMock) Close() error {
+	return nil
+}
+
+func (m *replicaMock) entry(key string) (any, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, exists := m.data[key]
+	return value, m.ttls[key], exists
+}
+
+type versionedValue struct {
+	Name    string
+	Version uint64
+}
+
+func (v versionedValue) CacheVersion() uint64 {
+	return v.Version
+}
+
+func init() {
+	gob.Register(versionedValue{})
+}
+
+func newReplicatedMock(t *testing.T, n int, opts ...Option) (*Cache, []*replicaMock) {
+	t.Helper()
+	mocks := make([]*replicaMock, n)
+	replicas := make([]cachemanager.CacheBackend, n)
+	for i := range mocks {
+		mocks[i] = newReplicaMock()
+		replicas[i] = mocks[i]
+	}
+	replicated, err := NewReplicatedCache(replicas, opts...)
+	require.NoError(t, err)
+	return replicated, mocks
+}
+
+func replicaValue(t *testing.T, r *Cache, m *replicaMock, key string) (any, bool) {
+	t.Helper()
+	value, _, exists := m.entry(key)
+	if !exists {
+		return nil, false
+	}
+	require.IsType(t, "", value, "entries are stored as strings")
+	entry, err := r.decodeEntry(value)
+	require.NoError(t, err)
+	return entry.Value, !entry.Deleted
+}
+
+func TestReplicatedCache_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			replicas := make([]cachemanager.CacheBackend, 3)
+			for i := range replicas {
+				replicas[i] = inmemory.NewInMemoryCache()
+			}
+			replicated, err := NewReplicatedCache(replicas, WithDefaultTTL(defaultTTL))
+			require.NoError(t, err)
+			return replicated
+		},
+		Advance: time.Sleep,
+	})
+}
+
+func TestReplicatedCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("writes succeed with a quorum and report failed replicas", func(t *testing.T) {
+		var mu sync.Mutex
+		var reported []*ReplicaError
+		replicated, replicas := newReplicatedMock(t, 3, WithReplicaErrorHandler(func(op string, err *ReplicaError) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		}))
+		replicas[2].setDown(true)
+
+		require.NoError(t, replicated.Set(ctx, "key", "value", time.Minute))
+		replicated.background.Wait()
+		require.NoError(t, replicated.Close())
+		require.Len(t, reported, 1)
+		assert.Equal(t, 2, reported[0].Replica)
+		assert.ErrorIs(t, reported[0], errReplicaDown)
+
+		for _, replica := range replicas[:2] {
+			value, live := replicaValue(t, replicated, replica, "key")
+			assert.True(t, live)
+			assert.Equal(t, "value", value)
+		}
+		_, ttl, _ := replicas[0].entry("key")
+		assert.Equal(t, time.Minute, ttl)
+	})
+
+	t.Run("operations without a quorum fail with every replica error", func(t *testing.T) {
+		replicated, replicas := newReplicatedMock(t, 3)
+		defer replicated.Close()
+		replicas[0].setDown(true)
+		replicas[1].setDown(true)
+
+		err := replicated.Set(ctx, "key", "value", time.Minute)
+		var quorumErr *QuorumError
+		require.ErrorAs(t, err, &quorumErr)
+		assert.ErrorIs(t, err, ErrQuorumNotReached)
+		assert.ErrorIs(t, err, errReplicaDown)
+		assert.Equal(t, "set", quorumErr.Op)
+		assert.Equal(t, 2, quorumErr.Quorum)
+		failed := []int{quorumErr.Errors[0].Replica, quorumErr.Errors[1].Replica}
+		assert.ElementsMatch(t, []int{0, 1}, failed)
+
+		_, _, err = replicated.Get(ctx, "key")
+		assert.ErrorIs(t, err, ErrQuorumNotReached)
+	})
+
+	t.Run("reads pick the newest entry and repair stale replicas", func(t *testing.T) {
+		replicated, replicas := newReplicatedMock(t, 3, WithReadQuorum(3))
+		defer replicated.Close()
+
+		replicas[0].setDown(true)
+		require.NoError(t, replicated.Set(ctx, "key", "old", time.Minute))
+		replicated.background.Wait()
+		replicas[0].setDown(false)
+		replicas[1].setDown(true)
+		require.NoError(t, replicated.Set(ctx, "key", "new", time.Minute))
+		replicated.background.Wait()
+		replicas[1].setDown(false)
+
+		value, found, err := replicated.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "new", value)
+
+		assert.Eventually(t, func() bool {
+			value, _ := replicaValue(t, replicated, replicas[1], "key")
+			return value == "new"
+		}, time.Second, 5*time.Millisecond)
+		_, ttl, _ := replicas[1].entry("key")
+		assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+	})
+
+	t.Run("values with their own version keep the highest one", func(t *testing.T) {
+		replicated, replicas := newReplicatedMock(t, 2, WithWriteQuorum(1), WithReadQuorum(2))
+		defer replicated.Close()
+
+		replicas[1].setDown(true)
+		require.NoError(t, replicated.Set(ctx, "user", versionedValue{Name: "ada", Version: 2}, time.Minute))
+		replicated.background.Wait()
+		replicas[1].setDown(false)
+		replicas[0].setDown(true)
+		require.NoError(t, replicated.Set(ctx, "user", versionedValue{Name: "stale", Version: 1}, time.Minute))
+		replicated.background.Wait()
+		replicas[0].setDown(false)
+
+		value, _, err := replicated.Get(ctx, "user")
+		require.NoError(t, err)
+		assert.Equal(t, versionedValue{Name: "ada", Version: 2}, value)
+	})
+
+	t.Run("deleted values do not come back from a lagging replica", func(t *testing.T) {
+		replicated, replicas := newReplicatedMock(t, 3, WithDeleteTombstoneTTL(time.Hour))
+		defer replicated.Close()
+		require.NoError(t, replicated.Set(ctx, "key", "value", time.Minute))
+		// Let the write reach the last replica before deleting
+		replicated.background.Wait()
+
+		replicas[0].setDown(true)
+		require.NoError(t, replicated.Delete(ctx, "key"))
+		replicated.background.Wait()
+		replicas[0].setDown(false)
+		replicas[1].setDown(true)
+
+		_, found, err := replicated.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.Eventually(t, func() bool {
+			_, live := replicaValue(t, replicated, replicas[0], "key")
+			return !live
+		}, time.Second, 5*time.Millisecond)
+		_, ttl, _ := replicas[0].entry("key")
+		assert.InDelta(t, time.Hour, ttl, float64(time.Second))
+	})
+
+	t.Run("expired entries are misses", func(t *testing.T) {
+		replicated, _ := newReplicatedMock(t, 3, WithDefaultTTL(20*time.Millisecond))
+		defer replicated.Close()
+
+		assert.ErrorIs(t, replicated.Set(ctx, "key", "value", -time.Second), cachemanager.ErrInvalidTTL)
+		require.NoError(t, replicated.Set(ctx, "key", "value", cachemanager.DefaultTTL))
+		time.Sleep(30 * time.Millisecond)
+		_, found, err := replicated.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("quorums must fit the replicas", func(t *testing.T) {
+		_, err := NewReplicatedCache([]cachemanager.CacheBackend{newReplicaMock()}, WithWriteQuorum(2))
+		assert.Error(t, err)
+		_, err = NewReplicatedCache([]cachemanager.CacheBackend{newReplicaMock()}, WithReadQuorum(0))
+		assert.Error(t, err)
+		_, err = NewReplicatedCache(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("hung replicas are given up on and do not block Close", func(t *testing.T) {
+		var mu sync.Mutex
+		var reported []*ReplicaError
+		replicated, replicas := newReplicatedMock(t, 3, WithBackgroundTimeout(20*time.Millisecond),
+			WithReplicaErrorHandler(func(op string, err *ReplicaError) {
+				mu.Lock()
+				defer mu.Unlock()
+				reported = append(reported, err)
+			}))
+		replicas[2].hang()
+		defer close(replicas[2].release)
+
+		require.NoError(t, replicated.Set(ctx, "key", "value", time.Minute))
+		value, found, err := replicated.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "value", value)
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(reported) == 2
+		}, time.Second, 5*time.Millisecond)
+		for _, err := range reported {
+			assert.Equal(t, 2, err.Replica)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}
+
+		// Close cancels what is still waiting on the hung replica
+		replicated, replicas = newReplicatedMock(t, 3)
+		replicas[2].hang()
+		defer close(replicas[2].release)
+		require.NoError(t, replicated.Set(ctx, "key", "value", time.Minute))
+		closed := make(chan error)
+		go func() { closed <- replicated.Close() }()
+		select {
+		case err := <-closed:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Close waited for the hung replica")
+		}
+	})
+}
+
+func TestReplicatedCache_Redis(t *testing.T) {
+	ctx := context.Background()
+	servers := make([]*miniredis.Miniredis, 3)
+	replicas := make([]cachemanager.CacheBackend, 3)
+	for i := range replicas {
+		servers[i] = miniredis.RunT(t)
+		cache, err := redis.NewRedisCache(redis.NewGoRedisAdapter(servers[i].Addr()))
+		require.NoError(t, err)
+		replicas[i] = cache
+	}
+	replicated, err := NewReplicatedCache(replicas, WithReadQuorum(3))
+	require.NoError(t, err)
+	defer replicated.Close()
+
+	require.NoError(t, replicated.Set(ctx, "user", versionedValue{Name: "ada", Version: 1}, time.Minute))
+	replicated.background.Wait()
+	for _, server := range servers {
+		stored, err := server.Get("user")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(stored, entryPrefix))
+	}
+
+	// A lagging replica is repaired from the others
+	servers[0].Del("user")
+	value, found, err := replicated.Get(ctx, "user")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, versionedValue{Name: "ada", Version: 1}, value)
+	assert.Eventually(t, func() bool {
+		return servers[0].Exists("user")
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, replicated.Delete(ctx, "user"))
+	_, found, err = replicated.Get(ctx, "user")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
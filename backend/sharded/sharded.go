@@ -0,0 +1,549 @@
+// Package sharded spreads keys over several independent backends, such as
+// Redis instances without Cluster mode, with rendezvous hashing.
+package sharded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	cachemanager "github.com/ethan-k/cachemanager-go"
+)
+
+var (
+	// ErrNoShards is returned by a Cache that has no shards
+	ErrNoShards = errors.New("sharded backend has no shards")
+	// ErrShardExists is returned when adding a shard under a name in use
+	ErrShardExists = errors.New("shard already exists")
+	// ErrShardNotFound is returned when removing a shard that does not exist
+	ErrShardNotFound = errors.New("shard not found")
+)
+
+// Shard is a named backend of a Cache. Keys are placed by shard
+// name, so a shard keeps its keys when other shards are added or removed.
+type Shard struct {
+	Name    string
+	Backend cachemanager.CacheBackend
+}
+
+// Cache spreads keys over several backends with rendezvous hashing:
+// a key belongs to the shard whose name scores highest against it. Adding a
+// shard only moves the keys it now wins, and removing one only moves the
+// keys it owned. Entries are not migrated, so moved keys start as misses.
+//
+// Batch operations are split per shard and run concurrently, operations on
+// all entries fan out to every shard, and the invalidations of all shards are
+// merged into the Cache's own channels. Operations that not every shard
+// supports are not offered, see Supports.
+type Cache struct {
+	mu            sync.RWMutex
+	shards        []*shard
+	closed        bool
+	invalidations chan string
+	events        chan cachemanager.InvalidationEvent
+	forwarders    sync.WaitGroup
+}
+
+type shard struct {
+	Shard
+	seed uint64
+	stop chan struct{}
+}
+
+// NewShardedCache creates a backend spreading keys over shards, whose names
+// must be unique
+func NewShardedCache(shards ...Shard) (*Cache, error) {
+	s := &Cache{
+		invalidations: make(chan string, 100),
+		events:        make(chan cachemanager.InvalidationEvent, 100),
+	}
+	for _, sh := range shards {
+		if err := s.AddShard(sh); err != nil {
+			s.stopForwarders()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// AddShard adds a shard. The keys it now owns are misses until written.
+func (s *Cache) AddShard(sh Shard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("sharded backend is closed")
+	}
+	for _, existing := range s.shards {
+		if existing.Name == sh.Name {
+			return fmt.Errorf("%w: %s", ErrShardExists, sh.Name)
+		}
+	}
+
+	added := &shard{Shard: sh, seed: xxhash.Sum64String(sh.Name), stop: make(chan struct{})}
+	s.shards = append(s.shards, added)
+	s.startForwarders(added)
+	return nil
+}
+
+// RemoveShard removes the shard named name and returns its backend, which
+// is neither cleared nor closed. Its keys are spread over the other shards.
+func (s *Cache) RemoveShard(name string) (cachemanager.CacheBackend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sh := range s.shards {
+		if sh.Name == name {
+			s.shards = append(s.shards[:i:i], s.shards[i+1:]...)
+			close(sh.stop)
+			return sh.Backend, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrShardNotFound, name)
+}
+
+// Supports reports whether every shard serves capability. Touch and batch
+// operations, which the Cache performs itself on shards without them, and
+// the merged invalidation channels are always supported.
+func (s *Cache) Supports(capability reflect.Type) bool {
+	switch capability {
+	case reflect.TypeFor[cachemanager.TouchableBackend](),
+		reflect.TypeFor[cachemanager.BatchBackend](),
+		reflect.TypeFor[cachemanager.CacheBackendWithInvalidationChannel](),
+		reflect.TypeFor[cachemanager.CacheBackendWithInvalidationEvents]():
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sh := range s.shards {
+		if !cachemanager.Supports(sh.Backend, capability) {
+			return false
+		}
+	}
+	return true
+}
+
+// Shards returns the names of the shards
+func (s *Cache) Shards() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, len(s.shards))
+	for i, sh := range s.shards {
+		names[i] = sh.Name
+	}
+	return names
+}
+
+// ShardFor returns the name of the shard owning key
+func (s *Cache) ShardFor(key string) (string, error) {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return "", err
+	}
+	return sh.Name, nil
+}
+
+func (s *Cache) Get(ctx context.Context, key string) (any, bool, error) {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return nil, false, err
+	}
+	return sh.Backend.Get(ctx, key)
+}
+
+func (s *Cache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return err
+	}
+	return sh.Backend.Set(ctx, key, value, ttl)
+}
+
+func (s *Cache) Delete(ctx context.Context, key string) error {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return err
+	}
+	return sh.Backend.Delete(ctx, key)
+}
+
+// Touch resets the TTL of key. Shards that cannot touch entries have the
+// value rewritten instead.
+func (s *Cache) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return false, err
+	}
+	if touchable, ok := cachemanager.As[cachemanager.TouchableBackend](sh.Backend); ok {
+		return touchable.Touch(ctx, key, ttl)
+	}
+
+	value, found, err := sh.Backend.Get(ctx, key)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, sh.Backend.Set(ctx, key, value, ttl)
+}
+
+// GetMany returns the cached values of keys. Missing keys are left out.
+// Each shard is asked for its own keys only.
+func (s *Cache) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	groups, err := s.groupKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	found := make(map[string]any, len(keys))
+	err = forEachShard(groups, func(sh *shard, keys []string) error {
+		values, err := getMany(ctx, sh.Backend, keys)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for key, value := range values {
+			found[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+func (s *Cache) SetMany(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	s.mu.RLock()
+	groups := make(map[*shard]map[string]any)
+	for key, value := range values {
+		sh := s.pick(key)
+		if sh == nil {
+			s.mu.RUnlock()
+			return ErrNoShards
+		}
+		if groups[sh] == nil {
+			groups[sh] = make(map[string]any)
+		}
+		groups[sh][key] = value
+	}
+	s.mu.RUnlock()
+
+	return forEachShard(groups, func(sh *shard, values map[string]any) error {
+		if batch, ok := cachemanager.As[cachemanager.BatchBackend](sh.Backend); ok {
+			return batch.SetMany(ctx, values, ttl)
+		}
+		for key, value := range values {
+			if err := sh.Backend.Set(ctx, key, value, ttl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Cache) DeleteMany(ctx context.Context, keys []string) error {
+	groups, err := s.groupKeys(keys)
+	if err != nil {
+		return err
+	}
+	return forEachShard(groups, func(sh *shard, keys []string) error {
+		if batch, ok := cachemanager.As[cachemanager.BatchBackend](sh.Backend); ok {
+			return batch.DeleteMany(ctx, keys)
+		}
+		for _, key := range keys {
+			if err := sh.Backend.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetWithTags stores value with tags in the shard owning key. Shards without
+// tag support store the value untagged.
+func (s *Cache) SetWithTags(ctx context.Context, key string, value any, ttl time.Duration, tags []string) error {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return err
+	}
+	if tagBackend, ok := cachemanager.As[cachemanager.TagBackend](sh.Backend); ok {
+		return tagBackend.SetWithTags(ctx, key, value, ttl, tags)
+	}
+	return sh.Backend.Set(ctx, key, value, ttl)
+}
+
+// InvalidateTags removes the entries tagged with tags from every shard and
+// returns their keys
+func (s *Cache) InvalidateTags(ctx context.Context, tags ...string) ([]string, error) {
+	var mu sync.Mutex
+	var removed []string
+	err := forEachShard(s.allShards(), func(sh *shard, _ struct{}) error {
+		tagBackend, ok := cachemanager.As[cachemanager.TagBackend](sh.Backend)
+		if !ok {
+			return nil
+		}
+		keys, err := tagBackend.InvalidateTags(ctx, tags...)
+		mu.Lock()
+		removed = append(removed, keys...)
+		mu.Unlock()
+		return err
+	})
+	return removed, err
+}
+
+// Clear removes every entry from every shard
+func (s *Cache) Clear(ctx context.Context) error {
+	return forEachShard(s.allShards(), func(sh *shard, _ struct{}) error {
+		clearable, ok := cachemanager.As[cachemanager.ClearableBackend](sh.Backend)
+		if !ok {
+			return cachemanager.ErrClearNotSupported
+		}
+		return clearable.Clear(ctx)
+	})
+}
+
+// DeletePrefix removes every entry whose key starts with prefix from every
+// shard
+func (s *Cache) DeletePrefix(ctx context.Context, prefix string) error {
+	return forEachShard(s.allShards(), func(sh *shard, _ struct{}) error {
+		clearable, ok := cachemanager.As[cachemanager.ClearableBackend](sh.Backend)
+		if !ok {
+			return cachemanager.ErrClearNotSupported
+		}
+		return clearable.DeletePrefix(ctx, prefix)
+	})
+}
+
+func (s *Cache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return 0, err
+	}
+	counter, ok := cachemanager.As[cachemanager.CounterBackend](sh.Backend)
+	if !ok {
+		return 0, cachemanager.ErrCounterNotSupported
+	}
+	return counter.Incr(ctx, key, delta, ttl)
+}
+
+func (s *Cache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return false, err
+	}
+	conditional, ok := cachemanager.As[cachemanager.ConditionalBackend](sh.Backend)
+	if !ok {
+		return false, cachemanager.ErrConditionalNotSupported
+	}
+	return conditional.SetNX(ctx, key, value, ttl)
+}
+
+func (s *Cache) CompareAndSwap(ctx context.Context, key string, expectedVersion uint64, value any, ttl time.Duration) (bool, error) {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return false, err
+	}
+	conditional, ok := cachemanager.As[cachemanager.ConditionalBackend](sh.Backend)
+	if !ok {
+		return false, cachemanager.ErrConditionalNotSupported
+	}
+	return conditional.CompareAndSwap(ctx, key, expectedVersion, value, ttl)
+}
+
+func (s *Cache) GetWithVersion(ctx context.Context, key string) (any, uint64, bool, error) {
+	sh, err := s.shardFor(key)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	conditional, ok := cachemanager.As[cachemanager.ConditionalBackend](sh.Backend)
+	if !ok {
+		return nil, 0, false, cachemanager.ErrConditionalNotSupported
+	}
+	return conditional.GetWithVersion(ctx, key)
+}
+
+// GetInvalidationChannel returns the invalidated keys of every shard
+func (s *Cache) GetInvalidationChannel() <-chan string {
+	return s.invalidations
+}
+
+// GetInvalidationEvents returns the invalidation events of every shard
+func (s *Cache) GetInvalidationEvents() <-chan cachemanager.InvalidationEvent {
+	return s.events
+}
+
+// Close closes every shard
+func (s *Cache) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	shards := s.shards
+	s.mu.Unlock()
+
+	s.stopForwarders()
+	var errs []error
+	for _, sh := range shards {
+		if err := sh.Backend.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing shard %s: %w", sh.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Cache) shardFor(key string) (*shard, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if sh := s.pick(key); sh != nil {
+		return sh, nil
+	}
+	return nil, ErrNoShards
+}
+
+// pick returns the shard with the highest score for key, or nil if there
+// are no shards. The caller must hold s.mu.
+func (s *Cache) pick(key string) *shard {
+	hash := xxhash.Sum64String(key)
+	var best *shard
+	var bestScore uint64
+	for _, sh := range s.shards {
+		if score := mix64(hash ^ sh.seed); best == nil || score > bestScore {
+			best, bestScore = sh, score
+		}
+	}
+	return best
+}
+
+func (s *Cache) groupKeys(keys []string) (map[*shard][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	groups := make(map[*shard][]string)
+	for _, key := range keys {
+		sh := s.pick(key)
+		if sh == nil {
+			return nil, ErrNoShards
+		}
+		groups[sh] = append(groups[sh], key)
+	}
+	return groups, nil
+}
+
+func (s *Cache) allShards() map[*shard]struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	shards := make(map[*shard]struct{}, len(s.shards))
+	for _, sh := range s.shards {
+		shards[sh] = struct{}{}
+	}
+	return shards
+}
+
+// startForwarders relays the invalidations of sh until it is removed. The
+// caller must hold s.mu.
+func (s *Cache) startForwarders(sh *shard) {
+	if source, ok := cachemanager.As[cachemanager.CacheBackendWithInvalidationChannel](sh.Backend); ok {
+		if keys := source.GetInvalidationChannel(); keys != nil {
+			s.forwarders.Add(1)
+			go func() {
+				defer s.forwarders.Done()
+				forward(keys, s.invalidations, sh.stop)
+			}()
+		}
+	}
+	if source, ok := cachemanager.As[cachemanager.CacheBackendWithInvalidationEvents](sh.Backend); ok {
+		if events := source.GetInvalidationEvents(); events != nil {
+			s.forwarders.Add(1)
+			go func() {
+				defer s.forwarders.Done()
+				forward(events, s.events, sh.stop)
+			}()
+		}
+	}
+}
+
+// stopForwarders stops relaying invalidations and closes the merged channels
+func (s *Cache) stopForwarders() {
+	s.mu.Lock()
+	for _, sh := range s.shards {
+		close(sh.stop)
+	}
+	s.shards = nil
+	s.mu.Unlock()
+
+	s.forwarders.Wait()
+	close(s.invalidations)
+	close(s.events)
+}
+
+// forward copies from src to dst until src is closed or stop is
+func forward[T any](src <-chan T, dst chan<- T, stop <-chan struct{}) {
+	for {
+		select {
+		case item, ok := <-src:
+			if !ok {
+				return
+			}
+			select {
+			case dst <- item:
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// forEachShard runs fn concurrently for every shard of groups and returns
+// the errors of all shards
+func forEachShard[T any](groups map[*shard]T, fn func(sh *shard, part T) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, 0, len(groups))
+	var mu sync.Mutex
+	for sh, part := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(sh, part); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("error in shard %s: %w", sh.Name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// getMany reads keys from backend, in one call if it is a BatchBackend
+func getMany(ctx context.Context, backend cachemanager.CacheBackend, keys []string) (map[string]any, error) {
+	if batch, ok := cachemanager.As[cachemanager.BatchBackend](backend); ok {
+		return batch.GetMany(ctx, keys)
+	}
+	found := make(map[string]any, len(keys))
+	for _, key := range keys {
+		value, exists, err := backend.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			found[key] = value
+		}
+	}
+	return found, nil
+}
+
+// mix64 scrambles the bits of x so that close inputs get unrelated scores
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,295 @@
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/backend/inmemory"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchMockBackend stores entries in a map and records the keys of every
+// batch call
+type batchMockBackend struct {
+	mu            sync.Mutex
+	data          map[string]any
+	invalidations chan string
+	batches       [][]string
+}
+
+func newBatchMockBackend() *batchMockBackend {
+	return &batchMockBackend{data: make(map[string]any), invalidations: make(chan string)}
+}
+
+func (m *batchMockBackend) Get(ctx context.Context, key string) (any, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, exists := m.data[key]
+	return value, exists, nil
+}
+
+func (m *batchMockBackend) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *batchMockBackend) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *batchMockBackend) Close() error {
+	return nil
+}
+
+func (m *batchMockBackend) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	m.record(keys)
+	found := make(map[string]any)
+	for _, key := range keys {
+		if value, exists, _ := m.Get(ctx, key); exists {
+			found[key] = value
+		}
+	}
+	return found, nil
+}
+
+func (m *batchMockBackend) SetMany(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		keys = append(keys, key)
+		_ = m.Set(ctx, key, value, ttl)
+	}
+	m.record(keys)
+	return nil
+}
+
+func (m *batchMockBackend) DeleteMany(ctx context.Context, keys []string) error {
+	m.record(keys)
+	for _, key := range keys {
+		_ = m.Delete(ctx, key)
+	}
+	return nil
+}
+
+func (m *batchMockBackend) GetInvalidationChannel() <-chan string {
+	return m.invalidations
+}
+
+func (m *batchMockBackend) record(keys []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, keys)
+}
+
+func (m *batchMockBackend) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.data)
+}
+
+func newShardedMock(t *testing.T, n int) (*Cache, map[string]*batchMockBackend) {
+	t.Helper()
+	backends := make(map[string]*batchMockBackend, n)
+	shards := make([]Shard, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("redis-%d", i)
+		backends[name] = newBatchMockBackend()
+		shards = append(shards, Shard{Name: name, Backend: backends[name]})
+	}
+	sharded, err := NewShardedCache(shards...)
+	require.NoError(t, err)
+	return sharded, backends
+}
+
+func TestShardedBackend_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			shards := make([]Shard, 3)
+			for i := range shards {
+				shards[i] = Shard{Name: fmt.Sprintf("shard-%d", i), Backend: inmemory.NewInMemoryCache(inmemory.WithDefaultTTL(defaultTTL))}
+			}
+			sharded, err := NewShardedCache(shards...)
+			require.NoError(t, err)
+			return sharded
+		},
+		Advance: time.Sleep,
+	})
+}
+
+func TestShardedBackend(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("keys are spread over the shards", func(t *testing.T) {
+		sharded, backends := newShardedMock(t, 4)
+		defer sharded.Close()
+
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, sharded.Set(ctx, fmt.Sprintf("key:%d", i), i, time.Minute))
+		}
+		for name, backend := range backends {
+			assert.Greater(t, backend.len(), 150, name)
+		}
+
+		key := "key:42"
+		name, err := sharded.ShardFor(key)
+		require.NoError(t, err)
+		value, exists, _ := backends[name].Get(ctx, key)
+		assert.True(t, exists)
+		assert.Equal(t, 42, value)
+
+		value, found, err := sharded.Get(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, 42, value)
+		require.NoError(t, sharded.Delete(ctx, key))
+		_, exists, _ = backends[name].Get(ctx, key)
+		assert.False(t, exists)
+	})
+
+	t.Run("adding and removing a shard moves few keys", func(t *testing.T) {
+		sharded, _ := newShardedMock(t, 4)
+		defer sharded.Close()
+
+		owners := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			owners[key], _ = sharded.ShardFor(key)
+		}
+
+		require.NoError(t, sharded.AddShard(Shard{Name: "redis-4", Backend: newBatchMockBackend()}))
+		assert.ErrorIs(t, sharded.AddShard(Shard{Name: "redis-4", Backend: newBatchMockBackend()}), ErrShardExists)
+		moved := 0
+		for key, owner := range owners {
+			name, err := sharded.ShardFor(key)
+			require.NoError(t, err)
+			if name != owner {
+				assert.Equal(t, "redis-4", name, "keys only move to the new shard")
+				moved++
+			}
+		}
+		assert.InDelta(t, 200, moved, 60)
+
+		_, err := sharded.RemoveShard("redis-4")
+		require.NoError(t, err)
+		for key, owner := range owners {
+			name, _ := sharded.ShardFor(key)
+			assert.Equal(t, owner, name)
+		}
+		_, err = sharded.RemoveShard("redis-4")
+		assert.ErrorIs(t, err, ErrShardNotFound)
+		assert.Len(t, sharded.Shards(), 4)
+	})
+
+	t.Run("batches are split per shard", func(t *testing.T) {
+		sharded, backends := newShardedMock(t, 3)
+		defer sharded.Close()
+
+		values := make(map[string]any)
+		keys := make([]string, 0, 30)
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			values[key] = i
+			keys = append(keys, key)
+		}
+		require.NoError(t, sharded.SetMany(ctx, values, time.Minute))
+		found, err := sharded.GetMany(ctx, append(keys, "missing"))
+		require.NoError(t, err)
+		assert.Equal(t, values, found)
+		require.NoError(t, sharded.DeleteMany(ctx, keys))
+
+		for name, backend := range backends {
+			require.Len(t, backend.batches, 3, name)
+			for _, batch := range backend.batches {
+				for _, key := range batch {
+					owner, _ := sharded.ShardFor(key)
+					assert.Equal(t, name, owner, "%s only receives its own keys", name)
+				}
+			}
+			assert.Zero(t, backend.len())
+		}
+	})
+
+	t.Run("invalidations of every shard are merged", func(t *testing.T) {
+		sharded, backends := newShardedMock(t, 2)
+		upper := inmemory.NewInMemoryCache()
+		cm := cachemanager.NewCacheManager(
+			cachemanager.CacheConfig{Backend: upper, TTL: time.Minute},
+			cachemanager.CacheConfig{Backend: sharded, TTL: time.Hour},
+		)
+		require.NoError(t, cm.Set(ctx, "a", "1"))
+		require.NoError(t, cm.Set(ctx, "b", "2"))
+
+		backends["redis-0"].invalidations <- "a"
+		backends["redis-1"].invalidations <- "b"
+		assert.Eventually(t, func() bool {
+			_, a, _ := upper.Get(ctx, "a")
+			_, b, _ := upper.Get(ctx, "b")
+			return !a && !b
+		}, time.Second, 5*time.Millisecond)
+
+		// Removed shards are no longer relayed
+		removed, err := sharded.RemoveShard("redis-0")
+		require.NoError(t, err)
+		select {
+		case removed.(*batchMockBackend).invalidations <- "a":
+			t.Fatal("invalidation of a removed shard was relayed")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		require.NoError(t, cm.Close())
+		_, open := <-sharded.GetInvalidationChannel()
+		assert.False(t, open)
+	})
+
+	t.Run("without shards", func(t *testing.T) {
+		sharded, err := NewShardedCache()
+		require.NoError(t, err)
+		defer sharded.Close()
+
+		_, _, err = sharded.Get(ctx, "key")
+		assert.ErrorIs(t, err, ErrNoShards)
+		assert.ErrorIs(t, sharded.SetMany(ctx, map[string]any{"key": 1}, time.Minute), ErrNoShards)
+		assert.NoError(t, sharded.Clear(ctx))
+
+		_, err = NewShardedCache(Shard{Name: "a", Backend: newBatchMockBackend()}, Shard{Name: "a", Backend: newBatchMockBackend()})
+		assert.ErrorIs(t, err, ErrShardExists)
+	})
+
+	t.Run("only capabilities of every shard are offered", func(t *testing.T) {
+		counting := inmemory.NewInMemoryCache()
+		sharded, err := NewShardedCache(Shard{Name: "a", Backend: counting})
+		require.NoError(t, err)
+		defer sharded.Close()
+		counter := reflect.TypeFor[cachemanager.CounterBackend]()
+		assert.True(t, cachemanager.Supports(sharded, counter))
+
+		require.NoError(t, sharded.AddShard(Shard{Name: "b", Backend: newBatchMockBackend()}))
+		assert.False(t, cachemanager.Supports(sharded, counter))
+		assert.False(t, cachemanager.Supports(sharded, reflect.TypeFor[cachemanager.ClearableBackend]()))
+		assert.True(t, cachemanager.Supports(sharded, reflect.TypeFor[cachemanager.BatchBackend]()))
+
+		// The counter lives in the tier above, the only one that can count
+		upper := inmemory.NewInMemoryCache()
+		cm := cachemanager.NewCacheManager(
+			cachemanager.CacheConfig{Backend: upper, TTL: time.Minute},
+			cachemanager.CacheConfig{Backend: sharded, TTL: time.Hour},
+		)
+		value, err := cm.Increment(ctx, "views", 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), value)
+		stored, _, err := upper.Get(ctx, "views")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), stored)
+	})
+}
@@ -0,0 +1,457 @@
+// Package sqlite provides a persistent cache backend stored in an SQLite
+// database through a pure-Go driver, so the cache can be inspected with SQL
+// tools and backed up like any other database file.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/ethan-k/cachemanager-go/internal/valuecodec"
+	_ "modernc.org/sqlite"
+)
+
+// maxBatchKeys bounds the number of parameters of a single statement
+const maxBatchKeys = 500
+
+// schema creates the entries table. expires_at is in Unix milliseconds, NULL
+// for entries that never expire; tags is a JSON array; version counts the
+// writes of the key since it was created.
+const schema = `
+CREATE TABLE IF NOT EXISTS cache_entries (
+	key        TEXT PRIMARY KEY,
+	value      BLOB NOT NULL,
+	flags      INTEGER NOT NULL,
+	expires_at INTEGER,
+	tags       TEXT NOT NULL DEFAULT '[]',
+	version    INTEGER NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS cache_entries_expires_at
+	ON cache_entries (expires_at) WHERE expires_at IS NOT NULL;
+`
+
+const (
+	getQuery = `SELECT value, flags FROM cache_entries
+		WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`
+	upsertQuery = `INSERT INTO cache_entries (key, value, flags, expires_at, tags)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			value = excluded.value, flags = excluded.flags,
+			expires_at = excluded.expires_at, tags = excluded.tags,
+			version = version + 1`
+	deleteQuery = `DELETE FROM cache_entries WHERE key = ?`
+	touchQuery  = `UPDATE cache_entries SET expires_at = ?
+		WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`
+	cleanupQuery = `DELETE FROM cache_entries WHERE key IN (
+		SELECT key FROM cache_entries WHERE expires_at <= ? LIMIT ?)`
+)
+
+// Cache is a cache stored in an SQLite database
+type Cache struct {
+	db               *sql.DB
+	codec            codec.Codec
+	defaultTTL       time.Duration
+	wal              bool
+	cleanupInterval  time.Duration
+	cleanupBatchSize int
+	stop             chan struct{}
+	done             chan struct{}
+
+	get     *sql.Stmt
+	upsert  *sql.Stmt
+	delete  *sql.Stmt
+	touch   *sql.Stmt
+	cleanup *sql.Stmt
+}
+
+// Option defines the functional option type for configuring the cache
+type Option func(*Cache)
+
+// WithCodec sets the codec used for values that are not []byte. It defaults
+// to codec.Gob.
+func WithCodec(cdc codec.Codec) Option {
+	return func(c *Cache) {
+		c.codec = cdc
+	}
+}
+
+// WithDefaultTTL sets the TTL applied when Set is called with
+// cachemanager.DefaultTTL. It defaults to cachemanager.NoExpiration.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithWAL switches the database to write-ahead logging, which lets readers
+// proceed while a write is in progress.
+func WithWAL() Option {
+	return func(c *Cache) {
+		c.wal = true
+	}
+}
+
+// WithCleanupInterval sets the interval for deleting expired entries. It
+// defaults to one minute.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(c *Cache) {
+		if interval > 0 {
+			c.cleanupInterval = interval
+		}
+	}
+}
+
+// WithCleanupBatchSize sets the maximum number of expired entries deleted per
+// statement. It defaults to 1000.
+func WithCleanupBatchSize(size int) Option {
+	return func(c *Cache) {
+		if size > 0 {
+			c.cleanupBatchSize = size
+		}
+	}
+}
+
+// NewSQLiteCache opens or creates the database at path and its schema
+func NewSQLiteCache(path string, opts ...Option) (*Cache, error) {
+	cache := &Cache{
+		codec:            codec.Gob{},
+		defaultTTL:       cachemanager.NoExpiration,
+		cleanupInterval:  time.Minute,
+		cleanupBatchSize: 1000,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	// Pragmas are applied to every pooled connection. Transactions take the
+	// write lock up front so they wait for each other instead of failing.
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	if cache.wal {
+		params.Add("_pragma", "journal_mode(WAL)")
+	}
+	params.Set("_txlock", "immediate")
+	dsn := url.URL{Scheme: "file", Path: path, OmitHost: true, RawQuery: params.Encode()}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite cache: %w", err)
+	}
+	cache.db = db
+
+	if err := cache.prepare(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare sqlite cache: %w", err)
+	}
+	go cache.runCleanup()
+
+	return cache, nil
+}
+
+func (c *Cache) prepare() error {
+	if _, err := c.db.Exec(schema); err != nil {
+		return err
+	}
+	for _, stmt := range []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		{&c.get, getQuery},
+		{&c.upsert, upsertQuery},
+		{&c.delete, deleteQuery},
+		{&c.touch, touchQuery},
+		{&c.cleanup, cleanupQuery},
+	} {
+		prepared, err := c.db.Prepare(stmt.query)
+		if err != nil {
+			return err
+		}
+		*stmt.dst = prepared
+	}
+	return nil
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (any, bool, error) {
+	var data []byte
+	var flag valuecodec.Flag
+	err := c.get.QueryRowContext(ctx, key, nowMillis()).Scan(&data, &flag)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := valuecodec.Decode(c.codec, key, flag, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return c.set(ctx, c.upsert, key, value, ttl, nil)
+}
+
+// DefaultTTL returns the TTL applied to writes made with
+// cachemanager.DefaultTTL
+func (c *Cache) DefaultTTL() time.Duration {
+	return c.defaultTTL
+}
+
+// SetWithTags stores value and associates it with tags
+func (c *Cache) SetWithTags(ctx context.Context, key string, value any, ttl time.Duration, tags []string) error {
+	return c.set(ctx, c.upsert, key, value, ttl, tags)
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	_, err := c.delete.ExecContext(ctx, key)
+	return err
+}
+
+// Touch resets the expiration of key without rewriting its value. It reports
+// whether the key was found.
+func (c *Cache) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return false, err
+	}
+	result, err := c.touch.ExecContext(ctx, expiresAt(ttl), key, nowMillis())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetMany returns the cached values of keys. Missing keys are left out.
+func (c *Cache) GetMany(ctx context.Context, keys []string) (map[string]any, error) {
+	found := make(map[string]any, len(keys))
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), maxBatchKeys)]
+		keys = keys[len(batch):]
+
+		args := make([]any, 0, len(batch)+1)
+		args = append(args, nowMillis())
+		for _, key := range batch {
+			args = append(args, key)
+		}
+		rows, err := c.db.QueryContext(ctx, `SELECT key, value, flags FROM cache_entries
+			WHERE (expires_at IS NULL OR expires_at > ?) AND key IN (`+placeholders(len(batch))+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key string
+			var data []byte
+			var flag valuecodec.Flag
+			if err := rows.Scan(&key, &data, &flag); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			value, err := valuecodec.Decode(c.codec, key, flag, data)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			found[key] = value
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// SetMany upserts values in one transaction
+func (c *Cache) SetMany(ctx context.Context, values map[string]any, ttl time.Duration) error {
+	return c.inTx(ctx, func(tx *sql.Tx) error {
+		upsert := tx.StmtContext(ctx, c.upsert)
+		for key, value := range values {
+			if err := c.set(ctx, upsert, key, value, ttl, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteMany removes keys in one transaction
+func (c *Cache) DeleteMany(ctx context.Context, keys []string) error {
+	return c.inTx(ctx, func(tx *sql.Tx) error {
+		del := tx.StmtContext(ctx, c.delete)
+		for _, key := range keys {
+			if _, err := del.ExecContext(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// InvalidateTags removes every entry tagged with any of tags and returns
+// their keys
+func (c *Cache) InvalidateTags(ctx context.Context, tags ...string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(tags))
+	for i, tag := range tags {
+		args[i] = tag
+	}
+
+	var keys []string
+	err := c.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `DELETE FROM cache_entries WHERE key IN (
+			SELECT DISTINCT cache_entries.key FROM cache_entries, json_each(cache_entries.tags)
+			WHERE json_each.value IN (`+placeholders(len(tags))+`)) RETURNING key`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Clear removes every entry from the cache.
+func (c *Cache) Clear(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM cache_entries`)
+	return err
+}
+
+// DeletePrefix removes every entry whose key starts with prefix, using a key
+// range so only the matching rows are visited.
+func (c *Cache) DeletePrefix(ctx context.Context, prefix string) error {
+	if upper, ok := prefixUpperBound(prefix); ok {
+		_, err := c.db.ExecContext(ctx, `DELETE FROM cache_entries WHERE key >= ? AND key < ?`, prefix, upper)
+		return err
+	}
+	_, err := c.db.ExecContext(ctx, `DELETE FROM cache_entries WHERE key >= ?`, prefix)
+	return err
+}
+
+func (c *Cache) GetInvalidationChannel() <-chan string {
+	return nil
+}
+
+// Close stops the background cleanup and closes the database
+func (c *Cache) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.db.Close()
+}
+
+func (c *Cache) runCleanup() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = c.removeExpired(context.Background())
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// removeExpired deletes expired entries in batches of at most
+// cleanupBatchSize, found through the expires_at index
+func (c *Cache) removeExpired(ctx context.Context) error {
+	for {
+		result, err := c.cleanup.ExecContext(ctx, nowMillis(), c.cleanupBatchSize)
+		if err != nil {
+			return err
+		}
+		removed, err := result.RowsAffected()
+		if err != nil || removed < int64(c.cleanupBatchSize) {
+			return err
+		}
+	}
+}
+
+// set encodes value and runs upsert for it
+func (c *Cache) set(ctx context.Context, upsert *sql.Stmt, key string, value any, ttl time.Duration, tags []string) error {
+	ttl, err := cachemanager.ResolveTTL(ttl, c.defaultTTL)
+	if err != nil {
+		return err
+	}
+	flag, data, err := valuecodec.Encode(c.codec, key, value)
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	encodedTags, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	_, err = upsert.ExecContext(ctx, key, data, flag, expiresAt(ttl), string(encodedTags))
+	return err
+}
+
+func (c *Cache) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// expiresAt returns the expires_at column for a resolved TTL
+func expiresAt(ttl time.Duration) any {
+	if ttl == cachemanager.NoExpiration {
+		return nil
+	}
+	return time.Now().Add(ttl).UnixMilli()
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// prefixUpperBound returns the smallest string greater than every string
+// starting with prefix, or false if there is none
+func prefixUpperBound(prefix string) (string, bool) {
+	upper := []byte(prefix)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xff {
+			upper[i]++
+			return string(upper[:i+1]), true
+		}
+	}
+	return "", false
+}
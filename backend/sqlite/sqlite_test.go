@@ -0,0 +1,131 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/cachetest"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCache(t *testing.T, opts ...Option) (*Cache, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cache.sqlite")
+	cache, err := NewSQLiteCache(path, opts...)
+	require.NoError(t, err)
+	return cache, path
+}
+
+func countRows(t *testing.T, cache *Cache) int {
+	t.Helper()
+	var n int
+	require.NoError(t, cache.db.QueryRow(`SELECT COUNT(*) FROM cache_entries`).Scan(&n))
+	return n
+}
+
+func TestSQLiteCache_Conformance(t *testing.T) {
+	cachetest.Run(t, cachetest.Harness{
+		New: func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+			cache, _ := newCache(t, WithDefaultTTL(defaultTTL))
+			return cache
+		},
+		Advance: time.Sleep,
+	})
+	cachetest.RunPersistent(t, cachetest.PersistentHarness{
+		Open: func(t *testing.T, path string) cachemanager.CacheBackend {
+			cache, err := NewSQLiteCache(path, WithCodec(codec.JSON{}))
+			require.NoError(t, err)
+			return cache
+		},
+	})
+}
+
+func TestSQLiteCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("upserts bump the version", func(t *testing.T) {
+		cache, _ := newCache(t)
+		defer cache.Close()
+		require.NoError(t, cache.Set(ctx, "key", "a", time.Hour))
+		require.NoError(t, cache.SetWithTags(ctx, "key", "b", cachemanager.NoExpiration, []string{"t"}))
+
+		var version int
+		var expiresAt *int64
+		var tags string
+		require.NoError(t, cache.db.QueryRow(
+			`SELECT version, expires_at, tags FROM cache_entries WHERE key = ?`, "key",
+		).Scan(&version, &expiresAt, &tags))
+		assert.Equal(t, 2, version)
+		assert.Nil(t, expiresAt)
+		assert.Equal(t, `["t"]`, tags)
+	})
+
+	t.Run("batches span several statements", func(t *testing.T) {
+		cache, _ := newCache(t, WithWAL())
+		defer cache.Close()
+
+		n := 2*maxBatchKeys + 1
+		values := make(map[string]any, n)
+		keys := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			values[key] = i
+			keys = append(keys, key)
+		}
+		require.NoError(t, cache.SetMany(ctx, values, time.Hour))
+
+		found, err := cache.GetMany(ctx, keys)
+		require.NoError(t, err)
+		assert.Equal(t, values, found)
+
+		require.NoError(t, cache.DeleteMany(ctx, keys[:maxBatchKeys]))
+		assert.Equal(t, len(keys)-maxBatchKeys, countRows(t, cache))
+
+		var mode string
+		require.NoError(t, cache.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode))
+		assert.Equal(t, "wal", mode)
+	})
+
+	t.Run("expired entries are reclaimed in the background", func(t *testing.T) {
+		cache, _ := newCache(t, WithCleanupInterval(10*time.Millisecond), WithCleanupBatchSize(2))
+		defer cache.Close()
+		for i := 0; i < 5; i++ {
+			require.NoError(t, cache.Set(ctx, fmt.Sprintf("short:%d", i), "v", 5*time.Millisecond))
+		}
+		require.NoError(t, cache.Set(ctx, "long", "v", time.Hour))
+		require.NoError(t, cache.Set(ctx, "forever", "v", cachemanager.NoExpiration))
+
+		assert.Eventually(t, func() bool {
+			return countRows(t, cache) == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("paths are escaped in the connection string", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache #1?mode=ro.sqlite")
+		cache, err := NewSQLiteCache(path)
+		require.NoError(t, err)
+		defer cache.Close()
+		require.NoError(t, cache.Set(ctx, "key", "value", time.Hour))
+		assert.FileExists(t, path)
+	})
+
+	t.Run("tags are invalidated through the tag column", func(t *testing.T) {
+		cache, _ := newCache(t)
+		defer cache.Close()
+		require.NoError(t, cache.SetWithTags(ctx, "user:1", "a", time.Hour, []string{"users", "team:1"}))
+		require.NoError(t, cache.SetWithTags(ctx, "user:2", "b", time.Hour, []string{"users"}))
+		require.NoError(t, cache.SetWithTags(ctx, "team:1", "c", time.Hour, []string{"team:1"}))
+		require.NoError(t, cache.Set(ctx, "other", "d", time.Hour))
+
+		keys, err := cache.InvalidateTags(ctx, "users", "team:1")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"user:1", "user:2", "team:1"}, keys)
+		assert.Equal(t, 1, countRows(t, cache))
+	})
+}
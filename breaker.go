@@ -0,0 +1,249 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethan-k/cachemanager-go/codec"
+)
+
+// BreakerState is the state of a tier's circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through to the tier
+	BreakerClosed BreakerState = iota
+	// BreakerOpen skips the tier until the cool-down ends
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe calls through to decide whether the
+	// tier has recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerEvent describes a state change of a tier's circuit breaker
+type BreakerEvent struct {
+	// Tier is the index of the tier in NewCacheManager
+	Tier int
+	From BreakerState
+	To   BreakerState
+}
+
+// ErrCircuitOpen is returned, wrapped with the tier's index, by writes,
+// deletes and invalidations that skipped a tier whose circuit breaker is
+// open, since that tier may still hold the old value. The operations that
+// only one tier can serve, such as Increment and CompareAndSwap, return it
+// too. Callers that accept a skipped tier can ignore it with errors.Is.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreakerConfig configures the circuit breaker of a tier. While the
+// breaker is open, CacheManager skips the tier for reads, writes and
+// backfills instead of waiting for it to time out. Zero fields take their
+// defaults.
+type CircuitBreakerConfig struct {
+	// Window is the period over which failures are counted. It defaults to
+	// ten seconds.
+	Window time.Duration
+	// MinCalls is the number of calls a window needs before the breaker can
+	// open. It defaults to 20.
+	MinCalls int
+	// FailureRate is the fraction of failed calls in a window that opens the
+	// breaker. It defaults to 0.5.
+	FailureRate float64
+	// Cooldown is how long the breaker stays open before probing the tier.
+	// It defaults to five seconds.
+	Cooldown time.Duration
+	// HalfOpenCalls is how many probe calls are let through once the
+	// cool-down ends. The breaker closes when they all succeed and opens
+	// again on the first failure. It defaults to 1.
+	HalfOpenCalls int
+	// OnStateChange, if set, is called on every state change
+	OnStateChange func(BreakerEvent)
+}
+
+// circuitBreaker tracks the failures of one tier
+type circuitBreaker struct {
+	tier   int
+	config CircuitBreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+func newCircuitBreaker(tier int, config CircuitBreakerConfig) *circuitBreaker {
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.MinCalls <= 0 {
+		config.MinCalls = 20
+	}
+	if config.FailureRate <= 0 {
+		config.FailureRate = 0.5
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 5 * time.Second
+	}
+	if config.HalfOpenCalls <= 0 {
+		config.HalfOpenCalls = 1
+	}
+	return &circuitBreaker{tier: tier, config: config, windowStart: time.Now()}
+}
+
+// allow reports whether a call may go to the tier, and whether it is a
+// probe of a half-open breaker
+func (b *circuitBreaker) allow() (ok, probe bool) {
+	b.mu.Lock()
+	var event *BreakerEvent
+	defer func() {
+		b.mu.Unlock()
+		b.emit(event)
+	}()
+
+	switch b.state {
+	case BreakerClosed:
+		return true, false
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.config.Cooldown {
+			return false, false
+		}
+		event = b.transition(BreakerHalfOpen)
+	}
+	if b.probes >= b.config.HalfOpenCalls {
+		return false, false
+	}
+	b.probes++
+	return true, true
+}
+
+// record counts the outcome of a call let through by allow. Calls canceled
+// by their caller, and those that failed because of the caller, say nothing
+// about the tier and are ignored.
+func (b *circuitBreaker) record(probe bool, err error) {
+	b.mu.Lock()
+	var event *BreakerEvent
+	defer func() {
+		b.mu.Unlock()
+		b.emit(event)
+	}()
+
+	if callerError(err) {
+		if probe && b.state == BreakerHalfOpen {
+			// Free the probe slot for another call
+			b.probes--
+		}
+		return
+	}
+
+	if probe {
+		if b.state != BreakerHalfOpen {
+			return
+		}
+		if err != nil {
+			event = b.transition(BreakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenCalls {
+			event = b.transition(BreakerClosed)
+		}
+		return
+	}
+
+	// Calls started before the breaker opened are not counted again
+	if b.state != BreakerClosed {
+		return
+	}
+	now := time.Now()
+	if now.Sub(b.windowStart) >= b.config.Window {
+		b.windowStart, b.calls, b.failures = now, 0, 0
+	}
+	b.calls++
+	if err != nil {
+		b.failures++
+	}
+	if b.calls >= b.config.MinCalls && float64(b.failures) >= b.config.FailureRate*float64(b.calls) {
+		event = b.transition(BreakerOpen)
+	}
+}
+
+// callerError reports whether err was caused by the caller rather than the
+// tier: a canceled call, a key the tier cannot lease, a value that is not a
+// counter, an invalid TTL or a value that cannot be encoded
+func callerError(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, ErrLeaseNotSupported) ||
+		errors.Is(err, ErrNotCounter) ||
+		errors.Is(err, ErrInvalidTTL) ||
+		errors.Is(err, codec.ErrEncode)
+}
+
+// currentState returns the state of the breaker
+func (b *circuitBreaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// transition moves the breaker to state and returns the event to emit once
+// b.mu is released. The caller must hold b.mu.
+func (b *circuitBreaker) transition(state BreakerState) *BreakerEvent {
+	event := &BreakerEvent{Tier: b.tier, From: b.state, To: state}
+	b.state = state
+	b.probes, b.successes = 0, 0
+	switch state {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerClosed:
+		b.windowStart, b.calls, b.failures = time.Now(), 0, 0
+	}
+	return event
+}
+
+func (b *circuitBreaker) emit(event *BreakerEvent) {
+	if event != nil && b.config.OnStateChange != nil {
+		b.config.OnStateChange(*event)
+	}
+}
+
+// beginCall reports whether tier i may be called and returns the function
+// recording the outcome of the call. Tiers without a circuit breaker are
+// always called.
+func (cm *CacheManager) beginCall(i int) (func(error), bool) {
+	breaker := cm.breakers[i]
+	if breaker == nil {
+		return func(error) {}, true
+	}
+	ok, probe := breaker.allow()
+	if !ok {
+		return nil, false
+	}
+	return func(err error) { breaker.record(probe, err) }, true
+}
+
+// BreakerState returns the state of the circuit breaker of tier i. Tiers
+// without a circuit breaker are always closed.
+func (cm *CacheManager) BreakerState(i int) BreakerState {
+	if breaker := cm.breakers[i]; breaker != nil {
+		return breaker.currentState()
+	}
+	return BreakerClosed
+}
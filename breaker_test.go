@@ -0,0 +1,247 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyMockBackend fails every call while down and counts the calls it
+// receives
+type flakyMockBackend struct {
+	*lockedMockBackend
+	downMu sync.Mutex
+	down   bool
+	calls  atomic.Int64
+}
+
+var errBackendDown = errors.New("backend down")
+
+func (m *flakyMockBackend) setDown(down bool) {
+	m.downMu.Lock()
+	defer m.downMu.Unlock()
+	m.down = down
+}
+
+func (m *flakyMockBackend) err() error {
+	m.calls.Add(1)
+	m.downMu.Lock()
+	defer m.downMu.Unlock()
+	if m.down {
+		return errBackendDown
+	}
+	return nil
+}
+
+func (m *flakyMockBackend) Get(ctx context.Context, key string) (any, bool, error) {
+	if err := m.err(); err != nil {
+		return nil, false, err
+	}
+	return m.lockedMockBackend.Get(ctx, key)
+}
+
+func (m *flakyMockBackend) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := m.err(); err != nil {
+		return err
+	}
+	return m.lockedMockBackend.Set(ctx, key, value, ttl)
+}
+
+// flakyCounterMockBackend is a counting, clearable tier that fails while down
+type flakyCounterMockBackend struct {
+	*flakyMockBackend
+}
+
+func (m *flakyCounterMockBackend) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if err := m.err(); err != nil {
+		return 0, err
+	}
+	return (&counterMockBackend{m.lockedMockBackend}).Incr(ctx, key, delta, ttl)
+}
+
+func (m *flakyCounterMockBackend) Clear(ctx context.Context) error {
+	return m.err()
+}
+
+func (m *flakyCounterMockBackend) DeletePrefix(ctx context.Context, prefix string) error {
+	return m.err()
+}
+
+// rejectingMockBackend fails every write and increment with err, as a
+// backend does for a request it cannot serve
+type rejectingMockBackend struct {
+	*lockedMockBackend
+	err error
+}
+
+func (m *rejectingMockBackend) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return m.err
+}
+
+func (m *rejectingMockBackend) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return 0, m.err
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	newManager := func(t *testing.T, cooldown time.Duration) (*CacheManager, *flakyMockBackend, *lockedMockBackend, func() []BreakerEvent) {
+		t.Helper()
+		var mu sync.Mutex
+		var events []BreakerEvent
+		flaky := &flakyMockBackend{lockedMockBackend: newLockedMockBackend()}
+		lower := newLockedMockBackend()
+		cm := NewCacheManager(
+			CacheConfig{Backend: flaky, TTL: time.Minute, CircuitBreaker: &CircuitBreakerConfig{
+				MinCalls: 4,
+				Cooldown: cooldown,
+				OnStateChange: func(event BreakerEvent) {
+					mu.Lock()
+					defer mu.Unlock()
+					events = append(events, event)
+				},
+			}},
+			CacheConfig{Backend: lower, TTL: time.Hour},
+		)
+		return cm, flaky, lower, func() []BreakerEvent {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]BreakerEvent(nil), events...)
+		}
+	}
+
+	t.Run("failing tiers are skipped while the breaker is open", func(t *testing.T) {
+		cm, flaky, lower, events := newManager(t, time.Hour)
+		require.NoError(t, lower.Set(ctx, "key", "value", time.Hour))
+		flaky.setDown(true)
+
+		for i := 0; i < 4; i++ {
+			value, err := cm.Get(ctx, "key")
+			require.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}
+		assert.Eventually(t, func() bool {
+			return cm.BreakerState(0) == BreakerOpen
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []BreakerEvent{{Tier: 0, From: BreakerClosed, To: BreakerOpen}}, events())
+		assert.Equal(t, BreakerClosed, cm.BreakerState(1))
+
+		// Let the backfills of the reads above finish
+		time.Sleep(20 * time.Millisecond)
+		calls := flaky.calls.Load()
+		value, err := cm.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+		err = cm.Set(ctx, "other", "value")
+		assert.ErrorIs(t, err, ErrCircuitOpen, "a skipped tier keeps the old value")
+		has, _, _ := lower.Get(ctx, "other")
+		assert.Equal(t, "value", has, "the other tiers are still written")
+		err = cm.Delete(ctx, "other")
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.ErrorContains(t, err, "backend 0")
+		_, found, _ := lower.Get(ctx, "other")
+		assert.False(t, found)
+		// Give a backfill the chance to run
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, calls, flaky.calls.Load())
+	})
+
+	t.Run("a successful probe closes the breaker", func(t *testing.T) {
+		cm, flaky, lower, events := newManager(t, 20*time.Millisecond)
+		flaky.setDown(true)
+		for i := 0; i < 4; i++ {
+			_ = cm.Set(ctx, "key", "value")
+		}
+		require.Equal(t, BreakerOpen, cm.BreakerState(0))
+
+		time.Sleep(30 * time.Millisecond)
+		flaky.setDown(false)
+		require.NoError(t, cm.Set(ctx, "key", "new"))
+		assert.Equal(t, BreakerClosed, cm.BreakerState(0))
+		value, _, exists := flaky.entry("key")
+		assert.True(t, exists)
+		assert.Equal(t, "new", value)
+		value, _, _ = lower.entry("key")
+		assert.Equal(t, "new", value)
+
+		assert.Equal(t, []BreakerEvent{
+			{Tier: 0, From: BreakerClosed, To: BreakerOpen},
+			{Tier: 0, From: BreakerOpen, To: BreakerHalfOpen},
+			{Tier: 0, From: BreakerHalfOpen, To: BreakerClosed},
+		}, events())
+	})
+
+	t.Run("a failed probe opens the breaker again", func(t *testing.T) {
+		cm, flaky, _, events := newManager(t, 20*time.Millisecond)
+		flaky.setDown(true)
+		for i := 0; i < 4; i++ {
+			_ = cm.Set(ctx, "key", "value")
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		assert.Error(t, cm.Set(ctx, "key", "value"))
+		assert.Equal(t, BreakerOpen, cm.BreakerState(0))
+		assert.Equal(t, BreakerHalfOpen, events()[1].To)
+		assert.Equal(t, BreakerEvent{Tier: 0, From: BreakerHalfOpen, To: BreakerOpen}, events()[2])
+	})
+
+	t.Run("a healthy window keeps the breaker closed", func(t *testing.T) {
+		cm, flaky, _, events := newManager(t, time.Hour)
+		for i := 0; i < 10; i++ {
+			flaky.setDown(i%4 == 0)
+			_ = cm.Set(ctx, "key", "value")
+		}
+		assert.Equal(t, BreakerClosed, cm.BreakerState(0))
+		assert.Empty(t, events())
+	})
+
+	t.Run("errors caused by the caller leave the breaker closed", func(t *testing.T) {
+		for _, err := range []error{
+			fmt.Errorf("key counter: %w", ErrNotCounter),
+			ErrInvalidTTL,
+			fmt.Errorf("%w for key counter: unsupported type", codec.ErrEncode),
+			fmt.Errorf("key counter: %w", ErrLeaseNotSupported),
+		} {
+			backend := &rejectingMockBackend{lockedMockBackend: newLockedMockBackend(), err: err}
+			cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute, CircuitBreaker: &CircuitBreakerConfig{
+				MinCalls: 4,
+				Cooldown: time.Hour,
+			}})
+			for i := 0; i < 8; i++ {
+				_, incrErr := cm.Increment(ctx, "counter", 1)
+				assert.ErrorIs(t, incrErr, err)
+				assert.ErrorIs(t, cm.Set(ctx, "counter", "value"), err)
+			}
+			assert.Equal(t, BreakerClosed, cm.BreakerState(0), err.Error())
+		}
+	})
+
+	t.Run("single-tier operations go through the breaker", func(t *testing.T) {
+		flaky := &flakyCounterMockBackend{&flakyMockBackend{lockedMockBackend: newLockedMockBackend()}}
+		cm := NewCacheManager(CacheConfig{Backend: flaky, TTL: time.Minute, CircuitBreaker: &CircuitBreakerConfig{
+			MinCalls: 4,
+			Cooldown: time.Hour,
+		}})
+		flaky.setDown(true)
+		for i := 0; i < 4; i++ {
+			_, err := cm.Increment(ctx, "counter", 1)
+			assert.ErrorIs(t, err, errBackendDown)
+		}
+		require.Equal(t, BreakerOpen, cm.BreakerState(0))
+
+		calls := flaky.calls.Load()
+		_, err := cm.Increment(ctx, "counter", 1)
+		assert.ErrorIs(t, err, ErrCircuitOpen, "the only counting tier cannot be skipped")
+		assert.ErrorIs(t, cm.Clear(ctx), ErrCircuitOpen)
+		assert.ErrorIs(t, cm.DeletePrefix(ctx, "user:"), ErrCircuitOpen)
+		assert.Equal(t, calls, flaky.calls.Load())
+	})
+}
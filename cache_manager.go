@@ -2,10 +2,21 @@ package cachemanager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/ethan-k/cachemanager-go/internal/singleflight"
 )
 
+// ErrClearNotSupported is returned by CacheManager.Clear and DeletePrefix for
+// tiers that do not implement ClearableBackend
+var ErrClearNotSupported = errors.New("backend does not support clearing")
+
+// ErrEmptyPrefix is returned by DeletePrefix for an empty prefix, which would
+// match every key; use Clear to remove everything
+var ErrEmptyPrefix = errors.New("empty prefix")
+
 type CacheBackend interface {
 	Get(ctx context.Context, key string) (any, bool, error)
 	Set(ctx context.Context, key string, value any, ttl time.Duration) error
@@ -18,61 +29,544 @@ type CacheBackendWithInvalidationChannel interface {
 	GetInvalidationChannel() <-chan string
 }
 
+// InvalidationEvent describes entries invalidated by another cache instance.
+// Keys lists individual keys; Tags lists tags whose entries were dropped;
+// Prefixes lists key prefixes that were deleted; All reports that the whole
+// cache was cleared.
+type InvalidationEvent struct {
+	Keys     []string `json:"keys,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+	All      bool     `json:"all,omitempty"`
+}
+
+// CacheBackendWithInvalidationEvents is implemented by backends that relay
+// invalidations made by other instances, beyond single keys
+type CacheBackendWithInvalidationEvents interface {
+	CacheBackend
+	GetInvalidationEvents() <-chan InvalidationEvent
+}
+
+// TagBackend is implemented by backends that can associate entries with tags
+// and remove every entry carrying a tag
+type TagBackend interface {
+	CacheBackend
+	SetWithTags(ctx context.Context, key string, value any, ttl time.Duration, tags []string) error
+	// InvalidateTags removes every entry tagged with any of tags and returns
+	// the removed keys
+	InvalidateTags(ctx context.Context, tags ...string) ([]string, error)
+}
+
+// ClearableBackend is implemented by backends that can drop every entry, or
+// every entry under a key prefix, at once
+type ClearableBackend interface {
+	CacheBackend
+	Clear(ctx context.Context) error
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// TouchableBackend is implemented by backends that can reset an entry's TTL
+// without rewriting its value
+type TouchableBackend interface {
+	CacheBackend
+	Touch(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// BatchBackend is implemented by backends that can read and write many keys
+// in one round trip
+type BatchBackend interface {
+	CacheBackend
+	// GetMany returns the cached values of keys. Missing keys are left out.
+	GetMany(ctx context.Context, keys []string) (map[string]any, error)
+	SetMany(ctx context.Context, values map[string]any, ttl time.Duration) error
+	DeleteMany(ctx context.Context, keys []string) error
+}
+
 // CacheConfig holds configuration for a single cache backend
 type CacheConfig struct {
 	Backend CacheBackend
-	TTL     time.Duration
+	// TTL is passed to the backend on every write. NoExpiration keeps entries
+	// until they are deleted and DefaultTTL uses the backend's own default.
+	TTL time.Duration
+	// NegativeTTL is how long the tier caches a key that does not exist at
+	// the origin. Zero disables negative caching in the tier.
+	NegativeTTL time.Duration
+	// CircuitBreaker, if set, makes the manager skip the tier for reads,
+	// writes and backfills while it keeps failing
+	CircuitBreaker *CircuitBreakerConfig
+	// ReadTimeout and WriteTimeout, if positive, bound every read and every
+	// write or delete the manager makes to the tier
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// HedgeAfter, if positive, makes reads that have not been answered by
+	// the tier in time query the next tier, or the loader of GetOrLoad, in
+	// parallel. The first answer wins.
+	HedgeAfter time.Duration
+	// HedgeQuantile, if set, derives the hedging delay from the latency of
+	// the tier's recent reads: 0.95 hedges reads slower than the tier's p95.
+	// HedgeAfter applies until enough reads have been seen.
+	HedgeQuantile float64
+}
+
+// Loader fetches the value of a key from the origin. It returns ErrNotFound,
+// possibly wrapped, when the key does not exist there.
+type Loader func(ctx context.Context) (any, error)
+
+// LoadOption configures a single GetOrLoad call
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	earlyRefresh bool
+	beta         float64
+
+	locker        Locker
+	leaseTTL      time.Duration
+	leaseWait     time.Duration
+	leaseInterval time.Duration
 }
 
 // CacheManager orchestrates multiple cache backends
 type CacheManager struct {
 	backends []CacheConfig
+	breakers []*circuitBreaker
+	loads    singleflight.Group[any]
+
+	// hedging is set if any tier hedges its reads
+	hedging   bool
+	latencies []*latencyWindow
 }
 
 func NewCacheManager(configs ...CacheConfig) *CacheManager {
 	cm := &CacheManager{
-		backends: configs,
+		backends:  configs,
+		breakers:  make([]*circuitBreaker, len(configs)),
+		latencies: make([]*latencyWindow, len(configs)),
+	}
+	for i, config := range configs {
+		if config.CircuitBreaker != nil {
+			cm.breakers[i] = newCircuitBreaker(i, *config.CircuitBreaker)
+		}
+		if config.HedgeQuantile > 0 {
+			cm.latencies[i] = &latencyWindow{}
+		}
+		cm.hedging = cm.hedging || config.HedgeAfter > 0 || config.HedgeQuantile > 0
 	}
 
 	// Start listening for invalidation events from all backends
 	for i, config := range configs {
-		if cacheBackend, ok := config.Backend.(CacheBackendWithInvalidationChannel); ok {
+		if cacheBackend, ok := As[CacheBackendWithInvalidationChannel](config.Backend); ok {
 			go cm.handleInvalidation(context.Background(), cacheBackend.GetInvalidationChannel(), i)
 		}
+		if cacheBackend, ok := As[CacheBackendWithInvalidationEvents](config.Backend); ok {
+			go cm.handleInvalidationEvents(context.Background(), cacheBackend.GetInvalidationEvents(), i)
+		}
 	}
 
 	return cm
 }
 
-// Get retrieves a value from the cache chain
+// Get retrieves a value from the cache chain. A cached miss is reported as
+// ErrNotFound.
 func (cm *CacheManager) Get(ctx context.Context, key string) (any, error) {
+	value, found, err := cm.lookup(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("key %s not found in any backend", key)
+	}
+	if IsTombstone(value) {
+		return nil, fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+	return unwrapValue(value), nil
+}
+
+// GetOrLoad retrieves a value from the cache chain, calling load on a miss
+// and storing its result in every backend. Concurrent misses of the same key
+// share one call to load. When load returns ErrNotFound, a tombstone is
+// cached for each backend's NegativeTTL and ErrNotFound is returned until it
+// expires.
+func (cm *CacheManager) GetOrLoad(ctx context.Context, key string, load Loader, opts ...LoadOption) (any, error) {
+	options := loadOptions{
+		leaseWait:     defaultLeaseWait,
+		leaseInterval: defaultLeaseInterval,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	tokens := make(map[int]uint64)
+	result := cm.lookupChain(ctx, key, tokens, func(ctx context.Context, tokens map[int]uint64) (any, error) {
+		return cm.load(ctx, key, load, options, nil, tokens)
+	})
+	if result.loaded {
+		return result.value, result.err
+	}
+	value := result.value
+	if IsTombstone(value) {
+		return nil, fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+
+	entry, ok := value.(EarlyRefreshEntry)
+	if !ok {
+		return value, nil
+	}
+	if options.earlyRefresh && shouldRefreshEarly(entry, options.beta, time.Now()) {
+		refreshed, err := cm.load(ctx, key, load, options, &entry, nil)
+		// The cached value is still valid if the refresh failed
+		if err == nil || errors.Is(err, ErrNotFound) {
+			return refreshed, err
+		}
+	}
+	return entry.Value, nil
+}
+
+// load calls load once for all concurrent callers and caches its result.
+// tokens holds the fill tokens handed out by LeaseBackend tiers on the miss;
+// callers that only wait for another caller's load release theirs, which
+// that load cannot write with. With a lease configured, only the instance
+// holding the lease calls load; the others return stale, when refreshing a
+// cached value, or wait for the holder to store the value.
+func (cm *CacheManager) load(ctx context.Context, key string, load Loader, options loadOptions, stale *EarlyRefreshEntry, tokens map[int]uint64) (any, error) {
+	ran := false
+	value, err := cm.loads.Do(key, func() (any, error) {
+		ran = true
+		guard := &writeGuard{tokens: tokens}
+		if options.locker == nil {
+			return cm.loadAndStore(ctx, key, load, options, guard)
+		}
+
+		lease, err := options.locker.AcquireLease(ctx, key, options.leaseTTL)
+		if err != nil {
+			// Coordination is best effort; an unreachable locker must not
+			// make the key unavailable
+			return cm.loadAndStore(ctx, key, load, options, guard)
+		}
+		if lease == nil {
+			if stale != nil {
+				return stale.Value, nil
+			}
+			if value, found, err := cm.waitForLoad(ctx, key, options); found {
+				return value, err
+			}
+			// The holder did not store a value in time; load it ourselves
+			return cm.loadAndStore(ctx, key, load, options, guard)
+		}
+
+		defer func() {
+			_ = options.locker.ReleaseLease(context.WithoutCancel(ctx), lease)
+		}()
+		guard.lease = lease
+		return cm.loadAndStore(ctx, key, load, options, guard)
+	})
+	if !ran {
+		cm.releaseFillLeases(ctx, key, &writeGuard{tokens: tokens})
+	}
+	return value, err
+}
+
+// loadAndStore calls load and caches its result, with writes protected by
+// guard
+func (cm *CacheManager) loadAndStore(ctx context.Context, key string, load Loader, options loadOptions, guard *writeGuard) (any, error) {
+	start := time.Now()
+	value, err := load(ctx)
+	if errors.Is(err, ErrNotFound) {
+		_ = cm.setNegative(ctx, key, guard)
+		cm.releaseFillLeases(ctx, key, guard)
+		return nil, fmt.Errorf("key %s: %w", key, err)
+	}
+	if err != nil {
+		cm.releaseFillLeases(ctx, key, guard)
+		return nil, err
+	}
+
+	if options.earlyRefresh {
+		_ = cm.setEarlyRefresh(ctx, key, value, time.Since(start), guard)
+	} else {
+		_ = cm.setAll(ctx, key, value, guard)
+	}
+	return value, nil
+}
+
+// releaseFillLeases gives up the fill tokens of guard that were not used, so
+// other callers do not have to wait for them to expire. Tokens consumed by a
+// write are no longer outstanding and are left alone by the backend.
+func (cm *CacheManager) releaseFillLeases(ctx context.Context, key string, guard *writeGuard) {
+	ctx = context.WithoutCancel(ctx)
+	for i, token := range guard.tokens {
+		if token == 0 {
+			continue
+		}
+		_ = cm.backends[i].Backend.(LeaseBackend).ReleaseFillLease(ctx, key, token)
+	}
+}
+
+// lookup returns the value of key from the first backend holding it and
+// backfills the backends before it. Backend errors are only reported if no
+// backend holds the key. If tokens is not nil, LeaseBackend tiers that miss
+// hand out fill tokens, recorded by backend index.
+func (cm *CacheManager) lookup(ctx context.Context, key string, tokens map[int]uint64) (any, bool, error) {
+	result := cm.lookupChain(ctx, key, tokens, nil)
+	return result.value, result.found, result.err
+}
+
+// lookupResult is the outcome of reading a key through the cache chain
+type lookupResult struct {
+	value any
+	found bool
+	// loaded reports that value and err come from the fallback
+	loaded bool
+	err    error
+}
+
+// lookupChain is lookup with fallback, if not nil, called with the fill
+// tokens when every backend misses
+func (cm *CacheManager) lookupChain(ctx context.Context, key string, tokens map[int]uint64, fallback func(context.Context, map[int]uint64) (any, error)) lookupResult {
+	if cm.hedging {
+		return cm.hedgedLookup(ctx, key, tokens, fallback)
+	}
+
+	var lastErr error
+	for i := range cm.backends {
+		read := cm.readTier(ctx, key, i, tokens != nil)
+		if read.err != nil {
+			lastErr = fmt.Errorf("error from backend %d: %w", i, read.err)
+			continue
+		}
+		if read.found {
+			go cm.populatePreviousBackends(ctx, key, read.value, i, tokens)
+			return lookupResult{value: read.value, found: true}
+		}
+		if read.leased {
+			tokens[i] = read.token
+		}
+	}
+
+	if fallback != nil {
+		value, err := fallback(ctx, tokens)
+		return lookupResult{value: value, loaded: true, err: err}
+	}
+	return lookupResult{err: lastErr}
+}
+
+// tierRead is the outcome of reading a key from one backend
+type tierRead struct {
+	tier  int
+	value any
+	found bool
+	// leased reports that a LeaseBackend tier missed and handed out token
+	leased bool
+	token  uint64
+	err    error
+}
+
+// readTier reads key from the backend at index i, asking LeaseBackend tiers
+// for a fill token if lease is set. Keys the tier cannot lease are read with
+// Get. A tier whose circuit breaker is open misses.
+func (cm *CacheManager) readTier(ctx context.Context, key string, i int, lease bool) tierRead {
+	read := tierRead{tier: i}
+	done, ok := cm.beginCall(i)
+	if !ok {
+		return read
+	}
+	ctx, cancel := cm.readContext(ctx, i)
+	defer cancel()
+
+	start := time.Now()
+	window := cm.latencies[i]
+	var delay time.Duration
+	if window != nil {
+		delay = cm.hedgeDelay(i)
+	}
+	backend := cm.backends[i].Backend
+	leaseBackend, leasing := As[LeaseBackend](backend)
+	if leasing = leasing && lease; leasing {
+		read.value, read.found, read.token, read.err = leaseBackend.GetWithLease(ctx, key)
+		read.leased = read.err == nil && !read.found
+		leasing = !errors.Is(read.err, ErrLeaseNotSupported)
+	}
+	if !leasing {
+		read.value, read.found, read.err = backend.Get(ctx, key)
+	}
+	done(read.err)
+	if window != nil {
+		switch elapsed := time.Since(start); {
+		case read.err == nil:
+			window.observe(elapsed)
+		case errors.Is(read.err, context.Canceled), errors.Is(read.err, context.DeadlineExceeded):
+			// A read abandoned once hedged past, or timed out, took at least
+			// as long as the hedging delay; dropping it would only keep the
+			// fast reads and shrink the delay further
+			window.observe(max(elapsed, delay))
+		}
+	}
+	return read
+}
+
+// setNegative caches a tombstone for key in every backend with negative
+// caching enabled
+func (cm *CacheManager) setNegative(ctx context.Context, key string, guard *writeGuard) error {
 	var lastErr error
 
 	for i, config := range cm.backends {
-		value, found, err := config.Backend.Get(ctx, key)
-		if err != nil {
-			lastErr = fmt.Errorf("error from backend %d: %w", i, err)
+		if config.NegativeTTL == 0 {
 			continue
 		}
+		if err := cm.setBackend(ctx, i, key, Tombstone{}, config.NegativeTTL, guard); err != nil {
+			lastErr = fmt.Errorf("error setting in backend %d: %w", i, err)
+		}
+	}
+
+	return lastErr
+}
+
+// Set stores a value in all cache backends, one after another unless
+// WithParallelWrites is given
+func (cm *CacheManager) Set(ctx context.Context, key string, value any, opts ...WriteOption) error {
+	var options writeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if options.parallel {
+		return cm.fanOut(ctx, options.order, func(ctx context.Context, i int) error {
+			if err := cm.setBackend(ctx, i, key, value, cm.backends[i].TTL, nil); err != nil {
+				return fmt.Errorf("error setting in backend %d: %w", i, err)
+			}
+			return nil
+		})
+	}
+	return cm.setAll(ctx, key, value, nil)
+}
 
-		if found {
-			go cm.populatePreviousBackends(ctx, key, value, i)
-			return value, nil
+// setAll stores value in every backend, with writes protected by guard if it
+// is not nil
+func (cm *CacheManager) setAll(ctx context.Context, key string, value any, guard *writeGuard) error {
+	var lastErr error
+
+	for i, config := range cm.backends {
+		err := cm.setBackend(ctx, i, key, value, config.TTL, guard)
+		if err != nil {
+			lastErr = fmt.Errorf("error setting in backend %d: %w", i, err)
 		}
 	}
 
-	if lastErr != nil {
-		return nil, lastErr
+	return lastErr
+}
+
+// writeGuard protects the writes of a load from overwriting newer data
+type writeGuard struct {
+	// lease is the distributed lease the value was loaded under, if any
+	lease *Lease
+	// tokens holds, by backend index, the fill tokens handed out by
+	// LeaseBackend tiers on the miss; 0 means another caller holds the fill
+	tokens map[int]uint64
+}
+
+// setBackend stores value in the backend at index i. It returns
+// ErrCircuitOpen if the tier's circuit breaker is open.
+func (cm *CacheManager) setBackend(ctx context.Context, i int, key string, value any, ttl time.Duration, guard *writeGuard) error {
+	done, ok := cm.beginCall(i)
+	if !ok {
+		return ErrCircuitOpen
 	}
-	return nil, fmt.Errorf("key %s not found in any backend", key)
+	ctx, cancel := cm.writeContext(ctx, i)
+	defer cancel()
+	err := cm.writeBackend(ctx, i, key, value, ttl, guard)
+	done(err)
+	return err
 }
 
-// Set stores a value in all cache backends
-func (cm *CacheManager) Set(ctx context.Context, key string, value any) error {
+// writeBackend stores value in the backend at index i. A LeaseBackend tier
+// that handed out a fill token on the miss only accepts the write with that
+// token, and skips it if another caller holds the fill. Writes made under a
+// distributed lease go through SetFenced where supported, so they are
+// dropped if a newer lease has been granted since. Keys the tier cannot
+// lease are written with Set.
+func (cm *CacheManager) writeBackend(ctx context.Context, i int, key string, value any, ttl time.Duration, guard *writeGuard) error {
+	backend := cm.backends[i].Backend
+	if guard != nil {
+		if token, ok := guard.tokens[i]; ok {
+			leaseBackend := backend.(LeaseBackend)
+			if token == 0 {
+				return nil
+			}
+			_, err := leaseBackend.SetWithLease(ctx, key, value, ttl, token)
+			if !errors.Is(err, ErrLeaseNotSupported) {
+				return err
+			}
+		} else if fenced, ok := As[FencedBackend](backend); ok && guard.lease != nil {
+			_, err := fenced.SetFenced(ctx, key, value, ttl, guard.lease)
+			if !errors.Is(err, ErrLeaseNotSupported) {
+				return err
+			}
+		}
+	}
+	return backend.Set(ctx, key, value, ttl)
+}
+
+// Delete removes a value from all cache backends, one after another unless
+// WithParallelWrites is given
+func (cm *CacheManager) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	var options writeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if options.parallel {
+		return cm.fanOut(ctx, options.order, func(ctx context.Context, i int) error {
+			if err := cm.deleteBackend(ctx, i, key); err != nil {
+				return fmt.Errorf("error deleting from backend %d: %w", i, err)
+			}
+			return nil
+		})
+	}
+
+	var lastErr error
+	for i := range cm.backends {
+		if err := cm.deleteBackend(ctx, i, key); err != nil {
+			lastErr = fmt.Errorf("error deleting from backend %d: %w", i, err)
+		}
+	}
+
+	return lastErr
+}
+
+// deleteBackend removes key from the backend at index i. It returns
+// ErrCircuitOpen if the tier's circuit breaker is open.
+func (cm *CacheManager) deleteBackend(ctx context.Context, i int, key string) error {
+	done, ok := cm.beginCall(i)
+	if !ok {
+		return ErrCircuitOpen
+	}
+	ctx, cancel := cm.writeContext(ctx, i)
+	defer cancel()
+	err := cm.backends[i].Backend.Delete(ctx, key)
+	done(err)
+	return err
+}
+
+// SetWithTags stores a value in all cache backends and associates it with
+// tags. Backends that do not implement TagBackend store the value untagged;
+// InvalidateTags still removes it from them by key.
+func (cm *CacheManager) SetWithTags(ctx context.Context, key string, value any, tags ...string) error {
 	var lastErr error
 
 	for i, config := range cm.backends {
-		err := config.Backend.Set(ctx, key, value, config.TTL)
+		done, ok := cm.beginCall(i)
+		if !ok {
+			lastErr = fmt.Errorf("error setting in backend %d: %w", i, ErrCircuitOpen)
+			continue
+		}
+		setCtx, cancel := cm.writeContext(ctx, i)
+		var err error
+		if tagBackend, ok := As[TagBackend](config.Backend); ok {
+			err = tagBackend.SetWithTags(setCtx, key, value, config.TTL, tags)
+		} else {
+			err = config.Backend.Set(setCtx, key, value, config.TTL)
+		}
+		cancel()
+		done(err)
 		if err != nil {
 			lastErr = fmt.Errorf("error setting in backend %d: %w", i, err)
 		}
@@ -81,12 +575,53 @@ func (cm *CacheManager) Set(ctx context.Context, key string, value any) error {
 	return lastErr
 }
 
-// Delete removes a value from all cache backends
-func (cm *CacheManager) Delete(ctx context.Context, key string) error {
+// InvalidateTags removes every entry tagged with any of tags. Keys dropped by
+// a tag-aware backend are also deleted from every other backend, so copies
+// written without tags, such as backfilled entries, are removed too.
+func (cm *CacheManager) InvalidateTags(ctx context.Context, tags ...string) error {
 	var lastErr error
+	keySet := make(map[string]struct{})
+
+	for i, config := range cm.backends {
+		tagBackend, ok := As[TagBackend](config.Backend)
+		if !ok {
+			continue
+		}
+		done, ok := cm.beginCall(i)
+		if !ok {
+			lastErr = fmt.Errorf("error invalidating tags in backend %d: %w", i, ErrCircuitOpen)
+			continue
+		}
+		invalidateCtx, cancel := cm.writeContext(ctx, i)
+		keys, err := tagBackend.InvalidateTags(invalidateCtx, tags...)
+		cancel()
+		done(err)
+		if err != nil {
+			lastErr = fmt.Errorf("error invalidating tags in backend %d: %w", i, err)
+		}
+		for _, key := range keys {
+			keySet[key] = struct{}{}
+		}
+	}
 
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return lastErr
+	}
 	for i, config := range cm.backends {
-		if err := config.Backend.Delete(ctx, key); err != nil {
+		done, ok := cm.beginCall(i)
+		if !ok {
+			lastErr = fmt.Errorf("error deleting from backend %d: %w", i, ErrCircuitOpen)
+			continue
+		}
+		deleteCtx, cancel := cm.writeContext(ctx, i)
+		err := deleteKeys(deleteCtx, config.Backend, keys)
+		cancel()
+		done(err)
+		if err != nil {
 			lastErr = fmt.Errorf("error deleting from backend %d: %w", i, err)
 		}
 	}
@@ -94,11 +629,170 @@ func (cm *CacheManager) Delete(ctx context.Context, key string) error {
 	return lastErr
 }
 
-// populatePreviousBackends populates all backends before the hit index
-func (cm *CacheManager) populatePreviousBackends(ctx context.Context, key string, value any, hitIndex int) {
+// Clear removes every entry from all cache backends
+func (cm *CacheManager) Clear(ctx context.Context) error {
+	var lastErr error
+
+	for i, config := range cm.backends {
+		clearable, ok := As[ClearableBackend](config.Backend)
+		if !ok {
+			lastErr = fmt.Errorf("error clearing backend %d: %w", i, ErrClearNotSupported)
+			continue
+		}
+		done, ok := cm.beginCall(i)
+		if !ok {
+			lastErr = fmt.Errorf("error clearing backend %d: %w", i, ErrCircuitOpen)
+			continue
+		}
+		clearCtx, cancel := cm.writeContext(ctx, i)
+		err := clearable.Clear(clearCtx)
+		cancel()
+		done(err)
+		if err != nil {
+			lastErr = fmt.Errorf("error clearing backend %d: %w", i, err)
+		}
+	}
+
+	return lastErr
+}
+
+// DeletePrefix removes every entry whose key starts with prefix from all
+// cache backends. An empty prefix returns ErrEmptyPrefix.
+func (cm *CacheManager) DeletePrefix(ctx context.Context, prefix string) error {
+	if prefix == "" {
+		return ErrEmptyPrefix
+	}
+	var lastErr error
+
+	for i, config := range cm.backends {
+		clearable, ok := As[ClearableBackend](config.Backend)
+		if !ok {
+			lastErr = fmt.Errorf("error deleting prefix from backend %d: %w", i, ErrClearNotSupported)
+			continue
+		}
+		done, ok := cm.beginCall(i)
+		if !ok {
+			lastErr = fmt.Errorf("error deleting prefix from backend %d: %w", i, ErrCircuitOpen)
+			continue
+		}
+		deleteCtx, cancel := cm.writeContext(ctx, i)
+		err := clearable.DeletePrefix(deleteCtx, prefix)
+		cancel()
+		done(err)
+		if err != nil {
+			lastErr = fmt.Errorf("error deleting prefix from backend %d: %w", i, err)
+		}
+	}
+
+	return lastErr
+}
+
+// readContext returns ctx bounded by the ReadTimeout of the backend at index
+// i
+func (cm *CacheManager) readContext(ctx context.Context, i int) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, cm.backends[i].ReadTimeout)
+}
+
+// writeContext returns ctx bounded by the WriteTimeout of the backend at
+// index i
+func (cm *CacheManager) writeContext(ctx context.Context, i int) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, cm.backends[i].WriteTimeout)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// deleteKeys removes keys from backend, in one call if it is a BatchBackend
+func deleteKeys(ctx context.Context, backend CacheBackend, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if batchBackend, ok := As[BatchBackend](backend); ok {
+		return batchBackend.DeleteMany(ctx, keys)
+	}
+
+	var lastErr error
+	for _, key := range keys {
+		if err := backend.Delete(ctx, key); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Touch resets the TTL of key in every backend to the backend's configured
+// TTL. Backends that cannot touch entries have the value rewritten instead.
+func (cm *CacheManager) Touch(ctx context.Context, key string) error {
+	var lastErr error
+	touched := false
+
+	for i, config := range cm.backends {
+		done, ok := cm.beginCall(i)
+		if !ok {
+			continue
+		}
+		touchCtx, cancel := cm.writeContext(ctx, i)
+		found, err := cm.touchBackend(touchCtx, config, key)
+		cancel()
+		done(err)
+		if err != nil {
+			lastErr = fmt.Errorf("error touching in backend %d: %w", i, err)
+			continue
+		}
+		touched = touched || found
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	if !touched {
+		return fmt.Errorf("key %s not found in any backend", key)
+	}
+	return nil
+}
+
+func (cm *CacheManager) touchBackend(ctx context.Context, config CacheConfig, key string) (bool, error) {
+	if touchable, ok := As[TouchableBackend](config.Backend); ok {
+		return touchable.Touch(ctx, key, config.TTL)
+	}
+
+	value, found, err := config.Backend.Get(ctx, key)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, config.Backend.Set(ctx, key, value, config.TTL)
+}
+
+// populatePreviousBackends populates all backends before the hit index.
+// Tiers that handed out a fill token on the miss are written with it, so a
+// delete since the read is not undone by the backfill, and the tokens left
+// unused are released.
+func (cm *CacheManager) populatePreviousBackends(ctx context.Context, key string, value any, hitIndex int, tokens map[int]uint64) {
+	guard := &writeGuard{tokens: tokens}
+	defer cm.releaseFillLeases(ctx, key, guard)
 	for i := 0; i < hitIndex; i++ {
 		config := cm.backends[i]
-		_ = config.Backend.Set(ctx, key, value, config.TTL)
+		ttl := config.TTL
+		switch v := value.(type) {
+		case Tombstone:
+			if config.NegativeTTL == 0 {
+				continue
+			}
+			ttl = config.NegativeTTL
+		case EarlyRefreshEntry:
+			// Never outlive the copy the value came from
+			if remaining := time.Until(v.Expiry); !v.Expiry.IsZero() && (ttl <= 0 || remaining < ttl) {
+				if remaining <= 0 {
+					continue
+				}
+				ttl = remaining
+			}
+		}
+		_ = cm.setBackend(ctx, i, key, value, ttl, guard)
 	}
 }
 
@@ -106,17 +800,63 @@ func (cm *CacheManager) populatePreviousBackends(ctx context.Context, key string
 func (cm *CacheManager) handleInvalidation(ctx context.Context, invalidationChan <-chan string, sourceIndex int) {
 	for key := range invalidationChan {
 		// Delete from all other backends except the source
-		for i, config := range cm.backends {
+		for i := range cm.backends {
 			if i != sourceIndex {
 				// Use a new context for each delete operation
 				deleteCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-				_ = config.Backend.Delete(deleteCtx, key)
+				_ = cm.deleteBackend(deleteCtx, i, key)
 				cancel()
 			}
 		}
 	}
 }
 
+// handleInvalidationEvents applies invalidation events relayed by a backend
+// to every other backend
+func (cm *CacheManager) handleInvalidationEvents(ctx context.Context, events <-chan InvalidationEvent, sourceIndex int) {
+	for event := range events {
+		for i, config := range cm.backends {
+			if i == sourceIndex {
+				continue
+			}
+			done, ok := cm.beginCall(i)
+			if !ok {
+				continue
+			}
+			invalidateCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			done(applyInvalidation(invalidateCtx, config.Backend, event))
+			cancel()
+		}
+	}
+}
+
+// applyInvalidation removes the entries named by event from backend and
+// returns the last error
+func applyInvalidation(ctx context.Context, backend CacheBackend, event InvalidationEvent) error {
+	var lastErr error
+	if clearable, ok := As[ClearableBackend](backend); ok {
+		if event.All {
+			if err := clearable.Clear(ctx); err != nil {
+				lastErr = err
+			}
+		}
+		for _, prefix := range event.Prefixes {
+			if err := clearable.DeletePrefix(ctx, prefix); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	if tagBackend, ok := As[TagBackend](backend); ok && len(event.Tags) > 0 {
+		if _, err := tagBackend.InvalidateTags(ctx, event.Tags...); err != nil {
+			lastErr = err
+		}
+	}
+	if err := deleteKeys(ctx, backend, event.Keys); err != nil {
+		lastErr = err
+	}
+	return lastErr
+}
+
 // Close closes all cache backends
 func (cm *CacheManager) Close() error {
 	var lastErr error
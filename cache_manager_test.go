@@ -2,6 +2,11 @@ package cachemanager
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -153,3 +158,332 @@ func TestCacheManager_Delete(t *testing.T) {
 	require.NoError(t, err)
 	assert.False(t, exists2)
 }
+
+type touchableMockBackend struct {
+	*mockBackend
+	touched map[string]time.Duration
+}
+
+func (m *touchableMockBackend) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if _, exists := m.data[key]; !exists {
+		return false, nil
+	}
+	m.touched[key] = ttl
+	return true, nil
+}
+
+func TestCacheManager_Touch(t *testing.T) {
+	ctx := context.Background()
+	backend1 := &touchableMockBackend{mockBackend: newMockBackend(), touched: make(map[string]time.Duration)}
+	backend2 := newMockBackend()
+
+	backend1.data["test"] = "value"
+	backend2.data["test"] = "value"
+
+	cm := NewCacheManager(
+		CacheConfig{Backend: backend1, TTL: time.Minute},
+		CacheConfig{Backend: backend2, TTL: time.Hour},
+	)
+
+	err := cm.Touch(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, backend1.touched["test"])
+	assert.Equal(t, "value", backend2.data["test"])
+
+	err = cm.Touch(ctx, "missing")
+	assert.Error(t, err)
+}
+
+type tagMockBackend struct {
+	*mockBackend
+	tags map[string][]string
+}
+
+func newTagMockBackend() *tagMockBackend {
+	return &tagMockBackend{mockBackend: newMockBackend(), tags: make(map[string][]string)}
+}
+
+func (m *tagMockBackend) SetWithTags(ctx context.Context, key string, value any, ttl time.Duration, tags []string) error {
+	m.data[key] = value
+	for _, tag := range tags {
+		m.tags[tag] = append(m.tags[tag], key)
+	}
+	return nil
+}
+
+func (m *tagMockBackend) InvalidateTags(ctx context.Context, tags ...string) ([]string, error) {
+	var removed []string
+	for _, tag := range tags {
+		for _, key := range m.tags[tag] {
+			if _, exists := m.data[key]; exists {
+				delete(m.data, key)
+				removed = append(removed, key)
+			}
+		}
+		delete(m.tags, tag)
+	}
+	return removed, nil
+}
+
+func TestCacheManager_InvalidateTags(t *testing.T) {
+	ctx := context.Background()
+	backend1 := newMockBackend()
+	backend2 := newTagMockBackend()
+
+	cm := NewCacheManager(
+		CacheConfig{Backend: backend1, TTL: time.Minute},
+		CacheConfig{Backend: backend2, TTL: time.Hour},
+	)
+
+	require.NoError(t, cm.SetWithTags(ctx, "profile:42", "alice", "user:42"))
+	require.NoError(t, cm.SetWithTags(ctx, "profile:7", "bob", "user:7"))
+	assert.Equal(t, "alice", backend1.data["profile:42"])
+	assert.Equal(t, []string{"profile:42"}, backend2.tags["user:42"])
+
+	require.NoError(t, cm.InvalidateTags(ctx, "user:42"))
+	assert.NotContains(t, backend1.data, "profile:42")
+	assert.NotContains(t, backend2.data, "profile:42")
+	assert.Contains(t, backend1.data, "profile:7")
+	assert.Contains(t, backend2.data, "profile:7")
+}
+
+type eventMockBackend struct {
+	*mockBackend
+	events chan InvalidationEvent
+}
+
+func (m *eventMockBackend) GetInvalidationEvents() <-chan InvalidationEvent {
+	return m.events
+}
+
+func TestCacheManager_HandleInvalidationEvents(t *testing.T) {
+	local := newTagMockBackend()
+	require.NoError(t, local.SetWithTags(context.Background(), "profile:42", "alice", time.Minute, []string{"user:42"}))
+	local.data["orders:42"] = "backfilled"
+
+	source := &eventMockBackend{mockBackend: newMockBackend(), events: make(chan InvalidationEvent)}
+	NewCacheManager(
+		CacheConfig{Backend: local, TTL: time.Minute},
+		CacheConfig{Backend: source, TTL: time.Hour},
+	)
+
+	source.events <- InvalidationEvent{Keys: []string{"orders:42"}, Tags: []string{"user:42"}}
+	// The handler only receives the second event once it has applied the first
+	source.events <- InvalidationEvent{}
+	close(source.events)
+
+	assert.Empty(t, local.data)
+	assert.Empty(t, local.tags)
+}
+
+type clearableMockBackend struct {
+	*mockBackend
+}
+
+func (m *clearableMockBackend) Clear(ctx context.Context) error {
+	m.data = make(map[string]interface{})
+	return nil
+}
+
+func (m *clearableMockBackend) DeletePrefix(ctx context.Context, prefix string) error {
+	for key := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.data, key)
+		}
+	}
+	return nil
+}
+
+func TestCacheManager_DeletePrefixAndClear(t *testing.T) {
+	ctx := context.Background()
+	backend1 := &clearableMockBackend{newMockBackend()}
+	backend2 := &clearableMockBackend{newMockBackend()}
+
+	cm := NewCacheManager(
+		CacheConfig{Backend: backend1, TTL: time.Minute},
+		CacheConfig{Backend: backend2, TTL: time.Hour},
+	)
+	require.NoError(t, cm.Set(ctx, "user:1", "alice"))
+	require.NoError(t, cm.Set(ctx, "order:1", "book"))
+
+	require.NoError(t, cm.DeletePrefix(ctx, "user:"))
+	assert.Equal(t, map[string]interface{}{"order:1": "book"}, backend1.data)
+	assert.Equal(t, map[string]interface{}{"order:1": "book"}, backend2.data)
+	assert.ErrorIs(t, cm.DeletePrefix(ctx, ""), ErrEmptyPrefix)
+	assert.Equal(t, map[string]interface{}{"order:1": "book"}, backend2.data)
+
+	require.NoError(t, cm.Clear(ctx))
+	assert.Empty(t, backend1.data)
+	assert.Empty(t, backend2.data)
+
+	unsupported := NewCacheManager(
+		CacheConfig{Backend: backend1, TTL: time.Minute},
+		CacheConfig{Backend: newMockBackend(), TTL: time.Hour},
+	)
+	assert.ErrorIs(t, unsupported.Clear(ctx), ErrClearNotSupported)
+	assert.ErrorIs(t, unsupported.DeletePrefix(ctx, "user:"), ErrClearNotSupported)
+}
+
+func TestCacheManager_HandleClearEvents(t *testing.T) {
+	local := &clearableMockBackend{newMockBackend()}
+	local.data["user:1"] = "alice"
+	local.data["order:1"] = "book"
+
+	source := &eventMockBackend{mockBackend: newMockBackend(), events: make(chan InvalidationEvent)}
+	NewCacheManager(
+		CacheConfig{Backend: local, TTL: time.Minute},
+		CacheConfig{Backend: source, TTL: time.Hour},
+	)
+
+	source.events <- InvalidationEvent{Prefixes: []string{"user:"}}
+	source.events <- InvalidationEvent{}
+	assert.Equal(t, map[string]interface{}{"order:1": "book"}, local.data)
+
+	source.events <- InvalidationEvent{All: true}
+	source.events <- InvalidationEvent{}
+	close(source.events)
+	assert.Empty(t, local.data)
+}
+
+// lockedMockBackend is a mockBackend safe for concurrent use
+type lockedMockBackend struct {
+	mu   sync.Mutex
+	data map[string]interface{}
+	ttls map[string]time.Duration
+}
+
+func newLockedMockBackend() *lockedMockBackend {
+	return &lockedMockBackend{data: make(map[string]interface{}), ttls: make(map[string]time.Duration)}
+}
+
+func (m *lockedMockBackend) Get(ctx context.Context, key string) (interface{}, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, exists := m.data[key]
+	return value, exists, nil
+}
+
+func (m *lockedMockBackend) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	m.ttls[key] = ttl
+	return nil
+}
+
+func (m *lockedMockBackend) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *lockedMockBackend) Close() error {
+	return nil
+}
+
+func (m *lockedMockBackend) entry(key string) (interface{}, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, exists := m.data[key]
+	return value, m.ttls[key], exists
+}
+
+func TestCacheManager_GetOrLoad(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("loads once and caches the value", func(t *testing.T) {
+		backend := newLockedMockBackend()
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		var loads atomic.Int32
+		release := make(chan struct{})
+		load := func(context.Context) (any, error) {
+			loads.Add(1)
+			<-release
+			return "value", nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := cm.GetOrLoad(ctx, "key", load)
+				assert.NoError(t, err)
+				assert.Equal(t, "value", value)
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), loads.Load())
+		value, ttl, _ := backend.entry("key")
+		assert.Equal(t, "value", value)
+		assert.Equal(t, time.Minute, ttl)
+	})
+
+	t.Run("caches misses with the negative TTL", func(t *testing.T) {
+		backend1 := newLockedMockBackend()
+		backend2 := newLockedMockBackend()
+		cm := NewCacheManager(
+			CacheConfig{Backend: backend1, TTL: time.Minute},
+			CacheConfig{Backend: backend2, TTL: time.Hour, NegativeTTL: 30 * time.Second},
+		)
+
+		loads := 0
+		load := func(context.Context) (any, error) {
+			loads++
+			return nil, fmt.Errorf("no such user: %w", ErrNotFound)
+		}
+
+		_, err := cm.GetOrLoad(ctx, "user:404", load)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = cm.GetOrLoad(ctx, "user:404", load)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, 1, loads)
+
+		_, err = cm.Get(ctx, "user:404")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, _, exists := backend1.entry("user:404")
+		assert.False(t, exists, "tiers without a negative TTL do not cache misses")
+		value, ttl, _ := backend2.entry("user:404")
+		assert.True(t, IsTombstone(value))
+		assert.Equal(t, 30*time.Second, ttl)
+	})
+
+	t.Run("does not cache loader errors", func(t *testing.T) {
+		backend := newLockedMockBackend()
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute, NegativeTTL: time.Second})
+
+		failure := errors.New("database down")
+		_, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return nil, failure
+		})
+		assert.ErrorIs(t, err, failure)
+		assert.NotErrorIs(t, err, ErrNotFound)
+		_, _, exists := backend.entry("key")
+		assert.False(t, exists)
+	})
+}
+
+func TestCacheManager_BackfillsTombstonesWithNegativeTTL(t *testing.T) {
+	ctx := context.Background()
+	backend1 := newLockedMockBackend()
+	backend2 := newLockedMockBackend()
+	backend2.data["user:404"] = Tombstone{}
+
+	cm := NewCacheManager(
+		CacheConfig{Backend: backend1, TTL: time.Minute, NegativeTTL: time.Second},
+		CacheConfig{Backend: backend2, TTL: time.Hour, NegativeTTL: time.Minute},
+	)
+
+	_, err := cm.Get(ctx, "user:404")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Eventually(t, func() bool {
+		value, ttl, _ := backend1.entry("user:404")
+		return IsTombstone(value) && ttl == time.Second
+	}, time.Second, 10*time.Millisecond)
+}
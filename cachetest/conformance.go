@@ -0,0 +1,155 @@
+// Package cachetest provides a conformance suite that every
+// cachemanager.CacheBackend implementation is expected to pass.
+package cachetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Harness describes how to drive a backend under test.
+type Harness struct {
+	// New returns a fresh, empty backend configured with defaultTTL as its
+	// tier default. The suite closes the backend when the test ends.
+	New func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend
+
+	// Advance moves the backend's clock forward by d. Backends that use the
+	// wall clock can simply sleep.
+	Advance func(d time.Duration)
+}
+
+// ttlUnit is the shortest TTL used by the suite. It is long enough for the
+// entry to still be readable right after Set, and short enough to keep the
+// suite fast for backends that advance time by sleeping.
+const ttlUnit = 50 * time.Millisecond
+
+// Run runs the conformance suite against the backend described by h.
+func Run(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	newBackend := func(t *testing.T, defaultTTL time.Duration) cachemanager.CacheBackend {
+		backend := h.New(t, defaultTTL)
+		t.Cleanup(func() { _ = backend.Close() })
+		return backend
+	}
+
+	assertFound := func(t *testing.T, backend cachemanager.CacheBackend, key string, expected any) {
+		t.Helper()
+		value, exists, err := backend.Get(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, exists, "expected %q to be cached", key)
+		assert.Equal(t, expected, value)
+	}
+
+	assertMissing := func(t *testing.T, backend cachemanager.CacheBackend, key string) {
+		t.Helper()
+		value, exists, err := backend.Get(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, exists, "expected %q to be missing", key)
+		assert.Nil(t, value)
+	}
+
+	t.Run("set and get", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		require.NoError(t, backend.Set(ctx, "key", "value", time.Minute))
+		assertFound(t, backend, "key", "value")
+	})
+
+	t.Run("get missing key", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		assertMissing(t, backend, "missing")
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		require.NoError(t, backend.Set(ctx, "key", "old", time.Minute))
+		require.NoError(t, backend.Set(ctx, "key", "new", time.Minute))
+		assertFound(t, backend, "key", "new")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		require.NoError(t, backend.Set(ctx, "key", "value", time.Minute))
+		require.NoError(t, backend.Delete(ctx, "key"))
+		assertMissing(t, backend, "key")
+	})
+
+	t.Run("delete missing key", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		require.NoError(t, backend.Delete(ctx, "missing"))
+	})
+
+	t.Run("positive TTL expires", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		require.NoError(t, backend.Set(ctx, "key", "value", ttlUnit))
+		assertFound(t, backend, "key", "value")
+
+		h.Advance(2 * ttlUnit)
+		assertMissing(t, backend, "key")
+	})
+
+	t.Run("NoExpiration never expires", func(t *testing.T) {
+		backend := newBackend(t, ttlUnit)
+		require.NoError(t, backend.Set(ctx, "key", "value", cachemanager.NoExpiration))
+		assertFound(t, backend, "key", "value")
+
+		h.Advance(2 * ttlUnit)
+		assertFound(t, backend, "key", "value")
+	})
+
+	t.Run("DefaultTTL uses tier default", func(t *testing.T) {
+		backend := newBackend(t, ttlUnit)
+		require.NoError(t, backend.Set(ctx, "key", "value", cachemanager.DefaultTTL))
+		assertFound(t, backend, "key", "value")
+
+		h.Advance(2 * ttlUnit)
+		assertMissing(t, backend, "key")
+	})
+
+	t.Run("DefaultTTL without tier default never expires", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		require.NoError(t, backend.Set(ctx, "key", "value", cachemanager.DefaultTTL))
+
+		h.Advance(2 * ttlUnit)
+		assertFound(t, backend, "key", "value")
+	})
+
+	t.Run("negative TTL rejected", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		err := backend.Set(ctx, "key", "value", -time.Second)
+		assert.ErrorIs(t, err, cachemanager.ErrInvalidTTL)
+		assertMissing(t, backend, "key")
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		backend := newBackend(t, cachemanager.NoExpiration)
+		touchable, ok := cachemanager.As[cachemanager.TouchableBackend](backend)
+		if !ok {
+			t.Skip("backend does not support TouchableBackend")
+		}
+
+		require.NoError(t, backend.Set(ctx, "key", "value", ttlUnit))
+		found, err := touchable.Touch(ctx, "key", cachemanager.NoExpiration)
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		h.Advance(2 * ttlUnit)
+		assertFound(t, backend, "key", "value")
+
+		found, err = touchable.Touch(ctx, "key", ttlUnit)
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		h.Advance(2 * ttlUnit)
+		assertMissing(t, backend, "key")
+
+		found, err = touchable.Touch(ctx, "missing", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+}
@@ -0,0 +1,82 @@
+package cachetest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// PersistentHarness describes how to drive a backend stored in a file.
+type PersistentHarness struct {
+	// Open opens the backend stored in the file at path, creating the file
+	// if it does not exist. Values must be encoded with codec.JSON, so that
+	// maps come back as map[string]any. The suite closes the backend.
+	Open func(t *testing.T, path string) cachemanager.CacheBackend
+}
+
+// RunPersistent runs the checks shared by backends stored in a file: entries
+// outlive the process, and prefix deletion and clearing reach every entry.
+func RunPersistent(t *testing.T, h PersistentHarness) {
+	ctx := context.Background()
+
+	// found returns the entries among keys that backend holds
+	found := func(t *testing.T, backend cachemanager.CacheBackend, keys ...string) map[string]any {
+		t.Helper()
+		values := make(map[string]any)
+		for _, key := range keys {
+			value, exists, err := backend.Get(ctx, key)
+			require.NoError(t, err)
+			if exists {
+				values[key] = value
+			}
+		}
+		return values
+	}
+
+	t.Run("entries survive a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache")
+		backend := h.Open(t, path)
+		require.NoError(t, backend.Set(ctx, "user", map[string]any{"name": "ada"}, time.Hour))
+		require.NoError(t, backend.Set(ctx, "raw", []byte("payload"), cachemanager.NoExpiration))
+		require.NoError(t, backend.Set(ctx, "missing", cachemanager.Tombstone{}, time.Hour))
+		require.NoError(t, backend.Set(ctx, "short", "value", ttlUnit))
+		require.NoError(t, backend.Close())
+
+		time.Sleep(2 * ttlUnit)
+		backend = h.Open(t, path)
+		defer backend.Close()
+
+		assert.Equal(t, map[string]any{
+			"user":    map[string]any{"name": "ada"},
+			"raw":     []byte("payload"),
+			"missing": cachemanager.Tombstone{},
+		}, found(t, backend, "user", "raw", "missing", "short"))
+	})
+
+	t.Run("delete prefix and clear", func(t *testing.T) {
+		backend := h.Open(t, filepath.Join(t.TempDir(), "cache"))
+		defer backend.Close()
+		clearable, ok := cachemanager.As[cachemanager.ClearableBackend](backend)
+		if !ok {
+			t.Skip("backend does not support ClearableBackend")
+		}
+		for key, value := range map[string]string{
+			"user:1": "a", "user:2": "b", "users": "c", "order:1": "d", "\xff\xff": "e",
+		} {
+			require.NoError(t, backend.Set(ctx, key, value, time.Hour))
+		}
+
+		require.NoError(t, clearable.DeletePrefix(ctx, "user:"))
+		require.NoError(t, clearable.DeletePrefix(ctx, "\xff"))
+		assert.Equal(t, map[string]any{"users": "c", "order:1": "d"},
+			found(t, backend, "user:1", "user:2", "users", "order:1", "\xff\xff"))
+
+		require.NoError(t, clearable.Clear(ctx))
+		assert.Empty(t, found(t, backend, "users", "order:1"))
+	})
+}
@@ -0,0 +1,36 @@
+package cachemanager
+
+import "reflect"
+
+// CapabilityBackend is implemented by backends wrapping other backends, such
+// as namespaced or sharded caches. They have the methods of every optional
+// interface but can only serve those the wrapped backends do.
+type CapabilityBackend interface {
+	CacheBackend
+	// Supports reports whether the methods of the optional interface
+	// capability, such as reflect.TypeFor[CounterBackend](), can be used.
+	Supports(capability reflect.Type) bool
+}
+
+// Supports reports whether backend serves the optional interface
+// capability: it implements it and, if it is a CapabilityBackend, supports
+// it. Wrappers answer Supports by asking it of the backends they wrap.
+func Supports(backend CacheBackend, capability reflect.Type) bool {
+	if backend == nil || !reflect.TypeOf(backend).Implements(capability) {
+		return false
+	}
+	if wrapper, ok := backend.(CapabilityBackend); ok {
+		return wrapper.Supports(capability)
+	}
+	return true
+}
+
+// As returns backend as the optional interface T if it serves it. Use it
+// instead of a type assertion, which wrappers always satisfy.
+func As[T any](backend CacheBackend) (T, bool) {
+	if !Supports(backend, reflect.TypeFor[T]()) {
+		var zero T
+		return zero, false
+	}
+	return backend.(T), true
+}
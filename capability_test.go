@@ -0,0 +1,46 @@
+package cachemanager
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wrapperMockBackend has a counter's methods but only serves them when the
+// backend it stands for can count
+type wrapperMockBackend struct {
+	*counterMockBackend
+	counts bool
+}
+
+func (m *wrapperMockBackend) Supports(capability reflect.Type) bool {
+	return m.counts || capability != reflect.TypeFor[CounterBackend]()
+}
+
+func TestAs(t *testing.T) {
+	ctx := context.Background()
+
+	_, ok := As[CounterBackend](newMockBackend())
+	assert.False(t, ok)
+	_, ok = As[CounterBackend](&counterMockBackend{newLockedMockBackend()})
+	assert.True(t, ok)
+	_, ok = As[CounterBackend](&wrapperMockBackend{counterMockBackend: &counterMockBackend{newLockedMockBackend()}})
+	assert.False(t, ok)
+	assert.False(t, Supports(nil, reflect.TypeFor[CacheBackend]()))
+
+	// Increment skips the wrapper that cannot count for the tier above it
+	upper := &counterMockBackend{newLockedMockBackend()}
+	cm := NewCacheManager(
+		CacheConfig{Backend: upper, TTL: time.Minute},
+		CacheConfig{Backend: &wrapperMockBackend{counterMockBackend: &counterMockBackend{newLockedMockBackend()}}, TTL: time.Hour},
+	)
+	value, err := cm.Increment(ctx, "views", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), value)
+	stored, _, _ := upper.entry("views")
+	assert.Equal(t, int64(2), stored)
+}
@@ -0,0 +1,61 @@
+// Package codec converts cached values to and from bytes for backends that
+// store serialized data.
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+// ErrEncode is wrapped by the errors backends return for values they cannot
+// serialize. It is a mistake of the caller, not a failure of the backend.
+var ErrEncode = errors.New("failed to encode value")
+
+// Codec serializes cache values.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte) (any, error)
+}
+
+// Gob encodes values with encoding/gob. The dynamic type of the value is
+// preserved, so custom types must be registered with gob.Register before use.
+type Gob struct{}
+
+// gobValue wraps the value so gob records its concrete type.
+type gobValue struct {
+	V any
+}
+
+func (Gob) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gobValue{V: v}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (Gob) Unmarshal(data []byte) (any, error) {
+	var value gobValue
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value.V, nil
+}
+
+// JSON encodes values with encoding/json. Values are decoded into the generic
+// JSON types: map[string]any, []any, string, float64, bool and nil.
+type JSON struct{}
+
+func (JSON) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSON) Unmarshal(data []byte) (any, error) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
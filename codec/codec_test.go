@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type profile struct {
+	Name string
+	Age  int
+}
+
+func init() {
+	gob.Register(profile{})
+}
+
+func TestGob(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+	}{
+		{name: "string", value: "hello"},
+		{name: "int", value: 42},
+		{name: "bytes", value: []byte("raw")},
+		{name: "registered struct", value: profile{Name: "ethan", Age: 30}},
+		{name: "nil", value: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Gob{}.Marshal(tt.value)
+			require.NoError(t, err)
+
+			value, err := Gob{}.Unmarshal(data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := JSON{}.Marshal(profile{Name: "ethan", Age: 30})
+	require.NoError(t, err)
+
+	value, err := JSON{}.Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"Name": "ethan", "Age": float64(30)}, value)
+
+	_, err = JSON{}.Unmarshal([]byte("{"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrConditionalNotSupported is returned by the conditional write methods
+// when no backend implements ConditionalBackend.
+var ErrConditionalNotSupported = errors.New("no backend supports conditional writes")
+
+// ConditionalBackend is implemented by backends that version their entries
+// and write them conditionally. Versions are opaque: they only compare
+// equal as long as the entry has not been rewritten.
+type ConditionalBackend interface {
+	CacheBackend
+	// SetNX stores value only if key is missing and reports whether it did.
+	SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error)
+	// CompareAndSwap stores value only if key still has expectedVersion and
+	// reports whether it did.
+	CompareAndSwap(ctx context.Context, key string, expectedVersion uint64, value any, ttl time.Duration) (bool, error)
+	// GetWithVersion returns the value of key with its current version.
+	GetWithVersion(ctx context.Context, key string) (value any, version uint64, found bool, err error)
+}
+
+// SetIfAbsent stores value in the authoritative tier, the last backend
+// implementing ConditionalBackend, only if key is missing there. It reports
+// whether value was stored, in which case the key is deleted from the tiers
+// above so they read it through.
+func (cm *CacheManager) SetIfAbsent(ctx context.Context, key string, value any) (bool, error) {
+	tier, backend, ok := authoritativeTier[ConditionalBackend](cm.backends)
+	if !ok {
+		return false, ErrConditionalNotSupported
+	}
+
+	done, ok := cm.beginCall(tier)
+	if !ok {
+		return false, fmt.Errorf("error setting in backend %d: %w", tier, ErrCircuitOpen)
+	}
+	setCtx, cancel := cm.writeContext(ctx, tier)
+	stored, err := backend.SetNX(setCtx, key, value, cm.backends[tier].TTL)
+	cancel()
+	done(err)
+	if err != nil {
+		return false, fmt.Errorf("error setting in backend %d: %w", tier, err)
+	}
+	if !stored {
+		return false, nil
+	}
+	return true, cm.deleteAbove(ctx, tier, key)
+}
+
+// GetWithVersion reads key from the authoritative tier, bypassing the tiers
+// above it, together with the version to pass to CompareAndSwap.
+func (cm *CacheManager) GetWithVersion(ctx context.Context, key string) (any, uint64, error) {
+	tier, backend, ok := authoritativeTier[ConditionalBackend](cm.backends)
+	if !ok {
+		return nil, 0, ErrConditionalNotSupported
+	}
+
+	done, ok := cm.beginCall(tier)
+	if !ok {
+		return nil, 0, fmt.Errorf("error getting from backend %d: %w", tier, ErrCircuitOpen)
+	}
+	getCtx, cancel := cm.readContext(ctx, tier)
+	defer cancel()
+	value, version, found, err := backend.GetWithVersion(getCtx, key)
+	done(err)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting from backend %d: %w", tier, err)
+	}
+	if !found {
+		return nil, 0, fmt.Errorf("key %s not found in any backend", key)
+	}
+	if IsTombstone(value) {
+		return nil, 0, fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+	return unwrapValue(value), version, nil
+}
+
+// CompareAndSwap stores value in the authoritative tier only if key still
+// has the version returned by GetWithVersion. It reports whether value was
+// stored, in which case the key is deleted from the tiers above.
+func (cm *CacheManager) CompareAndSwap(ctx context.Context, key string, expectedVersion uint64, value any) (bool, error) {
+	tier, backend, ok := authoritativeTier[ConditionalBackend](cm.backends)
+	if !ok {
+		return false, ErrConditionalNotSupported
+	}
+
+	done, ok := cm.beginCall(tier)
+	if !ok {
+		return false, fmt.Errorf("error setting in backend %d: %w", tier, ErrCircuitOpen)
+	}
+	setCtx, cancel := cm.writeContext(ctx, tier)
+	stored, err := backend.CompareAndSwap(setCtx, key, expectedVersion, value, cm.backends[tier].TTL)
+	cancel()
+	done(err)
+	if err != nil {
+		return false, fmt.Errorf("error setting in backend %d: %w", tier, err)
+	}
+	if !stored {
+		return false, nil
+	}
+	return true, cm.deleteAbove(ctx, tier, key)
+}
+
+// authoritativeTier returns the last backend implementing T, the one that
+// holds the source of truth for operations the other tiers cannot perform.
+func authoritativeTier[T CacheBackend](backends []CacheConfig) (int, T, bool) {
+	for i := len(backends) - 1; i >= 0; i-- {
+		if backend, ok := As[T](backends[i].Backend); ok {
+			return i, backend, true
+		}
+	}
+	var zero T
+	return -1, zero, false
+}
+
+// deleteAbove removes key from the backends before tier, so they cannot
+// serve a value written to tier alone
+func (cm *CacheManager) deleteAbove(ctx context.Context, tier int, key string) error {
+	var lastErr error
+	for i := range cm.backends[:tier] {
+		if err := cm.deleteBackend(ctx, i, key); err != nil {
+			lastErr = fmt.Errorf("error deleting from backend %d: %w", i, err)
+		}
+	}
+	return lastErr
+}
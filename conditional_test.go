@@ -0,0 +1,129 @@
+package cachemanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conditionalMockBackend versions its entries with a shared counter
+type conditionalMockBackend struct {
+	*lockedMockBackend
+	versions map[string]uint64
+	last     uint64
+}
+
+func newConditionalMockBackend() *conditionalMockBackend {
+	return &conditionalMockBackend{lockedMockBackend: newLockedMockBackend(), versions: make(map[string]uint64)}
+}
+
+func (m *conditionalMockBackend) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.data[key]; exists {
+		return false, nil
+	}
+	m.store(key, value, ttl)
+	return true, nil
+}
+
+func (m *conditionalMockBackend) CompareAndSwap(ctx context.Context, key string, expectedVersion uint64, value any, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.data[key]; !exists || m.versions[key] != expectedVersion {
+		return false, nil
+	}
+	m.store(key, value, ttl)
+	return true, nil
+}
+
+func (m *conditionalMockBackend) GetWithVersion(ctx context.Context, key string) (any, uint64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, exists := m.data[key]
+	return value, m.versions[key], exists, nil
+}
+
+func (m *conditionalMockBackend) store(key string, value any, ttl time.Duration) {
+	m.last++
+	m.data[key] = value
+	m.ttls[key] = ttl
+	m.versions[key] = m.last
+}
+
+func TestCacheManager_ConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("set if absent only stores once", func(t *testing.T) {
+		upper := newLockedMockBackend()
+		authoritative := newConditionalMockBackend()
+		cm := NewCacheManager(
+			CacheConfig{Backend: upper, TTL: time.Minute},
+			CacheConfig{Backend: authoritative, TTL: time.Hour},
+		)
+		// A cached miss in the upper tier must not hide the new key
+		require.NoError(t, upper.Set(ctx, "request:1", Tombstone{}, time.Minute))
+
+		stored, err := cm.SetIfAbsent(ctx, "request:1", "first")
+		require.NoError(t, err)
+		assert.True(t, stored)
+		stored, err = cm.SetIfAbsent(ctx, "request:1", "second")
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		_, _, exists := upper.entry("request:1")
+		assert.False(t, exists)
+		value, ttl, _ := authoritative.entry("request:1")
+		assert.Equal(t, "first", value)
+		assert.Equal(t, time.Hour, ttl)
+
+		value, err = cm.Get(ctx, "request:1")
+		require.NoError(t, err)
+		assert.Equal(t, "first", value)
+	})
+
+	t.Run("compare and swap rejects a stale version", func(t *testing.T) {
+		upper := newLockedMockBackend()
+		authoritative := newConditionalMockBackend()
+		cm := NewCacheManager(
+			CacheConfig{Backend: upper, TTL: time.Minute},
+			CacheConfig{Backend: authoritative, TTL: time.Hour},
+		)
+		_, err := cm.SetIfAbsent(ctx, "cart", "a")
+		require.NoError(t, err)
+
+		value, version, err := cm.GetWithVersion(ctx, "cart")
+		require.NoError(t, err)
+		assert.Equal(t, "a", value)
+
+		require.NoError(t, upper.Set(ctx, "cart", "a", time.Minute))
+		stored, err := cm.CompareAndSwap(ctx, "cart", version, "ab")
+		require.NoError(t, err)
+		assert.True(t, stored)
+		_, _, exists := upper.entry("cart")
+		assert.False(t, exists)
+
+		// A writer that read before the swap loses
+		stored, err = cm.CompareAndSwap(ctx, "cart", version, "ac")
+		require.NoError(t, err)
+		assert.False(t, stored)
+
+		value, _, err = cm.GetWithVersion(ctx, "cart")
+		require.NoError(t, err)
+		assert.Equal(t, "ab", value)
+	})
+
+	t.Run("fails without a conditional tier", func(t *testing.T) {
+		cm := NewCacheManager(CacheConfig{Backend: newMockBackend()})
+
+		_, err := cm.SetIfAbsent(ctx, "key", "value")
+		assert.ErrorIs(t, err, ErrConditionalNotSupported)
+		_, _, err = cm.GetWithVersion(ctx, "key")
+		assert.ErrorIs(t, err, ErrConditionalNotSupported)
+		_, err = cm.CompareAndSwap(ctx, "key", 1, "value")
+		assert.ErrorIs(t, err, ErrConditionalNotSupported)
+	})
+}
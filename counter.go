@@ -0,0 +1,57 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCounterNotSupported is returned by Increment and Decrement when no
+// backend implements CounterBackend.
+var ErrCounterNotSupported = errors.New("no backend supports counters")
+
+// ErrNotCounter is returned by CounterBackend implementations when the key
+// holds a value that is not a counter.
+var ErrNotCounter = errors.New("value is not a counter")
+
+// CounterBackend is implemented by backends that update integer counters
+// atomically.
+type CounterBackend interface {
+	CacheBackend
+	// Incr adds delta to the counter at key and returns the new value. A
+	// missing counter starts from 0 and expires after ttl; incrementing an
+	// existing counter keeps its expiration.
+	Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// Increment atomically adds delta to the counter at key and returns the new
+// value. The counter lives in the authoritative tier, the last backend
+// implementing CounterBackend, using that tier's TTL when it is created;
+// the key is deleted from the tiers above it so they cannot serve a stale
+// count.
+func (cm *CacheManager) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	tier, backend, ok := authoritativeTier[CounterBackend](cm.backends)
+	if !ok {
+		return 0, ErrCounterNotSupported
+	}
+
+	done, ok := cm.beginCall(tier)
+	if !ok {
+		return 0, fmt.Errorf("error incrementing in backend %d: %w", tier, ErrCircuitOpen)
+	}
+	incrCtx, cancel := cm.writeContext(ctx, tier)
+	value, err := backend.Incr(incrCtx, key, delta, cm.backends[tier].TTL)
+	cancel()
+	done(err)
+	if err != nil {
+		return 0, fmt.Errorf("error incrementing in backend %d: %w", tier, err)
+	}
+	return value, cm.deleteAbove(ctx, tier, key)
+}
+
+// Decrement atomically subtracts delta from the counter at key and returns
+// the new value. See Increment.
+func (cm *CacheManager) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	return cm.Increment(ctx, key, -delta)
+}
@@ -0,0 +1,78 @@
+package cachemanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counterMockBackend keeps int64 counters and records the TTLs they were
+// created with
+type counterMockBackend struct {
+	*lockedMockBackend
+}
+
+func (m *counterMockBackend) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, exists := m.data[key]
+	if !exists {
+		m.ttls[key] = ttl
+		current = int64(0)
+	}
+	count, ok := current.(int64)
+	if !ok {
+		return 0, ErrNotCounter
+	}
+	m.data[key] = count + delta
+	return count + delta, nil
+}
+
+func TestCacheManager_Increment(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("counts in the authoritative tier and invalidates the others", func(t *testing.T) {
+		upper := &counterMockBackend{newLockedMockBackend()}
+		authoritative := &counterMockBackend{newLockedMockBackend()}
+		cm := NewCacheManager(
+			CacheConfig{Backend: upper, TTL: time.Minute},
+			CacheConfig{Backend: authoritative, TTL: time.Hour},
+		)
+
+		value, err := cm.Increment(ctx, "views", 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), value)
+
+		// A backfilled copy in the upper tier must not survive the next update
+		require.NoError(t, upper.Set(ctx, "views", int64(2), time.Minute))
+		value, err = cm.Decrement(ctx, "views", 5)
+		require.NoError(t, err)
+		assert.Equal(t, int64(-3), value)
+
+		_, _, exists := upper.entry("views")
+		assert.False(t, exists)
+		count, ttl, exists := authoritative.entry("views")
+		assert.True(t, exists)
+		assert.Equal(t, int64(-3), count)
+		assert.Equal(t, time.Hour, ttl)
+	})
+
+	t.Run("fails without a counter tier", func(t *testing.T) {
+		cm := NewCacheManager(CacheConfig{Backend: newMockBackend()})
+
+		_, err := cm.Increment(ctx, "views", 1)
+		assert.ErrorIs(t, err, ErrCounterNotSupported)
+	})
+
+	t.Run("reports values that are not counters", func(t *testing.T) {
+		backend := &counterMockBackend{newLockedMockBackend()}
+		require.NoError(t, backend.Set(ctx, "views", "text", time.Minute))
+		cm := NewCacheManager(CacheConfig{Backend: backend})
+
+		_, err := cm.Increment(ctx, "views", 1)
+		assert.ErrorIs(t, err, ErrNotCounter)
+	})
+}
@@ -0,0 +1,96 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// WriteOption configures a single Set or Delete call
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	parallel bool
+	order    WriteOrder
+}
+
+// WriteOrder is the order in which a parallel Set or Delete reaches the
+// tiers
+type WriteOrder int
+
+const (
+	// AllAtOnce writes every tier at the same time
+	AllAtOnce WriteOrder = iota
+	// LowestFirst writes the last tier first and the tiers above it once it
+	// has answered, so a concurrent read cannot backfill the upper tiers
+	// from a lower tier that has not been updated yet
+	LowestFirst
+	// HighestFirst writes the first tier first and the tiers below it once
+	// it has answered
+	HighestFirst
+)
+
+// WithParallelWrites makes Set and Delete write the tiers concurrently, in
+// order, instead of one after another. The call waits for every tier or for
+// ctx to be done, and reports the error of every tier that failed or had
+// not answered.
+func WithParallelWrites(order WriteOrder) WriteOption {
+	return func(o *writeOptions) {
+		o.parallel = true
+		o.order = order
+	}
+}
+
+// fanOut calls op for every backend index, concurrently within each stage
+// of order, and joins the errors of every tier
+func (cm *CacheManager) fanOut(ctx context.Context, order WriteOrder, op func(ctx context.Context, i int) error) error {
+	type result struct {
+		tier int
+		err  error
+	}
+
+	errs := make([]error, len(cm.backends))
+	answered := make([]bool, len(cm.backends))
+	results := make(chan result, len(cm.backends))
+
+	for _, stage := range fanOutStages(len(cm.backends), order) {
+		for _, i := range stage {
+			go func(i int) {
+				results <- result{tier: i, err: op(ctx, i)}
+			}(i)
+		}
+		for range stage {
+			select {
+			case r := <-results:
+				errs[r.tier], answered[r.tier] = r.err, true
+			case <-ctx.Done():
+				for i := range errs {
+					if !answered[i] {
+						errs[i] = fmt.Errorf("error waiting for backend %d: %w", i, ctx.Err())
+					}
+				}
+				return errors.Join(errs...)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fanOutStages splits the indexes of n backends into the stages of order.
+// The backends of a stage are written once the previous stage has answered.
+func fanOutStages(n int, order WriteOrder) [][]int {
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	if n < 2 {
+		return [][]int{all}
+	}
+	switch order {
+	case LowestFirst:
+		return [][]int{all[n-1:], all[:n-1]}
+	case HighestFirst:
+		return [][]int{all[:1], all[1:]}
+	}
+	return [][]int{all}
+}
@@ -0,0 +1,143 @@
+package cachemanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callLog records the order in which backends are called
+type callLog struct {
+	mu    sync.Mutex
+	calls []int
+}
+
+func (l *callLog) record(tier int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, tier)
+}
+
+func (l *callLog) get() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]int(nil), l.calls...)
+}
+
+// loggedMockBackend records its writes and deletes in a shared callLog
+type loggedMockBackend struct {
+	*lockedMockBackend
+	tier int
+	log  *callLog
+}
+
+func (m *loggedMockBackend) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	m.log.record(m.tier)
+	return m.lockedMockBackend.Set(ctx, key, value, ttl)
+}
+
+func (m *loggedMockBackend) Delete(ctx context.Context, key string) error {
+	m.log.record(m.tier)
+	return m.lockedMockBackend.Delete(ctx, key)
+}
+
+func TestParallelWrites(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("tiers are written concurrently", func(t *testing.T) {
+		backends := make([]*slowMockBackend, 3)
+		configs := make([]CacheConfig, 3)
+		for i := range backends {
+			backends[i] = &slowMockBackend{lockedMockBackend: newLockedMockBackend(), writeDelay: 50 * time.Millisecond}
+			configs[i] = CacheConfig{Backend: backends[i], TTL: time.Minute}
+		}
+		cm := NewCacheManager(configs...)
+
+		start := time.Now()
+		require.NoError(t, cm.Set(ctx, "key", "value", WithParallelWrites(AllAtOnce)))
+		require.NoError(t, cm.Delete(ctx, "other", WithParallelWrites(AllAtOnce)))
+		assert.Less(t, time.Since(start), 140*time.Millisecond)
+		for _, backend := range backends {
+			value, _, _ := backend.entry("key")
+			assert.Equal(t, "value", value)
+		}
+	})
+
+	t.Run("every tier error is reported", func(t *testing.T) {
+		failing := []*flakyMockBackend{
+			{lockedMockBackend: newLockedMockBackend()},
+			{lockedMockBackend: newLockedMockBackend()},
+		}
+		failing[0].setDown(true)
+		failing[1].setDown(true)
+		healthy := newLockedMockBackend()
+		cm := NewCacheManager(
+			CacheConfig{Backend: failing[0], TTL: time.Minute},
+			CacheConfig{Backend: healthy, TTL: time.Minute},
+			CacheConfig{Backend: failing[1], TTL: time.Minute},
+		)
+
+		err := cm.Set(ctx, "key", "value", WithParallelWrites(AllAtOnce))
+		assert.ErrorIs(t, err, errBackendDown)
+		assert.ErrorContains(t, err, "error setting in backend 0")
+		assert.ErrorContains(t, err, "error setting in backend 2")
+		assert.NotContains(t, err.Error(), "backend 1")
+		value, _, _ := healthy.entry("key")
+		assert.Equal(t, "value", value)
+	})
+
+	t.Run("writes follow the configured order", func(t *testing.T) {
+		tests := []struct {
+			order WriteOrder
+			first int
+		}{
+			{LowestFirst, 2},
+			{HighestFirst, 0},
+		}
+		for _, tt := range tests {
+			log := &callLog{}
+			configs := make([]CacheConfig, 3)
+			for i := range configs {
+				configs[i] = CacheConfig{Backend: &loggedMockBackend{lockedMockBackend: newLockedMockBackend(), tier: i, log: log}}
+			}
+			cm := NewCacheManager(configs...)
+
+			require.NoError(t, cm.Delete(ctx, "key", WithParallelWrites(tt.order)))
+			require.NoError(t, cm.Set(ctx, "key", "value", WithParallelWrites(tt.order)))
+			calls := log.get()
+			require.Len(t, calls, 6)
+			assert.Equal(t, tt.first, calls[0])
+			assert.Equal(t, tt.first, calls[3])
+			assert.ElementsMatch(t, []int{0, 1, 2}, calls[:3])
+		}
+	})
+
+	t.Run("the call returns when ctx is done", func(t *testing.T) {
+		fast := newLockedMockBackend()
+		slow := &slowMockBackend{lockedMockBackend: newLockedMockBackend(), writeDelay: time.Second}
+		cm := NewCacheManager(
+			CacheConfig{Backend: fast, TTL: time.Minute},
+			CacheConfig{Backend: slow, TTL: time.Minute},
+		)
+
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := cm.Delete(ctx, "key", WithParallelWrites(HighestFirst))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "backend 1")
+		assert.NotContains(t, err.Error(), "backend 0")
+	})
+}
+
+func TestFanOutStages(t *testing.T) {
+	assert.Equal(t, [][]int{{0, 1, 2}}, fanOutStages(3, AllAtOnce))
+	assert.Equal(t, [][]int{{2}, {0, 1}}, fanOutStages(3, LowestFirst))
+	assert.Equal(t, [][]int{{0}, {1, 2}}, fanOutStages(3, HighestFirst))
+	assert.Equal(t, [][]int{{0}}, fanOutStages(1, LowestFirst))
+}
@@ -0,0 +1,189 @@
+package cachemanager
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples is how many recent reads a latencyWindow keeps
+	latencySamples = 128
+	// minLatencySamples is how many reads a latencyWindow needs before its
+	// quantiles are used
+	minLatencySamples = 20
+)
+
+// latencyWindow keeps the latencies of the recent reads of a tier
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [latencySamples]time.Duration
+	count   int
+}
+
+func (w *latencyWindow) observe(latency time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples[w.count%latencySamples] = latency
+	w.count++
+}
+
+// quantile returns the q quantile of the recent latencies, or false if too
+// few reads have been seen
+func (w *latencyWindow) quantile(q float64) (time.Duration, bool) {
+	w.mu.Lock()
+	n := min(w.count, latencySamples)
+	if n < minLatencySamples {
+		w.mu.Unlock()
+		return 0, false
+	}
+	samples := slices.Clone(w.samples[:n])
+	w.mu.Unlock()
+
+	slices.Sort(samples)
+	rank := int(math.Ceil(q*float64(n))) - 1
+	return samples[max(0, min(rank, n-1))], true
+}
+
+// hedgeDelay returns how long a read of the backend at index i may take
+// before the next tier is queried as well, or 0 if it is never hedged
+func (cm *CacheManager) hedgeDelay(i int) time.Duration {
+	config := cm.backends[i]
+	if window := cm.latencies[i]; window != nil {
+		if delay, ok := window.quantile(config.HedgeQuantile); ok {
+			return delay
+		}
+	}
+	return config.HedgeAfter
+}
+
+// hedgedLookup is lookupChain for tiers that hedge their reads. Tiers are
+// still read in order, but when a tier has not answered within its hedging
+// delay the next tier, or fallback after the last one, is started while it
+// keeps running. The first hit wins; a miss only counts once every tier
+// started has answered.
+func (cm *CacheManager) hedgedLookup(ctx context.Context, key string, tokens map[int]uint64, fallback func(context.Context, map[int]uint64) (any, error)) lookupResult {
+	// Reads still running when a winner is found are abandoned
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The fallback answers as tier len(cm.backends)
+	last := len(cm.backends)
+	if fallback == nil {
+		last--
+	}
+	reads := make(chan tierRead, last+1)
+	var timers []*time.Timer
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+
+	next, pending := 0, 0
+	var hedge <-chan time.Time
+	// fallbackTokens are the tokens the fallback writes with
+	var fallbackTokens map[int]uint64
+	// start starts reading the next tier, if any
+	start := func() {
+		hedge = nil
+		if next > last {
+			return
+		}
+		i := next
+		next++
+		pending++
+		if i == len(cm.backends) {
+			// The fallback gets its own copy of the tokens, which keep
+			// being recorded while it runs; releaseLateTokens hands back
+			// the ones it never saw
+			fallbackTokens = maps.Clone(tokens)
+			go func() {
+				value, err := fallback(ctx, fallbackTokens)
+				reads <- tierRead{tier: i, value: value, err: err}
+			}()
+			return
+		}
+		go func() {
+			reads <- cm.readTier(readCtx, key, i, tokens != nil)
+		}()
+		if delay := cm.hedgeDelay(i); delay > 0 {
+			timer := time.NewTimer(delay)
+			timers = append(timers, timer)
+			hedge = timer.C
+		}
+	}
+
+	var lastErr error
+	start()
+	for pending > 0 {
+		select {
+		case read := <-reads:
+			pending--
+			switch {
+			case read.tier == len(cm.backends):
+				cm.releaseLateTokens(ctx, key, tokens, fallbackTokens, reads, pending)
+				return lookupResult{value: read.value, loaded: true, err: read.err}
+			case read.err != nil:
+				lastErr = fmt.Errorf("error from backend %d: %w", read.tier, read.err)
+			case read.found:
+				cm.backfillHedged(ctx, key, read, tokens, fallbackTokens, reads, pending)
+				return lookupResult{value: read.value, found: true}
+			case read.leased:
+				tokens[read.tier] = read.token
+			}
+			// Only the most recently started tier moves the chain on; a
+			// tier that was hedged past is already followed by another
+			if read.tier == next-1 {
+				start()
+			}
+		case <-hedge:
+			start()
+		}
+	}
+	return lookupResult{err: lastErr}
+}
+
+// backfillHedged populates the tiers before the one that answered hit once
+// the pending reads arrived on reads, with the fill tokens of every tier
+// except those the fallback, if started, writes with itself
+func (cm *CacheManager) backfillHedged(ctx context.Context, key string, hit tierRead, tokens, fallbackTokens map[int]uint64, reads <-chan tierRead, pending int) {
+	backfill := make(map[int]uint64)
+	for i, token := range tokens {
+		if _, ok := fallbackTokens[i]; !ok {
+			backfill[i] = token
+		}
+	}
+	go func() {
+		for ; pending > 0; pending-- {
+			if read := <-reads; read.leased {
+				backfill[read.tier] = read.token
+			}
+		}
+		cm.populatePreviousBackends(ctx, key, hit.value, hit.tier, backfill)
+	}()
+}
+
+// releaseLateTokens releases the fill tokens the fallback did not write
+// with: those recorded after it started, and those of the pending reads
+// still to arrive on reads, which are abandoned
+func (cm *CacheManager) releaseLateTokens(ctx context.Context, key string, tokens, fallbackTokens map[int]uint64, reads <-chan tierRead, pending int) {
+	late := make(map[int]uint64)
+	for i, token := range tokens {
+		if _, ok := fallbackTokens[i]; !ok {
+			late[i] = token
+		}
+	}
+	go func() {
+		for ; pending > 0; pending-- {
+			if read := <-reads; read.leased {
+				late[read.tier] = read.token
+			}
+		}
+		cm.releaseFillLeases(ctx, key, &writeGuard{tokens: late})
+	}()
+}
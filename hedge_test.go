@@ -0,0 +1,259 @@
+package cachemanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowMockBackend delays its reads and writes, failing them once ctx is done
+type slowMockBackend struct {
+	*lockedMockBackend
+	readDelay  time.Duration
+	writeDelay time.Duration
+}
+
+func wait(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *slowMockBackend) Get(ctx context.Context, key string) (any, bool, error) {
+	if err := wait(ctx, m.readDelay); err != nil {
+		return nil, false, err
+	}
+	return m.lockedMockBackend.Get(ctx, key)
+}
+
+func (m *slowMockBackend) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := wait(ctx, m.writeDelay); err != nil {
+		return err
+	}
+	return m.lockedMockBackend.Set(ctx, key, value, ttl)
+}
+
+func (m *slowMockBackend) Delete(ctx context.Context, key string) error {
+	if err := wait(ctx, m.writeDelay); err != nil {
+		return err
+	}
+	return m.lockedMockBackend.Delete(ctx, key)
+}
+
+// slowLeaseMockBackend hands out fill tokens after readDelay, even once ctx
+// is done, like a server that granted the lease before the read was
+// abandoned
+type slowLeaseMockBackend struct {
+	*fillLeaseMockBackend
+	readDelay time.Duration
+	granted   bool
+}
+
+func (m *slowLeaseMockBackend) GetWithLease(ctx context.Context, key string) (any, bool, uint64, error) {
+	time.Sleep(m.readDelay)
+	value, found, token, err := m.fillLeaseMockBackend.GetWithLease(ctx, key)
+	m.mu.Lock()
+	m.granted = m.granted || token != 0
+	m.mu.Unlock()
+	return value, found, token, err
+}
+
+func TestTierTimeouts(t *testing.T) {
+	ctx := context.Background()
+	slow := &slowMockBackend{lockedMockBackend: newLockedMockBackend(), readDelay: time.Second, writeDelay: time.Second}
+	lower := newLockedMockBackend()
+	cm := NewCacheManager(
+		CacheConfig{Backend: slow, TTL: time.Minute, ReadTimeout: 20 * time.Millisecond, WriteTimeout: 20 * time.Millisecond},
+		CacheConfig{Backend: lower, TTL: time.Hour},
+	)
+
+	start := time.Now()
+	err := cm.Set(ctx, "key", "value")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	value, _, _ := lower.entry("key")
+	assert.Equal(t, "value", value)
+
+	value, err = cm.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	assert.ErrorIs(t, cm.Delete(ctx, "key"), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestHedgedReads(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("slow tiers are raced against the next tier", func(t *testing.T) {
+		slow := &slowMockBackend{lockedMockBackend: newLockedMockBackend(), readDelay: 500 * time.Millisecond}
+		lower := newLockedMockBackend()
+		require.NoError(t, slow.lockedMockBackend.Set(ctx, "key", "upper", time.Minute))
+		require.NoError(t, lower.Set(ctx, "key", "lower", time.Hour))
+		cm := NewCacheManager(
+			CacheConfig{Backend: slow, TTL: time.Minute, HedgeAfter: 10 * time.Millisecond},
+			CacheConfig{Backend: lower, TTL: time.Hour},
+		)
+
+		start := time.Now()
+		value, err := cm.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "lower", value)
+		assert.Less(t, time.Since(start), 250*time.Millisecond)
+	})
+
+	t.Run("a slow hit wins over a miss of the next tier", func(t *testing.T) {
+		slow := &slowMockBackend{lockedMockBackend: newLockedMockBackend(), readDelay: 50 * time.Millisecond}
+		require.NoError(t, slow.lockedMockBackend.Set(ctx, "key", "upper", time.Minute))
+		cm := NewCacheManager(
+			CacheConfig{Backend: slow, TTL: time.Minute, HedgeAfter: 10 * time.Millisecond},
+			CacheConfig{Backend: newLockedMockBackend(), TTL: time.Hour},
+		)
+
+		value, err := cm.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "upper", value)
+
+		_, err = cm.Get(ctx, "missing")
+		assert.Error(t, err)
+	})
+
+	t.Run("the last tier is raced against the loader", func(t *testing.T) {
+		slow := &slowMockBackend{lockedMockBackend: newLockedMockBackend(), readDelay: 500 * time.Millisecond}
+		require.NoError(t, slow.lockedMockBackend.Set(ctx, "key", "cached", time.Minute))
+		cm := NewCacheManager(CacheConfig{Backend: slow, TTL: time.Minute, HedgeAfter: 10 * time.Millisecond})
+
+		start := time.Now()
+		value, err := cm.GetOrLoad(ctx, "key", func(ctx context.Context) (any, error) {
+			return "loaded", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", value)
+		assert.Less(t, time.Since(start), 250*time.Millisecond)
+
+		// Misses still load without hedging
+		value, err = cm.GetOrLoad(ctx, "other", func(ctx context.Context) (any, error) {
+			return "other", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "other", value)
+	})
+
+	t.Run("fill leases taken after the hedge are released", func(t *testing.T) {
+		loaders := map[string]Loader{
+			// The lease is taken while the loader runs
+			"during the load": func(ctx context.Context) (any, error) {
+				time.Sleep(100 * time.Millisecond)
+				return "loaded", nil
+			},
+			// The lease is taken after the loader returned, and the key
+			// stays missing
+			"after the load": func(ctx context.Context) (any, error) {
+				return nil, ErrNotFound
+			},
+		}
+		for name, load := range loaders {
+			t.Run(name, func(t *testing.T) {
+				backend := &slowLeaseMockBackend{fillLeaseMockBackend: newFillLeaseMockBackend(), readDelay: 50 * time.Millisecond}
+				cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute, HedgeAfter: 10 * time.Millisecond})
+
+				_, _ = cm.GetOrLoad(ctx, "key", load)
+				assert.Eventually(t, func() bool {
+					backend.mu.Lock()
+					defer backend.mu.Unlock()
+					return backend.granted && len(backend.leases) == 0
+				}, time.Second, 5*time.Millisecond)
+			})
+		}
+	})
+
+	t.Run("fast tiers are not hedged", func(t *testing.T) {
+		upper := newLockedMockBackend()
+		require.NoError(t, upper.Set(ctx, "key", "upper", time.Minute))
+		cm := NewCacheManager(
+			CacheConfig{Backend: upper, TTL: time.Minute, HedgeAfter: 50 * time.Millisecond},
+			CacheConfig{Backend: newLockedMockBackend(), TTL: time.Hour},
+		)
+
+		loads := 0
+		value, err := cm.GetOrLoad(ctx, "key", func(ctx context.Context) (any, error) {
+			loads++
+			return "loaded", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "upper", value)
+		assert.Zero(t, loads)
+	})
+}
+
+// bimodalMockBackend answers keys starting with "slow:" after slowDelay and
+// the others right away
+type bimodalMockBackend struct {
+	*lockedMockBackend
+	slowDelay time.Duration
+}
+
+func (m *bimodalMockBackend) Get(ctx context.Context, key string) (any, bool, error) {
+	if strings.HasPrefix(key, "slow:") {
+		if err := wait(ctx, m.slowDelay); err != nil {
+			return nil, false, err
+		}
+	}
+	return m.lockedMockBackend.Get(ctx, key)
+}
+
+func TestHedgeQuantile(t *testing.T) {
+	ctx := context.Background()
+	upper := &bimodalMockBackend{lockedMockBackend: newLockedMockBackend(), slowDelay: time.Second}
+	lower := newLockedMockBackend()
+	require.NoError(t, upper.lockedMockBackend.Set(ctx, "fast:key", "upper", time.Minute))
+	require.NoError(t, lower.Set(ctx, "slow:key", "lower", time.Hour))
+	cm := NewCacheManager(
+		CacheConfig{Backend: upper, TTL: time.Minute, HedgeAfter: 20 * time.Millisecond, HedgeQuantile: 0.9},
+		CacheConfig{Backend: lower, TTL: time.Hour},
+	)
+
+	for i := 0; i < 2*minLatencySamples; i++ {
+		key := "fast:key"
+		if i%2 == 1 {
+			key = "slow:key"
+		}
+		_, err := cm.Get(ctx, key)
+		require.NoError(t, err)
+	}
+
+	// Half the reads are hedged past and abandoned, so the p90 is one of
+	// them and stays at the hedging delay instead of dropping to the fast
+	// reads
+	assert.Eventually(t, func() bool {
+		delay, ok := cm.latencies[0].quantile(0.9)
+		return ok && delay >= 20*time.Millisecond
+	}, time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, cm.hedgeDelay(0), 20*time.Millisecond)
+}
+
+func TestLatencyWindow(t *testing.T) {
+	var window latencyWindow
+	for i := 1; i < minLatencySamples; i++ {
+		window.observe(time.Duration(i) * time.Millisecond)
+	}
+	_, ok := window.quantile(0.95)
+	assert.False(t, ok)
+
+	window = latencyWindow{}
+	for i := 1; i <= 100; i++ {
+		window.observe(time.Duration(i) * time.Millisecond)
+	}
+	p95, ok := window.quantile(0.95)
+	require.True(t, ok)
+	assert.Equal(t, 95*time.Millisecond, p95)
+
+	cm := NewCacheManager(CacheConfig{Backend: newMockBackend(), HedgeAfter: time.Second, HedgeQuantile: 0.5})
+	assert.Equal(t, time.Second, cm.hedgeDelay(0))
+}
@@ -0,0 +1,52 @@
+// Package hashring maps keys to nodes with consistent hashing, for the
+// backends that spread keys over several servers or peers.
+package hashring
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Ring maps keys to nodes: every node is placed at many points on a hash
+// circle and a key belongs to the first point at or after its own hash.
+// Adding or removing a node only moves the keys that hash next to its
+// points.
+type Ring struct {
+	points []point
+}
+
+type point struct {
+	hash uint64
+	node string
+}
+
+// New places every node at pointsPerNode points
+func New(nodes []string, pointsPerNode int) *Ring {
+	r := &Ring{points: make([]point, 0, len(nodes)*pointsPerNode)}
+	for _, node := range nodes {
+		for i := 0; i < pointsPerNode; i++ {
+			r.points = append(r.points, point{
+				hash: xxhash.Sum64String(node + "-" + strconv.Itoa(i)),
+				node: node,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// Node returns the node owning key
+func (r *Ring) Node(key string) string {
+	hash := xxhash.Sum64String(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
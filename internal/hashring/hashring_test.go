@@ -0,0 +1,35 @@
+package hashring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	servers := []string{"a:11211", "b:11211", "c:11211"}
+	r := New(servers, 160)
+
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		owners[key] = r.Node(key)
+		counts[owners[key]]++
+	}
+	for _, server := range servers {
+		assert.InDelta(t, 10000/3, counts[server], 700, server)
+	}
+
+	// Adding a server only moves keys to the new server
+	grown := New(append(servers, "d:11211"), 160)
+	moved := 0
+	for key, owner := range owners {
+		if server := grown.Node(key); server != owner {
+			assert.Equal(t, "d:11211", server)
+			moved++
+		}
+	}
+	assert.InDelta(t, 10000/4, moved, 700)
+}
@@ -0,0 +1,52 @@
+// Package singleflight deduplicates concurrent calls for the same key, as
+// used for the loads of CacheManager and the fetches of the peer cache.
+package singleflight
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPanicked is reported to callers waiting on a call that panicked
+var ErrPanicked = errors.New("call panicked")
+
+// Group runs one call per key at a time: callers arriving while a call for
+// their key is running wait for its result instead of making their own.
+// The zero value is ready to use.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+type call[T any] struct {
+	wg    sync.WaitGroup
+	value T
+	err   error
+}
+
+// Do runs fn for key, unless a call for key is running, in which case it
+// returns that call's result
+func (g *Group[T]) Do(key string, fn func() (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+	c := &call[T]{err: ErrPanicked}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.value, c.err = fn()
+	return c.value, c.err
+}
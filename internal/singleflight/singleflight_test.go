@@ -0,0 +1,53 @@
+package singleflight
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	t.Run("concurrent calls share one result", func(t *testing.T) {
+		var g Group[int]
+		var calls atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := g.Do("key", func() (int, error) {
+					calls.Add(1)
+					time.Sleep(20 * time.Millisecond)
+					return 42, nil
+				})
+				assert.NoError(t, err)
+				assert.Equal(t, 42, value)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(1), calls.Load())
+	})
+
+	t.Run("waiters of a call that panicked get ErrPanicked", func(t *testing.T) {
+		var g Group[int]
+		started := make(chan struct{})
+		waited := make(chan error)
+		go func() {
+			defer func() { _ = recover() }()
+			_, _ = g.Do("key", func() (int, error) {
+				close(started)
+				time.Sleep(20 * time.Millisecond)
+				panic("boom")
+			})
+		}()
+		<-started
+		go func() {
+			_, err := g.Do("key", func() (int, error) { return 0, nil })
+			waited <- err
+		}()
+		assert.ErrorIs(t, <-waited, ErrPanicked)
+	})
+}
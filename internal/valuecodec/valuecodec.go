@@ -0,0 +1,57 @@
+// Package valuecodec converts cached values to bytes and a flag recording how
+// they were converted, for the backends that store or send serialized values.
+package valuecodec
+
+import (
+	"fmt"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+)
+
+// Flag records how a value was converted to bytes. Backends store it with the
+// bytes, in an entry header, a column, the flags of a memcached item or an
+// HTTP header.
+type Flag uint32
+
+const (
+	// Raw marks a []byte stored as is
+	Raw Flag = 1
+	// Codec marks a value serialized with the codec of the backend
+	Codec Flag = 2
+	// Tombstone marks a cachemanager.Tombstone, whose data is empty
+	Tombstone Flag = 3
+)
+
+// Encode converts value to bytes: []byte values are kept as is, tombstones
+// become empty and other values are serialized with c. Errors of c wrap
+// codec.ErrEncode.
+func Encode(c codec.Codec, key string, value any) (Flag, []byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return Raw, v, nil
+	case cachemanager.Tombstone:
+		return Tombstone, []byte{}, nil
+	}
+	data, err := c.Marshal(value)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w for key %s: %w", codec.ErrEncode, key, err)
+	}
+	return Codec, data, nil
+}
+
+// Decode converts the bytes of a value encoded with flag back to the value.
+// Raw data is returned without being copied.
+func Decode(c codec.Codec, key string, flag Flag, data []byte) (any, error) {
+	switch flag {
+	case Raw:
+		return data, nil
+	case Tombstone:
+		return cachemanager.Tombstone{}, nil
+	}
+	value, err := c.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode value for key %s: %w", key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,47 @@
+package valuecodec
+
+import (
+	"testing"
+
+	cachemanager "github.com/ethan-k/cachemanager-go"
+	"github.com/ethan-k/cachemanager-go/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		flag  Flag
+	}{
+		{"bytes are kept as is", []byte("raw"), Raw},
+		{"tombstones are empty", cachemanager.Tombstone{}, Tombstone},
+		{"other values use the codec", map[string]any{"name": "Ada"}, Codec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag, data, err := Encode(codec.JSON{}, "key", tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.flag, flag)
+			if flag == Tombstone {
+				assert.Empty(t, data)
+			}
+
+			value, err := Decode(codec.JSON{}, "key", flag, data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+
+	t.Run("values the codec rejects are caller errors", func(t *testing.T) {
+		_, _, err := Encode(codec.JSON{}, "key", make(chan int))
+		assert.ErrorIs(t, err, codec.ErrEncode)
+		assert.ErrorContains(t, err, "key key")
+	})
+
+	t.Run("undecodable data is reported", func(t *testing.T) {
+		_, err := Decode(codec.JSON{}, "key", Codec, []byte("{"))
+		assert.Error(t, err)
+	})
+}
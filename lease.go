@@ -0,0 +1,124 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultLeaseWait     = 5 * time.Second
+	defaultLeaseInterval = 50 * time.Millisecond
+)
+
+// ErrLeaseNotSupported is returned, possibly wrapped, by the methods of
+// LeaseBackend and FencedBackend for keys the backend cannot guard, such as
+// keys whose lease cannot live in the same Redis Cluster slot. CacheManager
+// reads and writes such keys in that tier without a lease.
+var ErrLeaseNotSupported = errors.New("key cannot be leased")
+
+// Lease is the right to recompute a key, held by one instance at a time
+// until it is released or its TTL runs out
+type Lease struct {
+	Key string
+	// Token identifies the holder; only the holder can release the lease
+	Token string
+	// Fence increases every time a lease on Key is granted. Writes made
+	// under the lease carry it, so a holder whose lease ran out cannot
+	// overwrite data written under a newer lease.
+	Fence int64
+}
+
+// Locker grants leases shared by every instance using the same locker, such
+// as a Redis cache
+type Locker interface {
+	// AcquireLease takes the lease on key for ttl. It returns nil if another
+	// holder has it.
+	AcquireLease(ctx context.Context, key string, ttl time.Duration) (*Lease, error)
+	// ReleaseLease gives the lease up early. Releasing a lease that ran out
+	// and was granted to someone else has no effect.
+	ReleaseLease(ctx context.Context, lease *Lease) error
+}
+
+// FencedBackend is implemented by backends that can reject writes made under
+// a superseded lease
+type FencedBackend interface {
+	CacheBackend
+	// SetFenced stores value unless a lease on key newer than lease has been
+	// granted. It reports whether the value was stored.
+	SetFenced(ctx context.Context, key string, value any, ttl time.Duration, lease *Lease) (bool, error)
+}
+
+// LeaseBackend is implemented by backends that hand out memcache-style fill
+// leases. A miss returns a token authorizing one SetWithLease; Delete revokes
+// outstanding tokens, so a value loaded before a concurrent update and delete
+// cannot be written back over it.
+type LeaseBackend interface {
+	CacheBackend
+	// GetWithLease returns the value of key, or on a miss a fill token. The
+	// token is 0 if another caller already holds an unexpired fill lease.
+	GetWithLease(ctx context.Context, key string) (value any, found bool, token uint64, err error)
+	// SetWithLease stores value if token is still the key's outstanding fill
+	// lease and reports whether it did. The write is dropped otherwise.
+	SetWithLease(ctx context.Context, key string, value any, ttl time.Duration, token uint64) (bool, error)
+	// ReleaseFillLease gives up a fill token that will not be used, so
+	// other callers can fill the key right away. Releasing a token that is
+	// no longer outstanding has no effect.
+	ReleaseFillLease(ctx context.Context, key string, token uint64) error
+}
+
+// WithLease makes GetOrLoad take a lease on the key from locker, for ttl,
+// before calling the loader, so that a single instance in the fleet
+// recomputes the key. Instances that find the lease taken serve the cached
+// value when refreshing early, and otherwise poll the cache until the holder
+// has stored the value (see WithLeaseWait). A holder that dies is replaced
+// once ttl runs out.
+func WithLease(locker Locker, ttl time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		o.locker = locker
+		o.leaseTTL = ttl
+	}
+}
+
+// WithLeaseWait sets how long GetOrLoad waits for another instance's lease
+// holder to store the value, polling the cache every interval, before
+// calling the loader itself. It defaults to 5s and 50ms.
+func WithLeaseWait(maxWait, interval time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		if maxWait > 0 {
+			o.leaseWait = maxWait
+		}
+		if interval > 0 {
+			o.leaseInterval = interval
+		}
+	}
+}
+
+// waitForLoad polls the cache for key until another instance stores it, the
+// wait runs out or ctx is done. It reports whether it has a result.
+func (cm *CacheManager) waitForLoad(ctx context.Context, key string, options loadOptions) (any, bool, error) {
+	timeout := time.NewTimer(options.leaseWait)
+	defer timeout.Stop()
+	ticker := time.NewTicker(options.leaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		case <-timeout.C:
+			return nil, false, nil
+		case <-ticker.C:
+		}
+
+		value, found, _ := cm.lookup(ctx, key, nil)
+		if !found {
+			continue
+		}
+		if IsTombstone(value) {
+			return nil, true, fmt.Errorf("key %s: %w", key, ErrNotFound)
+		}
+		return unwrapValue(value), true, nil
+	}
+}
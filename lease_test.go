@@ -0,0 +1,419 @@
+package cachemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockLocker grants one lease per key at a time
+type mockLocker struct {
+	mu       sync.Mutex
+	held     map[string]bool
+	fence    int64
+	err      error
+	released []*Lease
+}
+
+func newMockLocker() *mockLocker {
+	return &mockLocker{held: make(map[string]bool)}
+}
+
+func (l *mockLocker) AcquireLease(ctx context.Context, key string, ttl time.Duration) (*Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return nil, l.err
+	}
+	if l.held[key] {
+		return nil, nil
+	}
+	l.held[key] = true
+	l.fence++
+	return &Lease{Key: key, Token: "token", Fence: l.fence}, nil
+}
+
+func (l *mockLocker) ReleaseLease(ctx context.Context, lease *Lease) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, lease.Key)
+	l.released = append(l.released, lease)
+	return nil
+}
+
+// fencedMockBackend records the fences of the writes made under a lease
+type fencedMockBackend struct {
+	*lockedMockBackend
+	fences []int64
+}
+
+func (m *fencedMockBackend) SetFenced(ctx context.Context, key string, value any, ttl time.Duration, lease *Lease) (bool, error) {
+	m.mu.Lock()
+	m.fences = append(m.fences, lease.Fence)
+	m.mu.Unlock()
+	return true, m.Set(ctx, key, value, ttl)
+}
+
+func TestCacheManager_GetOrLoadWithLease(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("holder writes under its fence and releases", func(t *testing.T) {
+		locker := newMockLocker()
+		backend := &fencedMockBackend{lockedMockBackend: newLockedMockBackend()}
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "value", nil
+		}, WithLease(locker, time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+		assert.Equal(t, []int64{1}, backend.fences)
+		assert.Len(t, locker.released, 1)
+	})
+
+	t.Run("waits for another holder", func(t *testing.T) {
+		locker := newMockLocker()
+		locker.held["key"] = true
+		backend := newLockedMockBackend()
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			_ = backend.Set(ctx, "key", "from holder", time.Minute)
+		}()
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return nil, errors.New("must not load while the lease is held")
+		}, WithLease(locker, time.Second), WithLeaseWait(time.Second, 5*time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, "from holder", value)
+	})
+
+	t.Run("loads itself when the holder is too slow", func(t *testing.T) {
+		locker := newMockLocker()
+		locker.held["key"] = true
+		cm := NewCacheManager(CacheConfig{Backend: newLockedMockBackend(), TTL: time.Minute})
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "loaded", nil
+		}, WithLease(locker, time.Second), WithLeaseWait(20*time.Millisecond, 5*time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", value)
+	})
+
+	t.Run("serves the stale value while another instance refreshes", func(t *testing.T) {
+		locker := newMockLocker()
+		locker.held["key"] = true
+		backend := newLockedMockBackend()
+		backend.data["key"] = EarlyRefreshEntry{Value: "stale", Delta: time.Second, Expiry: time.Now()}
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return nil, errors.New("must not load while the lease is held")
+		}, WithEarlyRefresh(1), WithLease(locker, time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, "stale", value)
+	})
+
+	t.Run("loads when the locker is unavailable", func(t *testing.T) {
+		locker := newMockLocker()
+		locker.err = errors.New("connection refused")
+		cm := NewCacheManager(CacheConfig{Backend: newLockedMockBackend(), TTL: time.Minute})
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "loaded", nil
+		}, WithLease(locker, time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", value)
+	})
+}
+
+// fillLeaseMockBackend hands out memcache-style fill tokens that Delete
+// revokes. With unsupported set, every lease call fails with
+// ErrLeaseNotSupported.
+type fillLeaseMockBackend struct {
+	*lockedMockBackend
+	leases      map[string]uint64
+	last        uint64
+	unsupported bool
+}
+
+func newFillLeaseMockBackend() *fillLeaseMockBackend {
+	return &fillLeaseMockBackend{lockedMockBackend: newLockedMockBackend(), leases: make(map[string]uint64)}
+}
+
+func (m *fillLeaseMockBackend) GetWithLease(ctx context.Context, key string) (any, bool, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unsupported {
+		return nil, false, 0, ErrLeaseNotSupported
+	}
+	if value, exists := m.data[key]; exists {
+		return value, true, 0, nil
+	}
+	if _, held := m.leases[key]; held {
+		return nil, false, 0, nil
+	}
+	m.last++
+	m.leases[key] = m.last
+	return nil, false, m.last, nil
+}
+
+func (m *fillLeaseMockBackend) SetWithLease(ctx context.Context, key string, value any, ttl time.Duration, token uint64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unsupported {
+		return false, ErrLeaseNotSupported
+	}
+	if m.leases[key] != token {
+		return false, nil
+	}
+	delete(m.leases, key)
+	m.data[key] = value
+	m.ttls[key] = ttl
+	return true, nil
+}
+
+func (m *fillLeaseMockBackend) ReleaseFillLease(ctx context.Context, key string, token uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unsupported {
+		return ErrLeaseNotSupported
+	}
+	if m.leases[key] == token {
+		delete(m.leases, key)
+	}
+	return nil
+}
+
+func (m *fillLeaseMockBackend) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.leases, key)
+	m.mu.Unlock()
+	return m.lockedMockBackend.Delete(ctx, key)
+}
+
+// deletingMockBackend deletes every key it is read from in another backend,
+// as a concurrent invalidation would between the reads of two tiers
+type deletingMockBackend struct {
+	*lockedMockBackend
+	deletes CacheBackend
+}
+
+func (m *deletingMockBackend) Get(ctx context.Context, key string) (any, bool, error) {
+	if err := m.deletes.Delete(ctx, key); err != nil {
+		return nil, false, err
+	}
+	return m.lockedMockBackend.Get(ctx, key)
+}
+
+// gatedFillLeaseMockBackend holds back the caller it hands the first fill
+// token to until gate is closed
+type gatedFillLeaseMockBackend struct {
+	*fillLeaseMockBackend
+	gate  chan struct{}
+	gated sync.Once
+}
+
+func (m *gatedFillLeaseMockBackend) GetWithLease(ctx context.Context, key string) (any, bool, uint64, error) {
+	value, found, token, err := m.fillLeaseMockBackend.GetWithLease(ctx, key)
+	if token != 0 {
+		m.gated.Do(func() { <-m.gate })
+	}
+	return value, found, token, err
+}
+
+func TestCacheManager_GetOrLoadWithFillLease(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stores the value with the token", func(t *testing.T) {
+		backend := newFillLeaseMockBackend()
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "loaded", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", value)
+
+		stored, _, exists := backend.entry("key")
+		assert.True(t, exists)
+		assert.Equal(t, "loaded", stored)
+		assert.Empty(t, backend.leases)
+	})
+
+	t.Run("drops a stale value after a concurrent delete", func(t *testing.T) {
+		backend := newFillLeaseMockBackend()
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		value, err := cm.GetOrLoad(ctx, "key", func(ctx context.Context) (any, error) {
+			// The source changes and the key is invalidated while loading
+			require.NoError(t, cm.Delete(ctx, "key"))
+			return "stale", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "stale", value)
+
+		_, _, exists := backend.entry("key")
+		assert.False(t, exists)
+	})
+
+	t.Run("releases the token when the load fails", func(t *testing.T) {
+		backend := newFillLeaseMockBackend()
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		_, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return nil, errors.New("origin down")
+		})
+		assert.Error(t, err)
+		assert.Empty(t, backend.leases)
+
+		// The next caller fills the key instead of waiting for the lease
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "loaded", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", value)
+		stored, _, exists := backend.entry("key")
+		assert.True(t, exists)
+		assert.Equal(t, "loaded", stored)
+	})
+
+	t.Run("does not store while another caller holds the fill", func(t *testing.T) {
+		backend := newFillLeaseMockBackend()
+		backend.leases["key"] = 42
+		upper := newLockedMockBackend()
+		cm := NewCacheManager(
+			CacheConfig{Backend: upper, TTL: time.Minute},
+			CacheConfig{Backend: backend, TTL: time.Minute},
+		)
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "loaded", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", value)
+
+		_, _, exists := backend.entry("key")
+		assert.False(t, exists)
+		stored, _, exists := upper.entry("key")
+		assert.True(t, exists)
+		assert.Equal(t, "loaded", stored)
+	})
+
+	t.Run("backfills with the token of the miss", func(t *testing.T) {
+		upper := newFillLeaseMockBackend()
+		lower := newLockedMockBackend()
+		require.NoError(t, lower.Set(ctx, "key", "value", time.Hour))
+		cm := NewCacheManager(
+			CacheConfig{Backend: upper, TTL: time.Minute},
+			CacheConfig{Backend: lower, TTL: time.Hour},
+		)
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			t.Fatal("a cached value must not be reloaded")
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+
+		assert.Eventually(t, func() bool {
+			stored, _, exists := upper.entry("key")
+			return exists && stored == "value"
+		}, time.Second, 5*time.Millisecond)
+		upper.mu.Lock()
+		defer upper.mu.Unlock()
+		assert.Empty(t, upper.leases)
+	})
+
+	t.Run("does not backfill a value deleted since the miss", func(t *testing.T) {
+		upper := newFillLeaseMockBackend()
+		lower := &deletingMockBackend{lockedMockBackend: newLockedMockBackend(), deletes: upper}
+		require.NoError(t, lower.Set(ctx, "key", "stale", time.Hour))
+		cm := NewCacheManager(
+			CacheConfig{Backend: upper, TTL: time.Minute},
+			CacheConfig{Backend: lower, TTL: time.Hour},
+		)
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "loaded", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "stale", value)
+
+		// Give the backfill the chance to run
+		time.Sleep(20 * time.Millisecond)
+		_, _, exists := upper.entry("key")
+		assert.False(t, exists)
+	})
+
+	t.Run("callers waiting for another load release their tokens", func(t *testing.T) {
+		backend := &gatedFillLeaseMockBackend{fillLeaseMockBackend: newFillLeaseMockBackend(), gate: make(chan struct{})}
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+		// The first caller gets the token but the second one, without a
+		// token, starts the load
+		holder := make(chan error)
+		go func() {
+			_, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+				return "holder", nil
+			})
+			holder <- err
+		}()
+		assert.Eventually(t, func() bool {
+			backend.mu.Lock()
+			defer backend.mu.Unlock()
+			return len(backend.leases) == 1
+		}, time.Second, time.Millisecond)
+
+		value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			close(backend.gate)
+			// Let the holder join this load
+			time.Sleep(20 * time.Millisecond)
+			return "loaded", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "loaded", value)
+		require.NoError(t, <-holder)
+
+		backend.mu.Lock()
+		assert.Empty(t, backend.leases, "the token of the waiting caller is released")
+		backend.mu.Unlock()
+		value, err = cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+			return "next", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "next", value)
+		stored, _, exists := backend.entry("key")
+		assert.True(t, exists, "the next miss fills the tier")
+		assert.Equal(t, "next", stored)
+	})
+
+	t.Run("falls back to plain reads and writes for keys it cannot lease", func(t *testing.T) {
+		backend := newFillLeaseMockBackend()
+		backend.unsupported = true
+		cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute, CircuitBreaker: &CircuitBreakerConfig{
+			MinCalls: 4,
+			Cooldown: time.Hour,
+		}})
+
+		for i := 0; i < 8; i++ {
+			key := fmt.Sprintf("key%d", i)
+			value, err := cm.GetOrLoad(ctx, key, func(context.Context) (any, error) {
+				return "loaded", nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "loaded", value)
+
+			stored, _, exists := backend.entry(key)
+			assert.True(t, exists)
+			assert.Equal(t, "loaded", stored)
+		}
+		assert.Equal(t, BreakerClosed, cm.BreakerState(0), "unleasable keys are not tier failures")
+	})
+}
@@ -0,0 +1,27 @@
+package cachemanager
+
+import (
+	"encoding/gob"
+	"errors"
+)
+
+// ErrNotFound is returned when a key is known not to exist at the origin:
+// a loader reports it to have the miss cached, and Get and GetOrLoad report
+// it when they find a cached miss.
+var ErrNotFound = errors.New("not found")
+
+// Tombstone is the value cached in place of a key that does not exist at the
+// origin. Backends that serialize values must store it in a form that
+// decodes back to Tombstone{}.
+type Tombstone struct{}
+
+func init() {
+	// Registered so the gob codec can encode tombstones held in an any
+	gob.Register(Tombstone{})
+}
+
+// IsTombstone reports whether value is a cached miss
+func IsTombstone(value any) bool {
+	_, ok := value.(Tombstone)
+	return ok
+}
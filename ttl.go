@@ -0,0 +1,40 @@
+package cachemanager
+
+import (
+	"errors"
+	"time"
+)
+
+// TTL values with a special meaning for every CacheBackend.
+//
+// A positive TTL expires the entry after that duration. NoExpiration keeps the
+// entry until it is deleted or evicted. DefaultTTL defers to the default TTL
+// configured on the backend itself. Any other negative TTL is rejected with
+// ErrInvalidTTL.
+const (
+	NoExpiration time.Duration = 0
+	DefaultTTL   time.Duration = -1
+)
+
+// ErrInvalidTTL is returned by backends when a negative TTL other than
+// DefaultTTL is passed to Set.
+var ErrInvalidTTL = errors.New("invalid negative TTL")
+
+// ResolveTTL applies the TTL model to ttl, substituting defaultTTL for
+// DefaultTTL. The result is either NoExpiration or a positive duration.
+func ResolveTTL(ttl, defaultTTL time.Duration) (time.Duration, error) {
+	if ttl == DefaultTTL {
+		ttl = defaultTTL
+	}
+	if ttl < 0 {
+		return 0, ErrInvalidTTL
+	}
+	return ttl, nil
+}
+
+// DefaultTTLBackend is implemented by backends that report the TTL they
+// apply to writes made with DefaultTTL
+type DefaultTTLBackend interface {
+	CacheBackend
+	DefaultTTL() time.Duration
+}
@@ -0,0 +1,37 @@
+package cachemanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		defaultTTL  time.Duration
+		expected    time.Duration
+		expectedErr error
+	}{
+		{name: "positive TTL", ttl: time.Minute, defaultTTL: time.Hour, expected: time.Minute},
+		{name: "no expiration", ttl: NoExpiration, defaultTTL: time.Hour, expected: NoExpiration},
+		{name: "default TTL", ttl: DefaultTTL, defaultTTL: time.Hour, expected: time.Hour},
+		{name: "default TTL without tier default", ttl: DefaultTTL, defaultTTL: NoExpiration, expected: NoExpiration},
+		{name: "negative TTL", ttl: -time.Second, defaultTTL: time.Hour, expectedErr: ErrInvalidTTL},
+		{name: "negative tier default", ttl: DefaultTTL, defaultTTL: -time.Second, expectedErr: ErrInvalidTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, err := ResolveTTL(tt.ttl, tt.defaultTTL)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, ttl)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package cachemanager
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// EarlyRefreshEntry is the value cached by GetOrLoad when early refresh is
+// enabled. Besides the loaded value it records how long the loader took and
+// when the entry expires, which is what probabilistic early expiration
+// (XFetch) needs to decide whether to refresh it. Backends that serialize
+// values must preserve both fields.
+type EarlyRefreshEntry struct {
+	Value any
+	// Delta is how long the loader took to compute Value
+	Delta time.Duration
+	// Expiry is when the entry expires in the backend it was read from. It
+	// is zero when the TTL is not known, which disables early refresh.
+	Expiry time.Time
+}
+
+func init() {
+	gob.Register(EarlyRefreshEntry{})
+}
+
+// WithEarlyRefresh enables probabilistic early expiration: every hit
+// refreshes the entry ahead of its expiry with a probability that grows as
+// the expiry approaches and with the time the loader takes. beta scales how
+// early refreshes happen; 1 is the usual choice and values above 1 favour
+// earlier refreshes. Only one instance in a fleet is then likely to recompute
+// a hot key instead of every instance missing at the same moment.
+func WithEarlyRefresh(beta float64) LoadOption {
+	return func(o *loadOptions) {
+		if beta <= 0 {
+			beta = 1
+		}
+		o.earlyRefresh = true
+		o.beta = beta
+	}
+}
+
+// randFloat64 returns a number in [0, 1); replaced in tests
+var randFloat64 = rand.Float64
+
+// shouldRefreshEarly implements the XFetch test: refresh when
+// now - delta * beta * log(rand) reaches the expiry
+func shouldRefreshEarly(entry EarlyRefreshEntry, beta float64, now time.Time) bool {
+	if entry.Expiry.IsZero() {
+		return false
+	}
+	// 1 - rand is in (0, 1], so the logarithm is finite and not positive
+	gap := -float64(entry.Delta) * beta * math.Log(1-randFloat64())
+	return !now.Add(time.Duration(gap)).Before(entry.Expiry)
+}
+
+// setEarlyRefresh stores value in every backend along with the metadata
+// needed to refresh it early
+func (cm *CacheManager) setEarlyRefresh(ctx context.Context, key string, value any, delta time.Duration, guard *writeGuard) error {
+	var lastErr error
+	now := time.Now()
+
+	for i, config := range cm.backends {
+		entry := EarlyRefreshEntry{Value: value, Delta: delta}
+		if ttl := cm.tierTTL(i); ttl > 0 {
+			entry.Expiry = now.Add(ttl)
+		}
+		if err := cm.setBackend(ctx, i, key, entry, config.TTL, guard); err != nil {
+			lastErr = fmt.Errorf("error setting in backend %d: %w", i, err)
+		}
+	}
+
+	return lastErr
+}
+
+// tierTTL returns the TTL the writes of the manager get in tier i, with
+// DefaultTTL resolved to the default the backend reports. It is
+// NoExpiration when the TTL is not known.
+func (cm *CacheManager) tierTTL(i int) time.Duration {
+	config := cm.backends[i]
+	defaultTTL := NoExpiration
+	if backend, ok := As[DefaultTTLBackend](config.Backend); ok {
+		defaultTTL = backend.DefaultTTL()
+	}
+	ttl, err := ResolveTTL(config.TTL, defaultTTL)
+	if err != nil {
+		return NoExpiration
+	}
+	return ttl
+}
+
+// unwrapValue returns the value cached in an EarlyRefreshEntry, or value
+// itself if it is not one
+func unwrapValue(value any) any {
+	if entry, ok := value.(EarlyRefreshEntry); ok {
+		return entry.Value
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+package cachemanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubRand makes randFloat64 return r for the duration of the test
+func stubRand(t *testing.T, r float64) {
+	original := randFloat64
+	randFloat64 = func() float64 { return r }
+	t.Cleanup(func() { randFloat64 = original })
+}
+
+func TestShouldRefreshEarly(t *testing.T) {
+	now := time.Now()
+	entry := EarlyRefreshEntry{Delta: time.Second, Expiry: now.Add(2 * time.Second)}
+
+	tests := []struct {
+		name     string
+		entry    EarlyRefreshEntry
+		rand     float64
+		beta     float64
+		expected bool
+	}{
+		// -log(1 - 0.5) ≈ 0.69, so the refresh window is about 0.69s
+		{name: "far from expiry", entry: entry, rand: 0.5, beta: 1, expected: false},
+		// -log(1 - 0.9) ≈ 2.3s reaches past the expiry
+		{name: "unlucky draw", entry: entry, rand: 0.9, beta: 1, expected: true},
+		{name: "beta widens the window", entry: entry, rand: 0.5, beta: 3, expected: true},
+		{name: "expired", entry: EarlyRefreshEntry{Delta: time.Second, Expiry: now.Add(-time.Second)}, rand: 0, beta: 1, expected: true},
+		{name: "unknown expiry", entry: EarlyRefreshEntry{Delta: time.Hour}, rand: 0.99, beta: 1, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubRand(t, tt.rand)
+			assert.Equal(t, tt.expected, shouldRefreshEarly(tt.entry, tt.beta, now))
+		})
+	}
+}
+
+func TestCacheManager_GetOrLoadEarlyRefresh(t *testing.T) {
+	ctx := context.Background()
+	backend := newLockedMockBackend()
+	cm := NewCacheManager(CacheConfig{Backend: backend, TTL: time.Minute})
+
+	loads := 0
+	load := func(context.Context) (any, error) {
+		loads++
+		time.Sleep(time.Millisecond)
+		return loads, nil
+	}
+
+	value, err := cm.GetOrLoad(ctx, "key", load, WithEarlyRefresh(1))
+	require.NoError(t, err)
+	assert.Equal(t, 1, value)
+
+	stored, _, _ := backend.entry("key")
+	entry, ok := stored.(EarlyRefreshEntry)
+	require.True(t, ok, "metadata is stored alongside the value")
+	assert.Equal(t, 1, entry.Value)
+	assert.GreaterOrEqual(t, entry.Delta, time.Millisecond)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), entry.Expiry, time.Second)
+
+	// A draw far from the expiry serves the cached value
+	stubRand(t, 0.5)
+	value, err = cm.GetOrLoad(ctx, "key", load, WithEarlyRefresh(1))
+	require.NoError(t, err)
+	assert.Equal(t, 1, value)
+
+	// Get unwraps the metadata
+	value, err = cm.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, value)
+
+	// Close to the expiry, the hit recomputes the value
+	entry.Expiry = time.Now()
+	require.NoError(t, backend.Set(ctx, "key", entry, time.Minute))
+	value, err = cm.GetOrLoad(ctx, "key", load, WithEarlyRefresh(1))
+	require.NoError(t, err)
+	assert.Equal(t, 2, value)
+	assert.Equal(t, 2, loads)
+}
+
+func TestCacheManager_BackfillKeepsEarlyRefreshExpiry(t *testing.T) {
+	ctx := context.Background()
+	backend1 := newLockedMockBackend()
+	backend2 := newLockedMockBackend()
+	backend2.data["key"] = EarlyRefreshEntry{Value: "value", Delta: time.Millisecond, Expiry: time.Now().Add(10 * time.Second)}
+
+	cm := NewCacheManager(
+		CacheConfig{Backend: backend1, TTL: time.Minute},
+		CacheConfig{Backend: backend2, TTL: time.Hour},
+	)
+
+	value, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+		t.Fatal("a cached value must not be reloaded")
+		return nil, nil
+	}, WithEarlyRefresh(1))
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	assert.Eventually(t, func() bool {
+		_, ttl, exists := backend1.entry("key")
+		return exists && ttl <= 10*time.Second && ttl > 9*time.Second
+	}, time.Second, 10*time.Millisecond)
+}
+
+// defaultTTLMockBackend reports a default TTL
+type defaultTTLMockBackend struct {
+	*lockedMockBackend
+	defaultTTL time.Duration
+}
+
+func (m *defaultTTLMockBackend) DefaultTTL() time.Duration {
+	return m.defaultTTL
+}
+
+func TestCacheManager_EarlyRefreshResolvesDefaultTTL(t *testing.T) {
+	ctx := context.Background()
+	backend := &defaultTTLMockBackend{lockedMockBackend: newLockedMockBackend(), defaultTTL: time.Minute}
+	unknown := newLockedMockBackend()
+	cm := NewCacheManager(
+		CacheConfig{Backend: backend, TTL: DefaultTTL},
+		CacheConfig{Backend: unknown, TTL: DefaultTTL},
+	)
+
+	_, err := cm.GetOrLoad(ctx, "key", func(context.Context) (any, error) {
+		return "value", nil
+	}, WithEarlyRefresh(1))
+	require.NoError(t, err)
+
+	stored, _, _ := backend.entry("key")
+	entry, ok := stored.(EarlyRefreshEntry)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), entry.Expiry, time.Second)
+
+	stored, _, _ = unknown.entry("key")
+	entry, ok = stored.(EarlyRefreshEntry)
+	require.True(t, ok)
+	assert.True(t, entry.Expiry.IsZero(), "the default of a backend that does not report it is unknown")
+}